@@ -0,0 +1,426 @@
+package main
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/fcrclient"
+)
+
+// Discovery modes
+const (
+	modeStandard   = "standard"
+	modeStandardV2 = "standard-v2"
+	modeDHT        = "dht"
+	modeDHTV2      = "dht-v2"
+)
+
+// runFindGateways lists the gateways of a region.
+func runFindGateways(cfg *config, args []string) error {
+	fs := flag.NewFlagSet("find-gateways", flag.ExitOnError)
+	region := fs.String("region", "", "region code")
+	max := fs.Int("max", 16, "maximum number of gateways")
+	fs.Parse(args)
+	if *region == "" {
+		return errors.New("-region is required")
+	}
+
+	client, err := cfg.client()
+	if err != nil {
+		return err
+	}
+	defer cfg.close()
+	gateways, err := client.FindNearestGateways(*region, *max)
+	if err != nil {
+		return err
+	}
+	type gatewayView struct {
+		GatewayID  string  `json:"gateway_id"`
+		RegionCode string  `json:"region_code"`
+		Distance   float64 `json:"distance_km"`
+	}
+	views := make([]gatewayView, 0, len(gateways))
+	rows := make([][]string, 0, len(gateways))
+	for _, gateway := range gateways {
+		views = append(views, gatewayView{gateway.GatewayID.ToString(), gateway.RegionCode, gateway.Distance})
+		rows = append(rows, []string{gateway.GatewayID.ToString(), gateway.RegionCode, fmt.Sprintf("%.0f", gateway.Distance)})
+	}
+	return cfg.output(views, []string{"GATEWAY", "REGION", "DISTANCE (KM)"}, rows)
+}
+
+// runGateways adds and removes gateways to use, then lists the gateways to use.
+func runGateways(cfg *config, args []string) error {
+	fs := flag.NewFlagSet("gateways", flag.ExitOnError)
+	add := fs.String("add", "", "comma separated gateway IDs to add")
+	remove := fs.String("remove", "", "comma separated gateway IDs to remove")
+	fs.Parse(args)
+	toAdd, err := parseNodeIDs(*add)
+	if err != nil {
+		return err
+	}
+	toRemove, err := parseNodeIDs(*remove)
+	if err != nil {
+		return err
+	}
+
+	client, err := cfg.client()
+	if err != nil {
+		return err
+	}
+	defer cfg.close()
+	client.AddGatewaysToUse(toAdd)
+	client.RemoveGatewaysToUse(toRemove)
+	ids := nodeIDStrings(client.GetGatewaysToUse())
+	return cfg.output(ids, []string{"GATEWAY TO USE"}, singleColumnRows(ids))
+}
+
+// runEstablish establishes with gateways and lists the active gateways.
+func runEstablish(cfg *config, args []string) error {
+	fs := flag.NewFlagSet("establish", flag.ExitOnError)
+	gateways := fs.String("gateways", "", "comma separated gateway IDs")
+	fs.Parse(args)
+	ids, err := parseNodeIDs(*gateways)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return errMissingGateways
+	}
+
+	client, err := cfg.client()
+	if err != nil {
+		return err
+	}
+	defer cfg.close()
+	client.AddGatewaysToUse(ids)
+	client.AddActiveGateways(ids)
+
+	active := make(map[string]bool)
+	for _, id := range client.GetActiveGateways() {
+		active[id.ToString()] = true
+	}
+	type establishmentView struct {
+		GatewayID   string `json:"gateway_id"`
+		Established bool   `json:"established"`
+	}
+	views := make([]establishmentView, 0, len(ids))
+	rows := make([][]string, 0, len(ids))
+	for _, id := range ids {
+		views = append(views, establishmentView{id.ToString(), active[id.ToString()]})
+		rows = append(rows, []string{id.ToString(), fmt.Sprint(active[id.ToString()])})
+	}
+	return cfg.output(views, []string{"GATEWAY", "ESTABLISHED"}, rows)
+}
+
+// runDiscover finds offers for a CID through an active gateway.
+func runDiscover(cfg *config, args []string) error {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	contentIDFlag := fs.String("cid", "", "CID to find offers for")
+	gatewayFlag := fs.String("gateway", "", "gateway ID")
+	mode := fs.String("mode", modeStandard, "discovery mode: standard, standard-v2, dht or dht-v2")
+	numDHT := fs.Int64("num-dht", 4, "number of gateways contacted by DHT discovery")
+	maxOffers := fs.Int("max-offers", 10, "maximum number of offers, for standard-v2 and dht-v2")
+	proofFile := fs.String("proof", "", "file the proof bundle of the offers found is written to")
+	fs.Parse(args)
+	if *contentIDFlag == "" {
+		return errors.New("-cid is required")
+	}
+	contentID, err := cid.NewContentIDFromHexString(*contentIDFlag)
+	if err != nil {
+		return fmt.Errorf("invalid -cid %s: %s", *contentIDFlag, err.Error())
+	}
+	gatewayID, err := parseNodeID("gateway", *gatewayFlag)
+	if err != nil {
+		return err
+	}
+
+	client, err := cfg.client()
+	if err != nil {
+		return err
+	}
+	defer cfg.close()
+	if err := activateGateway(client, gatewayID); err != nil {
+		return err
+	}
+
+	var offers []offerView
+	var bundleErr error
+	bundle := fcrclient.NewProofBundle()
+	switch *mode {
+	case modeStandard:
+		found, err := client.FindOffersStandardDiscovery(contentID, gatewayID)
+		if err != nil {
+			return err
+		}
+		offers = newOfferViews(gatewayID.ToString(), found)
+		bundleErr = client.AddOffers(bundle, gatewayID, found)
+	case modeStandardV2:
+		found, err := client.FindOffersStandardDiscoveryV2(contentID, gatewayID, *maxOffers)
+		if err != nil {
+			return err
+		}
+		offers = newOfferViews(gatewayID.ToString(), found)
+		bundleErr = client.AddOffers(bundle, gatewayID, found)
+	case modeDHT:
+		found, err := client.FindOffersDHTDiscovery(contentID, gatewayID, *numDHT)
+		if err != nil {
+			return err
+		}
+		offers = dhtOfferViews(found)
+		bundleErr = addDHTOffers(client, bundle, found)
+	case modeDHTV2:
+		found, err := client.FindOffersDHTDiscoveryV2(contentID, gatewayID, *numDHT, *maxOffers)
+		if err != nil {
+			return err
+		}
+		offers = dhtOfferViews(found)
+		bundleErr = addDHTOffers(client, bundle, found)
+	default:
+		return fmt.Errorf("unknown discovery mode %q", *mode)
+	}
+	if bundleErr != nil {
+		return bundleErr
+	}
+	if *proofFile != "" {
+		if err := writeProofBundle(*proofFile, bundle); err != nil {
+			return err
+		}
+	}
+
+	payments := newPaymentViews(client.PaymentStatus())
+	if cfg.outputFormat == outputJSON {
+		return cfg.output(struct {
+			Offers   []offerView   `json:"offers"`
+			Payments []paymentView `json:"payments"`
+		}{offers, payments}, nil, nil)
+	}
+	if err := cfg.output(nil, offerHeaders, offerRows(offers)); err != nil {
+		return err
+	}
+	if len(payments) == 0 {
+		return nil
+	}
+	fmt.Println()
+	return cfg.output(nil, paymentHeaders, paymentRows(payments))
+}
+
+// runOfferAck checks a gateway acknowledged the DHT offer of a provider for a CID.
+func runOfferAck(cfg *config, args []string) error {
+	fs := flag.NewFlagSet("offer-ack", flag.ExitOnError)
+	contentIDFlag := fs.String("cid", "", "CID of the offer")
+	gatewayFlag := fs.String("gateway", "", "gateway ID")
+	providerFlag := fs.String("provider", "", "provider ID")
+	proofFile := fs.String("proof", "", "file the proof bundle of the ack is written to")
+	fs.Parse(args)
+	if *contentIDFlag == "" {
+		return errors.New("-cid is required")
+	}
+	contentID, err := cid.NewContentIDFromHexString(*contentIDFlag)
+	if err != nil {
+		return fmt.Errorf("invalid -cid %s: %s", *contentIDFlag, err.Error())
+	}
+	gatewayID, err := parseNodeID("gateway", *gatewayFlag)
+	if err != nil {
+		return err
+	}
+	providerID, err := parseNodeID("provider", *providerFlag)
+	if err != nil {
+		return err
+	}
+
+	client, err := cfg.client()
+	if err != nil {
+		return err
+	}
+	defer cfg.close()
+	proof, err := client.FindDHTOfferAckProof(contentID, gatewayID, providerID)
+	if err != nil {
+		return err
+	}
+	view := struct {
+		ContentID    string `json:"cid"`
+		GatewayID    string `json:"gateway_id"`
+		ProviderID   string `json:"provider_id"`
+		Acknowledged bool   `json:"acknowledged"`
+		Nonce        int64  `json:"nonce,omitempty"`
+		RequestHash  string `json:"request_hash,omitempty"`
+	}{ContentID: contentID.ToString(), GatewayID: gatewayID.ToString(), ProviderID: providerID.ToString(), Acknowledged: proof != nil}
+	if proof != nil {
+		view.Nonce = proof.Nonce
+		view.RequestHash = hex.EncodeToString(proof.RequestHash)
+	}
+	if proof != nil && *proofFile != "" {
+		bundle := fcrclient.NewProofBundle()
+		if err := client.AddAck(bundle, proof); err != nil {
+			return err
+		}
+		if err := writeProofBundle(*proofFile, bundle); err != nil {
+			return err
+		}
+	}
+	return cfg.output(view, []string{"CID", "GATEWAY", "PROVIDER", "ACKNOWLEDGED", "NONCE", "REQUEST HASH"},
+		[][]string{{view.ContentID, view.GatewayID, view.ProviderID, fmt.Sprint(view.Acknowledged), fmt.Sprint(view.Nonce), view.RequestHash}})
+}
+
+// runOfferAcks checks the acks of many gateways for the DHT offers of providers for a CID.
+func runOfferAcks(cfg *config, args []string) error {
+	fs := flag.NewFlagSet("offer-acks", flag.ExitOnError)
+	contentIDFlag := fs.String("cid", "", "CID of the offers")
+	gateways := fs.String("gateways", "", "comma separated gateway IDs, the DHT neighbourhood of the CID if empty")
+	providers := fs.String("providers", "", "comma separated provider IDs")
+	concurrency := fs.Int("concurrency", 0, "maximum number of acks checked in parallel, 0 for the default")
+	fs.Parse(args)
+	if *contentIDFlag == "" {
+		return errors.New("-cid is required")
+	}
+	contentID, err := cid.NewContentIDFromHexString(*contentIDFlag)
+	if err != nil {
+		return fmt.Errorf("invalid -cid %s: %s", *contentIDFlag, err.Error())
+	}
+	gatewayIDs, err := parseNodeIDs(*gateways)
+	if err != nil {
+		return err
+	}
+	providerIDs, err := parseNodeIDs(*providers)
+	if err != nil {
+		return err
+	}
+	if len(providerIDs) == 0 {
+		return errors.New("-providers is required")
+	}
+
+	client, err := cfg.client()
+	if err != nil {
+		return err
+	}
+	defer cfg.close()
+	matrix, err := client.FindDHTOfferAcksBatch(contentID, gatewayIDs, providerIDs, *concurrency)
+	if err != nil {
+		return err
+	}
+	type verdictView struct {
+		GatewayID  string `json:"gateway_id"`
+		ProviderID string `json:"provider_id"`
+		Verdict    string `json:"verdict"`
+		Error      string `json:"error,omitempty"`
+	}
+	views := make([]verdictView, 0)
+	rows := make([][]string, 0)
+	providerIDStrings := nodeIDStrings(providerIDs)
+	gatewayIDStrings := make([]string, 0, len(matrix.Results))
+	for gatewayID := range matrix.Results {
+		gatewayIDStrings = append(gatewayIDStrings, gatewayID)
+	}
+	sort.Strings(gatewayIDStrings)
+	for _, gatewayID := range gatewayIDStrings {
+		for _, providerID := range providerIDStrings {
+			result, exists := matrix.Results[gatewayID][providerID]
+			if !exists {
+				continue
+			}
+			view := verdictView{gatewayID, providerID, string(result.Verdict), ""}
+			if result.Err != nil {
+				view.Error = result.Err.Error()
+			}
+			views = append(views, view)
+			rows = append(rows, []string{view.GatewayID, view.ProviderID, view.Verdict, view.Error})
+		}
+	}
+	return cfg.output(views, []string{"GATEWAY", "PROVIDER", "VERDICT", "ERROR"}, rows)
+}
+
+// runPaymentStatus shows the payment settings and checks the payment manager can be initialised.
+func runPaymentStatus(cfg *config, args []string) error {
+	fs := flag.NewFlagSet("payment-status", flag.ExitOnError)
+	fs.Parse(args)
+
+	client, err := cfg.client()
+	if err != nil {
+		return err
+	}
+	defer cfg.close()
+	view := struct {
+		LotusAP     string `json:"lotus_ap"`
+		SearchPrice string `json:"search_price"`
+		OfferPrice  string `json:"offer_price"`
+		TopUpAmount string `json:"topup_amount"`
+		TopupPolicy string `json:"topup_policy"`
+		Available   bool   `json:"payment_manager_available"`
+	}{
+		client.Settings.LotusAP(),
+		client.Settings.SearchPrice().String(),
+		client.Settings.OfferPrice().String(),
+		client.Settings.TopUpAmount().String(),
+		cfg.topupPolicy,
+		client.PaymentMgr() != nil,
+	}
+	return cfg.output(view, []string{"LOTUS AP", "SEARCH PRICE", "OFFER PRICE", "TOPUP AMOUNT", "TOPUP POLICY", "PAYMENT MANAGER AVAILABLE"},
+		[][]string{{view.LotusAP, view.SearchPrice, view.OfferPrice, view.TopUpAmount, view.TopupPolicy, fmt.Sprint(view.Available)}})
+}
+
+// activateGateway adds a gateway to use and establishes with it.
+func activateGateway(client *fcrclient.FilecoinRetrievalClient, gatewayID *nodeid.NodeID) error {
+	ids := []*nodeid.NodeID{gatewayID}
+	client.AddGatewaysToUse(ids)
+	if client.AddActiveGateways(ids) == 0 {
+		return fmt.Errorf("unable to establish with gateway %s", gatewayID.ToString())
+	}
+	return nil
+}
+
+// dhtOfferViews converts the offers of a DHT discovery, sorted by gateway.
+func dhtOfferViews(offersMap map[string]*[]cidoffer.SubCIDOffer) []offerView {
+	gatewayIDs := make([]string, 0, len(offersMap))
+	for gatewayID := range offersMap {
+		gatewayIDs = append(gatewayIDs, gatewayID)
+	}
+	sort.Strings(gatewayIDs)
+	views := make([]offerView, 0)
+	for _, gatewayID := range gatewayIDs {
+		if offersMap[gatewayID] != nil {
+			views = append(views, newOfferViews(gatewayID, *offersMap[gatewayID])...)
+		}
+	}
+	return views
+}
+
+// nodeIDStrings returns the string representations of node IDs, sorted.
+func nodeIDStrings(ids []*nodeid.NodeID) []string {
+	res := make([]string, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, id.ToString())
+	}
+	sort.Strings(res)
+	return res
+}
+
+// singleColumnRows returns the table rows of a single column.
+func singleColumnRows(values []string) [][]string {
+	rows := make([][]string, 0, len(values))
+	for _, value := range values {
+		rows = append(rows, []string{value})
+	}
+	return rows
+}
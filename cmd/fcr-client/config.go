@@ -0,0 +1,600 @@
+package main
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrcrypto"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrregistermgr"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi"
+	"github.com/ConsenSys/fc-retrieval-client/pkg/fcrclient"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+
+	trustModeReject     = "reject"
+	trustModeQuarantine = "quarantine"
+
+	transportHTTP  = "http"
+	transportHTTP2 = "http2"
+	transportH2C   = "h2c"
+
+	topupPolicyCover        = "cover"
+	topupPolicyFixed        = "fixed"
+	topupPolicyProportional = "proportional"
+
+	registerRefreshDuration = 30 * time.Second
+)
+
+// config - the global flags of the tool
+type config struct {
+	registerURL      string
+	blockchainKey    string
+	retrievalKey     string
+	walletKey        string
+	lotusAP          string
+	lotusAuthToken   string
+	searchPrice      string
+	offerPrice       string
+	maxSearchPrice   string
+	maxOfferPrice    string
+	gatewayPrices    string
+	askGatewayPrices bool
+	topUpAmount      string
+	topupPolicy      string
+	topupMultiplier  int64
+	topupWatermark   string
+	topupMaxBalance  string
+	establishmentTTL int64
+	logLevel         string
+	outputFormat     string
+	trustRootKeys    string
+	trustPins        string
+	signingKeyCerts  string
+	trustMode        string
+	transport        string
+	tls              bool
+	tlsCA            string
+	tlsCert          string
+	tlsKey           string
+	tlsPins          string
+	rateLimit        float64
+	rateBurst        int
+	maxInFlight      int
+	circuitFailures  int
+	circuitTimeout   time.Duration
+
+	paymentRequiredRetries int
+	paymentRequiredBudget  string
+	laneAllocation         string
+	maxPaymentLanes        int
+	record                 string
+	replay                 string
+	nonceSeed              int64
+
+	registerMgr *fcrregistermgr.FCRRegisterMgr
+}
+
+// newConfig creates a configuration with values from the environment.
+func newConfig() *config {
+	return &config{
+		registerURL:    os.Getenv("FCR_REGISTER_URL"),
+		blockchainKey:  os.Getenv("FCR_BLOCKCHAIN_KEY"),
+		retrievalKey:   os.Getenv("FCR_RETRIEVAL_KEY"),
+		walletKey:      os.Getenv("FCR_WALLET_KEY"),
+		lotusAP:        os.Getenv("FCR_LOTUS_AP"),
+		lotusAuthToken: os.Getenv("FCR_LOTUS_AUTH_TOKEN"),
+		trustRootKeys:  os.Getenv("FCR_TRUST_ROOT_KEYS"),
+	}
+}
+
+// registerFlags registers the global flags.
+func (cfg *config) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.registerURL, "register", cfg.registerURL, "register URL (env FCR_REGISTER_URL)")
+	fs.StringVar(&cfg.blockchainKey, "blockchain-key", cfg.blockchainKey, "hex encoded blockchain private key, random if empty (env FCR_BLOCKCHAIN_KEY)")
+	fs.StringVar(&cfg.retrievalKey, "retrieval-key", cfg.retrievalKey, "hex encoded retrieval private key, random if empty (env FCR_RETRIEVAL_KEY)")
+	fs.StringVar(&cfg.walletKey, "wallet-key", cfg.walletKey, "wallet private key paying the gateways (env FCR_WALLET_KEY)")
+	fs.StringVar(&cfg.lotusAP, "lotus-ap", cfg.lotusAP, "lotus API address (env FCR_LOTUS_AP)")
+	fs.StringVar(&cfg.lotusAuthToken, "lotus-auth-token", cfg.lotusAuthToken, "lotus API auth token (env FCR_LOTUS_AUTH_TOKEN)")
+	fs.StringVar(&cfg.searchPrice, "search-price", "", "price paid for a search, in attoFIL")
+	fs.StringVar(&cfg.offerPrice, "offer-price", "", "price paid for an offer, in attoFIL")
+	fs.StringVar(&cfg.maxSearchPrice, "max-search-price", "", "maximum search price paid to a gateway, in attoFIL")
+	fs.StringVar(&cfg.maxOfferPrice, "max-offer-price", "", "maximum offer price paid to a gateway, in attoFIL")
+	fs.StringVar(&cfg.gatewayPrices, "gateway-prices", "", "comma separated <gateway id>=<search price>:<offer price> prices of gateways charging other prices than -search-price and -offer-price")
+	fs.BoolVar(&cfg.askGatewayPrices, "ask-gateway-prices", false, "ask the gateways not in -gateway-prices for their signed prices at "+fcrclient.GatewayPricesPath+" (experimental)")
+	fs.StringVar(&cfg.topUpAmount, "topup-amount", "", "amount payment channels are topped up with, in attoFIL")
+	fs.StringVar(&cfg.topupPolicy, "topup-policy", topupPolicyCover, "how payment channels are topped up: cover (what is expected to be spent, at least the top up amount), fixed (the top up amount) or proportional")
+	fs.Int64Var(&cfg.topupMultiplier, "topup-multiplier", 2, "multiple of the expected spend the proportional top up policy tops up with")
+	fs.StringVar(&cfg.topupWatermark, "topup-watermark", "", "channel balance under which channels are topped up before a payment, in attoFIL")
+	fs.StringVar(&cfg.topupMaxBalance, "topup-max-balance", "", "maximum balance of a payment channel, in attoFIL")
+	fs.IntVar(&cfg.paymentRequiredRetries, "payment-required-retries", 1, "times a request a gateway answers with payment required is sent again after topping up, 0 to fail at once")
+	fs.StringVar(&cfg.paymentRequiredBudget, "payment-required-budget", "", "maximum amount each payment channel is topped up with after payment required answers, in attoFIL")
+	fs.StringVar(&cfg.laneAllocation, "lane-allocation", string(fcrclient.LaneAllocationSession), "how payments to a gateway use the lanes of its payment channel: session (a lane per concurrent operation), request-type or single")
+	fs.IntVar(&cfg.maxPaymentLanes, "max-lanes", 8, "maximum number of lanes of a payment channel with -lane-allocation session")
+	fs.StringVar(&cfg.record, "record", "", "file to record the exchanges with gateways and providers to")
+	fs.StringVar(&cfg.replay, "replay", "", "file of recorded exchanges to answer requests from, instead of the gateways and providers")
+	fs.Int64Var(&cfg.nonceSeed, "nonce-seed", 1, "seed of the request nonces with -record and -replay, the same for both")
+	fs.Int64Var(&cfg.establishmentTTL, "ttl", 0, "establishment time to live, in seconds")
+	fs.StringVar(&cfg.logLevel, "log-level", "error", "log level")
+	fs.StringVar(&cfg.outputFormat, "output", outputTable, "output format: table or json")
+	fs.StringVar(&cfg.trustRootKeys, "trust-root-keys", cfg.trustRootKeys, "comma separated hex encoded root signing keys register entries must use (env FCR_TRUST_ROOT_KEYS)")
+	fs.StringVar(&cfg.trustPins, "trust-pins", "", "comma separated <node id>=<hex encoded root signing key> pinned root keys of nodes")
+	fs.StringVar(&cfg.signingKeyCerts, "signing-key-certs", "", "JSON file of the hex encoded signatures of the signing keys of nodes by their root keys, by node ID, required to trust a node")
+	fs.StringVar(&cfg.trustMode, "trust-mode", trustModeReject, "what to do with register entries not using a trusted root key: reject or quarantine")
+	fs.StringVar(&cfg.transport, "transport", transportHTTP, "transport to gateways and providers: http, http2 (pooled connections) or h2c (cleartext HTTP/2)")
+	fs.BoolVar(&cfg.tls, "tls", false, "send messages to gateways and providers over TLS")
+	fs.StringVar(&cfg.tlsCA, "tls-ca", "", "PEM file of the certificate authorities node certificates must chain to, the system ones if empty")
+	fs.StringVar(&cfg.tlsCert, "tls-cert", "", "PEM file of the client certificate, for nodes requiring mutual TLS")
+	fs.StringVar(&cfg.tlsKey, "tls-key", "", "PEM file of the client certificate private key")
+	fs.StringVar(&cfg.tlsPins, "tls-pins", "", "comma separated <node id>=<hex SHA-256 of the certificate public key> pins, obtained from the node operators")
+	fs.Float64Var(&cfg.rateLimit, "rate-limit", 0, "requests per second sent to each gateway and provider, unlimited if 0")
+	fs.IntVar(&cfg.rateBurst, "rate-burst", 1, "requests sent at once to a node within the rate limit")
+	fs.IntVar(&cfg.maxInFlight, "max-in-flight", 0, "requests waiting for a response from each gateway and provider, unlimited if 0")
+	fs.IntVar(&cfg.circuitFailures, "circuit-failures", fcrclient.NewCircuitBreakerOptions().FailureThreshold, "consecutive failed requests after which a gateway is no longer contacted, 0 to disable")
+	fs.DurationVar(&cfg.circuitTimeout, "circuit-timeout", fcrclient.NewCircuitBreakerOptions().OpenTimeout, "time after which a gateway no longer contacted is contacted again")
+}
+
+// settings creates the client settings from the configuration.
+func (cfg *config) settings() (*fcrclient.ClientSettings, error) {
+	if cfg.outputFormat != outputTable && cfg.outputFormat != outputJSON {
+		return nil, fmt.Errorf("unknown output format %q", cfg.outputFormat)
+	}
+	builder := fcrclient.CreateSettings()
+	builder.SetLogging(cfg.logLevel, "STDOUT", "fcr-client")
+	if cfg.registerURL != "" {
+		builder.SetRegisterURL(cfg.registerURL)
+	}
+	if cfg.establishmentTTL > 0 {
+		builder.SetEstablishmentTTL(cfg.establishmentTTL)
+	}
+
+	var blockchainKey *fcrcrypto.KeyPair
+	var err error
+	if cfg.blockchainKey != "" {
+		blockchainKey, err = fcrcrypto.DecodePrivateKey(cfg.blockchainKey)
+	} else {
+		blockchainKey, err = fcrcrypto.GenerateBlockchainKeyPair()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error with the blockchain private key: %s", err.Error())
+	}
+	builder.SetBlockchainPrivateKey(blockchainKey)
+	if cfg.retrievalKey != "" {
+		retrievalKey, err := fcrcrypto.DecodePrivateKey(cfg.retrievalKey)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding the retrieval private key: %s", err.Error())
+		}
+		builder.SetRetrievalPrivateKey(retrievalKey, fcrcrypto.DecodeKeyVersion(1))
+	}
+
+	builder.SetWalletPrivateKey(cfg.walletKey)
+	builder.SetLotusAP(cfg.lotusAP)
+	builder.SetLotusAuthToken(cfg.lotusAuthToken)
+	if cfg.searchPrice != "" {
+		price, err := parseAmount("search price", cfg.searchPrice)
+		if err != nil {
+			return nil, err
+		}
+		builder.SetSearchPrice(price)
+	}
+	if cfg.offerPrice != "" {
+		price, err := parseAmount("offer price", cfg.offerPrice)
+		if err != nil {
+			return nil, err
+		}
+		builder.SetOfferPrice(price)
+	}
+	if err := cfg.priceSettings(builder); err != nil {
+		return nil, err
+	}
+	if cfg.topUpAmount != "" {
+		amount, err := parseAmount("top up amount", cfg.topUpAmount)
+		if err != nil {
+			return nil, err
+		}
+		builder.SetTopUpAmount(amount)
+	}
+	policy, err := cfg.topupPolicySetting()
+	if err != nil {
+		return nil, err
+	}
+	builder.SetTopupPolicy(policy)
+	if cfg.paymentRequiredRetries < 0 {
+		return nil, errors.New("payment required retries can not be negative")
+	}
+	var budget *big.Int
+	if cfg.paymentRequiredBudget != "" {
+		budget, err = parseAmount("payment required budget", cfg.paymentRequiredBudget)
+		if err != nil {
+			return nil, err
+		}
+	}
+	builder.SetPaymentRequiredRetry(cfg.paymentRequiredRetries, budget)
+	switch allocation := fcrclient.LaneAllocation(cfg.laneAllocation); allocation {
+	case fcrclient.LaneAllocationSession, fcrclient.LaneAllocationRequestType, fcrclient.LaneAllocationSingle:
+		if cfg.maxPaymentLanes < 1 {
+			return nil, errors.New("max lanes must be at least 1")
+		}
+		builder.SetLaneAllocation(allocation, cfg.maxPaymentLanes)
+	default:
+		return nil, fmt.Errorf("unknown lane allocation: %s", cfg.laneAllocation)
+	}
+	if cfg.rateLimit < 0 || cfg.maxInFlight < 0 {
+		return nil, errors.New("rate limit and max in flight requests can not be negative")
+	}
+	builder.SetRateLimit(fcrclient.RateLimit{RequestsPerSecond: cfg.rateLimit, Burst: cfg.rateBurst, MaxInFlight: cfg.maxInFlight})
+	circuitBreaker := fcrclient.NewCircuitBreakerOptions()
+	circuitBreaker.FailureThreshold = cfg.circuitFailures
+	circuitBreaker.OpenTimeout = cfg.circuitTimeout
+	builder.SetCircuitBreaker(circuitBreaker)
+	transport, err := cfg.messageTransport()
+	if err != nil {
+		return nil, err
+	}
+	transport, err = cfg.recordReplayTransport(transport)
+	if err != nil {
+		return nil, err
+	}
+	if transport != nil {
+		builder.SetTransport(transport)
+	}
+	if cfg.record != "" || cfg.replay != "" {
+		builder.SetNonceSeed(cfg.nonceSeed)
+	}
+	anchor, err := cfg.trustAnchor()
+	if err != nil {
+		return nil, err
+	}
+	if anchor != nil {
+		switch cfg.trustMode {
+		case trustModeReject:
+			builder.SetTrustAnchor(anchor, fcrclient.TrustModeReject)
+		case trustModeQuarantine:
+			builder.SetTrustAnchor(anchor, fcrclient.TrustModeQuarantine)
+		default:
+			return nil, fmt.Errorf("unknown trust mode %q", cfg.trustMode)
+		}
+	}
+	return builder.Build(), nil
+}
+
+// priceSettings sets the maximum prices and the prices of the gateways.
+func (cfg *config) priceSettings(builder *fcrclient.SettingsBuilder) error {
+	var maxSearchPrice, maxOfferPrice *big.Int
+	var err error
+	if cfg.maxSearchPrice != "" {
+		if maxSearchPrice, err = parseAmount("maximum search price", cfg.maxSearchPrice); err != nil {
+			return err
+		}
+	}
+	if cfg.maxOfferPrice != "" {
+		if maxOfferPrice, err = parseAmount("maximum offer price", cfg.maxOfferPrice); err != nil {
+			return err
+		}
+	}
+	builder.SetMaxPrices(maxSearchPrice, maxOfferPrice)
+	sources := make(fcrclient.PriceSources, 0)
+	if cfg.gatewayPrices != "" {
+		source, err := cfg.staticPriceSource()
+		if err != nil {
+			return err
+		}
+		sources = append(sources, source)
+	}
+	if cfg.askGatewayPrices {
+		sources = append(sources, fcrclient.NewGatewayPriceSource(registerLookup{cfg}, nil))
+	}
+	if len(sources) > 0 {
+		builder.SetPriceSource(sources)
+	}
+	return nil
+}
+
+// staticPriceSource creates a price source with the prices of -gateway-prices.
+func (cfg *config) staticPriceSource() (*fcrclient.StaticPriceSource, error) {
+	source := fcrclient.NewStaticPriceSource()
+	for _, entry := range strings.Split(cfg.gatewayPrices, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid gateway prices %q, expected <gateway id>=<search price>:<offer price>", entry)
+		}
+		gatewayID, err := parseNodeID("gateway-prices", parts[0])
+		if err != nil {
+			return nil, err
+		}
+		amounts := strings.SplitN(parts[1], ":", 2)
+		if len(amounts) != 2 {
+			return nil, fmt.Errorf("invalid gateway prices %q, expected <gateway id>=<search price>:<offer price>", entry)
+		}
+		searchPrice, err := parseAmount("search price", amounts[0])
+		if err != nil {
+			return nil, err
+		}
+		offerPrice, err := parseAmount("offer price", amounts[1])
+		if err != nil {
+			return nil, err
+		}
+		source.Set(gatewayID, fcrclient.GatewayPrices{SearchPrice: searchPrice, OfferPrice: offerPrice})
+	}
+	return source, nil
+}
+
+// registerLookup - looks gateways up in the register of the client, which is created after the settings
+type registerLookup struct {
+	cfg *config
+}
+
+// GetGateway implements fcrclient.GatewayLookup
+func (l registerLookup) GetGateway(id *nodeid.NodeID) register.GatewayRegistrar {
+	if l.cfg.registerMgr == nil {
+		return nil
+	}
+	return l.cfg.registerMgr.GetGateway(id)
+}
+
+// topupPolicySetting creates the top up policy of the payment channels.
+func (cfg *config) topupPolicySetting() (fcrclient.TopupPolicy, error) {
+	var policy fcrclient.TopupPolicy
+	switch cfg.topupPolicy {
+	case topupPolicyCover:
+		policy = fcrclient.CoverPendingTopupPolicy{}
+	case topupPolicyFixed:
+		policy = fcrclient.FixedTopupPolicy{}
+	case topupPolicyProportional:
+		if cfg.topupMultiplier <= 0 {
+			return nil, errors.New("top up multiplier must be positive")
+		}
+		policy = fcrclient.ProportionalTopupPolicy{Multiplier: cfg.topupMultiplier}
+	default:
+		return nil, fmt.Errorf("unknown top up policy %q", cfg.topupPolicy)
+	}
+	if cfg.topupWatermark != "" {
+		watermark, err := parseAmount("top up watermark", cfg.topupWatermark)
+		if err != nil {
+			return nil, err
+		}
+		policy = fcrclient.LowWatermarkTopupPolicy{Watermark: watermark, Refill: policy}
+	}
+	if cfg.topupMaxBalance != "" {
+		maxBalance, err := parseAmount("maximum channel balance", cfg.topupMaxBalance)
+		if err != nil {
+			return nil, err
+		}
+		policy = fcrclient.MaxBalanceTopupPolicy{MaxBalance: maxBalance, Policy: policy}
+	}
+	return policy, nil
+}
+
+// recordReplayTransport wraps transport to record its exchanges with -record, or replaces it with the recorded
+// exchanges with -replay. The default transport is recorded if transport is nil.
+func (cfg *config) recordReplayTransport(transport clientapi.Transport) (clientapi.Transport, error) {
+	switch {
+	case cfg.record != "" && cfg.replay != "":
+		return nil, errors.New("-record and -replay can not be used together")
+	case cfg.replay != "":
+		return clientapi.NewReplayTransport(cfg.replay)
+	case cfg.record != "":
+		if transport == nil {
+			transport = clientapi.NewHTTPTransport(nil)
+		}
+		return clientapi.NewRecordingTransport(transport, cfg.record, nil)
+	}
+	return transport, nil
+}
+
+// messageTransport creates the transport messages are sent with, nil for the default one.
+func (cfg *config) messageTransport() (clientapi.Transport, error) {
+	if cfg.transport != transportHTTP && cfg.transport != transportHTTP2 && cfg.transport != transportH2C {
+		return nil, fmt.Errorf("unknown transport %q", cfg.transport)
+	}
+	if !cfg.tls {
+		if cfg.tlsCA != "" || cfg.tlsCert != "" || cfg.tlsKey != "" || cfg.tlsPins != "" {
+			return nil, errors.New("TLS flags given without -tls")
+		}
+		switch cfg.transport {
+		case transportHTTP2:
+			return clientapi.NewHTTP2Transport(clientapi.PoolOptions{}), nil
+		case transportH2C:
+			return clientapi.NewHTTP2Transport(clientapi.PoolOptions{H2C: true}), nil
+		}
+		return nil, nil
+	}
+	if cfg.transport == transportH2C {
+		return nil, errors.New("h2c transport can not be used with TLS")
+	}
+	options := clientapi.TLSOptions{}
+	if cfg.tlsCA != "" {
+		pem, err := ioutil.ReadFile(cfg.tlsCA)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the certificate authorities: %s", err.Error())
+		}
+		options.RootCAs = x509.NewCertPool()
+		if !options.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", cfg.tlsCA)
+		}
+	}
+	if cfg.tlsCert != "" || cfg.tlsKey != "" {
+		certificate, err := tls.LoadX509KeyPair(cfg.tlsCert, cfg.tlsKey)
+		if err != nil {
+			return nil, fmt.Errorf("error loading the client certificate: %s", err.Error())
+		}
+		options.Certificates = []tls.Certificate{certificate}
+	}
+	if cfg.tlsPins != "" {
+		options.Pins = clientapi.NewCertificatePins()
+		for _, pin := range strings.Split(cfg.tlsPins, ",") {
+			parts := strings.SplitN(strings.TrimSpace(pin), "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid pin %q, expected <node id>=<hash>", pin)
+			}
+			nodeID, err := parseNodeID("tls-pins", parts[0])
+			if err != nil {
+				return nil, err
+			}
+			hash, err := hex.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid pin hash %q: %s", parts[1], err.Error())
+			}
+			if err := options.Pins.Pin(nodeID, hash); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return clientapi.NewTLSTransport(options, clientapi.PoolOptions{})
+}
+
+// trustAnchor creates the trust anchor of the trusted root keys, nil if there are none.
+func (cfg *config) trustAnchor() (*fcrclient.TrustAnchor, error) {
+	if cfg.trustRootKeys == "" && cfg.trustPins == "" {
+		if cfg.signingKeyCerts != "" {
+			return nil, errors.New("-signing-key-certs given without -trust-root-keys or -trust-pins")
+		}
+		return nil, nil
+	}
+	if cfg.signingKeyCerts == "" {
+		return nil, errors.New("-trust-root-keys or -trust-pins given without -signing-key-certs: no node could be trusted")
+	}
+	anchor := fcrclient.NewTrustAnchor()
+	if cfg.trustRootKeys != "" {
+		for _, key := range strings.Split(cfg.trustRootKeys, ",") {
+			if err := anchor.TrustRootKey(strings.TrimSpace(key)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if cfg.trustPins != "" {
+		for _, pin := range strings.Split(cfg.trustPins, ",") {
+			parts := strings.SplitN(strings.TrimSpace(pin), "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid trust pin %q, expected <node id>=<root key>", pin)
+			}
+			id, err := nodeid.NewNodeIDFromHexString(parts[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid node id in trust pin %q: %s", pin, err.Error())
+			}
+			if err := anchor.PinRootKey(id, parts[1]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	certificates, err := readSigningKeyCertificates(cfg.signingKeyCerts)
+	if err != nil {
+		return nil, err
+	}
+	anchor.Certifier = certificates
+	return anchor, nil
+}
+
+// readSigningKeyCertificates reads a JSON object of hex encoded signing key certificates by node ID.
+func readSigningKeyCertificates(path string) (fcrclient.SigningKeyCertificates, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the signing key certificates: %s", err.Error())
+	}
+	var encoded map[string]string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, fmt.Errorf("invalid signing key certificates: %s", err.Error())
+	}
+	certificates := make(fcrclient.SigningKeyCertificates, len(encoded))
+	for id, signature := range encoded {
+		decoded, err := hex.DecodeString(strings.TrimPrefix(signature, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing key certificate of node %s: %s", id, err.Error())
+		}
+		certificates[strings.ToLower(id)] = decoded
+	}
+	return certificates, nil
+}
+
+// client creates a client connected to the register.
+func (cfg *config) client() (*fcrclient.FilecoinRetrievalClient, error) {
+	settings, err := cfg.settings()
+	if err != nil {
+		return nil, err
+	}
+	cfg.registerMgr = fcrregistermgr.NewFCRRegisterMgr(settings.RegisterURL(), true, true, registerRefreshDuration)
+	if err := cfg.registerMgr.Start(); err != nil {
+		return nil, fmt.Errorf("error starting the register manager: %s", err.Error())
+	}
+	// Load the register before running the command
+	cfg.registerMgr.Refresh()
+	return fcrclient.NewFilecoinRetrievalClient(*settings, cfg.registerMgr)
+}
+
+// close releases the resources of the client.
+func (cfg *config) close() {
+	if cfg.registerMgr != nil {
+		cfg.registerMgr.Shutdown()
+	}
+}
+
+// parseAmount parses an amount in attoFIL.
+func parseAmount(name string, value string) (*big.Int, error) {
+	amount, ok := new(big.Int).SetString(value, 10)
+	if !ok || amount.Sign() < 0 {
+		return nil, fmt.Errorf("invalid %s: %s", name, value)
+	}
+	return amount, nil
+}
+
+// parseNodeID parses a mandatory node ID flag.
+func parseNodeID(name string, value string) (*nodeid.NodeID, error) {
+	if value == "" {
+		return nil, fmt.Errorf("-%s is required", name)
+	}
+	id, err := nodeid.NewNodeIDFromHexString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -%s %s: %s", name, value, err.Error())
+	}
+	return id, nil
+}
+
+// parseNodeIDs parses a comma separated list of node IDs.
+func parseNodeIDs(value string) ([]*nodeid.NodeID, error) {
+	ids := make([]*nodeid.NodeID, 0)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, err := nodeid.NewNodeIDFromHexString(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node id %s: %s", entry, err.Error())
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// errMissingGateways is returned when a command needs at least one gateway.
+var errMissingGateways = errors.New("at least one gateway is required")
@@ -0,0 +1,127 @@
+package main
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/fcrclient"
+)
+
+// parseConfig returns the configuration of the global flags args.
+func parseConfig(t *testing.T, args ...string) *config {
+	t.Helper()
+	cfg := newConfig()
+	fs := flag.NewFlagSet("fcr-client", flag.ContinueOnError)
+	cfg.registerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// captureOutput returns what run prints.
+func captureOutput(t *testing.T, run func() error) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = run()
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestSettingsFromFlags(t *testing.T) {
+	cfg := parseConfig(t, "-search-price", "1000", "-offer-price", "20", "-ttl", "60")
+	settings, err := cfg.settings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.SearchPrice().Int64() != 1000 || settings.OfferPrice().Int64() != 20 {
+		t.Fatalf("expected the prices of the flags, got %s and %s", settings.SearchPrice(), settings.OfferPrice())
+	}
+	if settings.EstablishmentTTL() != 60 {
+		t.Fatalf("expected the establishment TTL of the flags, got %d", settings.EstablishmentTTL())
+	}
+}
+
+func TestSettingsRejectInvalidFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-output", "yaml"},
+		{"-search-price", "-1"},
+		{"-offer-price", "twenty"},
+		{"-blockchain-key", "not a key"},
+		{"-trust-root-keys", "0x01"},
+	} {
+		if _, err := parseConfig(t, args...).settings(); err == nil {
+			t.Fatalf("invalid flags %v accepted", args)
+		}
+	}
+}
+
+func TestOutputFormats(t *testing.T) {
+	version := fcrclient.GetVersion()
+
+	table := captureOutput(t, func() error { return runVersion(parseConfig(t), nil) })
+	lines := strings.Split(strings.TrimSpace(table), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "VERSION") || !strings.HasPrefix(lines[1], version.Version) {
+		t.Fatalf("expected a table with a header, got %q", table)
+	}
+
+	printed := captureOutput(t, func() error { return runVersion(parseConfig(t, "-output", "json"), nil) })
+	var decoded fcrclient.VersionInfo
+	if err := json.Unmarshal([]byte(printed), &decoded); err != nil {
+		t.Fatalf("output not JSON: %s", err.Error())
+	}
+	if decoded.Version != version.Version {
+		t.Fatalf("expected version %s, got %s", version.Version, decoded.Version)
+	}
+}
+
+func TestParseNodeIDs(t *testing.T) {
+	first, second := nodeid.NewRandomNodeID(), nodeid.NewRandomNodeID()
+	ids, err := parseNodeIDs(first.ToString() + ", " + second.ToString() + ",")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0].ToString() != first.ToString() || ids[1].ToString() != second.ToString() {
+		t.Fatal("node IDs not parsed in order")
+	}
+	if _, err := parseNodeIDs(first.ToString() + ",zz"); err == nil {
+		t.Fatal("invalid node ID accepted")
+	}
+	if _, err := parseNodeID("gateway", ""); err == nil {
+		t.Fatal("missing node ID accepted")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/fcrdaemon"
+)
+
+const (
+	defaultDaemonListenAddr    = "127.0.0.1:9030"
+	defaultRegisterWatchPeriod = time.Minute
+)
+
+// runDaemon hosts a client and serves its API until interrupted.
+func runDaemon(cfg *config, args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	listen := fs.String("listen", defaultDaemonListenAddr, "address the API listens on")
+	token := fs.String("token", os.Getenv("FCR_DAEMON_TOKEN"), "bearer token required by the API (env FCR_DAEMON_TOKEN)")
+	watch := fs.Duration("watch-register", defaultRegisterWatchPeriod, "interval between checks of the register entries in use, 0 to disable")
+	fs.Parse(args)
+	if *token == "" {
+		return errors.New("-token is required")
+	}
+
+	client, err := cfg.client()
+	if err != nil {
+		return err
+	}
+	defer cfg.close()
+	if *watch > 0 {
+		if err := client.StartRegisterWatcher(*watch); err != nil {
+			return err
+		}
+		defer client.StopRegisterWatcher()
+	}
+	daemon, err := fcrdaemon.NewDaemon(client, *listen, *token)
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		client.Logger().Info("Shutting down the client daemon")
+		if err := daemon.Shutdown(); err != nil {
+			client.Logger().Error("Error shutting down the client daemon", "error", err)
+		}
+	}()
+	return daemon.ListenAndServe()
+}
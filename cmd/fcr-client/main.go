@@ -0,0 +1,93 @@
+package main
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+// fcr-client is a command line tool for the Filecoin Secondary Retrieval Market client library.
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/fcrclient"
+)
+
+// command - a subcommand of the tool
+type command struct {
+	name        string
+	usage       string
+	description string
+	run         func(cfg *config, args []string) error
+}
+
+var commands = []command{
+	{"find-gateways", "-region <code> [-max <n>]", "find the registered gateways nearest to a region", runFindGateways},
+	{"gateways", "[-add <ids>] [-remove <ids>]", "add or remove gateways to use and list them", runGateways},
+	{"establish", "-gateways <ids>", "establish with gateways and list the active ones", runEstablish},
+	{"discover", "-cid <cid> -gateway <id> [-mode standard|standard-v2|dht|dht-v2] [-proof <file>]", "find offers for a CID", runDiscover},
+	{"offer-ack", "-cid <cid> -gateway <id> -provider <id> [-proof <file>]", "check a gateway acknowledged a provider's DHT offer", runOfferAck},
+	{"offer-acks", "-cid <cid> -providers <ids> [-gateways <ids>] [-concurrency <n>]", "check the acks of many gateways for providers' DHT offers", runOfferAcks},
+	{"verify-proof", "-file <file>", "verify a proof bundle offline", runVerifyProof},
+	{"payment-status", "", "show the payment settings and check the payment manager is available", runPaymentStatus},
+	{"daemon", "-token <token> [-listen <addr>]", "serve the client operations over a local HTTP/JSON API", runDaemon},
+	{"version", "", "show the client library version", runVersion},
+}
+
+func main() {
+	cfg := newConfig()
+	global := flag.NewFlagSet("fcr-client", flag.ExitOnError)
+	cfg.registerFlags(global)
+	global.Usage = func() { usage(global) }
+	global.Parse(os.Args[1:])
+
+	if global.NArg() == 0 {
+		usage(global)
+		os.Exit(2)
+	}
+	name := global.Arg(0)
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if err := cmd.run(cfg, global.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "fcr-client %s: %s\n", name, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "fcr-client: unknown command %q\n", name)
+	usage(global)
+	os.Exit(2)
+}
+
+// usage prints the usage of the tool.
+func usage(global *flag.FlagSet) {
+	out := global.Output()
+	fmt.Fprintf(out, "Usage: fcr-client [global flags] <command> [command flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-15s %s\n", cmd.name, cmd.description)
+		if cmd.usage != "" {
+			fmt.Fprintf(out, "  %-15s   %s\n", "", cmd.usage)
+		}
+	}
+	fmt.Fprintf(out, "\nGlobal flags:\n")
+	global.PrintDefaults()
+}
+
+// runVersion prints the client library version.
+func runVersion(cfg *config, args []string) error {
+	version := fcrclient.GetVersion()
+	return cfg.output(version, []string{"VERSION", "BUILD DATE"}, [][]string{{version.Version, version.BuildDate}})
+}
@@ -0,0 +1,144 @@
+package main
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/fcrclient"
+)
+
+// offerView - an offer as printed by the tool
+type offerView struct {
+	GatewayID  string `json:"gateway_id"`
+	ProviderID string `json:"provider_id"`
+	SubCID     string `json:"sub_cid"`
+	Price      uint64 `json:"price"`
+	Expiry     int64  `json:"expiry"`
+	QoS        uint64 `json:"qos"`
+}
+
+// paymentView - the payments to a gateway as printed by the tool
+type paymentView struct {
+	GatewayID      string `json:"gateway_id"`
+	PaymentChannel string `json:"payment_channel"`
+	Paid           string `json:"paid"`
+	ToppedUp       string `json:"topped_up"`
+	Payments       int    `json:"payments"`
+	TopUps         int    `json:"topups"`
+	// Lanes are the amounts paid on each lane, printed in JSON only
+	Lanes map[uint64]string `json:"lanes"`
+}
+
+// output prints v as JSON, or the rows as a table, depending on the output format.
+func (cfg *config) output(v interface{}, headers []string, rows [][]string) error {
+	if cfg.outputFormat == outputJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// newOfferViews converts offers received from a gateway.
+func newOfferViews(gatewayID string, offers []cidoffer.SubCIDOffer) []offerView {
+	views := make([]offerView, 0, len(offers))
+	for _, offer := range offers {
+		views = append(views, offerView{
+			GatewayID:  gatewayID,
+			ProviderID: offer.GetProviderID().ToString(),
+			SubCID:     offer.GetSubCID().ToString(),
+			Price:      offer.GetPrice(),
+			Expiry:     offer.GetExpiry(),
+			QoS:        offer.GetQoS(),
+		})
+	}
+	return views
+}
+
+// offerRows returns the table rows of offers.
+func offerRows(offers []offerView) [][]string {
+	rows := make([][]string, 0, len(offers))
+	for _, offer := range offers {
+		rows = append(rows, []string{
+			offer.GatewayID,
+			offer.ProviderID,
+			offer.SubCID,
+			fmt.Sprint(offer.Price),
+			time.Unix(offer.Expiry, 0).UTC().Format(time.RFC3339),
+			fmt.Sprint(offer.QoS),
+		})
+	}
+	return rows
+}
+
+var offerHeaders = []string{"GATEWAY", "PROVIDER", "SUB CID", "PRICE", "EXPIRY", "QOS"}
+
+// newPaymentViews converts the payment status of a client.
+func newPaymentViews(status []fcrclient.GatewayPaymentStatus) []paymentView {
+	views := make([]paymentView, 0, len(status))
+	for _, entry := range status {
+		views = append(views, paymentView{
+			GatewayID:      entry.GatewayID,
+			PaymentChannel: entry.PaymentChannel,
+			Paid:           entry.Paid.String(),
+			ToppedUp:       entry.ToppedUp.String(),
+			Payments:       entry.Payments,
+			TopUps:         entry.TopUps,
+			Lanes:          laneAmounts(entry.Lanes),
+		})
+	}
+	return views
+}
+
+// laneAmounts returns the amounts paid on each lane.
+func laneAmounts(lanes []fcrclient.PaymentLaneStatus) map[uint64]string {
+	res := make(map[uint64]string, len(lanes))
+	for _, lane := range lanes {
+		res[lane.Lane] = lane.Paid.String()
+	}
+	return res
+}
+
+// paymentRows returns the table rows of payments.
+func paymentRows(payments []paymentView) [][]string {
+	rows := make([][]string, 0, len(payments))
+	for _, payment := range payments {
+		rows = append(rows, []string{
+			payment.GatewayID,
+			payment.PaymentChannel,
+			payment.Paid,
+			payment.ToppedUp,
+			fmt.Sprint(payment.Payments),
+			fmt.Sprint(payment.TopUps),
+		})
+	}
+	return rows
+}
+
+var paymentHeaders = []string{"GATEWAY", "PAYMENT CHANNEL", "PAID", "TOPPED UP", "PAYMENTS", "TOPUPS"}
@@ -0,0 +1,108 @@
+package main
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/fcrclient"
+)
+
+// runVerifyProof verifies a proof bundle without contacting the register, gateways or providers.
+func runVerifyProof(cfg *config, args []string) error {
+	fs := flag.NewFlagSet("verify-proof", flag.ExitOnError)
+	file := fs.String("file", "", "proof bundle file")
+	fs.Parse(args)
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+	data, err := ioutil.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	bundle, err := fcrclient.ParseProofBundle(data)
+	if err != nil {
+		return err
+	}
+	anchor, err := cfg.trustAnchor()
+	if err != nil {
+		return err
+	}
+
+	view := struct {
+		Offers int    `json:"offers"`
+		Acks   int    `json:"acks"`
+		Valid  bool   `json:"valid"`
+		Status string `json:"status"`
+		Error  string `json:"error,omitempty"`
+	}{Offers: len(bundle.Offers), Acks: len(bundle.Acks)}
+	// Without a trust anchor the register entries of the bundle may be forged, so the bundle is never valid
+	if anchor == nil {
+		err = fcrclient.VerifyProofBundleConsistency(bundle)
+	} else {
+		err = fcrclient.VerifyProofBundle(bundle, anchor)
+	}
+	switch {
+	case err != nil:
+		view.Status = "invalid"
+		view.Error = err.Error()
+	case anchor == nil:
+		view.Status = "self-consistent, untrusted"
+	default:
+		view.Valid = true
+		view.Status = "valid"
+	}
+	return cfg.output(view, []string{"OFFERS", "ACKS", "VALID", "STATUS", "ERROR"},
+		[][]string{{fmt.Sprint(view.Offers), fmt.Sprint(view.Acks), fmt.Sprint(view.Valid), view.Status, view.Error}})
+}
+
+// addDHTOffers adds the offers of a DHT discovery to a proof bundle, sorted by gateway.
+func addDHTOffers(client *fcrclient.FilecoinRetrievalClient, bundle *fcrclient.ProofBundle, offersMap map[string]*[]cidoffer.SubCIDOffer) error {
+	gatewayIDs := make([]string, 0, len(offersMap))
+	for gatewayID := range offersMap {
+		gatewayIDs = append(gatewayIDs, gatewayID)
+	}
+	sort.Strings(gatewayIDs)
+	for _, gatewayID := range gatewayIDs {
+		if offersMap[gatewayID] == nil {
+			continue
+		}
+		id, err := nodeid.NewNodeIDFromHexString(gatewayID)
+		if err != nil {
+			return err
+		}
+		if err := client.AddOffers(bundle, id, *offersMap[gatewayID]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeProofBundle writes a proof bundle to a file.
+func writeProofBundle(file string, bundle *fcrclient.ProofBundle) error {
+	data, err := bundle.Marshal()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
@@ -2,4 +2,13 @@ module github.com/ConsenSys/fc-retrieval-client
 
 go 1.16
 
-require github.com/ConsenSys/fc-retrieval-common v0.0.0-20210629151030-12ab560d14bb
+require (
+	github.com/ConsenSys/fc-retrieval-common v0.0.0-20210629151030-12ab560d14bb
+	github.com/golang/mock v1.6.0
+	github.com/libp2p/go-libp2p-core v0.7.0
+	github.com/multiformats/go-multiaddr v0.3.1
+	github.com/prometheus/client_golang v1.11.0
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
+	golang.org/x/net v0.0.0-20210405180319-a5a99cb37ef4
+)
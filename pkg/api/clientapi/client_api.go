@@ -1,20 +1,49 @@
 package clientapi
 
 import (
+	"context"
+
 	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/request"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/fcrlogger"
 )
 
 type Client struct {
-	httpCommunicator request.HttpCommunications
+	transport Transport
+	logger    fcrlogger.Logger
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithLogger sets the logger of the client api. The global logger is used if not set.
+func WithLogger(logger fcrlogger.Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
 }
 
+// WithTransport sets the transport the client api sends messages with. An HTTP transport is used if not set.
+func WithTransport(transport Transport) Option {
+	return func(c *Client) {
+		if transport != nil {
+			c.transport = transport
+		}
+	}
+}
+
+//go:generate go run github.com/golang/mock/mockgen -destination=mocks/mock_client_api.go -package=mocks github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi ClientApi
+
+// ClientApi sends the requests of the client to gateways and providers. mocks.MockClientApi is a generated
+// implementation for the unit tests of code using the client.
 type ClientApi interface {
 	RequestDHTOfferDiscover(
+		ctx context.Context,
 		gatewayInfo register.GatewayRegistrar,
 		gatewayIDs []nodeid.NodeID,
 		contentID *cid.ContentID,
@@ -25,6 +54,7 @@ type ClientApi interface {
 	) ([]GatewaySubOffers, error)
 
 	RequestDHTDiscover(
+		ctx context.Context,
 		gatewayInfo register.GatewayRegistrar,
 		contentID *cid.ContentID,
 		nonce int64,
@@ -36,6 +66,7 @@ type ClientApi interface {
 	) ([]nodeid.NodeID, []fcrmessages.FCRMessage, []nodeid.NodeID, error)
 
 	RequestDHTDiscoverV2(
+		ctx context.Context,
 		gatewayInfo register.GatewayRegistrar,
 		contentID *cid.ContentID,
 		nonce int64,
@@ -47,12 +78,14 @@ type ClientApi interface {
 	) ([]nodeid.NodeID, []fcrmessages.FCRMessage, []nodeid.NodeID, error)
 
 	RequestDHTOfferAck(
+		ctx context.Context,
 		providerInfo register.ProviderRegistrar,
 		contentID *cid.ContentID,
 		gatewayID *nodeid.NodeID,
 	) (bool, *fcrmessages.FCRMessage, *fcrmessages.FCRMessage, error)
 
 	RequestEstablishment(
+		ctx context.Context,
 		gatewayInfo register.GatewayRegistrar,
 		challenge []byte,
 		clientID *nodeid.NodeID,
@@ -60,6 +93,7 @@ type ClientApi interface {
 	) error
 
 	RequestStandardDiscoverOffer(
+		ctx context.Context,
 		gatewayInfo register.GatewayRegistrar,
 		contentID *cid.ContentID,
 		nonce int64,
@@ -70,6 +104,7 @@ type ClientApi interface {
 	) ([]cidoffer.SubCIDOffer, error)
 
 	RequestStandardDiscover(
+		ctx context.Context,
 		gatewayInfo register.GatewayRegistrar,
 		contentID *cid.ContentID,
 		nonce int64,
@@ -79,6 +114,7 @@ type ClientApi interface {
 	) ([]cidoffer.SubCIDOffer, error)
 
 	RequestStandardDiscoverV2(
+		ctx context.Context,
 		gatewayInfo register.GatewayRegistrar,
 		contentID *cid.ContentID,
 		nonce int64,
@@ -88,14 +124,34 @@ type ClientApi interface {
 	) ([][cidoffer.CIDOfferDigestSize]byte, error)
 }
 
-func NewClientApi() ClientApi {
-	return &Client{
-		httpCommunicator: request.NewHttpCommunicator(),
-	}
+func NewClientApi(opts ...Option) ClientApi {
+	return newClient(NewHTTPTransport(nil), opts)
+}
+
+func NewAdminApiWithDep(httpCommunicator request.HttpCommunications, opts ...Option) ClientApi {
+	return newClient(NewCommunicatorTransport(httpCommunicator), opts)
 }
 
-func NewAdminApiWithDep(httpCommunicator request.HttpCommunications) ClientApi {
-	return &Client{
-		httpCommunicator: httpCommunicator,
+// newClient creates a traced client api sending messages with the given transport, unless an option sets another one.
+func newClient(transport Transport, opts []Option) ClientApi {
+	c := &Client{
+		transport: transport,
+		logger:    fcrlogger.NewGlobalLogger(),
 	}
-}
\ No newline at end of file
+	for _, opt := range opts {
+		opt(c)
+	}
+	return newTracedClientApi(c)
+}
+
+// node - a gateway or a provider messages are sent to
+type node interface {
+	GetNodeID() string
+	GetNetworkInfoClient() string
+}
+
+// sendMessage sends a message to the given node with the transport of the client and returns its response.
+// The node ID is passed to the transport in the context, see NodeIDFromContext.
+func (c *Client) sendMessage(ctx context.Context, to node, message *fcrmessages.FCRMessage) (*fcrmessages.FCRMessage, error) {
+	return c.transport.Send(ContextWithNodeID(ctx, to.GetNodeID()), to.GetNetworkInfoClient(), message)
+}
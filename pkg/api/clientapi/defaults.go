@@ -0,0 +1,38 @@
+package clientapi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import "time"
+
+const (
+	// defaultHTTPTimeout is the default timeout of the requests sent to gateways and providers.
+	defaultHTTPTimeout = 180 * time.Second
+
+	// defaultMaxIdleConnsPerHost is the default number of idle connections an HTTP/2 transport keeps per node.
+	defaultMaxIdleConnsPerHost = 8
+
+	// defaultIdleConnTimeout is the default time an HTTP/2 transport keeps an idle connection.
+	defaultIdleConnTimeout = 90 * time.Second
+
+	// defaultDialTimeout is the timeout of the connection to a node.
+	defaultDialTimeout = 30 * time.Second
+
+	// defaultKeepAlive is the keep alive period of the connections to the nodes.
+	defaultKeepAlive = 30 * time.Second
+
+	// maxStreamResponseSize is the maximum size of a response read from a libp2p stream.
+	maxStreamResponseSize = 64 << 20
+)
@@ -21,13 +21,13 @@ package clientapi
  */
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
 	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
-	"github.com/ConsenSys/fc-retrieval-common/pkg/logging"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
 )
@@ -39,6 +39,7 @@ type GatewaySubOffers struct {
 }
 
 func (c *Client) RequestDHTOfferDiscover(
+	ctx context.Context,
 	gatewayRegistrar register.GatewayRegistrar,
 	gatewayIDs []nodeid.NodeID,
 	contentID *cid.ContentID,
@@ -49,12 +50,12 @@ func (c *Client) RequestDHTOfferDiscover(
 ) ([]GatewaySubOffers, error) {
 	request, err := fcrmessages.EncodeClientDHTDiscoverOfferRequest(contentID, nonce, offersDigests, gatewayIDs, paymentChannelAddr, voucher)
 	if err != nil {
-		logging.Error("error encoding Client DHT Discover Request: %+v", err)
+		c.logger.Error("Error encoding Client DHT Discover Offer Request", "error", err)
 		return nil, err
 	}
 
 	// Send request and get response
-	response, err := c.httpCommunicator.SendMessage(gatewayRegistrar.GetNetworkInfoClient(), request)
+	response, err := c.sendMessage(ctx, gatewayRegistrar, request)
 	if err != nil {
 		return nil, err
 	}
@@ -78,16 +79,16 @@ func (c *Client) RequestDHTOfferDiscover(
 		return nil, fmt.Errorf("error decoding client DHT discover offer response, lengths of gateway IDs = %d and FCR messages = %d do not match", len(gatewayIDs), len(fcrMessages))
 	}
 	if paymentRequiredCl {
-		return nil, fmt.Errorf("payment required, in order to proceed topup your balance for payment channel address: %d", paymentChannelAddrToTopupCl)
+		return nil, NewPaymentRequiredError(gatewayRegistrar.GetNodeID(), paymentChannelAddrToTopupCl)
 	}
 	var result []GatewaySubOffers
 	for idx, fcrMessage := range fcrMessages {
 		_, _, found, subCIDOffers, _,  paymentRequired, paymentChannelAddrToTopup, decodeErr := fcrmessages.DecodeGatewayDHTDiscoverOfferResponse(&fcrMessage)
 		if decodeErr != nil {
-			logging.Error("error decoding gateway DHT discover offer response %s", decodeErr.Error())
+			c.logger.Error("Error decoding gateway DHT discover offer response", "error", decodeErr)
 		}
 		if paymentRequired {
-			return nil, fmt.Errorf("payment required, in order to proceed topup your balance for payment channel address: %d", paymentChannelAddrToTopup)
+			return nil, NewPaymentRequiredError(gatewayRegistrar.GetNodeID(), paymentChannelAddrToTopup)
 		}
 		// return first good one
 		if found && len(subCIDOffers) > 0 {
@@ -16,18 +16,18 @@ package clientapi
  */
 
 import (
+	"context"
 	"errors"
-	"fmt"
 
 	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
-	"github.com/ConsenSys/fc-retrieval-common/pkg/logging"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
 )
 
 // RequestDHTDiscover requests a dht discover to a given gateway for a given contentID, nonce and ttl.
 func (c *Client) RequestDHTDiscover(
+	ctx context.Context,
 	gatewayRegistrar register.GatewayRegistrar,
 	contentID *cid.ContentID,
 	nonce int64,
@@ -40,12 +40,12 @@ func (c *Client) RequestDHTDiscover(
 	// Construct request
 	request, err := fcrmessages.EncodeClientDHTDiscoverRequest(contentID, nonce, ttl, numDHT, incrementalResult, paychAddr, voucher)
 	if err != nil {
-		logging.Error("Error encoding Client DHT Discover Request: %+v", err)
+		c.logger.Error("Error encoding Client DHT Discover Request", "error", err)
 		return nil, nil, nil, err
 	}
 
 	// Send request and get response
-	response, err := c.httpCommunicator.SendMessage(gatewayRegistrar.GetNetworkInfoClient(), request)
+	response, err := c.sendMessage(ctx, gatewayRegistrar, request)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -72,7 +72,7 @@ func (c *Client) RequestDHTDiscover(
 		return nil, nil, nil, errors.New("length mismatch")
 	}
 	if paymentRequired {
-		return nil, nil, nil, fmt.Errorf("payment required, in order to proceed topup your balance for payment channel address: %d", paymentChannelAddrToTopup)
+		return nil, nil, nil, NewPaymentRequiredError(gatewayRegistrar.GetNodeID(), paymentChannelAddrToTopup)
 	}
 
 	return contacted, contactedResp, uncontactable, nil
@@ -16,6 +16,7 @@ package clientapi
  */
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
@@ -26,6 +27,7 @@ import (
 
 // RequestDHTDiscoverV2 requests a dht discover to a given gateway for a given contentID, nonce and ttl.
 func (c *Client) RequestDHTDiscoverV2(
+	ctx context.Context,
 	gatewayRegistrar register.GatewayRegistrar,
 	contentID *cid.ContentID,
 	nonce int64,
@@ -42,7 +44,7 @@ func (c *Client) RequestDHTDiscoverV2(
 	}
 
 	// Send request and get response
-	response, err := c.httpCommunicator.SendMessage(gatewayRegistrar.GetNetworkInfoClient(), request)
+	response, err := c.sendMessage(ctx, gatewayRegistrar, request)
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("error sending DHT discover message to gateway ID: %s, error: %s", gatewayRegistrar.GetNodeID(), err.Error())
 	}
@@ -69,7 +71,7 @@ func (c *Client) RequestDHTDiscoverV2(
 		return nil, nil, nil, fmt.Errorf("length mismatch error during DHT discover response validation for gateway ID: %s", gatewayRegistrar.GetNodeID())
 	}
 	if paymentRequired {
-		return nil, nil, nil, fmt.Errorf("payment required, in order to proceed topup your balance for payment channel address: %d", paymentChannelAddrToTopup)
+		return nil, nil, nil, NewPaymentRequiredError(gatewayRegistrar.GetNodeID(), paymentChannelAddrToTopup)
 	}
 
 	return contacted, contactedResp, uncontactable, nil
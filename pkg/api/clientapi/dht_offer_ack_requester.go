@@ -16,17 +16,18 @@ package clientapi
  */
 
 import (
+	"context"
 	"errors"
 
 	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
-	"github.com/ConsenSys/fc-retrieval-common/pkg/logging"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
 )
 
 // RequestDHTOfferAck requests a dht offer ack to a given provider for a pair of cid and gateway id
 func (c *Client) RequestDHTOfferAck(
+	ctx context.Context,
 	gatewayRegistrar register.ProviderRegistrar,
 	contentID *cid.ContentID,
 	gatewayID *nodeid.NodeID,
@@ -34,12 +35,12 @@ func (c *Client) RequestDHTOfferAck(
 	// Construct request
 	request, err := fcrmessages.EncodeClientDHTOfferAckRequest(contentID, gatewayID)
 	if err != nil {
-		logging.Error("Error encoding Client DHT Offer Ack Request: %+v", err)
+		c.logger.Error("Error encoding Client DHT Offer Ack Request", "error", err)
 		return false, nil, nil, err
 	}
 
 	// Send request and get response
-	response, err := c.httpCommunicator.SendMessage(gatewayRegistrar.GetNetworkInfoClient(), request)
+	response, err := c.sendMessage(ctx, gatewayRegistrar, request)
 	if err != nil {
 		return false, nil, nil, err
 	}
@@ -16,17 +16,18 @@ package clientapi
  */
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
 
 	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
-	"github.com/ConsenSys/fc-retrieval-common/pkg/logging"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
 )
 
 // RequestEstablishment requests an establishment to a given gateway for a given challenge, client id and ttl.
 func (c *Client) RequestEstablishment(
+	ctx context.Context,
 	gatewayRegistrar register.GatewayRegistrar,
 	challenge []byte,
 	clientID *nodeid.NodeID,
@@ -41,11 +42,11 @@ func (c *Client) RequestEstablishment(
 
 	request, err := fcrmessages.EncodeClientEstablishmentRequest(clientID, string(b), ttl)
 	if err != nil {
-		logging.Error("Error encoding Client Establishment Request: %+v", err)
+		c.logger.Error("Error encoding Client Establishment Request", "error", err)
 		return err
 	}
 
-	response, err := c.httpCommunicator.SendMessage(gatewayRegistrar.GetNetworkInfoClient(), request)
+	response, err := c.sendMessage(ctx, gatewayRegistrar, request)
 	if err != nil {
 		return err
 	}
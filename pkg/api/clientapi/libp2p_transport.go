@@ -0,0 +1,118 @@
+package clientapi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ClientProtocolID is the libp2p protocol of the client messages. A stream carries one message and its response,
+// each JSON encoded, the writing side closing the stream for writing after its message.
+// The gateways and providers do not serve this protocol: it is experimental, for nodes implementing it.
+const ClientProtocolID = protocol.ID("/fcr/client/1.0.0")
+
+// StreamHost is the part of a libp2p host used by the libp2p transport. A libp2p host.Host implements it.
+type StreamHost interface {
+	Connect(ctx context.Context, pi peer.AddrInfo) error
+	NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error)
+}
+
+// PeerResolver returns the libp2p peer of a node from its client network info.
+type PeerResolver func(address string) (peer.AddrInfo, error)
+
+// Libp2pTransport sends messages over libp2p streams of ClientProtocolID.
+// This is experimental: the gateways and providers only accept client messages over HTTP.
+type Libp2pTransport struct {
+	host     StreamHost
+	resolver PeerResolver
+}
+
+// NewLibp2pTransport creates a transport sending messages through a libp2p host.
+// The peer of a node is found with resolver or, if nil, with ResolveP2pAddr.
+func NewLibp2pTransport(host StreamHost, resolver PeerResolver) *Libp2pTransport {
+	if resolver == nil {
+		resolver = ResolveP2pAddr
+	}
+	return &Libp2pTransport{host: host, resolver: resolver}
+}
+
+// ResolveP2pAddr resolves a client network info holding a multiaddress ending with /p2p/<peer id>.
+func ResolveP2pAddr(address string) (peer.AddrInfo, error) {
+	addr, err := ma.NewMultiaddr(address)
+	if err != nil {
+		return peer.AddrInfo{}, fmt.Errorf("invalid multiaddress %s: %s", address, err.Error())
+	}
+	info, err := peer.AddrInfoFromP2pAddr(addr)
+	if err != nil {
+		return peer.AddrInfo{}, fmt.Errorf("invalid p2p address %s: %s", address, err.Error())
+	}
+	return *info, nil
+}
+
+// Send implements Transport
+func (t *Libp2pTransport) Send(ctx context.Context, address string, message *fcrmessages.FCRMessage) (*fcrmessages.FCRMessage, error) {
+	info, err := t.resolver(address)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.host.Connect(ctx, info); err != nil {
+		return nil, fmt.Errorf("can't connect to peer %s, error: %s", info.ID.Pretty(), err.Error())
+	}
+	stream, err := t.host.NewStream(ctx, info.ID, ClientProtocolID)
+	if err != nil {
+		return nil, fmt.Errorf("can't open stream to peer %s, error: %s", info.ID.Pretty(), err.Error())
+	}
+	defer stream.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
+	}
+
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		stream.Reset()
+		return nil, fmt.Errorf("can't marshal message, error: %s", err.Error())
+	}
+	if _, err := stream.Write(jsonData); err != nil {
+		stream.Reset()
+		return nil, fmt.Errorf("can't write message, error: %s", err.Error())
+	}
+	if err := stream.CloseWrite(); err != nil {
+		stream.Reset()
+		return nil, fmt.Errorf("can't close stream for writing, error: %s", err.Error())
+	}
+
+	bodyBytes, err := ioutil.ReadAll(io.LimitReader(stream, maxStreamResponseSize))
+	if err != nil {
+		stream.Reset()
+		return nil, fmt.Errorf("can't read response, error: %s", err.Error())
+	}
+	var response fcrmessages.FCRMessage
+	if err := json.Unmarshal(bodyBytes, &response); err != nil {
+		return nil, errors.New("can't unmarshal response")
+	}
+	return &response, nil
+}
@@ -0,0 +1,166 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi (interfaces: ClientApi)
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	context "context"
+	reflect "reflect"
+
+	clientapi "github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi"
+	cid "github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	cidoffer "github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+	fcrmessages "github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
+	nodeid "github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	register "github.com/ConsenSys/fc-retrieval-common/pkg/register"
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockClientApi is a mock of ClientApi interface.
+type MockClientApi struct {
+	ctrl     *gomock.Controller
+	recorder *MockClientApiMockRecorder
+}
+
+// MockClientApiMockRecorder is the mock recorder for MockClientApi.
+type MockClientApiMockRecorder struct {
+	mock *MockClientApi
+}
+
+// NewMockClientApi creates a new mock instance.
+func NewMockClientApi(ctrl *gomock.Controller) *MockClientApi {
+	mock := &MockClientApi{ctrl: ctrl}
+	mock.recorder = &MockClientApiMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockClientApi) EXPECT() *MockClientApiMockRecorder {
+	return m.recorder
+}
+
+// RequestDHTDiscover mocks base method.
+func (m *MockClientApi) RequestDHTDiscover(arg0 context.Context, arg1 register.GatewayRegistrar, arg2 *cid.ContentID, arg3, arg4, arg5 int64, arg6 bool, arg7, arg8 string) ([]nodeid.NodeID, []fcrmessages.FCRMessage, []nodeid.NodeID, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RequestDHTDiscover", arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8)
+	ret0, _ := ret[0].([]nodeid.NodeID)
+	ret1, _ := ret[1].([]fcrmessages.FCRMessage)
+	ret2, _ := ret[2].([]nodeid.NodeID)
+	ret3, _ := ret[3].(error)
+	return ret0, ret1, ret2, ret3
+}
+
+// RequestDHTDiscover indicates an expected call of RequestDHTDiscover.
+func (mr *MockClientApiMockRecorder) RequestDHTDiscover(arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequestDHTDiscover", reflect.TypeOf((*MockClientApi)(nil).RequestDHTDiscover), arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8)
+}
+
+// RequestDHTDiscoverV2 mocks base method.
+func (m *MockClientApi) RequestDHTDiscoverV2(arg0 context.Context, arg1 register.GatewayRegistrar, arg2 *cid.ContentID, arg3, arg4, arg5 int64, arg6 bool, arg7, arg8 string) ([]nodeid.NodeID, []fcrmessages.FCRMessage, []nodeid.NodeID, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RequestDHTDiscoverV2", arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8)
+	ret0, _ := ret[0].([]nodeid.NodeID)
+	ret1, _ := ret[1].([]fcrmessages.FCRMessage)
+	ret2, _ := ret[2].([]nodeid.NodeID)
+	ret3, _ := ret[3].(error)
+	return ret0, ret1, ret2, ret3
+}
+
+// RequestDHTDiscoverV2 indicates an expected call of RequestDHTDiscoverV2.
+func (mr *MockClientApiMockRecorder) RequestDHTDiscoverV2(arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequestDHTDiscoverV2", reflect.TypeOf((*MockClientApi)(nil).RequestDHTDiscoverV2), arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7, arg8)
+}
+
+// RequestDHTOfferAck mocks base method.
+func (m *MockClientApi) RequestDHTOfferAck(arg0 context.Context, arg1 register.ProviderRegistrar, arg2 *cid.ContentID, arg3 *nodeid.NodeID) (bool, *fcrmessages.FCRMessage, *fcrmessages.FCRMessage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RequestDHTOfferAck", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(*fcrmessages.FCRMessage)
+	ret2, _ := ret[2].(*fcrmessages.FCRMessage)
+	ret3, _ := ret[3].(error)
+	return ret0, ret1, ret2, ret3
+}
+
+// RequestDHTOfferAck indicates an expected call of RequestDHTOfferAck.
+func (mr *MockClientApiMockRecorder) RequestDHTOfferAck(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequestDHTOfferAck", reflect.TypeOf((*MockClientApi)(nil).RequestDHTOfferAck), arg0, arg1, arg2, arg3)
+}
+
+// RequestDHTOfferDiscover mocks base method.
+func (m *MockClientApi) RequestDHTOfferDiscover(arg0 context.Context, arg1 register.GatewayRegistrar, arg2 []nodeid.NodeID, arg3 *cid.ContentID, arg4 int64, arg5 [][][32]byte, arg6, arg7 string) ([]clientapi.GatewaySubOffers, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RequestDHTOfferDiscover", arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7)
+	ret0, _ := ret[0].([]clientapi.GatewaySubOffers)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RequestDHTOfferDiscover indicates an expected call of RequestDHTOfferDiscover.
+func (mr *MockClientApiMockRecorder) RequestDHTOfferDiscover(arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequestDHTOfferDiscover", reflect.TypeOf((*MockClientApi)(nil).RequestDHTOfferDiscover), arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7)
+}
+
+// RequestEstablishment mocks base method.
+func (m *MockClientApi) RequestEstablishment(arg0 context.Context, arg1 register.GatewayRegistrar, arg2 []byte, arg3 *nodeid.NodeID, arg4 int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RequestEstablishment", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RequestEstablishment indicates an expected call of RequestEstablishment.
+func (mr *MockClientApiMockRecorder) RequestEstablishment(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequestEstablishment", reflect.TypeOf((*MockClientApi)(nil).RequestEstablishment), arg0, arg1, arg2, arg3, arg4)
+}
+
+// RequestStandardDiscover mocks base method.
+func (m *MockClientApi) RequestStandardDiscover(arg0 context.Context, arg1 register.GatewayRegistrar, arg2 *cid.ContentID, arg3, arg4 int64, arg5, arg6 string) ([]cidoffer.SubCIDOffer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RequestStandardDiscover", arg0, arg1, arg2, arg3, arg4, arg5, arg6)
+	ret0, _ := ret[0].([]cidoffer.SubCIDOffer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RequestStandardDiscover indicates an expected call of RequestStandardDiscover.
+func (mr *MockClientApiMockRecorder) RequestStandardDiscover(arg0, arg1, arg2, arg3, arg4, arg5, arg6 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequestStandardDiscover", reflect.TypeOf((*MockClientApi)(nil).RequestStandardDiscover), arg0, arg1, arg2, arg3, arg4, arg5, arg6)
+}
+
+// RequestStandardDiscoverOffer mocks base method.
+func (m *MockClientApi) RequestStandardDiscoverOffer(arg0 context.Context, arg1 register.GatewayRegistrar, arg2 *cid.ContentID, arg3, arg4 int64, arg5 [][32]byte, arg6, arg7 string) ([]cidoffer.SubCIDOffer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RequestStandardDiscoverOffer", arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7)
+	ret0, _ := ret[0].([]cidoffer.SubCIDOffer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RequestStandardDiscoverOffer indicates an expected call of RequestStandardDiscoverOffer.
+func (mr *MockClientApiMockRecorder) RequestStandardDiscoverOffer(arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequestStandardDiscoverOffer", reflect.TypeOf((*MockClientApi)(nil).RequestStandardDiscoverOffer), arg0, arg1, arg2, arg3, arg4, arg5, arg6, arg7)
+}
+
+// RequestStandardDiscoverV2 mocks base method.
+func (m *MockClientApi) RequestStandardDiscoverV2(arg0 context.Context, arg1 register.GatewayRegistrar, arg2 *cid.ContentID, arg3, arg4 int64, arg5, arg6 string) ([][32]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RequestStandardDiscoverV2", arg0, arg1, arg2, arg3, arg4, arg5, arg6)
+	ret0, _ := ret[0].([][32]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RequestStandardDiscoverV2 indicates an expected call of RequestStandardDiscoverV2.
+func (mr *MockClientApiMockRecorder) RequestStandardDiscoverV2(arg0, arg1, arg2, arg3, arg4, arg5, arg6 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequestStandardDiscoverV2", reflect.TypeOf((*MockClientApi)(nil).RequestStandardDiscoverV2), arg0, arg1, arg2, arg3, arg4, arg5, arg6)
+}
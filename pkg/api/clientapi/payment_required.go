@@ -0,0 +1,39 @@
+package clientapi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"fmt"
+)
+
+// PaymentRequiredError - a gateway answered that the balance of the payment channel paying it is not enough
+// for the request
+type PaymentRequiredError struct {
+	// GatewayID is the gateway answering, the entry gateway for the sub responses of DHT discovery.
+	GatewayID string
+	// PaymentChannel is the payment channel to top up, as given by the gateway.
+	PaymentChannel int64
+}
+
+// NewPaymentRequiredError creates the error of a gateway answering payment required.
+func NewPaymentRequiredError(gatewayID string, paymentChannel int64) *PaymentRequiredError {
+	return &PaymentRequiredError{GatewayID: gatewayID, PaymentChannel: paymentChannel}
+}
+
+// Error implements error
+func (e *PaymentRequiredError) Error() string {
+	return fmt.Sprintf("payment required, in order to proceed topup your balance for payment channel address: %d", e.PaymentChannel)
+}
@@ -0,0 +1,167 @@
+package clientapi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/fcrlogger"
+)
+
+// RecordedExchange - a message sent to a node and its response, one JSON line of a recording
+type RecordedExchange struct {
+	NodeID   string                  `json:"node_id"`
+	Address  string                  `json:"address"`
+	Request  *fcrmessages.FCRMessage `json:"request"`
+	Response *fcrmessages.FCRMessage `json:"response,omitempty"`
+	Error    string                  `json:"error,omitempty"`
+}
+
+// RecordingTransport sends messages with another transport and appends every exchange to a recording file,
+// which a ReplayTransport answers from.
+type RecordingTransport struct {
+	transport Transport
+	file      *os.File
+	encoder   *json.Encoder
+	logger    fcrlogger.Logger
+	lock      sync.Mutex
+}
+
+// NewRecordingTransport creates a transport recording the exchanges of transport to the file at path,
+// created or truncated. Exchanges failing to be recorded are logged with logger, the global logger if nil.
+func NewRecordingTransport(transport Transport, path string, logger fcrlogger.Logger) (*RecordingTransport, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't create recording, error: %s", err.Error())
+	}
+	if logger == nil {
+		logger = fcrlogger.NewGlobalLogger()
+	}
+	return &RecordingTransport{transport: transport, file: file, encoder: json.NewEncoder(file), logger: logger}, nil
+}
+
+// Send implements Transport. The message has been sent whether its exchange is recorded or not, so the response is
+// returned even if recording fails.
+func (t *RecordingTransport) Send(ctx context.Context, address string, message *fcrmessages.FCRMessage) (*fcrmessages.FCRMessage, error) {
+	response, err := t.transport.Send(ctx, address, message)
+	exchange := RecordedExchange{NodeID: NodeIDFromContext(ctx), Address: address, Request: message, Response: response}
+	if err != nil {
+		exchange.Error = err.Error()
+	}
+	t.lock.Lock()
+	recordErr := t.encoder.Encode(exchange)
+	t.lock.Unlock()
+	if recordErr != nil {
+		t.logger.Error("Can't record exchange", "node_id", exchange.NodeID, "address", address, "error", recordErr)
+	}
+	return response, err
+}
+
+// Close closes the recording file.
+func (t *RecordingTransport) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.file.Close()
+}
+
+// replayKey - the exchanges replayed in order: those with the same node and request message type
+type replayKey struct {
+	nodeID      string
+	messageType int32
+}
+
+// ReplayTransport answers messages with the responses of a recording, without any network. A message is answered
+// with the response to the first exchange not replayed yet of the same node and message type whose request has the
+// same body. Signatures are not compared, but the nonces of the client must be seeded as when recording for the
+// requests to match.
+type ReplayTransport struct {
+	exchanges map[replayKey][]RecordedExchange
+	lock      sync.Mutex
+}
+
+// NewReplayTransport creates a transport replaying the recording at path.
+func NewReplayTransport(path string) (*ReplayTransport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open recording, error: %s", err.Error())
+	}
+	defer file.Close()
+
+	t := &ReplayTransport{exchanges: make(map[replayKey][]RecordedExchange)}
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for decoder.More() {
+		var exchange RecordedExchange
+		if err := decoder.Decode(&exchange); err != nil {
+			return nil, fmt.Errorf("can't read recording, error: %s", err.Error())
+		}
+		if exchange.Request == nil {
+			return nil, errors.New("can't read recording, error: exchange without request")
+		}
+		key := replayKey{nodeID: exchange.NodeID, messageType: exchange.Request.GetMessageType()}
+		t.exchanges[key] = append(t.exchanges[key], exchange)
+	}
+	return t, nil
+}
+
+// Send implements Transport
+func (t *ReplayTransport) Send(ctx context.Context, address string, message *fcrmessages.FCRMessage) (*fcrmessages.FCRMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	key := replayKey{nodeID: NodeIDFromContext(ctx), messageType: message.GetMessageType()}
+	t.lock.Lock()
+	exchanges := t.exchanges[key]
+	match := -1
+	for i := range exchanges {
+		if bytes.Equal(exchanges[i].Request.GetMessageBody(), message.GetMessageBody()) {
+			match = i
+			break
+		}
+	}
+	if match < 0 {
+		t.lock.Unlock()
+		return nil, fmt.Errorf("no recorded exchange left matching the message of type %d to node %s", key.messageType, key.nodeID)
+	}
+	exchange := exchanges[match]
+	t.exchanges[key] = append(exchanges[:match:match], exchanges[match+1:]...)
+	t.lock.Unlock()
+
+	if exchange.Error != "" {
+		return nil, errors.New(exchange.Error)
+	}
+	return copyMessage(exchange.Response)
+}
+
+// Remaining returns the number of recorded exchanges not replayed yet, so that tests can check every recorded
+// exchange took place.
+func (t *ReplayTransport) Remaining() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	res := 0
+	for _, exchanges := range t.exchanges {
+		res += len(exchanges)
+	}
+	return res
+}
@@ -0,0 +1,127 @@
+package clientapi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/fcrlogger"
+)
+
+// testMessage returns an establishment request of a node, told apart from the others by its challenge.
+func testMessage(t *testing.T, nodeID *nodeid.NodeID, challenge string) *fcrmessages.FCRMessage {
+	t.Helper()
+	message, err := fcrmessages.EncodeClientEstablishmentRequest(nodeID, challenge, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+// echoTransport returns an in-memory transport answering at address with the request it receives, failing the
+// requests with challenge "fail".
+func echoTransport(t *testing.T, address string, nodeID *nodeid.NodeID) *InMemoryTransport {
+	t.Helper()
+	failing := testMessage(t, nodeID, "fail")
+	transport := NewInMemoryTransport()
+	transport.Handle(address, func(ctx context.Context, message *fcrmessages.FCRMessage) (*fcrmessages.FCRMessage, error) {
+		if bytes.Equal(message.GetMessageBody(), failing.GetMessageBody()) {
+			return nil, errors.New("node failure")
+		}
+		return message, nil
+	})
+	return transport
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	address := "gateway:9012"
+	node := nodeid.NewRandomNodeID()
+	ctx := ContextWithNodeID(context.Background(), node.ToString())
+	first, second, failing := testMessage(t, node, "first"), testMessage(t, node, "second"), testMessage(t, node, "fail")
+
+	recording, err := NewRecordingTransport(echoTransport(t, address, node), path, fcrlogger.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range []*fcrmessages.FCRMessage{first, second, failing} {
+		_, _ = recording.Send(ctx, address, message)
+	}
+	if err := recording.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := NewReplayTransport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replay.Remaining() != 3 {
+		t.Fatalf("expected 3 recorded exchanges, got %d", replay.Remaining())
+	}
+	if _, err := replay.Send(ctx, address, testMessage(t, node, "unrecorded")); err == nil {
+		t.Fatal("message with a body never recorded answered")
+	}
+	if _, err := replay.Send(ContextWithNodeID(context.Background(), nodeid.NewRandomNodeID().ToString()), address, first); err == nil {
+		t.Fatal("message to another node answered")
+	}
+	// Responses are found by request body, whatever the order the requests are sent in
+	for _, message := range []*fcrmessages.FCRMessage{second, first} {
+		response, err := replay.Send(ctx, address, message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(response.GetMessageBody(), message.GetMessageBody()) {
+			t.Fatal("replayed the response to another request")
+		}
+	}
+	if _, err := replay.Send(ctx, address, failing); err == nil || err.Error() != "node failure" {
+		t.Fatalf("expected the recorded error, got %v", err)
+	}
+	if _, err := replay.Send(ctx, address, first); err == nil {
+		t.Fatal("exchange replayed twice")
+	}
+	if replay.Remaining() != 0 {
+		t.Fatalf("expected every exchange replayed, %d left", replay.Remaining())
+	}
+}
+
+func TestRecordingFailureReturnsResponse(t *testing.T) {
+	address := "gateway:9012"
+	node := nodeid.NewRandomNodeID()
+	recording, err := NewRecordingTransport(echoTransport(t, address, node), filepath.Join(t.TempDir(), "recording.jsonl"), fcrlogger.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Closing the file makes every recording fail, after the message is sent
+	if err := recording.Close(); err != nil {
+		t.Fatal(err)
+	}
+	message := testMessage(t, node, "first")
+	response, err := recording.Send(ContextWithNodeID(context.Background(), node.ToString()), address, message)
+	if err != nil {
+		t.Fatalf("recording failure returned as the error of the exchange: %s", err.Error())
+	}
+	if response == nil || !bytes.Equal(response.GetMessageBody(), message.GetMessageBody()) {
+		t.Fatal("response of the node not returned")
+	}
+}
@@ -16,18 +16,18 @@ package clientapi
  */
 
 import (
+	"context"
 	"errors"
-	"fmt"
 
 	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
-	"github.com/ConsenSys/fc-retrieval-common/pkg/logging"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
 )
 
 // RequestStandardDiscoverOffer requests a standard discover to a given gateway for a given contentID, nonce and ttl.
 func (c *Client) RequestStandardDiscoverOffer(
+	ctx context.Context,
 	gatewayRegistrar register.GatewayRegistrar,
 	contentID *cid.ContentID,
 	nonce int64,
@@ -39,12 +39,12 @@ func (c *Client) RequestStandardDiscoverOffer(
 	// Construct request
 	request, err := fcrmessages.EncodeClientStandardDiscoverOfferRequest(contentID, nonce, ttl, offerDigests, paychAddr, voucher)
 	if err != nil {
-		logging.Error("Error encoding Client Standard Discover Request: %+v", err)
+		c.logger.Error("Error encoding Client Standard Discover Offer Request", "error", err)
 		return nil, err
 	}
 
 	// Send request and get response
-	response, err := c.httpCommunicator.SendMessage(gatewayRegistrar.GetNetworkInfoClient(), request)
+	response, err := c.sendMessage(ctx, gatewayRegistrar, request)
 	if err != nil {
 		return nil, err
 	}
@@ -72,7 +72,7 @@ func (c *Client) RequestStandardDiscoverOffer(
 		return nil, errors.New("nonce mismatch")
 	}
 	if paymentRequired {
-		return nil, fmt.Errorf("payment required, in order to proceed topup your balance for payment channel address: %d", paymentChannelAddrToTopup)
+		return nil, NewPaymentRequiredError(gatewayRegistrar.GetNodeID(), paymentChannelAddrToTopup)
 	}
 
 	return offers, nil
@@ -16,17 +16,18 @@ package clientapi
  */
 
 import (
+	"context"
 	"errors"
 
 	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
-	"github.com/ConsenSys/fc-retrieval-common/pkg/logging"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
 )
 
 // RequestStandardDiscover requests a standard discover to a given gateway for a given contentID, nonce and ttl.
 func (c *Client) RequestStandardDiscover(
+	ctx context.Context,
 	gatewayRegistrar register.GatewayRegistrar,
 	contentID *cid.ContentID,
 	nonce int64,
@@ -37,12 +38,12 @@ func (c *Client) RequestStandardDiscover(
 	// Construct request
 	request, err := fcrmessages.EncodeClientStandardDiscoverRequest(contentID, nonce, ttl, paychAddr, voucher)
 	if err != nil {
-		logging.Error("Error encoding Client Standard Discover Request: %+v", err)
+		c.logger.Error("Error encoding Client Standard Discover Request", "error", err)
 		return nil, err
 	}
 
 	// Send request and get response
-	response, err := c.httpCommunicator.SendMessage(gatewayRegistrar.GetNetworkInfoClient(), request)
+	response, err := c.sendMessage(ctx, gatewayRegistrar, request)
 	if err != nil {
 		return nil, err
 	}
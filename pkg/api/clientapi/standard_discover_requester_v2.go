@@ -16,6 +16,7 @@ package clientapi
  */
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
@@ -26,6 +27,7 @@ import (
 
 // RequestStandardDiscoverV2 requests a standard discover to a given gateway for a given contentID, nonce and ttl.
 func (c *Client) RequestStandardDiscoverV2(
+	ctx context.Context,
 	gatewayRegistrar register.GatewayRegistrar,
 	contentID *cid.ContentID,
 	nonce int64,
@@ -41,7 +43,7 @@ func (c *Client) RequestStandardDiscoverV2(
 	}
 
 	// Send request and get response
-	response, err := c.httpCommunicator.SendMessage(gatewayRegistrar.GetNetworkInfoClient(), request)
+	response, err := c.sendMessage(ctx, gatewayRegistrar, request)
 	if err != nil {
 		return nil, fmt.Errorf("error sending message to gateway ID: %s, error: %s", gatewayRegistrar.GetNodeID(), err.Error())
 	}
@@ -69,7 +71,7 @@ func (c *Client) RequestStandardDiscoverV2(
 		return nil, fmt.Errorf("error validating nonce for Client Standard Discover Response for gateway ID: %s; expected nonce: %d, actual nonce: %d", gatewayRegistrar.GetNodeID(), nonce, nonceRecv)
 	}
 	if paymentRequired {
-		return nil, fmt.Errorf("payment required, in order to proceed topup your balance for payment channel address: %d", paymentChannelAddrToTopup)
+		return nil, NewPaymentRequiredError(gatewayRegistrar.GetNodeID(), paymentChannelAddrToTopup)
 	}
 
 	return offerDigests, nil
@@ -0,0 +1,170 @@
+package clientapi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+)
+
+// TLSOptions - the TLS configuration of a TLS transport
+type TLSOptions struct {
+	// RootCAs are the certificate authorities the certificates of the nodes must chain to. The system pool is used if nil.
+	RootCAs *x509.CertPool
+	// Certificates are the client certificates presented to the nodes requiring mutual TLS.
+	Certificates []tls.Certificate
+	// Pins are the public keys the nodes must present, by node ID. No key is pinned if nil.
+	Pins *CertificatePins
+}
+
+// NewTLSTransport creates an HTTPS transport keeping a pool of connections to each node, HTTP/2 being negotiated
+// with the nodes supporting it. The certificate of a node must be valid for the host of its client network info
+// and, if keys are pinned for its node ID, carry one of them. With pins, each node ID has its own connections,
+// whose pins are verified during the TLS handshake, before any request is sent: a connection verified for a
+// node is never used for another node at the same address.
+func NewTLSTransport(options TLSOptions, pool PoolOptions) (*HTTPTransport, error) {
+	if pool.H2C {
+		return nil, errors.New("cleartext HTTP/2 can not be used with TLS")
+	}
+	pool = pool.withDefaults()
+	config := &tls.Config{
+		RootCAs:      options.RootCAs,
+		Certificates: options.Certificates,
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	newClient := func(config *tls.Config) *http.Client {
+		roundTripper := newPooledTransport(pool)
+		roundTripper.TLSClientConfig = config
+		return &http.Client{Transport: roundTripper, Timeout: pool.Timeout}
+	}
+	transport := &HTTPTransport{client: newClient(config), scheme: "https"}
+	if options.Pins != nil {
+		pins := options.Pins
+		transport.nodeClients = newNodeClients(func(nodeID string) *http.Client {
+			nodeConfig := config.Clone()
+			nodeConfig.VerifyConnection = func(state tls.ConnectionState) error {
+				return pins.Verify(nodeID, state.PeerCertificates)
+			}
+			return newClient(nodeConfig)
+		})
+	}
+	return transport, nil
+}
+
+// nodeClients - an http client per node ID, so that connections are never shared between nodes
+type nodeClients struct {
+	newClient func(nodeID string) *http.Client
+	clients   map[string]*http.Client
+	lock      sync.Mutex
+}
+
+// newNodeClients creates the clients of the nodes, created with newClient on first use.
+func newNodeClients(newClient func(nodeID string) *http.Client) *nodeClients {
+	return &nodeClients{newClient: newClient, clients: make(map[string]*http.Client)}
+}
+
+// get returns the client of a node.
+func (n *nodeClients) get(nodeID string) *http.Client {
+	nodeID = strings.ToLower(nodeID)
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	client, exists := n.clients[nodeID]
+	if !exists {
+		client = n.newClient(nodeID)
+		n.clients[nodeID] = client
+	}
+	return client
+}
+
+// CertificatePins holds the public keys pinned for each node ID, as the SHA-256 hashes of their DER encoded
+// SubjectPublicKeyInfo. A node with pinned keys must present a certificate chain containing one of them.
+// Register entries carry neither a TLS certificate nor a key hash, so pins can not be derived from the registered
+// identity of a node: they are obtained out of band, for instance from the operator of the node, and added by hand.
+type CertificatePins struct {
+	// RequirePins rejects the nodes with no pinned key.
+	RequirePins bool
+
+	pins map[string]map[string]bool
+	lock sync.RWMutex
+}
+
+// NewCertificatePins creates an empty set of pins.
+func NewCertificatePins() *CertificatePins {
+	return &CertificatePins{pins: make(map[string]map[string]bool)}
+}
+
+// PublicKeyHash returns the SHA-256 hash of the SubjectPublicKeyInfo of a certificate, as pinned by CertificatePins.
+func PublicKeyHash(certificate *x509.Certificate) []byte {
+	hash := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+	return hash[:]
+}
+
+// Pin adds a public key hash to the keys pinned for a node.
+func (p *CertificatePins) Pin(nodeID *nodeid.NodeID, publicKeyHash []byte) error {
+	if len(publicKeyHash) != sha256.Size {
+		return fmt.Errorf("public key hash is %d bytes, expected %d", len(publicKeyHash), sha256.Size)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	id := nodeID.ToString()
+	if p.pins[id] == nil {
+		p.pins[id] = make(map[string]bool)
+	}
+	p.pins[id][hex.EncodeToString(publicKeyHash)] = true
+	return nil
+}
+
+// PinCertificate adds the public key of a certificate to the keys pinned for a node.
+func (p *CertificatePins) PinCertificate(nodeID *nodeid.NodeID, certificate *x509.Certificate) error {
+	return p.Pin(nodeID, PublicKeyHash(certificate))
+}
+
+// Verify checks the certificate chain presented by a node carries one of the keys pinned for it.
+func (p *CertificatePins) Verify(nodeID string, certificates []*x509.Certificate) error {
+	id := strings.ToLower(nodeID)
+	if nodeID != "" {
+		parsed, err := nodeid.NewNodeIDFromHexString(nodeID)
+		if err != nil {
+			return fmt.Errorf("invalid node id: %s", err.Error())
+		}
+		id = parsed.ToString()
+	}
+	p.lock.RLock()
+	pinned := p.pins[id]
+	p.lock.RUnlock()
+	if len(pinned) == 0 {
+		if p.RequirePins {
+			return fmt.Errorf("no public key pinned for node %s", nodeID)
+		}
+		return nil
+	}
+	for _, certificate := range certificates {
+		if pinned[hex.EncodeToString(PublicKeyHash(certificate))] {
+			return nil
+		}
+	}
+	return fmt.Errorf("certificate of node %s does not match the pinned public keys", nodeID)
+}
@@ -0,0 +1,140 @@
+package clientapi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+)
+
+// newTLSTestServer starts a TLS server answering every message with an establishment request, counting the
+// requests it received.
+func newTLSTestServer(t *testing.T, received *int32) (*httptest.Server, string) {
+	t.Helper()
+	response, err := fcrmessages.EncodeClientEstablishmentRequest(nodeid.NewRandomNodeID(), "challenge", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(received, 1)
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server, strings.TrimPrefix(server.URL, "https://")
+}
+
+// newPinnedTransport creates a TLS transport trusting the certificate of server, with the given pins.
+func newPinnedTransport(t *testing.T, server *httptest.Server, pins *CertificatePins) *HTTPTransport {
+	t.Helper()
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	transport, err := NewTLSTransport(TLSOptions{RootCAs: roots, Pins: pins}, PoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return transport
+}
+
+// sendTo sends a message to the node at address with transport.
+func sendTo(transport Transport, nodeID *nodeid.NodeID, address string) error {
+	message, err := fcrmessages.EncodeClientEstablishmentRequest(nodeID, "challenge", 1)
+	if err != nil {
+		return err
+	}
+	_, err = transport.Send(ContextWithNodeID(context.Background(), nodeID.ToString()), address, message)
+	return err
+}
+
+func TestPinnedTransportAcceptsPinnedKey(t *testing.T) {
+	var received int32
+	server, address := newTLSTestServer(t, &received)
+	pins := NewCertificatePins()
+	node := nodeid.NewRandomNodeID()
+	if err := pins.PinCertificate(node, server.Certificate()); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendTo(newPinnedTransport(t, server, pins), node, address); err != nil {
+		t.Fatalf("pinned node rejected: %s", err.Error())
+	}
+	if atomic.LoadInt32(&received) != 1 {
+		t.Fatalf("server received %d requests, expected 1", received)
+	}
+}
+
+func TestPinMismatchSendsNothing(t *testing.T) {
+	var received int32
+	server, address := newTLSTestServer(t, &received)
+	pins := NewCertificatePins()
+	node := nodeid.NewRandomNodeID()
+	wrongHash := sha256.Sum256([]byte("another key"))
+	if err := pins.Pin(node, wrongHash[:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendTo(newPinnedTransport(t, server, pins), node, address); err == nil {
+		t.Fatal("node with another key than the pinned one accepted")
+	}
+	if atomic.LoadInt32(&received) != 0 {
+		t.Fatal("message sent to a node failing its pins")
+	}
+}
+
+func TestPinnedConnectionsNotSharedBetweenNodes(t *testing.T) {
+	var received int32
+	server, address := newTLSTestServer(t, &received)
+	pins := NewCertificatePins()
+	pinned, other := nodeid.NewRandomNodeID(), nodeid.NewRandomNodeID()
+	if err := pins.PinCertificate(pinned, server.Certificate()); err != nil {
+		t.Fatal(err)
+	}
+	wrongHash := sha256.Sum256([]byte("another key"))
+	if err := pins.Pin(other, wrongHash[:]); err != nil {
+		t.Fatal(err)
+	}
+	transport := newPinnedTransport(t, server, pins)
+	if err := sendTo(transport, pinned, address); err != nil {
+		t.Fatal(err)
+	}
+	// The connection verified for the first node is open, and must not carry the message of the second node
+	if err := sendTo(transport, other, address); err == nil {
+		t.Fatal("connection of a node reused for another node at the same address")
+	}
+	if atomic.LoadInt32(&received) != 1 {
+		t.Fatalf("server received %d requests, expected 1", received)
+	}
+}
+
+func TestRequirePins(t *testing.T) {
+	var received int32
+	server, address := newTLSTestServer(t, &received)
+	pins := NewCertificatePins()
+	pins.RequirePins = true
+	if err := sendTo(newPinnedTransport(t, server, pins), nodeid.NewRandomNodeID(), address); err == nil {
+		t.Fatal("node with no pinned key accepted although pins are required")
+	}
+	if atomic.LoadInt32(&received) != 0 {
+		t.Fatal("message sent to a node with no pinned key")
+	}
+}
@@ -0,0 +1,148 @@
+package clientapi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the OpenTelemetry tracer used by the client api.
+const TracerName = "github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi"
+
+// Span attribute keys
+const (
+	AttributeCID            = attribute.Key("fcr.cid")
+	AttributeGatewayID      = attribute.Key("fcr.gateway_id")
+	AttributeProviderID     = attribute.Key("fcr.provider_id")
+	AttributeNumDHT         = attribute.Key("fcr.num_dht")
+	AttributeNumOffers      = attribute.Key("fcr.num_offers")
+	AttributePaymentChannel = attribute.Key("fcr.payment_channel")
+)
+
+// tracedClientApi is a ClientApi recording an OpenTelemetry span around every request.
+type tracedClientApi struct {
+	clientApi ClientApi
+}
+
+// newTracedClientApi wraps a client api so that every request is traced.
+func newTracedClientApi(clientApi ClientApi) ClientApi {
+	return &tracedClientApi{clientApi: clientApi}
+}
+
+// startSpan starts a client api span.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(TracerName).Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// endSpan records the error, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t *tracedClientApi) RequestDHTOfferDiscover(ctx context.Context, gatewayInfo register.GatewayRegistrar, gatewayIDs []nodeid.NodeID, contentID *cid.ContentID, nonce int64, offersDigests [][][cidoffer.CIDOfferDigestSize]byte, paymentChannelAddr string, voucher string) ([]GatewaySubOffers, error) {
+	ctx, span := startSpan(ctx, "clientapi.RequestDHTOfferDiscover",
+		AttributeGatewayID.String(gatewayInfo.GetNodeID()),
+		AttributeCID.String(contentID.ToString()),
+		AttributePaymentChannel.String(paymentChannelAddr))
+	res, err := t.clientApi.RequestDHTOfferDiscover(ctx, gatewayInfo, gatewayIDs, contentID, nonce, offersDigests, paymentChannelAddr, voucher)
+	endSpan(span, err)
+	return res, err
+}
+
+func (t *tracedClientApi) RequestDHTDiscover(ctx context.Context, gatewayInfo register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, numDHT int64, incrementalResult bool, paychAddr string, voucher string) ([]nodeid.NodeID, []fcrmessages.FCRMessage, []nodeid.NodeID, error) {
+	ctx, span := startSpan(ctx, "clientapi.RequestDHTDiscover",
+		AttributeGatewayID.String(gatewayInfo.GetNodeID()),
+		AttributeCID.String(contentID.ToString()),
+		AttributeNumDHT.Int64(numDHT),
+		AttributePaymentChannel.String(paychAddr))
+	contacted, contactedResp, uncontactable, err := t.clientApi.RequestDHTDiscover(ctx, gatewayInfo, contentID, nonce, ttl, numDHT, incrementalResult, paychAddr, voucher)
+	endSpan(span, err)
+	return contacted, contactedResp, uncontactable, err
+}
+
+func (t *tracedClientApi) RequestDHTDiscoverV2(ctx context.Context, gatewayInfo register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, numDHT int64, incrementalResult bool, paychAddr string, voucher string) ([]nodeid.NodeID, []fcrmessages.FCRMessage, []nodeid.NodeID, error) {
+	ctx, span := startSpan(ctx, "clientapi.RequestDHTDiscoverV2",
+		AttributeGatewayID.String(gatewayInfo.GetNodeID()),
+		AttributeCID.String(contentID.ToString()),
+		AttributeNumDHT.Int64(numDHT),
+		AttributePaymentChannel.String(paychAddr))
+	contacted, contactedResp, uncontactable, err := t.clientApi.RequestDHTDiscoverV2(ctx, gatewayInfo, contentID, nonce, ttl, numDHT, incrementalResult, paychAddr, voucher)
+	endSpan(span, err)
+	return contacted, contactedResp, uncontactable, err
+}
+
+func (t *tracedClientApi) RequestDHTOfferAck(ctx context.Context, providerInfo register.ProviderRegistrar, contentID *cid.ContentID, gatewayID *nodeid.NodeID) (bool, *fcrmessages.FCRMessage, *fcrmessages.FCRMessage, error) {
+	ctx, span := startSpan(ctx, "clientapi.RequestDHTOfferAck",
+		AttributeProviderID.String(providerInfo.GetNodeID()),
+		AttributeGatewayID.String(gatewayID.ToString()),
+		AttributeCID.String(contentID.ToString()))
+	found, request, ack, err := t.clientApi.RequestDHTOfferAck(ctx, providerInfo, contentID, gatewayID)
+	endSpan(span, err)
+	return found, request, ack, err
+}
+
+func (t *tracedClientApi) RequestEstablishment(ctx context.Context, gatewayInfo register.GatewayRegistrar, challenge []byte, clientID *nodeid.NodeID, ttl int64) error {
+	ctx, span := startSpan(ctx, "clientapi.RequestEstablishment",
+		AttributeGatewayID.String(gatewayInfo.GetNodeID()))
+	err := t.clientApi.RequestEstablishment(ctx, gatewayInfo, challenge, clientID, ttl)
+	endSpan(span, err)
+	return err
+}
+
+func (t *tracedClientApi) RequestStandardDiscoverOffer(ctx context.Context, gatewayInfo register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, offerDigests [][cidoffer.CIDOfferDigestSize]byte, paychAddr string, voucher string) ([]cidoffer.SubCIDOffer, error) {
+	ctx, span := startSpan(ctx, "clientapi.RequestStandardDiscoverOffer",
+		AttributeGatewayID.String(gatewayInfo.GetNodeID()),
+		AttributeCID.String(contentID.ToString()),
+		AttributeNumOffers.Int(len(offerDigests)),
+		AttributePaymentChannel.String(paychAddr))
+	offers, err := t.clientApi.RequestStandardDiscoverOffer(ctx, gatewayInfo, contentID, nonce, ttl, offerDigests, paychAddr, voucher)
+	endSpan(span, err)
+	return offers, err
+}
+
+func (t *tracedClientApi) RequestStandardDiscover(ctx context.Context, gatewayInfo register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, paychAddr string, voucher string) ([]cidoffer.SubCIDOffer, error) {
+	ctx, span := startSpan(ctx, "clientapi.RequestStandardDiscover",
+		AttributeGatewayID.String(gatewayInfo.GetNodeID()),
+		AttributeCID.String(contentID.ToString()),
+		AttributePaymentChannel.String(paychAddr))
+	offers, err := t.clientApi.RequestStandardDiscover(ctx, gatewayInfo, contentID, nonce, ttl, paychAddr, voucher)
+	endSpan(span, err)
+	return offers, err
+}
+
+func (t *tracedClientApi) RequestStandardDiscoverV2(ctx context.Context, gatewayInfo register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, paychAddr string, voucher string) ([][cidoffer.CIDOfferDigestSize]byte, error) {
+	ctx, span := startSpan(ctx, "clientapi.RequestStandardDiscoverV2",
+		AttributeGatewayID.String(gatewayInfo.GetNodeID()),
+		AttributeCID.String(contentID.ToString()),
+		AttributePaymentChannel.String(paychAddr))
+	digests, err := t.clientApi.RequestStandardDiscoverV2(ctx, gatewayInfo, contentID, nonce, ttl, paychAddr, voucher)
+	endSpan(span, err)
+	return digests, err
+}
@@ -0,0 +1,311 @@
+package clientapi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/request"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"golang.org/x/net/http2"
+)
+
+// Transport sends a message to a gateway or a provider and returns its response.
+// address is the client network info of the node, as found in its register entry.
+type Transport interface {
+	Send(ctx context.Context, address string, message *fcrmessages.FCRMessage) (*fcrmessages.FCRMessage, error)
+}
+
+// nodeIDKey - the context key of the node ID a message is sent to
+type nodeIDKey struct{}
+
+// ContextWithNodeID returns a context carrying the ID of the node a message is sent to.
+func ContextWithNodeID(ctx context.Context, nodeID string) context.Context {
+	return context.WithValue(ctx, nodeIDKey{}, nodeID)
+}
+
+// NodeIDFromContext returns the ID of the node a message is sent to, empty if ctx does not carry it.
+// The client api sets it on the context given to Transport.Send.
+func NodeIDFromContext(ctx context.Context) string {
+	nodeID, _ := ctx.Value(nodeIDKey{}).(string)
+	return nodeID
+}
+
+// HTTPTransport sends messages as JSON over HTTP or HTTPS, to the /v1 endpoint of the node.
+// The trace context is propagated in the request headers.
+type HTTPTransport struct {
+	client *http.Client
+	scheme string
+	// nodeClients, if set, are the clients used in place of client for each node
+	nodeClients *nodeClients
+}
+
+// NewHTTPTransport creates an HTTP transport using the given http client.
+// A client with the default timeout is used if httpClient is nil.
+func NewHTTPTransport(httpClient *http.Client) *HTTPTransport {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultHTTPTimeout}
+	}
+	return &HTTPTransport{client: httpClient, scheme: "http"}
+}
+
+// PoolOptions - the connection pool of an HTTP/2 transport. Zero values take the defaults.
+type PoolOptions struct {
+	// MaxIdleConnsPerHost is the number of idle connections kept per node.
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost limits the connections per node, 0 meaning no limit.
+	MaxConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept.
+	IdleConnTimeout time.Duration
+	// Timeout is the timeout of a request.
+	Timeout time.Duration
+	// H2C uses HTTP/2 without TLS, with prior knowledge: the nodes must accept cleartext HTTP/2.
+	H2C bool
+}
+
+// NewHTTP2Transport creates an HTTP transport keeping a pool of connections to each node.
+// Without H2C, HTTP/2 is negotiated with TLS, and HTTP/1.1 connections are kept alive otherwise.
+func NewHTTP2Transport(options PoolOptions) *HTTPTransport {
+	options = options.withDefaults()
+	if options.H2C {
+		roundTripper := &http2.Transport{AllowHTTP: true}
+		roundTripper.ConnPool = newH2CConnPool(roundTripper, newDialer().DialContext)
+		return &HTTPTransport{client: &http.Client{Transport: roundTripper, Timeout: options.Timeout}, scheme: "http"}
+	}
+	return &HTTPTransport{client: &http.Client{Transport: newPooledTransport(options), Timeout: options.Timeout}, scheme: "http"}
+}
+
+// withDefaults returns the pool options with the defaults in place of the zero values.
+func (options PoolOptions) withDefaults() PoolOptions {
+	if options.MaxIdleConnsPerHost <= 0 {
+		options.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	if options.IdleConnTimeout <= 0 {
+		options.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = defaultHTTPTimeout
+	}
+	return options
+}
+
+// newPooledTransport creates an http transport keeping a pool of connections to each node.
+func newPooledTransport(options PoolOptions) *http.Transport {
+	return &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         newDialer().DialContext,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        options.MaxIdleConnsPerHost * 16,
+		MaxIdleConnsPerHost: options.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     options.MaxConnsPerHost,
+		IdleConnTimeout:     options.IdleConnTimeout,
+	}
+}
+
+// newDialer creates the dialer of the connections to the nodes.
+func newDialer() *net.Dialer {
+	return &net.Dialer{Timeout: defaultDialTimeout, KeepAlive: defaultKeepAlive}
+}
+
+// h2cConnPool - the connections of an H2C transport, dialled with the context of the request needing them.
+// The connection pool of http2 dials without a context, so a cancelled request would still wait for its dial.
+type h2cConnPool struct {
+	transport *http2.Transport
+	dial      func(ctx context.Context, network string, addr string) (net.Conn, error)
+	lock      sync.Mutex
+	conns     map[string][]*http2.ClientConn
+}
+
+// newH2CConnPool creates the connection pool of transport, dialling with dial.
+func newH2CConnPool(transport *http2.Transport, dial func(ctx context.Context, network string, addr string) (net.Conn, error)) *h2cConnPool {
+	return &h2cConnPool{transport: transport, dial: dial, conns: make(map[string][]*http2.ClientConn)}
+}
+
+// GetClientConn implements http2.ClientConnPool
+func (p *h2cConnPool) GetClientConn(req *http.Request, addr string) (*http2.ClientConn, error) {
+	p.lock.Lock()
+	for _, cc := range p.conns[addr] {
+		if cc.CanTakeNewRequest() {
+			p.lock.Unlock()
+			return cc, nil
+		}
+	}
+	p.lock.Unlock()
+
+	conn, err := p.dial(req.Context(), "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	cc, err := p.transport.NewClientConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	p.lock.Lock()
+	p.conns[addr] = append(p.conns[addr], cc)
+	p.lock.Unlock()
+	return cc, nil
+}
+
+// MarkDead implements http2.ClientConnPool
+func (p *h2cConnPool) MarkDead(dead *http2.ClientConn) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for addr, conns := range p.conns {
+		for i, cc := range conns {
+			if cc == dead {
+				conns = append(conns[:i], conns[i+1:]...)
+				break
+			}
+		}
+		if len(conns) == 0 {
+			delete(p.conns, addr)
+		} else {
+			p.conns[addr] = conns
+		}
+	}
+}
+
+// Send implements Transport
+func (t *HTTPTransport) Send(ctx context.Context, address string, message *fcrmessages.FCRMessage) (*fcrmessages.FCRMessage, error) {
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("can't marshal message, error: %s", err.Error())
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.scheme+"://"+address+"/v1", bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("can't create request, error: %s", err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	client := t.client
+	if t.nodeClients != nil {
+		client = t.nodeClients.get(NodeIDFromContext(ctx))
+	}
+	r, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("can't read response body, error: %s", err.Error())
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, errors.New("receive error code: " + r.Status)
+	}
+	var response fcrmessages.FCRMessage
+	if err := json.Unmarshal(bodyBytes, &response); err != nil {
+		return nil, errors.New("can't unmarshal response body")
+	}
+	return &response, nil
+}
+
+// communicatorTransport sends messages with an http communicator of the common library.
+// The communicator does not take a context, so neither cancellation nor the trace context are propagated.
+type communicatorTransport struct {
+	communicator request.HttpCommunications
+}
+
+// NewCommunicatorTransport creates a transport sending messages with the given http communicator.
+func NewCommunicatorTransport(communicator request.HttpCommunications) Transport {
+	return &communicatorTransport{communicator: communicator}
+}
+
+// Send implements Transport
+func (t *communicatorTransport) Send(ctx context.Context, address string, message *fcrmessages.FCRMessage) (*fcrmessages.FCRMessage, error) {
+	return t.communicator.SendMessage(address, message)
+}
+
+// Handler answers the messages sent to a node of an in-memory transport.
+type Handler func(ctx context.Context, message *fcrmessages.FCRMessage) (*fcrmessages.FCRMessage, error)
+
+// InMemoryTransport delivers messages to handlers registered by address, without any network.
+// Messages go through a JSON round trip, as they would over the network, so handlers never share them with the caller.
+type InMemoryTransport struct {
+	handlers map[string]Handler
+	lock     sync.RWMutex
+}
+
+// NewInMemoryTransport creates an in-memory transport with no handler.
+func NewInMemoryTransport() *InMemoryTransport {
+	return &InMemoryTransport{handlers: make(map[string]Handler)}
+}
+
+// Handle registers the handler of the messages sent to an address, replacing the existing one if any.
+func (t *InMemoryTransport) Handle(address string, handler Handler) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.handlers[address] = handler
+}
+
+// Remove removes the handler of an address.
+func (t *InMemoryTransport) Remove(address string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.handlers, address)
+}
+
+// Send implements Transport
+func (t *InMemoryTransport) Send(ctx context.Context, address string, message *fcrmessages.FCRMessage) (*fcrmessages.FCRMessage, error) {
+	t.lock.RLock()
+	handler, exists := t.handlers[address]
+	t.lock.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("no handler for address %s", address)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	request, err := copyMessage(message)
+	if err != nil {
+		return nil, err
+	}
+	response, err := handler(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return copyMessage(response)
+}
+
+// copyMessage copies a message through its JSON encoding.
+func copyMessage(message *fcrmessages.FCRMessage) (*fcrmessages.FCRMessage, error) {
+	if message == nil {
+		return nil, errors.New("missing message")
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("can't marshal message, error: %s", err.Error())
+	}
+	var res fcrmessages.FCRMessage
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("can't unmarshal message, error: %s", err.Error())
+	}
+	return &res, nil
+}
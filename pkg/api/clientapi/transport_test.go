@@ -0,0 +1,175 @@
+package clientapi
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// newH2CTestServer starts a cleartext HTTP/2 server answering every message with an establishment request,
+// counting the HTTP/2 requests it received.
+func newH2CTestServer(t *testing.T, received *int32) string {
+	t.Helper()
+	response, err := fcrmessages.EncodeClientEstablishmentRequest(nodeid.NewRandomNodeID(), "challenge", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 {
+			atomic.AddInt32(received, 1)
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}), &http2.Server{}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// contextKey - the key of the value identifying the context of a request in the tests
+type contextKey struct{}
+
+func TestH2CTransportReusesConnections(t *testing.T) {
+	var received, dials int32
+	address := newH2CTestServer(t, &received)
+	transport := NewHTTP2Transport(PoolOptions{H2C: true})
+	pool := transport.client.Transport.(*http2.Transport).ConnPool.(*h2cConnPool)
+	dial := pool.dial
+	pool.dial = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		return dial(ctx, network, addr)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := sendTo(transport, nodeid.NewRandomNodeID(), address); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if received != 3 {
+		t.Fatalf("expected 3 HTTP/2 requests, got %d", received)
+	}
+	if dials != 1 {
+		t.Fatalf("expected one connection for every request, got %d", dials)
+	}
+}
+
+func TestH2CTransportDialsWithRequestContext(t *testing.T) {
+	var received int32
+	address := newH2CTestServer(t, &received)
+	transport := NewHTTP2Transport(PoolOptions{H2C: true})
+	pool := transport.client.Transport.(*http2.Transport).ConnPool.(*h2cConnPool)
+	var dialled interface{}
+	pool.dial = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		dialled = ctx.Value(contextKey{})
+		return nil, ctx.Err()
+	}
+
+	message, err := fcrmessages.EncodeClientEstablishmentRequest(nodeid.NewRandomNodeID(), "challenge", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), contextKey{}, "request"))
+	cancel()
+	if _, err := transport.Send(ctx, address, message); err == nil {
+		t.Fatal("message sent with a cancelled context")
+	}
+	if dialled != "request" {
+		t.Fatal("connection not dialled with the context of the request")
+	}
+	if received != 0 {
+		t.Fatal("cancelled request received")
+	}
+}
+
+// testStream - a libp2p stream answering a fixed response
+type testStream struct {
+	network.Stream
+	written     bytes.Buffer
+	closedWrite bool
+	response    *bytes.Reader
+}
+
+func (s *testStream) Write(p []byte) (int, error) { return s.written.Write(p) }
+func (s *testStream) Read(p []byte) (int, error)  { return s.response.Read(p) }
+func (s *testStream) CloseWrite() error           { s.closedWrite = true; return nil }
+func (s *testStream) Close() error                { return nil }
+func (s *testStream) Reset() error                { return nil }
+
+// testHost - a libp2p host opening a single test stream
+type testHost struct {
+	stream    *testStream
+	peer      peer.ID
+	protocols []protocol.ID
+}
+
+func (h *testHost) Connect(ctx context.Context, pi peer.AddrInfo) error {
+	h.peer = pi.ID
+	return nil
+}
+
+func (h *testHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	h.protocols = pids
+	return h.stream, nil
+}
+
+func TestLibp2pTransportSendsOneMessagePerStream(t *testing.T) {
+	response, err := fcrmessages.EncodeClientEstablishmentRequest(nodeid.NewRandomNodeID(), "response", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responseData, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := &testHost{stream: &testStream{response: bytes.NewReader(responseData)}}
+	node := peer.ID("node")
+	transport := NewLibp2pTransport(host, func(address string) (peer.AddrInfo, error) {
+		return peer.AddrInfo{ID: node}, nil
+	})
+
+	message, err := fcrmessages.EncodeClientEstablishmentRequest(nodeid.NewRandomNodeID(), "challenge", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := transport.Send(context.Background(), "node", message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host.peer != node || len(host.protocols) != 1 || host.protocols[0] != ClientProtocolID {
+		t.Fatal("stream not opened to the node with the client protocol")
+	}
+	var sent fcrmessages.FCRMessage
+	if err := json.Unmarshal(host.stream.written.Bytes(), &sent); err != nil || !host.stream.closedWrite {
+		t.Fatal("message not written and the stream closed for writing")
+	}
+	if received.GetMessageType() != response.GetMessageType() {
+		t.Fatal("response not read from the stream")
+	}
+}
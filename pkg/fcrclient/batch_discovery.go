@@ -0,0 +1,229 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/dhtring"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// BatchDiscoveryOptions - configuration of a batch discovery
+type BatchDiscoveryOptions struct {
+	// GatewayIDs are the active gateways the CIDs are spread over. All active gateways are used if empty.
+	GatewayIDs []*nodeid.NodeID
+	// MaxOffersPerCID is the maximum number of offers requested for each CID.
+	MaxOffersPerCID int
+	// MaxConcurrentGateways is the maximum number of gateways queried in parallel. Zero means no limit.
+	MaxConcurrentGateways int
+	// RequestsPerSecond is the maximum number of CIDs looked up per second on each gateway. Zero means no limit.
+	RequestsPerSecond float64
+}
+
+// BatchDiscoveryResult - the verified offers found for one CID of a batch discovery,
+// or the error which prevented finding them
+type BatchDiscoveryResult struct {
+	ContentID *cid.ContentID
+	GatewayID *nodeid.NodeID
+	SubOffers []cidoffer.SubCIDOffer
+	Err       error
+}
+
+// NewBatchDiscoveryOptions creates batch discovery options with the default settings.
+func NewBatchDiscoveryOptions() BatchDiscoveryOptions {
+	return BatchDiscoveryOptions{
+		MaxOffersPerCID:       defaultBatchMaxOffersPerCID,
+		MaxConcurrentGateways: defaultBatchMaxConcurrentGateways,
+		RequestsPerSecond:     defaultBatchRequestsPerSecond,
+	}
+}
+
+// FindOffersStandardDiscoveryBatch finds offers for many CIDs using standard discovery.
+// Each CID is looked up on the gateway whose node ID is the closest to it, gateways are queried in parallel
+// and each gateway is rate limited. A standard discovery request carries a single CID, so each CID is paid for
+// separately; when a payment channel needs to be topped up, it is topped up with enough to pay for all the CIDs
+// remaining for that gateway, rather than once per CID.
+// The result maps each CID to its result; a failure for one CID does not affect the others.
+func (c *FilecoinRetrievalClient) FindOffersStandardDiscoveryBatch(contentIDs []*cid.ContentID, options BatchDiscoveryOptions) (map[string]*BatchDiscoveryResult, error) {
+	return c.FindOffersStandardDiscoveryBatchContext(context.Background(), contentIDs, options)
+}
+
+// FindOffersStandardDiscoveryBatchContext is FindOffersStandardDiscoveryBatch with a context: the CIDs not looked up
+// yet when ctx is done fail with the error of ctx, and the batch is traced as a child of the span of ctx.
+func (c *FilecoinRetrievalClient) FindOffersStandardDiscoveryBatchContext(ctx context.Context, contentIDs []*cid.ContentID, options BatchDiscoveryOptions) (map[string]*BatchDiscoveryResult, error) {
+	ctx, span := startSpan(ctx, "fcrclient.FindOffersStandardDiscoveryBatch", attribute.Int("fcr.num_cids", len(contentIDs)))
+	results, err := c.findOffersStandardDiscoveryBatch(ctx, contentIDs, options)
+	endSpan(span, err)
+	return results, err
+}
+
+// findOffersStandardDiscoveryBatch is FindOffersStandardDiscoveryBatch within the given context.
+func (c *FilecoinRetrievalClient) findOffersStandardDiscoveryBatch(ctx context.Context, contentIDs []*cid.ContentID, options BatchDiscoveryOptions) (map[string]*BatchDiscoveryResult, error) {
+	if options.MaxOffersPerCID <= 0 {
+		return nil, errors.New("maximum number of offers per CID must be positive")
+	}
+	gateways := c.batchGateways(options.GatewayIDs)
+	if len(gateways) == 0 {
+		return nil, errors.New("no active gateway to run the batch discovery on")
+	}
+
+	results := make(map[string]*BatchDiscoveryResult)
+	groups, ungrouped := groupCIDsByClosestGateway(contentIDs, gateways)
+	for _, result := range ungrouped {
+		results[result.ContentID.ToString()] = result
+	}
+
+	var resultsLock sync.Mutex
+	var wg sync.WaitGroup
+	var semaphore chan struct{}
+	if options.MaxConcurrentGateways > 0 {
+		semaphore = make(chan struct{}, options.MaxConcurrentGateways)
+	}
+	for gatewayID, group := range groups {
+		wg.Add(1)
+		go func(gw register.GatewayRegistrar, group []*cid.ContentID) {
+			defer wg.Done()
+			if semaphore != nil {
+				semaphore <- struct{}{}
+				defer func() { <-semaphore }()
+			}
+			for _, result := range c.discoverBatchOnGateway(ctx, gw, group, options) {
+				resultsLock.Lock()
+				results[result.ContentID.ToString()] = result
+				resultsLock.Unlock()
+			}
+		}(gateways[gatewayID], group)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// discoverBatchOnGateway looks up the given CIDs one after the other on a gateway, complying with the rate limit.
+func (c *FilecoinRetrievalClient) discoverBatchOnGateway(ctx context.Context, gw register.GatewayRegistrar, contentIDs []*cid.ContentID, options BatchDiscoveryOptions) []*BatchDiscoveryResult {
+	gatewayID, err := nodeid.NewNodeIDFromHexString(gw.GetNodeID())
+	if err != nil {
+		c.logger.Error("Error in generating node id", "gateway_id", gw.GetNodeID())
+	}
+
+	var ticker *time.Ticker
+	if options.RequestsPerSecond > 0 {
+		ticker = time.NewTicker(time.Duration(float64(time.Second) / options.RequestsPerSecond))
+		defer ticker.Stop()
+	}
+
+	results := make([]*BatchDiscoveryResult, 0, len(contentIDs))
+	prices, err := c.gatewayPrices(ctx, gw.GetNodeID())
+	if err != nil {
+		for _, contentID := range contentIDs {
+			results = append(results, &BatchDiscoveryResult{ContentID: contentID, GatewayID: gatewayID, Err: err})
+		}
+		return results
+	}
+	// Expected cost of looking up one CID: the search plus the maximum number of offers.
+	costPerCID := new(big.Int).Mul(big.NewInt(int64(options.MaxOffersPerCID)), prices.OfferPrice)
+	costPerCID.Add(costPerCID, prices.SearchPrice)
+
+	for i, contentID := range contentIDs {
+		if ticker != nil && i > 0 {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			results = append(results, &BatchDiscoveryResult{ContentID: contentID, GatewayID: gatewayID, Err: ctx.Err()})
+			continue
+		}
+		// Everything left in this batch is expected to be spent, for the top up policy.
+		expected := new(big.Int).Mul(big.NewInt(int64(len(contentIDs)-i)), costPerCID)
+		offers, err := c.standardDiscoveryV2(ctx, gw, contentID, options.MaxOffersPerCID, expected)
+		if err != nil {
+			err = fmt.Errorf("error in batch discovery of CID %s on gateway %s: %s", contentID.ToString(), gw.GetNodeID(), err.Error())
+		}
+		results = append(results, &BatchDiscoveryResult{
+			ContentID: contentID,
+			GatewayID: gatewayID,
+			SubOffers: offers,
+			Err:       err,
+		})
+	}
+	return results
+}
+
+// batchGateways returns the registrations of the given active gateways, or of all active gateways if none is given.
+// Gateways whose circuit breaker is open are left out.
+func (c *FilecoinRetrievalClient) batchGateways(gatewayIDs []*nodeid.NodeID) map[string]register.GatewayRegistrar {
+	c.ActiveGatewaysLock.RLock()
+	defer c.ActiveGatewaysLock.RUnlock()
+
+	gateways := make(map[string]register.GatewayRegistrar)
+	if len(gatewayIDs) == 0 {
+		for id, gw := range c.ActiveGateways {
+			if c.circuitOpen(id) {
+				c.logger.Warn("Gateway circuit breaker is open, it is not used for the batch discovery", "gateway_id", id)
+				continue
+			}
+			gateways[id] = gw
+		}
+		return gateways
+	}
+	for _, gatewayID := range gatewayIDs {
+		gw, exists := c.ActiveGateways[gatewayID.ToString()]
+		if !exists {
+			c.logger.Warn("Gateway is not active, it is not used for the batch discovery", "gateway_id", gatewayID.ToString())
+			continue
+		}
+		if c.circuitOpen(gatewayID.ToString()) {
+			c.logger.Warn("Gateway circuit breaker is open, it is not used for the batch discovery", "gateway_id", gatewayID.ToString())
+			continue
+		}
+		gateways[gatewayID.ToString()] = gw
+	}
+	return gateways
+}
+
+// groupCIDsByClosestGateway assigns each CID to the gateway whose node ID is the closest to it on the DHT ring.
+// The CIDs which can't be assigned are returned with the error.
+func groupCIDsByClosestGateway(contentIDs []*cid.ContentID, gateways map[string]register.GatewayRegistrar) (map[string][]*cid.ContentID, []*BatchDiscoveryResult) {
+	ring := dhtring.CreateRing()
+	for id := range gateways {
+		ring.Insert(id)
+	}
+	groups := make(map[string][]*cid.ContentID)
+	ungrouped := make([]*BatchDiscoveryResult, 0)
+	for _, contentID := range contentIDs {
+		closest, err := ring.GetClosest(contentID.ToString(), 1, "")
+		if err != nil || len(closest) == 0 {
+			ungrouped = append(ungrouped, &BatchDiscoveryResult{
+				ContentID: contentID,
+				Err:       fmt.Errorf("error finding the closest gateway to CID %s", contentID.ToString()),
+			})
+			continue
+		}
+		groups[closest[0]] = append(groups[closest[0]], contentID)
+	}
+	return groups, ungrouped
+}
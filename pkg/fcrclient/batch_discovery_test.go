@@ -0,0 +1,103 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+	"github.com/golang/mock/gomock"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi/mocks"
+)
+
+// newBatchTestOptions returns batch discovery options without rate limit.
+func newBatchTestOptions() BatchDiscoveryOptions {
+	options := NewBatchDiscoveryOptions()
+	options.RequestsPerSecond = 0
+	return options
+}
+
+func TestBatchDiscoveryReportsFailuresPerCID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	api := mocks.NewMockClientApi(ctrl)
+	gateway, provider := newTestNode(t), newTestNode(t)
+	c := newPayingActiveGatewayClient(t, api, gateway, provider)
+
+	found, failed := cid.NewRandomContentID(), cid.NewRandomContentID()
+	api.EXPECT().RequestStandardDiscoverV2(gomock.Any(), gomock.Any(), found, gomock.Any(), gomock.Any(), "f01234", gomock.Any()).
+		Return([][cidoffer.CIDOfferDigestSize]byte{}, nil)
+	api.EXPECT().RequestStandardDiscoverV2(gomock.Any(), gomock.Any(), failed, gomock.Any(), gomock.Any(), "f01234", gomock.Any()).
+		Return(nil, errors.New("gateway unavailable"))
+
+	results, err := c.FindOffersStandardDiscoveryBatch([]*cid.ContentID{found, failed}, newBatchTestOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected a result for each CID, got %d", len(results))
+	}
+	if results[found.ToString()].Err != nil {
+		t.Fatalf("failure of one CID affected another: %s", results[found.ToString()].Err)
+	}
+	if results[failed.ToString()].Err == nil {
+		t.Fatal("failure of a CID not reported")
+	}
+}
+
+func TestBatchDiscoveryContextStopsOnCancel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	api := mocks.NewMockClientApi(ctrl)
+	gateway, provider := newTestNode(t), newTestNode(t)
+	c := newPayingActiveGatewayClient(t, api, gateway, provider)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	contentIDs := []*cid.ContentID{cid.NewRandomContentID(), cid.NewRandomContentID()}
+	results, err := c.FindOffersStandardDiscoveryBatchContext(ctx, contentIDs, newBatchTestOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, contentID := range contentIDs {
+		if result := results[contentID.ToString()]; result == nil || !errors.Is(result.Err, context.Canceled) {
+			t.Fatalf("CID looked up after the batch was cancelled: %+v", result)
+		}
+	}
+}
+
+func TestGroupCIDsByClosestGatewayReportsUngroupedCIDs(t *testing.T) {
+	gateway := newTestNode(t)
+	contentIDs := []*cid.ContentID{cid.NewRandomContentID(), cid.NewRandomContentID()}
+
+	groups, ungrouped := groupCIDsByClosestGateway(contentIDs, map[string]register.GatewayRegistrar{gateway.id.ToString(): gateway.gateway(t)})
+	if len(ungrouped) != 0 || len(groups[gateway.id.ToString()]) != 2 {
+		t.Fatalf("expected every CID assigned to the only gateway, got %d ungrouped", len(ungrouped))
+	}
+
+	groups, ungrouped = groupCIDsByClosestGateway(contentIDs, map[string]register.GatewayRegistrar{"not a node id": gateway.gateway(t)})
+	if len(groups) != 0 || len(ungrouped) != 2 {
+		t.Fatalf("expected every CID reported as ungrouped, got %d", len(ungrouped))
+	}
+	for _, result := range ungrouped {
+		if result.Err == nil {
+			t.Fatal("ungrouped CID reported without error")
+		}
+	}
+}
@@ -22,11 +22,16 @@ package fcrclient
  */
 
 import (
+	"fmt"
 	"math/big"
+	"time"
 
 	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrcrypto"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/logging"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi"
+	"github.com/ConsenSys/fc-retrieval-client/pkg/fcrlogger"
 )
 
 // SettingsBuilder holds the library configuration
@@ -49,6 +54,41 @@ type SettingsBuilder struct {
 	searchPrice      *big.Int
 	offerPrice       *big.Int
 	topUpAmount      *big.Int
+	topupPolicy      TopupPolicy
+
+	paymentRequiredRetries int
+	paymentRequiredBudget  *big.Int
+
+	laneAllocation  LaneAllocation
+	maxPaymentLanes int
+
+	clientApi   clientapi.ClientApi
+	nonceSeed   int64
+	nonceSeeded bool
+
+	priceSource    PriceSource
+	priceCacheTTL  time.Duration
+	maxSearchPrice *big.Int
+	maxOfferPrice  *big.Int
+
+	metrics *ClientMetrics
+	logger  fcrlogger.Logger
+
+	regionDistanceModel RegionDistanceModel
+
+	trustAnchor *TrustAnchor
+	trustMode   TrustMode
+
+	transport clientapi.Transport
+
+	rateLimit         RateLimit
+	gatewayRateLimits map[string]RateLimit
+
+	circuitBreaker CircuitBreakerOptions
+
+	channelSettler         ChannelSettler
+	settleOnRemove         bool
+	paymentChannelResolver PaymentChannelResolver
 }
 
 // CreateSettings creates an object with the default settings.
@@ -62,6 +102,13 @@ func CreateSettings() *SettingsBuilder {
 	f.searchPrice = big.NewInt(defaultSearchPrice)
 	f.offerPrice = big.NewInt(defaultOfferPrice)
 	f.topUpAmount = big.NewInt(defaultTopUpAmount)
+	f.priceCacheTTL = defaultPriceCacheTTL
+	f.paymentRequiredRetries = defaultPaymentRequiredRetries
+	f.paymentRequiredBudget = big.NewInt(defaultPaymentRequiredBudget)
+	f.laneAllocation = LaneAllocationSession
+	f.maxPaymentLanes = defaultMaxPaymentLanes
+	f.circuitBreaker = NewCircuitBreakerOptions()
+	f.settleOnRemove = true
 	return &f
 }
 
@@ -117,22 +164,151 @@ func (f *SettingsBuilder) SetTopUpAmount(topUpAmount *big.Int) {
 	f.topUpAmount = topUpAmount
 }
 
-// Build creates a settings object and initialises the logging system.
-func (f *SettingsBuilder) Build() *ClientSettings {
+// SetPriceSource sets the source of the prices of each gateway. The search and offer prices of the settings
+// are paid to the gateways whose prices the source does not know, or to all gateways if not set.
+func (f *SettingsBuilder) SetPriceSource(source PriceSource) {
+	f.priceSource = source
+}
+
+// SetPriceCacheTTL sets how long the prices of a gateway are cached before the price source is asked again.
+func (f *SettingsBuilder) SetPriceCacheTTL(ttl time.Duration) {
+	f.priceCacheTTL = ttl
+}
+
+// SetMaxPrices sets the maximum search and offer prices paid to a gateway. Gateways charging more are not paid.
+// A nil maximum means no maximum.
+func (f *SettingsBuilder) SetMaxPrices(maxSearchPrice *big.Int, maxOfferPrice *big.Int) {
+	f.maxSearchPrice = maxSearchPrice
+	f.maxOfferPrice = maxOfferPrice
+}
+
+// SetTopupPolicy sets the policy deciding when and by how much payment channels are topped up.
+// If not set, channels are topped up with what is expected to be spent, and at least the top up amount.
+func (f *SettingsBuilder) SetTopupPolicy(policy TopupPolicy) {
+	f.topupPolicy = policy
+}
+
+// SetPaymentRequiredRetry sets how many times a request a gateway answers with payment required is paid and sent
+// again, after topping up the payment channel, and the maximum amount each channel is topped up with this way.
+// Zero retries surface payment required answers as errors at once, and a nil budget keeps the default of 1 FIL.
+func (f *SettingsBuilder) SetPaymentRequiredRetry(retries int, budget *big.Int) {
+	f.paymentRequiredRetries = retries
+	if budget != nil {
+		f.paymentRequiredBudget = budget
+	}
+}
+
+// SetLaneAllocation sets how payments to a gateway are spread over the lanes of its payment channel, and the
+// maximum number of lanes used with LaneAllocationSession. Sessions share lanes once all are in use.
+func (f *SettingsBuilder) SetLaneAllocation(allocation LaneAllocation, maxLanes int) {
+	f.laneAllocation = allocation
+	f.maxPaymentLanes = maxLanes
+}
+
+// SetClientApi sets the client api the requests to gateways and providers are sent with, in place of one using the
+// transport of the settings. Unit tests set a mocks.MockClientApi. The metrics, rate limits and circuit breakers of
+// the settings still apply to it.
+func (f *SettingsBuilder) SetClientApi(api clientapi.ClientApi) {
+	f.clientApi = api
+}
+
+// SetNonceSeed seeds the generator of the request nonces and establishment challenges, so that the requests of a
+// client are the same from run to run, as replaying recorded exchanges needs. Never set it in production.
+func (f *SettingsBuilder) SetNonceSeed(seed int64) {
+	f.nonceSeed = seed
+	f.nonceSeeded = true
+}
+
+// SetMetrics sets the metrics recording the client operations. Metrics are disabled if not set.
+func (f *SettingsBuilder) SetMetrics(metrics *ClientMetrics) {
+	f.metrics = metrics
+}
+
+// SetRegionDistanceModel sets the model ordering gateways by proximity in FindGateways.
+// NewDefaultRegionDistanceModel is used if not set.
+func (f *SettingsBuilder) SetRegionDistanceModel(model RegionDistanceModel) {
+	f.regionDistanceModel = model
+}
+
+// SetTrustAnchor sets the trust anchor register entries are verified against, and what the client does
+// with the entries failing verification. Register entries are not verified if not set.
+func (f *SettingsBuilder) SetTrustAnchor(anchor *TrustAnchor, mode TrustMode) {
+	f.trustAnchor = anchor
+	f.trustMode = mode
+}
+
+// SetTransport sets the transport messages are sent to gateways and providers with, for instance
+// clientapi.NewHTTP2Transport or clientapi.NewLibp2pTransport. Messages are sent over HTTP if not set.
+func (f *SettingsBuilder) SetTransport(transport clientapi.Transport) {
+	f.transport = transport
+}
+
+// SetRateLimit sets the rate limit of the requests sent to each gateway and provider, unless
+// a rate limit is set for the node with SetGatewayRateLimit. Requests are not limited if not set.
+func (f *SettingsBuilder) SetRateLimit(limit RateLimit) {
+	f.rateLimit = limit
+}
+
+// SetGatewayRateLimit sets the rate limit of the requests sent to a gateway, replacing the one set with SetRateLimit.
+func (f *SettingsBuilder) SetGatewayRateLimit(gatewayID *nodeid.NodeID, limit RateLimit) {
+	if f.gatewayRateLimits == nil {
+		f.gatewayRateLimits = make(map[string]RateLimit)
+	}
+	f.gatewayRateLimits[gatewayID.ToString()] = limit
+}
 
-	logging.Init1(f.logLevel, f.logTarget, f.logServiceName)
+// SetCircuitBreaker sets the circuit breakers of the active gateways. NewCircuitBreakerOptions is used if not set.
+func (f *SettingsBuilder) SetCircuitBreaker(options CircuitBreakerOptions) {
+	f.circuitBreaker = options
+}
+
+// SetChannelSettler sets the settler of the payment channels, for instance one of lotussettler.NewChannelSettler.
+// Payment channels can not be settled or collected if not set.
+func (f *SettingsBuilder) SetChannelSettler(settler ChannelSettler) {
+	f.channelSettler = settler
+}
+
+// SetPaymentChannelResolver sets the resolver of the addresses of the payment channels to the IDs of their
+// actors. NewLotusPaymentChannelResolver asking the lotus node of SetLotusAP is used if not set.
+func (f *SettingsBuilder) SetPaymentChannelResolver(resolver PaymentChannelResolver) {
+	f.paymentChannelResolver = resolver
+}
+
+// SetSettleOnRemove sets whether the payment channel of a gateway is settled, with the settler of
+// SetChannelSettler, when RemoveGatewaysToUse or RemoveAllGatewaysToUse removes the gateway. True by default.
+// Gateways removed because their register entry was removed or became invalid are never settled: a register change
+// does not show the gateway can no longer be paid.
+func (f *SettingsBuilder) SetSettleOnRemove(settleOnRemove bool) {
+	f.settleOnRemove = settleOnRemove
+}
+
+// SetLogger sets the logger of the client. When set, the global logging system is left untouched by Build
+// and SetLogging has no effect.
+func (f *SettingsBuilder) SetLogger(logger fcrlogger.Logger) {
+	f.logger = logger
+}
+
+// Build creates a settings object and, unless a logger is set, initialises the global logging system.
+func (f *SettingsBuilder) Build() *ClientSettings {
+	logger := f.logger
+	if logger == nil {
+		logging.Init1(f.logLevel, f.logTarget, f.logServiceName)
+		logger = fcrlogger.NewGlobalLogger()
+	}
 
 	g := ClientSettings{}
+	g.logger = logger
 	g.establishmentTTL = f.establishmentTTL
 	g.registerURL = f.registerURL
 
 	if f.blockchainPrivateKey == nil {
-		logging.ErrorAndPanic("Settings: Blockchain Private Key not set")
+		logger.Error("Settings: Blockchain Private Key not set")
+		panic("Settings: Blockchain Private Key not set")
 	}
 	g.blockchainPrivateKey = f.blockchainPrivateKey
 
 	if f.clientID == nil {
-		logging.Info("Settings: No Client ID set. Generating random client ID")
+		logger.Info("Settings: No Client ID set. Generating random client ID")
 		// TODO replace once NewRandomNodeID becomes available.
 		g.clientID = nodeid.NewRandomNodeID()
 	} else {
@@ -142,7 +318,8 @@ func (f *SettingsBuilder) Build() *ClientSettings {
 	if f.retrievalPrivateKey == nil {
 		pKey, err := fcrcrypto.GenerateRetrievalV1KeyPair()
 		if err != nil {
-			logging.ErrorAndPanic("Settings: Error while generating random retrieval key pair: %s", err)
+			logger.Error("Settings: Error while generating random retrieval key pair", "error", err)
+			panic(fmt.Sprintf("Settings: Error while generating random retrieval key pair: %s", err))
 		}
 		g.retrievalPrivateKey = pKey
 		g.retrievalPrivateKeyVer = fcrcrypto.DecodeKeyVersion(1)
@@ -158,6 +335,35 @@ func (f *SettingsBuilder) Build() *ClientSettings {
 	g.searchPrice = f.searchPrice
 	g.offerPrice = f.offerPrice
 	g.topUpAmount = f.topUpAmount
+	g.topupPolicy = f.topupPolicy
+	g.paymentRequiredRetries = f.paymentRequiredRetries
+	g.paymentRequiredBudget = f.paymentRequiredBudget
+	g.laneAllocation = f.laneAllocation
+	g.maxPaymentLanes = f.maxPaymentLanes
+	g.clientApi = f.clientApi
+	g.nonceSeed = f.nonceSeed
+	g.nonceSeeded = f.nonceSeeded
+	g.priceSource = f.priceSource
+	g.priceCacheTTL = f.priceCacheTTL
+	g.maxSearchPrice = f.maxSearchPrice
+	g.maxOfferPrice = f.maxOfferPrice
+	g.metrics = f.metrics
+	g.regionDistanceModel = f.regionDistanceModel
+	if g.regionDistanceModel == nil {
+		g.regionDistanceModel = NewDefaultRegionDistanceModel()
+	}
+	g.trustAnchor = f.trustAnchor
+	g.trustMode = f.trustMode
+	g.transport = f.transport
+	g.rateLimit = f.rateLimit
+	g.circuitBreaker = f.circuitBreaker
+	g.channelSettler = f.channelSettler
+	g.settleOnRemove = f.settleOnRemove
+	g.paymentChannelResolver = f.paymentChannelResolver
+	g.gatewayRateLimits = make(map[string]RateLimit, len(f.gatewayRateLimits))
+	for id, limit := range f.gatewayRateLimits {
+		g.gatewayRateLimits[id] = limit
+	}
 
 	return &g
 }
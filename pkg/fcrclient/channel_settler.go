@@ -0,0 +1,29 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+)
+
+// ChannelSettler sends the messages settling and collecting payment channels to the chain.
+// Both calls return once the message is executed.
+type ChannelSettler interface {
+	// Settle starts the settlement period of a payment channel, after which the channel can be collected.
+	Settle(ctx context.Context, paymentChannel string) error
+	// Collect pays out the redeemed vouchers of a settled payment channel and returns the rest to the client.
+	Collect(ctx context.Context, paymentChannel string) error
+}
@@ -0,0 +1,316 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi"
+)
+
+// CircuitState - the state of the circuit breaker of a gateway
+type CircuitState string
+
+// Circuit breaker states
+const (
+	// CircuitClosed - requests are sent to the gateway.
+	CircuitClosed CircuitState = "closed"
+	// CircuitOpen - requests fail without being sent, until the open timeout elapses.
+	CircuitOpen CircuitState = "open"
+	// CircuitHalfOpen - a limited number of requests are sent to probe the gateway.
+	CircuitHalfOpen CircuitState = "half_open"
+)
+
+// CircuitBreakerOptions - configuration of the circuit breakers of the gateways
+type CircuitBreakerOptions struct {
+	// FailureThreshold is the number of consecutive failed requests opening the circuit. Zero disables the breakers.
+	FailureThreshold int
+	// OpenTimeout is how long the circuit stays open before probing the gateway.
+	OpenTimeout time.Duration
+	// HalfOpenMaxRequests is the number of probe requests sent at once while the circuit is half open.
+	HalfOpenMaxRequests int
+}
+
+// NewCircuitBreakerOptions creates circuit breaker options with the default settings.
+func NewCircuitBreakerOptions() CircuitBreakerOptions {
+	return CircuitBreakerOptions{
+		FailureThreshold:    defaultCircuitFailureThreshold,
+		OpenTimeout:         defaultCircuitOpenTimeout,
+		HalfOpenMaxRequests: defaultCircuitHalfOpenMaxRequests,
+	}
+}
+
+// CircuitOpenError - a request was not sent to a gateway because its circuit is open
+type CircuitOpenError struct {
+	GatewayID string
+	Until     time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker of gateway %s is open until %s", e.GatewayID, e.Until.Format(time.RFC3339))
+}
+
+// circuitBreakers - the circuit breaker of each gateway
+type circuitBreakers struct {
+	options  CircuitBreakerOptions
+	breakers map[string]*circuitBreaker
+	onChange func(gatewayID string, state CircuitState)
+	lock     sync.Mutex
+}
+
+// circuitBreaker - the state of the circuit of a gateway
+type circuitBreaker struct {
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probes   int
+}
+
+// newCircuitBreakers creates the circuit breakers, calling onChange on every state change.
+func newCircuitBreakers(options CircuitBreakerOptions, onChange func(gatewayID string, state CircuitState)) *circuitBreakers {
+	if options.HalfOpenMaxRequests <= 0 {
+		options.HalfOpenMaxRequests = 1
+	}
+	return &circuitBreakers{options: options, breakers: make(map[string]*circuitBreaker), onChange: onChange}
+}
+
+// enabled returns true if the breakers are enabled.
+func (b *circuitBreakers) enabled() bool {
+	return b.options.FailureThreshold > 0
+}
+
+// breaker returns the breaker of a gateway, creating it closed. Must be called with the lock held.
+func (b *circuitBreakers) breaker(gatewayID string) *circuitBreaker {
+	breaker, exists := b.breakers[gatewayID]
+	if !exists {
+		breaker = &circuitBreaker{state: CircuitClosed}
+		b.breakers[gatewayID] = breaker
+	}
+	return breaker
+}
+
+// setState changes the state of a breaker. Must be called with the lock held.
+func (b *circuitBreakers) setState(gatewayID string, breaker *circuitBreaker, state CircuitState) {
+	if breaker.state == state {
+		return
+	}
+	breaker.state = state
+	if b.onChange != nil {
+		b.onChange(gatewayID, state)
+	}
+}
+
+// allow returns an error if a request can not be sent to a gateway, and otherwise whether the request is a probe.
+func (b *circuitBreakers) allow(gatewayID string) (bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	breaker := b.breaker(gatewayID)
+	if breaker.state == CircuitOpen {
+		until := breaker.openedAt.Add(b.options.OpenTimeout)
+		if time.Now().Before(until) {
+			return false, &CircuitOpenError{gatewayID, until}
+		}
+		b.setState(gatewayID, breaker, CircuitHalfOpen)
+		breaker.probes = 0
+	}
+	if breaker.state == CircuitHalfOpen {
+		if breaker.probes >= b.options.HalfOpenMaxRequests {
+			return false, &CircuitOpenError{gatewayID, time.Now()}
+		}
+		breaker.probes++
+		return true, nil
+	}
+	return false, nil
+}
+
+// record records the result of a request sent to a gateway.
+func (b *circuitBreakers) record(gatewayID string, probe bool, failed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	breaker := b.breaker(gatewayID)
+	if probe && breaker.state == CircuitHalfOpen {
+		breaker.probes--
+	}
+	if !failed {
+		breaker.failures = 0
+		b.setState(gatewayID, breaker, CircuitClosed)
+		return
+	}
+	breaker.failures++
+	if breaker.state == CircuitHalfOpen || breaker.failures >= b.options.FailureThreshold {
+		breaker.openedAt = time.Now()
+		b.setState(gatewayID, breaker, CircuitOpen)
+	}
+}
+
+// abandon releases a probe whose result is not known.
+func (b *circuitBreakers) abandon(gatewayID string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	breaker := b.breaker(gatewayID)
+	if breaker.state == CircuitHalfOpen && breaker.probes > 0 {
+		breaker.probes--
+	}
+}
+
+// state returns the state of the circuit of a gateway, open circuits whose timeout elapsed being half open.
+func (b *circuitBreakers) state(gatewayID string) CircuitState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	breaker, exists := b.breakers[gatewayID]
+	if !exists {
+		return CircuitClosed
+	}
+	if breaker.state == CircuitOpen && !time.Now().Before(breaker.openedAt.Add(b.options.OpenTimeout)) {
+		return CircuitHalfOpen
+	}
+	return breaker.state
+}
+
+// reset forgets the breaker of a gateway, closing its circuit.
+func (b *circuitBreakers) reset(gatewayID string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	breaker, exists := b.breakers[gatewayID]
+	if !exists {
+		return
+	}
+	b.setState(gatewayID, breaker, CircuitClosed)
+	delete(b.breakers, gatewayID)
+}
+
+// CircuitState returns the state of the circuit breaker of a gateway.
+func (c *FilecoinRetrievalClient) CircuitState(gatewayID *nodeid.NodeID) CircuitState {
+	return c.breakers.state(gatewayID.ToString())
+}
+
+// ResetCircuitBreaker closes the circuit breaker of a gateway.
+func (c *FilecoinRetrievalClient) ResetCircuitBreaker(gatewayID *nodeid.NodeID) {
+	c.breakers.reset(gatewayID.ToString())
+}
+
+// circuitOpen returns true if the circuit of a gateway is open, and requests to it fail without being sent.
+func (c *FilecoinRetrievalClient) circuitOpen(gatewayID string) bool {
+	return c.breakers.enabled() && c.breakers.state(strings.ToLower(gatewayID)) == CircuitOpen
+}
+
+// circuitBreakerClientApi is a clientapi.ClientApi failing fast the requests to the gateways whose circuit is open.
+// Establishments go through, as they activate gateways, and so do the requests to providers.
+type circuitBreakerClientApi struct {
+	clientApi clientapi.ClientApi
+	breakers  *circuitBreakers
+}
+
+// newCircuitBreakerClientApi wraps a client api so that its requests to gateways go through the given breakers.
+func newCircuitBreakerClientApi(clientApi clientapi.ClientApi, breakers *circuitBreakers) clientapi.ClientApi {
+	return &circuitBreakerClientApi{clientApi: clientApi, breakers: breakers}
+}
+
+// call sends a request to a gateway through its breaker. A request failing because ctx is done is not counted,
+// and payment required answers are not failures.
+func (b *circuitBreakerClientApi) call(ctx context.Context, gatewayID string, request func() error) error {
+	gatewayID = strings.ToLower(gatewayID)
+	probe, err := b.breakers.allow(gatewayID)
+	if err != nil {
+		return err
+	}
+	err = request()
+	if err != nil && ctx.Err() != nil {
+		if probe {
+			b.breakers.abandon(gatewayID)
+		}
+		return err
+	}
+	// A gateway answering that payment is required is up
+	var paymentRequired *clientapi.PaymentRequiredError
+	b.breakers.record(gatewayID, probe, err != nil && !errors.As(err, &paymentRequired))
+	return err
+}
+
+func (b *circuitBreakerClientApi) RequestDHTOfferDiscover(ctx context.Context, gatewayInfo register.GatewayRegistrar, gatewayIDs []nodeid.NodeID, contentID *cid.ContentID, nonce int64, offersDigests [][][cidoffer.CIDOfferDigestSize]byte, paymentChannelAddr string, voucher string) ([]clientapi.GatewaySubOffers, error) {
+	var res []clientapi.GatewaySubOffers
+	err := b.call(ctx, gatewayInfo.GetNodeID(), func() (err error) {
+		res, err = b.clientApi.RequestDHTOfferDiscover(ctx, gatewayInfo, gatewayIDs, contentID, nonce, offersDigests, paymentChannelAddr, voucher)
+		return err
+	})
+	return res, err
+}
+
+func (b *circuitBreakerClientApi) RequestDHTDiscover(ctx context.Context, gatewayInfo register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, numDHT int64, incrementalResult bool, paychAddr string, voucher string) ([]nodeid.NodeID, []fcrmessages.FCRMessage, []nodeid.NodeID, error) {
+	var contacted, uncontactable []nodeid.NodeID
+	var contactedResp []fcrmessages.FCRMessage
+	err := b.call(ctx, gatewayInfo.GetNodeID(), func() (err error) {
+		contacted, contactedResp, uncontactable, err = b.clientApi.RequestDHTDiscover(ctx, gatewayInfo, contentID, nonce, ttl, numDHT, incrementalResult, paychAddr, voucher)
+		return err
+	})
+	return contacted, contactedResp, uncontactable, err
+}
+
+func (b *circuitBreakerClientApi) RequestDHTDiscoverV2(ctx context.Context, gatewayInfo register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, numDHT int64, incrementalResult bool, paychAddr string, voucher string) ([]nodeid.NodeID, []fcrmessages.FCRMessage, []nodeid.NodeID, error) {
+	var contacted, uncontactable []nodeid.NodeID
+	var contactedResp []fcrmessages.FCRMessage
+	err := b.call(ctx, gatewayInfo.GetNodeID(), func() (err error) {
+		contacted, contactedResp, uncontactable, err = b.clientApi.RequestDHTDiscoverV2(ctx, gatewayInfo, contentID, nonce, ttl, numDHT, incrementalResult, paychAddr, voucher)
+		return err
+	})
+	return contacted, contactedResp, uncontactable, err
+}
+
+func (b *circuitBreakerClientApi) RequestDHTOfferAck(ctx context.Context, providerInfo register.ProviderRegistrar, contentID *cid.ContentID, gatewayID *nodeid.NodeID) (bool, *fcrmessages.FCRMessage, *fcrmessages.FCRMessage, error) {
+	return b.clientApi.RequestDHTOfferAck(ctx, providerInfo, contentID, gatewayID)
+}
+
+func (b *circuitBreakerClientApi) RequestEstablishment(ctx context.Context, gatewayInfo register.GatewayRegistrar, challenge []byte, clientID *nodeid.NodeID, ttl int64) error {
+	return b.clientApi.RequestEstablishment(ctx, gatewayInfo, challenge, clientID, ttl)
+}
+
+func (b *circuitBreakerClientApi) RequestStandardDiscoverOffer(ctx context.Context, gatewayInfo register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, offerDigests [][cidoffer.CIDOfferDigestSize]byte, paychAddr string, voucher string) ([]cidoffer.SubCIDOffer, error) {
+	var offers []cidoffer.SubCIDOffer
+	err := b.call(ctx, gatewayInfo.GetNodeID(), func() (err error) {
+		offers, err = b.clientApi.RequestStandardDiscoverOffer(ctx, gatewayInfo, contentID, nonce, ttl, offerDigests, paychAddr, voucher)
+		return err
+	})
+	return offers, err
+}
+
+func (b *circuitBreakerClientApi) RequestStandardDiscover(ctx context.Context, gatewayInfo register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, paychAddr string, voucher string) ([]cidoffer.SubCIDOffer, error) {
+	var offers []cidoffer.SubCIDOffer
+	err := b.call(ctx, gatewayInfo.GetNodeID(), func() (err error) {
+		offers, err = b.clientApi.RequestStandardDiscover(ctx, gatewayInfo, contentID, nonce, ttl, paychAddr, voucher)
+		return err
+	})
+	return offers, err
+}
+
+func (b *circuitBreakerClientApi) RequestStandardDiscoverV2(ctx context.Context, gatewayInfo register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, paychAddr string, voucher string) ([][cidoffer.CIDOfferDigestSize]byte, error) {
+	var digests [][cidoffer.CIDOfferDigestSize]byte
+	err := b.call(ctx, gatewayInfo.GetNodeID(), func() (err error) {
+		digests, err = b.clientApi.RequestStandardDiscoverV2(ctx, gatewayInfo, contentID, nonce, ttl, paychAddr, voucher)
+		return err
+	})
+	return digests, err
+}
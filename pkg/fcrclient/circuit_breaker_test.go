@@ -0,0 +1,150 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+	"github.com/golang/mock/gomock"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi"
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi/mocks"
+)
+
+// newTestBreakers creates breakers opening after two failures, for openTimeout, recording their state changes.
+func newTestBreakers(openTimeout time.Duration) (*circuitBreakers, *[]CircuitState) {
+	changes := make([]CircuitState, 0)
+	breakers := newCircuitBreakers(CircuitBreakerOptions{FailureThreshold: 2, OpenTimeout: openTimeout}, func(gatewayID string, state CircuitState) {
+		changes = append(changes, state)
+	})
+	return breakers, &changes
+}
+
+func TestCircuitOpensAfterConsecutiveFailures(t *testing.T) {
+	breakers, changes := newTestBreakers(time.Hour)
+	breakers.record("gateway", false, true)
+	breakers.record("gateway", false, false)
+	breakers.record("gateway", false, true)
+	if breakers.state("gateway") != CircuitClosed {
+		t.Fatal("circuit opened without consecutive failures")
+	}
+	breakers.record("gateway", false, true)
+	if breakers.state("gateway") != CircuitOpen {
+		t.Fatal("circuit not opened after consecutive failures")
+	}
+	var open *CircuitOpenError
+	if _, err := breakers.allow("gateway"); !errors.As(err, &open) || open.GatewayID != "gateway" {
+		t.Fatalf("expected the request refused, got %v", err)
+	}
+	if _, err := breakers.allow("other"); err != nil {
+		t.Fatal("request to another gateway refused")
+	}
+	if len(*changes) != 1 || (*changes)[0] != CircuitOpen {
+		t.Fatalf("expected the circuit opening reported, got %v", *changes)
+	}
+}
+
+func TestHalfOpenCircuitProbes(t *testing.T) {
+	breakers, changes := newTestBreakers(time.Millisecond)
+	breakers.record("gateway", false, true)
+	breakers.record("gateway", false, true)
+	time.Sleep(2 * time.Millisecond)
+
+	probe, err := breakers.allow("gateway")
+	if err != nil || !probe {
+		t.Fatalf("expected a probe once the open timeout elapsed, got %v, %v", probe, err)
+	}
+	if _, err := breakers.allow("gateway"); err == nil {
+		t.Fatal("second probe sent at once")
+	}
+	breakers.record("gateway", true, true)
+	if breakers.state("gateway") != CircuitOpen {
+		t.Fatal("failed probe did not open the circuit again")
+	}
+	time.Sleep(2 * time.Millisecond)
+	if probe, err := breakers.allow("gateway"); err != nil || !probe {
+		t.Fatal("no probe after the second open timeout")
+	}
+	breakers.record("gateway", true, false)
+	if breakers.state("gateway") != CircuitClosed {
+		t.Fatal("successful probe did not close the circuit")
+	}
+	expected := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}
+	if len(*changes) != len(expected) {
+		t.Fatalf("expected the changes %v, got %v", expected, *changes)
+	}
+	for i := range expected {
+		if (*changes)[i] != expected[i] {
+			t.Fatalf("expected the changes %v, got %v", expected, *changes)
+		}
+	}
+}
+
+func TestCircuitIgnoresPaymentRequiredAndCancellations(t *testing.T) {
+	breakers, _ := newTestBreakers(time.Hour)
+	api := &circuitBreakerClientApi{breakers: breakers}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 2; i++ {
+		_ = api.call(context.Background(), "gateway", func() error { return clientapi.NewPaymentRequiredError("gateway", 1) })
+		_ = api.call(ctx, "gateway", func() error { return ctx.Err() })
+	}
+	if breakers.state("gateway") != CircuitClosed {
+		t.Fatal("circuit opened by payment required answers or cancelled requests")
+	}
+}
+
+func TestClientFailsFastWhileCircuitOpen(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	api := mocks.NewMockClientApi(ctrl)
+	gateway := newTestNode(t)
+	gatewayEntry := gateway.gateway(t)
+	c := newTestClientWithRegister(t, newTestRegister([]register.GatewayRegistrar{gatewayEntry}, nil), func(builder *SettingsBuilder) {
+		builder.SetClientApi(api)
+		builder.SetCircuitBreaker(CircuitBreakerOptions{FailureThreshold: 2, OpenTimeout: time.Hour})
+	})
+	api.EXPECT().RequestEstablishment(gomock.Any(), gatewayEntry, gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	if c.AddGatewaysToUse([]*nodeid.NodeID{gateway.id}) != 1 || c.AddActiveGateways([]*nodeid.NodeID{gateway.id}) != 1 {
+		t.Fatal("gateway not activated")
+	}
+	events, unsubscribe := c.Events().SubscribeChannel(10, EventCircuitStateChanged)
+
+	contentID := cid.NewRandomContentID()
+	api.EXPECT().RequestStandardDiscover(gomock.Any(), gomock.Any(), contentID, gomock.Any(), gomock.Any(), "", "").
+		Times(2).Return(nil, errors.New("gateway unavailable"))
+	for i := 0; i < 3; i++ {
+		if _, err := c.FindOffersStandardDiscovery(contentID, gateway.id); err == nil {
+			t.Fatal("discovery through a failing gateway succeeded")
+		}
+	}
+	if c.CircuitState(gateway.id) != CircuitOpen {
+		t.Fatal("circuit of the failing gateway not open")
+	}
+	c.ResetCircuitBreaker(gateway.id)
+	if c.CircuitState(gateway.id) != CircuitClosed {
+		t.Fatal("reset circuit not closed")
+	}
+	unsubscribe()
+	if changes := received(events); len(changes) != 2 {
+		t.Fatalf("expected the circuit opening and closing published, got %d events", len(changes))
+	}
+}
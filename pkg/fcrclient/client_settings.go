@@ -17,9 +17,14 @@ package fcrclient
 
 import (
 	"math/big"
+	"strings"
+	"time"
 
 	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrcrypto"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi"
+	"github.com/ConsenSys/fc-retrieval-client/pkg/fcrlogger"
 )
 
 // ClientSettings holds the library configuration
@@ -39,6 +44,41 @@ type ClientSettings struct {
 	searchPrice      *big.Int
 	offerPrice       *big.Int
 	topUpAmount      *big.Int
+	topupPolicy      TopupPolicy
+
+	paymentRequiredRetries int
+	paymentRequiredBudget  *big.Int
+
+	laneAllocation  LaneAllocation
+	maxPaymentLanes int
+
+	clientApi   clientapi.ClientApi
+	nonceSeed   int64
+	nonceSeeded bool
+
+	priceSource    PriceSource
+	priceCacheTTL  time.Duration
+	maxSearchPrice *big.Int
+	maxOfferPrice  *big.Int
+
+	metrics *ClientMetrics
+	logger  fcrlogger.Logger
+
+	regionDistanceModel RegionDistanceModel
+
+	trustAnchor *TrustAnchor
+	trustMode   TrustMode
+
+	transport clientapi.Transport
+
+	rateLimit         RateLimit
+	gatewayRateLimits map[string]RateLimit
+
+	circuitBreaker CircuitBreakerOptions
+
+	channelSettler         ChannelSettler
+	settleOnRemove         bool
+	paymentChannelResolver PaymentChannelResolver
 }
 
 // WalletPrivateKey returns the wallet private key
@@ -71,6 +111,86 @@ func (c ClientSettings) TopUpAmount() *big.Int {
 	return c.topUpAmount
 }
 
+// PaymentRequiredRetries returns how many times a request answered with payment required is sent again
+func (c ClientSettings) PaymentRequiredRetries() int {
+	return c.paymentRequiredRetries
+}
+
+// PaymentRequiredBudget returns the maximum amount a payment channel is topped up with after payment required answers
+func (c ClientSettings) PaymentRequiredBudget() *big.Int {
+	return c.paymentRequiredBudget
+}
+
+// LaneAllocation returns how payments to a gateway are spread over the lanes of its payment channel
+func (c ClientSettings) LaneAllocation() LaneAllocation {
+	return c.laneAllocation
+}
+
+// MaxPaymentLanes returns the maximum number of lanes of a payment channel used with LaneAllocationSession
+func (c ClientSettings) MaxPaymentLanes() int {
+	return c.maxPaymentLanes
+}
+
+// ClientApi returns the client api set in place of one using the transport, nil if not set
+func (c ClientSettings) ClientApi() clientapi.ClientApi {
+	return c.clientApi
+}
+
+// NonceSeed returns the seed of the request nonces and establishment challenges, and whether it is set
+func (c ClientSettings) NonceSeed() (int64, bool) {
+	return c.nonceSeed, c.nonceSeeded
+}
+
+// PriceSource returns the source of the prices of each gateway, nil if the prices of the settings are paid to all gateways
+func (c ClientSettings) PriceSource() PriceSource {
+	return c.priceSource
+}
+
+// PriceCacheTTL returns how long the prices of a gateway are cached
+func (c ClientSettings) PriceCacheTTL() time.Duration {
+	return c.priceCacheTTL
+}
+
+// MaxSearchPrice returns the maximum search price paid to a gateway, nil if there is no maximum
+func (c ClientSettings) MaxSearchPrice() *big.Int {
+	return c.maxSearchPrice
+}
+
+// MaxOfferPrice returns the maximum offer price paid to a gateway, nil if there is no maximum
+func (c ClientSettings) MaxOfferPrice() *big.Int {
+	return c.maxOfferPrice
+}
+
+// TopupPolicy returns the policy deciding when and by how much payment channels are topped up,
+// CoverPendingTopupPolicy if none is set
+func (c ClientSettings) TopupPolicy() TopupPolicy {
+	if c.topupPolicy == nil {
+		return CoverPendingTopupPolicy{}
+	}
+	return c.topupPolicy
+}
+
+// Metrics returns the metrics recording the client operations, nil if metrics are disabled
+func (c ClientSettings) Metrics() *ClientMetrics {
+	return c.metrics
+}
+
+// Logger returns the logger of the client, or the global logger if none is set.
+func (c ClientSettings) Logger() fcrlogger.Logger {
+	if c.logger == nil {
+		return fcrlogger.NewGlobalLogger()
+	}
+	return c.logger
+}
+
+// RegionDistanceModel returns the model ordering gateways by proximity.
+func (c ClientSettings) RegionDistanceModel() RegionDistanceModel {
+	if c.regionDistanceModel == nil {
+		return NewDefaultRegionDistanceModel()
+	}
+	return c.regionDistanceModel
+}
+
 // EstablishmentTTL returns the establishmentTTL
 func (c ClientSettings) EstablishmentTTL() int64 {
 	return c.establishmentTTL
@@ -100,3 +220,61 @@ func (c ClientSettings) RetrievalPrivateKey() *fcrcrypto.KeyPair {
 func (c ClientSettings) RetrievalPrivateKeyVer() *fcrcrypto.KeyVersion {
 	return c.retrievalPrivateKeyVer
 }
+
+// TrustAnchor returns the trust anchor register entries are verified against, nil if not set
+func (c ClientSettings) TrustAnchor() *TrustAnchor {
+	return c.trustAnchor
+}
+
+// TrustMode returns what the client does with register entries failing trust verification
+func (c ClientSettings) TrustMode() TrustMode {
+	return c.trustMode
+}
+
+// Transport returns the transport messages are sent with, nil if the default HTTP transport is used
+func (c ClientSettings) Transport() clientapi.Transport {
+	return c.transport
+}
+
+// RateLimit returns the rate limit of the requests sent to the given gateway or provider
+func (c ClientSettings) RateLimit(nodeID string) RateLimit {
+	if limit, exists := c.gatewayRateLimits[strings.ToLower(nodeID)]; exists {
+		return limit
+	}
+	return c.rateLimit
+}
+
+// CircuitBreaker returns the configuration of the circuit breakers of the active gateways
+func (c ClientSettings) CircuitBreaker() CircuitBreakerOptions {
+	return c.circuitBreaker
+}
+
+// ChannelSettler returns the settler of the payment channels, nil if payment channels can not be settled
+func (c ClientSettings) ChannelSettler() ChannelSettler {
+	return c.channelSettler
+}
+
+// PaymentChannelResolver returns the resolver of the payment channels, nil if the lotus node payments are made
+// with resolves them
+func (c ClientSettings) PaymentChannelResolver() PaymentChannelResolver {
+	return c.paymentChannelResolver
+}
+
+// SettleOnRemove returns true if the payment channel of a gateway is settled when the user removes the gateway,
+// if a settler is set
+func (c ClientSettings) SettleOnRemove() bool {
+	return c.settleOnRemove
+}
+
+// rateLimited returns true if the requests sent to some nodes are limited
+func (c ClientSettings) rateLimited() bool {
+	if c.rateLimit.enabled() {
+		return true
+	}
+	for _, limit := range c.gatewayRateLimits {
+		if limit.enabled() {
+			return true
+		}
+	}
+	return false
+}
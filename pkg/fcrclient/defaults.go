@@ -15,6 +15,8 @@ package fcrclient
  * SPDX-License-Identifier: Apache-2.0
  */
 
+import "time"
+
 const (
 	// DefaultEstablishmentTTL is the default Time To Live used with Client - Gateway estalishment messages.
 	defaultEstablishmentTTL = int64(100)
@@ -40,4 +42,51 @@ const (
 
 	// defaultTopUpAmount is the default top up amount.
 	defaultTopUpAmount = 100_000_000_000_000_000
+
+	// defaultPaymentLane is the payment channel lane used for payments to gateways with LaneAllocationSingle.
+	defaultPaymentLane = uint64(0)
+
+	// defaultMaxPaymentLanes is the maximum number of lanes of a payment channel used with LaneAllocationSession.
+	defaultMaxPaymentLanes = 8
+
+	// defaultPaymentRequiredRetries is the number of times a request answered with payment required is sent again.
+	defaultPaymentRequiredRetries = 1
+
+	// defaultPaymentRequiredBudget is the maximum amount a payment channel is topped up with after its gateway
+	// answered payment required.
+	defaultPaymentRequiredBudget = 1_000_000_000_000_000_000
+
+	// watchedProviderTTL is how long the register watcher checks the entry of a provider after its last verified
+	// offer or ack.
+	watchedProviderTTL = 24 * time.Hour
+
+	// defaultPriceCacheTTL is how long the prices of a gateway are cached.
+	defaultPriceCacheTTL = 10 * time.Minute
+
+	// defaultPriceRankingWeight is the weight given to the offer price by the default offer ranker.
+	defaultPriceRankingWeight = 1.0
+
+	// defaultExpiryRankingWeight is the weight given to the offer expiry by the default offer ranker.
+	defaultExpiryRankingWeight = 0.1
+
+	// defaultBatchMaxOffersPerCID is the default maximum number of offers requested for each CID of a batch discovery.
+	defaultBatchMaxOffersPerCID = 5
+
+	// defaultBatchMaxConcurrentGateways is the default maximum number of gateways queried in parallel by a batch discovery.
+	defaultBatchMaxConcurrentGateways = 8
+
+	// defaultBatchRequestsPerSecond is the default maximum number of CIDs looked up per second on each gateway by a batch discovery.
+	defaultBatchRequestsPerSecond = 10.0
+
+	// defaultCircuitFailureThreshold is the default number of consecutive failed requests opening the circuit of a gateway.
+	defaultCircuitFailureThreshold = 5
+
+	// defaultCircuitOpenTimeout is the default time the circuit of a gateway stays open before it is probed.
+	defaultCircuitOpenTimeout = 30 * time.Second
+
+	// defaultCircuitHalfOpenMaxRequests is the default number of probe requests sent at once to a half open gateway.
+	defaultCircuitHalfOpenMaxRequests = 1
+
+	// defaultEventBufferSize is the default number of events buffered for each event subscriber.
+	defaultEventBufferSize = 64
 )
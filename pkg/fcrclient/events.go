@@ -0,0 +1,304 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType - the type of a client event
+type EventType string
+
+// Client event types
+const (
+	EventGatewayAdded        EventType = "gateway_added"
+	EventGatewayActivated    EventType = "gateway_activated"
+	EventGatewayDeactivated  EventType = "gateway_deactivated"
+	EventEstablishmentFailed EventType = "establishment_failed"
+	EventOfferRejected       EventType = "offer_rejected"
+	EventPaymentMade         EventType = "payment_made"
+	EventTopupPerformed      EventType = "topup_performed"
+	EventPaymentRequired     EventType = "payment_required"
+
+	EventRegistrationUntrusted   EventType = "registration_untrusted"
+	EventRegisterEntryChanged    EventType = "register_entry_changed"
+	EventRegisterEntryRemoved    EventType = "register_entry_removed"
+	EventCircuitStateChanged     EventType = "circuit_state_changed"
+	EventPaymentChannelSettled   EventType = "payment_channel_settled"
+	EventPaymentChannelCollected EventType = "payment_channel_collected"
+)
+
+// Event is implemented by every client event.
+type Event interface {
+	Type() EventType
+	Time() time.Time
+}
+
+// eventTime - the time an event happened
+type eventTime struct {
+	At time.Time
+}
+
+// Time returns the time the event happened.
+func (e eventTime) Time() time.Time {
+	return e.At
+}
+
+// GatewayAddedEvent - a gateway was added to the gateways to use
+type GatewayAddedEvent struct {
+	eventTime
+	GatewayID string
+}
+
+// GatewayActivatedEvent - the establishment with a gateway succeeded and the gateway is active
+type GatewayActivatedEvent struct {
+	eventTime
+	GatewayID string
+}
+
+// GatewayDeactivatedEvent - a gateway is no longer active
+type GatewayDeactivatedEvent struct {
+	eventTime
+	GatewayID string
+}
+
+// EstablishmentFailedEvent - the establishment with a gateway failed
+type EstablishmentFailedEvent struct {
+	eventTime
+	GatewayID string
+	Err       error
+}
+
+// OfferRejectedEvent - an offer failed verification
+type OfferRejectedEvent struct {
+	eventTime
+	ProviderID string
+	SubCID     string
+	Reason     string
+	Err        error
+}
+
+// PaymentMadeEvent - a payment was made to a gateway
+type PaymentMadeEvent struct {
+	eventTime
+	GatewayID      string
+	PaymentChannel string
+	Lane           uint64
+	Amount         *big.Int
+}
+
+// TopupPerformedEvent - the payment channel to a gateway was topped up
+type TopupPerformedEvent struct {
+	eventTime
+	GatewayID string
+	Amount    *big.Int
+}
+
+// PaymentRequiredEvent - a gateway answered that its payment channel must be topped up
+type PaymentRequiredEvent struct {
+	eventTime
+	GatewayID      string
+	PaymentChannel string
+}
+
+// RegistrationUntrustedEvent - a register entry failed trust verification
+type RegistrationUntrustedEvent struct {
+	eventTime
+	NodeID      string
+	Kind        string
+	Reason      string
+	Quarantined bool
+}
+
+// RegisterEntryChangedEvent - the register entry of a gateway or provider in use changed.
+// Changes lists the RegisterField values of the fields which changed.
+type RegisterEntryChangedEvent struct {
+	eventTime
+	NodeID  string
+	Kind    string
+	Changes []string
+}
+
+// RegisterEntryRemovedEvent - a gateway or provider in use is no longer in the register
+type RegisterEntryRemovedEvent struct {
+	eventTime
+	NodeID string
+	Kind   string
+}
+
+// CircuitStateChangedEvent - the circuit breaker of a gateway changed state
+type CircuitStateChangedEvent struct {
+	eventTime
+	GatewayID string
+	State     CircuitState
+}
+
+// PaymentChannelSettledEvent - the payment channel to a gateway was settled
+type PaymentChannelSettledEvent struct {
+	eventTime
+	GatewayID      string
+	PaymentChannel string
+}
+
+// PaymentChannelCollectedEvent - the payment channel to a gateway was collected
+type PaymentChannelCollectedEvent struct {
+	eventTime
+	GatewayID      string
+	PaymentChannel string
+}
+
+// Type returns the event type.
+func (e GatewayAddedEvent) Type() EventType { return EventGatewayAdded }
+
+// Type returns the event type.
+func (e GatewayActivatedEvent) Type() EventType { return EventGatewayActivated }
+
+// Type returns the event type.
+func (e GatewayDeactivatedEvent) Type() EventType { return EventGatewayDeactivated }
+
+// Type returns the event type.
+func (e EstablishmentFailedEvent) Type() EventType { return EventEstablishmentFailed }
+
+// Type returns the event type.
+func (e OfferRejectedEvent) Type() EventType { return EventOfferRejected }
+
+// Type returns the event type.
+func (e PaymentMadeEvent) Type() EventType { return EventPaymentMade }
+
+// Type returns the event type.
+func (e TopupPerformedEvent) Type() EventType { return EventTopupPerformed }
+
+// Type returns the event type.
+func (e PaymentRequiredEvent) Type() EventType { return EventPaymentRequired }
+
+// Type returns the event type.
+func (e RegistrationUntrustedEvent) Type() EventType { return EventRegistrationUntrusted }
+
+// Type returns the event type.
+func (e RegisterEntryChangedEvent) Type() EventType { return EventRegisterEntryChanged }
+
+// Type returns the event type.
+func (e RegisterEntryRemovedEvent) Type() EventType { return EventRegisterEntryRemoved }
+
+// Type returns the event type.
+func (e CircuitStateChangedEvent) Type() EventType { return EventCircuitStateChanged }
+
+// Type returns the event type.
+func (e PaymentChannelSettledEvent) Type() EventType { return EventPaymentChannelSettled }
+
+// Type returns the event type.
+func (e PaymentChannelCollectedEvent) Type() EventType { return EventPaymentChannelCollected }
+
+// EventBus delivers client events to subscribers.
+// Publishing never blocks: each subscriber has a buffer, and events are dropped for subscribers whose buffer is full.
+type EventBus struct {
+	subscribers     map[uint64]*eventSubscriber
+	subscribersLock sync.RWMutex
+	nextID          uint64
+}
+
+// eventSubscriber - a subscriber of the event bus
+type eventSubscriber struct {
+	types   map[EventType]bool
+	events  chan Event
+	dropped uint64
+}
+
+// newEventBus creates an event bus without subscribers.
+func newEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[uint64]*eventSubscriber)}
+}
+
+// Subscribe calls handler, from a dedicated goroutine, for each event of the given types, or of every type if
+// none is given. The returned function cancels the subscription.
+func (b *EventBus) Subscribe(handler func(Event), types ...EventType) (unsubscribe func()) {
+	events, unsubscribe := b.SubscribeChannel(defaultEventBufferSize, types...)
+	go func() {
+		for event := range events {
+			handler(event)
+		}
+	}()
+	return unsubscribe
+}
+
+// SubscribeChannel delivers the events of the given types, or of every type if none is given, on the returned
+// channel, buffering up to bufferSize events. The returned function cancels the subscription and closes the channel.
+func (b *EventBus) SubscribeChannel(bufferSize int, types ...EventType) (<-chan Event, func()) {
+	if bufferSize <= 0 {
+		bufferSize = defaultEventBufferSize
+	}
+	subscriber := &eventSubscriber{
+		types:  make(map[EventType]bool),
+		events: make(chan Event, bufferSize),
+	}
+	for _, eventType := range types {
+		subscriber.types[eventType] = true
+	}
+
+	b.subscribersLock.Lock()
+	b.nextID++
+	id := b.nextID
+	b.subscribers[id] = subscriber
+	b.subscribersLock.Unlock()
+
+	var once sync.Once
+	return subscriber.events, func() {
+		once.Do(func() {
+			b.subscribersLock.Lock()
+			defer b.subscribersLock.Unlock()
+			delete(b.subscribers, id)
+			close(subscriber.events)
+		})
+	}
+}
+
+// Dropped returns the number of events dropped for the current subscribers because their buffers were full.
+func (b *EventBus) Dropped() uint64 {
+	b.subscribersLock.RLock()
+	defer b.subscribersLock.RUnlock()
+	var dropped uint64
+	for _, subscriber := range b.subscribers {
+		dropped += atomic.LoadUint64(&subscriber.dropped)
+	}
+	return dropped
+}
+
+// publish delivers an event to the subscribers without blocking.
+func (b *EventBus) publish(event Event) {
+	if b == nil {
+		return
+	}
+	b.subscribersLock.RLock()
+	defer b.subscribersLock.RUnlock()
+	for _, subscriber := range b.subscribers {
+		if len(subscriber.types) > 0 && !subscriber.types[event.Type()] {
+			continue
+		}
+		select {
+		case subscriber.events <- event:
+		default:
+			atomic.AddUint64(&subscriber.dropped, 1)
+		}
+	}
+}
+
+// now returns the time of an event happening now.
+func now() eventTime {
+	return eventTime{At: time.Now()}
+}
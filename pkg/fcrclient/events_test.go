@@ -0,0 +1,140 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+	"github.com/golang/mock/gomock"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi/mocks"
+)
+
+// received returns the events delivered on events until it is closed.
+func received(events <-chan Event) []Event {
+	res := make([]Event, 0)
+	for event := range events {
+		res = append(res, event)
+	}
+	return res
+}
+
+func TestSubscribeChannelFiltersTypes(t *testing.T) {
+	bus := newEventBus()
+	events, unsubscribe := bus.SubscribeChannel(10, EventGatewayAdded)
+	all, unsubscribeAll := bus.SubscribeChannel(10)
+	bus.publish(GatewayAddedEvent{now(), "gateway"})
+	bus.publish(GatewayActivatedEvent{now(), "gateway"})
+	unsubscribe()
+	unsubscribeAll()
+	unsubscribe()
+
+	if filtered := received(events); len(filtered) != 1 || filtered[0].Type() != EventGatewayAdded {
+		t.Fatalf("expected only the gateway added event, got %v", filtered)
+	}
+	if len(received(all)) != 2 {
+		t.Fatal("subscriber to every type did not receive every event")
+	}
+	bus.publish(GatewayAddedEvent{now(), "gateway"})
+}
+
+func TestPublishDropsEventsOfFullSubscribers(t *testing.T) {
+	bus := newEventBus()
+	events, unsubscribe := bus.SubscribeChannel(1)
+	bus.publish(GatewayAddedEvent{now(), "first"})
+	bus.publish(GatewayAddedEvent{now(), "second"})
+	if bus.Dropped() != 1 {
+		t.Fatalf("expected one event dropped, got %d", bus.Dropped())
+	}
+	unsubscribe()
+	if delivered := received(events); len(delivered) != 1 || delivered[0].(GatewayAddedEvent).GatewayID != "first" {
+		t.Fatal("expected the first event delivered")
+	}
+}
+
+func TestSubscribeCallsHandler(t *testing.T) {
+	bus := newEventBus()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var event Event
+	unsubscribe := bus.Subscribe(func(e Event) {
+		event = e
+		wg.Done()
+	})
+	defer unsubscribe()
+	bus.publish(GatewayDeactivatedEvent{now(), "gateway"})
+	wg.Wait()
+	if event.Type() != EventGatewayDeactivated || event.Time().IsZero() || time.Since(event.Time()) > time.Minute {
+		t.Fatalf("unexpected event %v", event)
+	}
+}
+
+func TestClientPublishesLifecycleEvents(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	api := mocks.NewMockClientApi(ctrl)
+	gateway, failing, provider := newTestNode(t), newTestNode(t), newTestNode(t)
+	gatewayEntry, failingEntry := gateway.gateway(t), failing.gateway(t)
+	registerMgr := newTestRegister([]register.GatewayRegistrar{gatewayEntry, failingEntry}, []register.ProviderRegistrar{provider.provider(t)})
+	c := newTestClientWithRegister(t, registerMgr, func(builder *SettingsBuilder) {
+		builder.SetClientApi(api)
+	})
+	events, unsubscribe := c.Events().SubscribeChannel(20)
+
+	api.EXPECT().RequestEstablishment(gomock.Any(), gatewayEntry, gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	api.EXPECT().RequestEstablishment(gomock.Any(), failingEntry, gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("unavailable"))
+	ids := []*nodeid.NodeID{gateway.id, failing.id}
+	if c.AddGatewaysToUse(ids) != 2 || c.AddActiveGateways(ids) != 1 {
+		t.Fatal("expected one of the two gateways activated")
+	}
+	contentID := cid.NewRandomContentID()
+	forged := newTestOffer(t, provider.id, newTestKey(t), contentID, 1)
+	api.EXPECT().RequestStandardDiscover(gomock.Any(), gomock.Any(), contentID, gomock.Any(), gomock.Any(), "", "").
+		Return([]cidoffer.SubCIDOffer{forged}, nil)
+	if _, err := c.FindOffersStandardDiscovery(contentID, gateway.id); err != nil {
+		t.Fatal(err)
+	}
+	c.RemoveActiveGateways([]*nodeid.NodeID{gateway.id})
+	unsubscribe()
+
+	counts := make(map[EventType]int)
+	for _, event := range received(events) {
+		counts[event.Type()]++
+		if rejected, ok := event.(OfferRejectedEvent); ok && (rejected.ProviderID != provider.id.ToString() || rejected.Reason != offerRejectedInvalidSignature) {
+			t.Fatalf("unexpected offer rejection %+v", rejected)
+		}
+		if failed, ok := event.(EstablishmentFailedEvent); ok && (failed.GatewayID != failing.id.ToString() || failed.Err == nil) {
+			t.Fatalf("unexpected establishment failure %+v", failed)
+		}
+	}
+	for eventType, expected := range map[EventType]int{
+		EventGatewayAdded:        2,
+		EventGatewayActivated:    1,
+		EventEstablishmentFailed: 1,
+		EventOfferRejected:       1,
+		EventGatewayDeactivated:  1,
+	} {
+		if counts[eventType] != expected {
+			t.Fatalf("expected %d %s events, got %d", expected, eventType, counts[eventType])
+		}
+	}
+}
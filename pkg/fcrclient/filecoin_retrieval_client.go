@@ -16,27 +16,36 @@ package fcrclient
  */
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/big"
-	"math/rand"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
-	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrcrypto"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrpaymentmgr"
-	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrregistermgr"
-	"github.com/ConsenSys/fc-retrieval-common/pkg/logging"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
 
 	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi"
+	"github.com/ConsenSys/fc-retrieval-client/pkg/fcrlogger"
 )
 
+// Register gives the client the register entries of the gateways and providers. It is implemented by
+// fcrregistermgr.FCRRegisterMgr, and by fakes in the unit tests of code using the client.
+type Register interface {
+	Refresh()
+	GetGateway(id *nodeid.NodeID) register.GatewayRegistrar
+	GetProvider(id *nodeid.NodeID) register.ProviderRegistrar
+	GetAllGateways() []register.GatewayRegistrar
+	GetAllProviders() []register.ProviderRegistrar
+	GetGatewaysNearCID(cID *cid.ContentID, numDHT int, notAllowed *nodeid.NodeID) ([]register.GatewayRegistrar, error)
+}
+
 // FilecoinRetrievalClient is an example implementation using the api,
 // which holds information about the interaction of the Filecoin
 // Retrieval Client with Filecoin Retrieval Gateways.
@@ -54,21 +63,69 @@ type FilecoinRetrievalClient struct {
 	// PaymentMgr payment manager
 	paymentMgr     *fcrpaymentmgr.FCRPaymentMgr
 	paymentMgrLock sync.RWMutex
+	// Payment manager gateways are paid with in place of PaymentMgr, if set
+	payer paymentManager
+
+	// Payments made to each gateway
+	paymentStatus     map[string]*GatewayPaymentStatus
+	paymentStatusLock sync.RWMutex
+	// IDs of the actors of the payment channels, by address
+	paymentChannelIDs map[string]int64
+	// Locks of the payment channels, by gateway ID
+	channelLocks map[string]*sync.RWMutex
 
 	clientApi   clientapi.ClientApi
-	registerMgr *fcrregistermgr.FCRRegisterMgr
+	registerMgr Register
+	events      *EventBus
+	trust       *trustState
+	watcher     *registerWatcher
+	breakers    *circuitBreakers
+	prices      *priceCache
+	random      *randomSource
+	logger      fcrlogger.Logger
 }
 
 // NewFilecoinRetrievalClient initialise the Filecoin Retrieval Client library
-func NewFilecoinRetrievalClient(settings ClientSettings, registerMgr *fcrregistermgr.FCRRegisterMgr) (*FilecoinRetrievalClient, error) {
+func NewFilecoinRetrievalClient(settings ClientSettings, registerMgr Register) (*FilecoinRetrievalClient, error) {
+	logger := settings.Logger()
 	f := &FilecoinRetrievalClient{
 		Settings:           settings,
 		GatewaysToUse:      make(map[string]register.GatewayRegistrar),
 		GatewaysToUseLock:  sync.RWMutex{},
 		ActiveGateways:     make(map[string]register.GatewayRegistrar),
 		ActiveGatewaysLock: sync.RWMutex{},
-		clientApi:          clientapi.NewClientApi(),
+		paymentStatus:      make(map[string]*GatewayPaymentStatus),
+		paymentChannelIDs:  make(map[string]int64),
+		channelLocks:       make(map[string]*sync.RWMutex),
+		clientApi:          settings.ClientApi(),
 		registerMgr:        registerMgr,
+		events:             newEventBus(),
+		trust:              newTrustState(),
+		watcher:            newRegisterWatcher(),
+		prices:             newPriceCache(),
+		random:             newRandomSource(settings),
+		logger:             logger,
+	}
+	if f.clientApi == nil {
+		f.clientApi = clientapi.NewClientApi(clientapi.WithLogger(logger), clientapi.WithTransport(settings.Transport()))
+	}
+	if settings.metrics != nil {
+		f.clientApi = newInstrumentedClientApi(f.clientApi, settings.metrics)
+		settings.metrics.setActiveGatewaysFunc(func() int {
+			f.ActiveGatewaysLock.RLock()
+			defer f.ActiveGatewaysLock.RUnlock()
+			return len(f.ActiveGateways)
+		})
+	}
+	if settings.rateLimited() {
+		f.clientApi = newRateLimitedClientApi(f.clientApi, newRateLimiter(settings.RateLimit))
+	}
+	f.breakers = newCircuitBreakers(settings.CircuitBreaker(), func(gatewayID string, state CircuitState) {
+		logger.Info("Gateway circuit breaker changed state", "gateway_id", gatewayID, "state", state)
+		f.events.publish(CircuitStateChangedEvent{now(), gatewayID, state})
+	})
+	if f.breakers.enabled() {
+		f.clientApi = newCircuitBreakerClientApi(f.clientApi, f.breakers)
 	}
 	return f, nil
 }
@@ -81,7 +138,7 @@ func (c *FilecoinRetrievalClient) PaymentMgr() *fcrpaymentmgr.FCRPaymentMgr {
 		if c.paymentMgr == nil {
 			mgr, err := fcrpaymentmgr.NewFCRPaymentMgr(c.Settings.walletPrivateKey, c.Settings.lotusAP, c.Settings.lotusAuthToken)
 			if err != nil {
-				logging.Error("Error initializing payment manager.")
+				c.logger.Error("Error initializing payment manager", "error", err)
 				return nil
 			}
 			c.paymentMgr = mgr
@@ -91,41 +148,38 @@ func (c *FilecoinRetrievalClient) PaymentMgr() *fcrpaymentmgr.FCRPaymentMgr {
 	return c.paymentMgr
 }
 
-// FindGateways find gateways located near to the specified location. Use AddGateways
+// Events returns the bus the client events are published on.
+func (c *FilecoinRetrievalClient) Events() *EventBus {
+	return c.events
+}
+
+// Logger returns the logger of the client.
+func (c *FilecoinRetrievalClient) Logger() fcrlogger.Logger {
+	return c.logger
+}
+
+// FindGateways find gateways located near to the specified location, nearest first. Gateways in the
+// location itself come first, followed by gateways in the closest regions. Use AddGateways
 // to use these gateways.
 func (c *FilecoinRetrievalClient) FindGateways(location string, maxNumToLocate int) ([]*nodeid.NodeID, error) {
-	// Determine gateways to use. For the moment, this is just "use all of them"
-	// TODO: This will have to become, use gateways that this client has FIL registered with.
-	gateways := c.registerMgr.GetAllGateways()
-	if gateways == nil {
-		return nil, errors.New("error in getting all registered gateways")
+	nearest, err := c.FindNearestGateways(location, maxNumToLocate)
+	if err != nil {
+		return nil, err
 	}
-
-	res := make([]*nodeid.NodeID, 0)
-	count := 0
-	for _, info := range gateways {
-		if info.GetRegionCode() == location {
-			nodeID, err := nodeid.NewNodeIDFromHexString(info.GetNodeID())
-			if err != nil {
-				logging.Error("Error in generating node id, skipping: %v", info.GetNodeID())
-				continue
-			}
-			res = append(res, nodeID)
-			count++
-			if count >= maxNumToLocate {
-				break
-			}
-		}
+	res := make([]*nodeid.NodeID, 0, len(nearest))
+	for _, gateway := range nearest {
+		res = append(res, gateway.GatewayID)
 	}
 	return res, nil
 }
 
-// AddGatewaysToUse adds one or more gateways to use.
+// AddGatewaysToUse adds one or more gateways to use. A gateway whose payment channel was settled is not added
+// again: the payment manager keeps paying a gateway with the same channel, which can no longer be used.
 func (c *FilecoinRetrievalClient) AddGatewaysToUse(gwNodeIDs []*nodeid.NodeID) int {
 	numAdded := 0
 	for _, gwToAddID := range gwNodeIDs {
 		if gwToAddID == nil {
-			logging.Error("can't add a gateway to use, a nil was given")
+			c.logger.Error("Can't add a gateway to use, a nil was given")
 			continue
 		}
 		c.GatewaysToUseLock.RLock()
@@ -136,56 +190,83 @@ func (c *FilecoinRetrievalClient) AddGatewaysToUse(gwNodeIDs []*nodeid.NodeID) i
 		}
 		gateway := c.registerMgr.GetGateway(gwToAddID)
 		if gateway == nil {
-			logging.Error("error getting registered gateway %v", gwToAddID.ToString())
+			c.logger.Error("Error getting registered gateway", "gateway_id", gwToAddID.ToString())
 			continue
 		}
-		if !validateGatewayInfo(gateway) {
-			logging.Error("Register info not valid.")
+		if !c.validGateway(gateway) {
+			c.logger.Error("Register info not valid")
 			continue
 		}
-		// Success
+		// A gateway whose payment channel is settled, or being settled, can no longer be paid
 		c.GatewaysToUseLock.Lock()
+		if !c.channelOpen(gwToAddID.ToString()) {
+			c.GatewaysToUseLock.Unlock()
+			c.logger.Error("Can't add a gateway to use whose payment channel is settled", "gateway_id", gwToAddID.ToString())
+			continue
+		}
+		// Success
 		c.GatewaysToUse[gwToAddID.ToString()] = gateway
 		c.GatewaysToUseLock.Unlock()
+		c.events.publish(GatewayAddedEvent{now(), gwToAddID.ToString()})
 		numAdded++
 	}
 	return numAdded
 }
 
 // RemoveGatewaysToUse removes one or more gateways from the list of Gateways to use.
-// This also removes the gateway from gateways in active map.
+// This also removes the gateway from gateways in active map and, if enabled in the settings,
+// settles their payment channels in the background.
 func (c *FilecoinRetrievalClient) RemoveGatewaysToUse(gwNodeIDs []*nodeid.NodeID) int {
+	return c.removeGatewaysToUse(gwNodeIDs, c.Settings.SettleOnRemove())
+}
+
+// removeGatewaysToUse is RemoveGatewaysToUse, settling the payment channels of the removed gateways if settle is true.
+func (c *FilecoinRetrievalClient) removeGatewaysToUse(gwNodeIDs []*nodeid.NodeID, settle bool) int {
 	c.GatewaysToUseLock.Lock()
 	defer c.GatewaysToUseLock.Unlock()
 
-	numRemoved := 0
+	removed := make([]string, 0, len(gwNodeIDs))
 	for _, gwToRemoveID := range gwNodeIDs {
 		_, exist := c.GatewaysToUse[gwToRemoveID.ToString()]
 		if exist {
 			delete(c.GatewaysToUse, gwToRemoveID.ToString())
-			numRemoved++
+			removed = append(removed, gwToRemoveID.ToString())
 			c.ActiveGatewaysLock.Lock()
-			delete(c.ActiveGateways, gwToRemoveID.ToString())
+			if _, active := c.ActiveGateways[gwToRemoveID.ToString()]; active {
+				delete(c.ActiveGateways, gwToRemoveID.ToString())
+				c.breakers.reset(gwToRemoveID.ToString())
+				c.events.publish(GatewayDeactivatedEvent{now(), gwToRemoveID.ToString()})
+			}
 			c.ActiveGatewaysLock.Unlock()
 		}
 	}
+	if settle {
+		c.settleRemoved(removed)
+	}
 
-	return numRemoved
+	return len(removed)
 }
 
 // RemoveAllGatewaysToUse removes all gateways from the list of Gateways.
-// This also cleared all gateways in active
+// This also cleared all gateways in active, and settles their payment channels if enabled, as RemoveGatewaysToUse does
 func (c *FilecoinRetrievalClient) RemoveAllGatewaysToUse() int {
 	c.GatewaysToUseLock.Lock()
 	defer c.GatewaysToUseLock.Unlock()
 	c.ActiveGatewaysLock.Lock()
 	defer c.ActiveGatewaysLock.Unlock()
 
-	numRemoved := len(c.GatewaysToUse)
+	removed := make([]string, 0, len(c.GatewaysToUse))
+	for id := range c.GatewaysToUse {
+		removed = append(removed, id)
+	}
+	c.publishDeactivated(c.ActiveGateways)
 	c.GatewaysToUse = make(map[string]register.GatewayRegistrar)
 	c.ActiveGateways = make(map[string]register.GatewayRegistrar)
+	if c.Settings.SettleOnRemove() {
+		c.settleRemoved(removed)
+	}
 
-	return numRemoved
+	return len(removed)
 }
 
 // GetGatewaysToUse returns the list of gateways to use.
@@ -197,7 +278,7 @@ func (c *FilecoinRetrievalClient) GetGatewaysToUse() []*nodeid.NodeID {
 	for key := range c.GatewaysToUse {
 		nodeID, err := nodeid.NewNodeIDFromHexString(key)
 		if err != nil {
-			logging.Error("Error in generating node id.")
+			c.logger.Error("Error in generating node id", "node_id", key)
 			continue
 		}
 		res = append(res, nodeID)
@@ -209,6 +290,12 @@ func (c *FilecoinRetrievalClient) GetGatewaysToUse() []*nodeid.NodeID {
 // AddActiveGateways adds one or more gateways to active gateway map.
 // Returns the number of gateways added.
 func (c *FilecoinRetrievalClient) AddActiveGateways(gwNodeIDs []*nodeid.NodeID) int {
+	return c.AddActiveGatewaysContext(context.Background(), gwNodeIDs)
+}
+
+// AddActiveGatewaysContext is AddActiveGateways with a context: the establishments stop when ctx is done, and are
+// traced as children of the span of ctx.
+func (c *FilecoinRetrievalClient) AddActiveGatewaysContext(ctx context.Context, gwNodeIDs []*nodeid.NodeID) int {
 	numAdded := 0
 	for _, gwToAddID := range gwNodeIDs {
 		c.ActiveGatewaysLock.RLock()
@@ -221,27 +308,39 @@ func (c *FilecoinRetrievalClient) AddActiveGateways(gwNodeIDs []*nodeid.NodeID)
 		gatewayRegistrar, exist := c.GatewaysToUse[strings.ToLower(gwToAddID.ToString())]
 		c.GatewaysToUseLock.RUnlock()
 		if !exist {
-			logging.Error("Given node id: %v does not exist in gateways to use map, consider add the gateway first.", gwToAddID.ToString())
+			c.logger.Error("Given node id does not exist in gateways to use map, consider add the gateway first", "gateway_id", gwToAddID.ToString())
 			continue
 		}
 		// Attempt an establishment
-		challenge := make([]byte, 32)
-		rand.Read(challenge)
-		ttl := time.Now().Unix() + c.Settings.EstablishmentTTL()
-		err := c.clientApi.RequestEstablishment(gatewayRegistrar, challenge, c.Settings.ClientID(), ttl)
-		if err != nil {
-			logging.Error("Error in initial establishment: %v", err.Error())
+		if err := c.establish(ctx, gwToAddID, gatewayRegistrar); err != nil {
+			c.logger.Error("Error in initial establishment", "gateway_id", gwToAddID.ToString(), "error", err)
 			continue
 		}
 		// It is success
+		c.breakers.reset(gwToAddID.ToString())
 		c.ActiveGatewaysLock.Lock()
 		c.ActiveGateways[gwToAddID.ToString()] = gatewayRegistrar
 		c.ActiveGatewaysLock.Unlock()
+		c.events.publish(GatewayActivatedEvent{now(), gwToAddID.ToString()})
 		numAdded++
 	}
 	return numAdded
 }
 
+// establish requests an establishment with a gateway, publishing an EstablishmentFailedEvent if it fails.
+func (c *FilecoinRetrievalClient) establish(ctx context.Context, gatewayID *nodeid.NodeID, gateway register.GatewayRegistrar) error {
+	challenge := make([]byte, 32)
+	c.random.read(challenge)
+	ttl := time.Now().Unix() + c.Settings.EstablishmentTTL()
+	ctx, span := startSpan(ctx, "fcrclient.Establishment", clientapi.AttributeGatewayID.String(gatewayID.ToString()))
+	err := c.clientApi.RequestEstablishment(ctx, gateway, challenge, c.Settings.ClientID(), ttl)
+	endSpan(span, err)
+	if err != nil {
+		c.events.publish(EstablishmentFailedEvent{now(), gatewayID.ToString(), err})
+	}
+	return err
+}
+
 // RemoveActiveGateways removes one or more gateways from the list of Gateways in active.
 func (c *FilecoinRetrievalClient) RemoveActiveGateways(gwNodeIDs []*nodeid.NodeID) int {
 	c.ActiveGatewaysLock.Lock()
@@ -252,6 +351,8 @@ func (c *FilecoinRetrievalClient) RemoveActiveGateways(gwNodeIDs []*nodeid.NodeI
 		_, exist := c.ActiveGateways[gwToRemoveID.ToString()]
 		if exist {
 			delete(c.ActiveGateways, gwToRemoveID.ToString())
+			c.breakers.reset(gwToRemoveID.ToString())
+			c.events.publish(GatewayDeactivatedEvent{now(), gwToRemoveID.ToString()})
 			numRemoved++
 		}
 	}
@@ -265,11 +366,20 @@ func (c *FilecoinRetrievalClient) RemoveAllActiveGateways() int {
 	defer c.ActiveGatewaysLock.Unlock()
 
 	numRemoved := len(c.ActiveGateways)
+	c.publishDeactivated(c.ActiveGateways)
 	c.ActiveGateways = make(map[string]register.GatewayRegistrar)
 
 	return numRemoved
 }
 
+// publishDeactivated publishes the deactivation of the given gateways.
+func (c *FilecoinRetrievalClient) publishDeactivated(gateways map[string]register.GatewayRegistrar) {
+	for id := range gateways {
+		c.breakers.reset(id)
+		c.events.publish(GatewayDeactivatedEvent{now(), id})
+	}
+}
+
 // GetActiveGateways returns the list of gateways that are active.
 func (c *FilecoinRetrievalClient) GetActiveGateways() []*nodeid.NodeID {
 	c.ActiveGatewaysLock.RLock()
@@ -279,7 +389,7 @@ func (c *FilecoinRetrievalClient) GetActiveGateways() []*nodeid.NodeID {
 	for key := range c.ActiveGateways {
 		nodeID, err := nodeid.NewNodeIDFromHexString(key)
 		if err != nil {
-			logging.Error("Error in generating node id.")
+			c.logger.Error("Error in generating node id", "node_id", key)
 			continue
 		}
 		res = append(res, nodeID)
@@ -288,8 +398,25 @@ func (c *FilecoinRetrievalClient) GetActiveGateways() []*nodeid.NodeID {
 	return res
 }
 
-// FindOffersStandardDiscovery finds offer using standard discovery from given gateways
+// FindOffersStandardDiscovery finds offer using standard discovery from given gateways.
+// Only the offers which pass verification are returned, see OfferRejectedEvent for the others.
 func (c *FilecoinRetrievalClient) FindOffersStandardDiscovery(contentID *cid.ContentID, gatewayID *nodeid.NodeID) ([]cidoffer.SubCIDOffer, error) {
+	return c.FindOffersStandardDiscoveryContext(context.Background(), contentID, gatewayID)
+}
+
+// FindOffersStandardDiscoveryContext is FindOffersStandardDiscovery with a context: the call stops when ctx is done,
+// and is traced as a child of the span of ctx.
+func (c *FilecoinRetrievalClient) FindOffersStandardDiscoveryContext(ctx context.Context, contentID *cid.ContentID, gatewayID *nodeid.NodeID) ([]cidoffer.SubCIDOffer, error) {
+	ctx, span := startSpan(ctx, "fcrclient.FindOffersStandardDiscovery",
+		clientapi.AttributeCID.String(contentID.ToString()),
+		clientapi.AttributeGatewayID.String(gatewayID.ToString()))
+	offers, err := c.findOffersStandardDiscovery(ctx, contentID, gatewayID)
+	endSpan(span, err)
+	return offers, err
+}
+
+// findOffersStandardDiscovery is FindOffersStandardDiscovery within the given context.
+func (c *FilecoinRetrievalClient) findOffersStandardDiscovery(ctx context.Context, contentID *cid.ContentID, gatewayID *nodeid.NodeID) ([]cidoffer.SubCIDOffer, error) {
 	c.ActiveGatewaysLock.RLock()
 	defer c.ActiveGatewaysLock.RUnlock()
 
@@ -298,47 +425,34 @@ func (c *FilecoinRetrievalClient) FindOffersStandardDiscovery(contentID *cid.Con
 		return make([]cidoffer.SubCIDOffer, 0), errors.New("given gatewayID is not in active nodes map")
 	}
 	// TODO need to do nonce management
-	offers, err := c.clientApi.RequestStandardDiscover(gw, contentID, rand.Int63(), time.Now().Unix()+c.Settings.EstablishmentTTL(), "", "")
+	offers, err := c.clientApi.RequestStandardDiscover(ctx, gw, contentID, c.random.nonce(), time.Now().Unix()+c.Settings.EstablishmentTTL(), "", "")
 	if err != nil {
-		logging.Warn("GatewayStdDiscovery error. Gateway: %s, Error: %s", gw.GetNodeID(), err)
+		c.logger.Warn("GatewayStdDiscovery error", "gateway_id", gw.GetNodeID(), "error", err)
 		return make([]cidoffer.SubCIDOffer, 0), errors.New("error in requesting standard discovery")
 	}
 	// Verify the offer one by one
-	for _, offer := range offers {
-		// Get provider's pubkey
-		provider := c.registerMgr.GetProvider(offer.GetProviderID())
-		if provider == nil {
-			logging.Error("Error getting provider info.")
-			continue
-		}
-		if !validateProviderInfo(provider) {
-			logging.Error("Provider register info not valid.")
-			continue
-		}
-		pubKey, err := provider.GetSigningKey()
-		if err != nil {
-			logging.Error("Fail to obtain public key.")
-			continue
-		}
-		// Verify the offer sig
-		err = offer.Verify(pubKey)
-		if err != nil {
-			logging.Error("Offer signature fail to verify 4, with error: " + fmt.Sprintf("%v", err))
-			continue
-		}
-		// Now Verify the merkle proof
-		if offer.VerifyMerkleProof() != nil {
-			logging.Error("Merkle proof verification failed.")
-			continue
-		}
-		// Offer pass verification
-		logging.Info("Offer pass every verification, added to result")
-	}
-	return offers, nil
+	return c.verifiedSubCIDOffers(ctx, offers), nil
 }
 
 // FindOffersDHTDiscovery finds offer using dht discovery from given gateways
 func (c *FilecoinRetrievalClient) FindOffersDHTDiscovery(contentID *cid.ContentID, gatewayID *nodeid.NodeID, numDHT int64) (map[string]*[]cidoffer.SubCIDOffer, error) {
+	return c.FindOffersDHTDiscoveryContext(context.Background(), contentID, gatewayID, numDHT)
+}
+
+// FindOffersDHTDiscoveryContext is FindOffersDHTDiscovery with a context: the call stops when ctx is done,
+// and is traced as a child of the span of ctx.
+func (c *FilecoinRetrievalClient) FindOffersDHTDiscoveryContext(ctx context.Context, contentID *cid.ContentID, gatewayID *nodeid.NodeID, numDHT int64) (map[string]*[]cidoffer.SubCIDOffer, error) {
+	ctx, span := startSpan(ctx, "fcrclient.FindOffersDHTDiscovery",
+		clientapi.AttributeCID.String(contentID.ToString()),
+		clientapi.AttributeGatewayID.String(gatewayID.ToString()),
+		clientapi.AttributeNumDHT.Int64(numDHT))
+	offersMap, err := c.findOffersDHTDiscovery(ctx, contentID, gatewayID, numDHT)
+	endSpan(span, err)
+	return offersMap, err
+}
+
+// findOffersDHTDiscovery is FindOffersDHTDiscovery within the given context.
+func (c *FilecoinRetrievalClient) findOffersDHTDiscovery(ctx context.Context, contentID *cid.ContentID, gatewayID *nodeid.NodeID, numDHT int64) (map[string]*[]cidoffer.SubCIDOffer, error) {
 	c.ActiveGatewaysLock.RLock()
 	defer c.ActiveGatewaysLock.RUnlock()
 
@@ -349,75 +463,23 @@ func (c *FilecoinRetrievalClient) FindOffersDHTDiscovery(contentID *cid.ContentI
 		return offersMap, errors.New("given gatewayID is not in active nodes map")
 	}
 	// TODO need to do nonce management
-	contacted, contactedResp, uncontactable, err := c.clientApi.RequestDHTDiscover(gw, contentID, rand.Int63(), time.Now().Unix()+c.Settings.EstablishmentTTL(), numDHT, false, "", "")
+	contacted, contactedResp, uncontactable, err := c.clientApi.RequestDHTDiscover(ctx, gw, contentID, c.random.nonce(), time.Now().Unix()+c.Settings.EstablishmentTTL(), numDHT, false, "", "")
 	if err != nil {
-		logging.Warn("GatewayDHTDiscovery error. Gateway: %s, Error: %s", gw.GetNodeID(), err)
+		c.logger.Warn("GatewayDHTDiscovery error", "gateway_id", gw.GetNodeID(), "error", err)
 		return offersMap, errors.New("error in requesting dht discovery")
 	}
 	for i := 0; i < len(uncontactable); i++ {
-		logging.Warn("Gateway: %v is uncontactable.", uncontactable[i].ToString())
+		c.logger.Warn("Gateway is uncontactable", "gateway_id", uncontactable[i].ToString())
 	}
 
 	for i := 0; i < len(contacted); i++ {
 		id := contacted[i]
 		resp := contactedResp[i]
-		// Verify the sub response
-		// Get gateway's pubkey
-		gateway := c.registerMgr.GetGateway(&id)
-		if gateway == nil {
-			logging.Error("Error in getting gateway info.")
-			continue
-		}
-		if !validateGatewayInfo(gateway) {
-			logging.Error("Gateway register info not valid.")
-			continue
-		}
-		pubKey, err := gateway.GetSigningKey()
-		if err != nil {
-			logging.Error("Fail to obtain public key.")
-			continue
-		}
-		if resp.Verify(pubKey) != nil {
-			logging.Error("Fail to verify sub response.")
-			continue
-		}
-		_, _, _, offers, _, err := fcrmessages.DecodeGatewayDHTDiscoverResponse(&resp)
+		entry, err := c.decodeGatewayDHTDiscoverResponse(ctx, &id, &resp)
 		if err != nil {
-			logging.Error("Fail to decode response")
+			c.logger.Error("Gateway sub response rejected", "gateway_id", id.ToString(), "error", err)
 			continue
 		}
-		entry := make([]cidoffer.SubCIDOffer, 0)
-		for _, offer := range offers {
-			// Get provider's pubkey
-			provider := c.registerMgr.GetProvider(offer.GetProviderID())
-			if provider == nil {
-				logging.Error("Error getting provider info.")
-				continue
-			}
-			if !validateProviderInfo(provider) {
-				logging.Error("Provider register info not valid.")
-				continue
-			}
-			pubKey, err := provider.GetSigningKey()
-			if err != nil {
-				logging.Error("Fail to obtain public key.")
-				continue
-			}
-			// Verify the offer sig
-			err = offer.Verify(pubKey)
-			if err != nil {
-				logging.Error("Offer signature fail to verify 5, with error: " + fmt.Sprintf("%v", err))
-				continue
-			}
-			// Now Verify the merkle proof
-			if offer.VerifyMerkleProof() != nil {
-				logging.Error("Merkle proof verification failed.")
-				continue
-			}
-			// Offer pass verification
-			entry = append(entry, offer)
-			logging.Info("Offer pass every verification, added to result")
-		}
 		offersMap[id.ToString()] = &entry
 	}
 
@@ -427,6 +489,23 @@ func (c *FilecoinRetrievalClient) FindOffersDHTDiscovery(contentID *cid.ContentI
 // FindOffersDHTDiscoveryV2 finds offer using dht discovery from given gateway with maximum number of offers
 // offersNumberLimit - maximum number of offers the client asking to have
 func (c *FilecoinRetrievalClient) FindOffersDHTDiscoveryV2(contentID *cid.ContentID, gatewayID *nodeid.NodeID, numDHT int64, offersNumberLimit int) (map[string]*[]cidoffer.SubCIDOffer, error) {
+	return c.FindOffersDHTDiscoveryV2Context(context.Background(), contentID, gatewayID, numDHT, offersNumberLimit)
+}
+
+// FindOffersDHTDiscoveryV2Context is FindOffersDHTDiscoveryV2 with a context: the call stops when ctx is done,
+// and is traced as a child of the span of ctx.
+func (c *FilecoinRetrievalClient) FindOffersDHTDiscoveryV2Context(ctx context.Context, contentID *cid.ContentID, gatewayID *nodeid.NodeID, numDHT int64, offersNumberLimit int) (map[string]*[]cidoffer.SubCIDOffer, error) {
+	ctx, span := startSpan(ctx, "fcrclient.FindOffersDHTDiscoveryV2",
+		clientapi.AttributeCID.String(contentID.ToString()),
+		clientapi.AttributeGatewayID.String(gatewayID.ToString()),
+		clientapi.AttributeNumDHT.Int64(numDHT))
+	offersMap, err := c.findOffersDHTDiscoveryV2(ctx, contentID, gatewayID, numDHT, offersNumberLimit)
+	endSpan(span, err)
+	return offersMap, err
+}
+
+// findOffersDHTDiscoveryV2 is FindOffersDHTDiscoveryV2 within the given context.
+func (c *FilecoinRetrievalClient) findOffersDHTDiscoveryV2(ctx context.Context, contentID *cid.ContentID, gatewayID *nodeid.NodeID, numDHT int64, offersNumberLimit int) (map[string]*[]cidoffer.SubCIDOffer, error) {
 	offersMap := make(map[string]*[]cidoffer.SubCIDOffer)
 
 	c.ActiveGatewaysLock.RLock()
@@ -438,33 +517,43 @@ func (c *FilecoinRetrievalClient) FindOffersDHTDiscoveryV2(contentID *cid.Conten
 		return nil, errors.New("given gatewayID is not in active nodes map")
 	}
 
-	defaultPaymentLane := uint64(0)
-	initialRequestPaymentAmount := new(big.Int).Mul(big.NewInt(numDHT), big.NewInt(defaultSearchPrice))
-	paymentChannel, voucher, needTopup, paymentErr := c.PaymentMgr().Pay(entryGateway.GetAddress(), defaultPaymentLane, initialRequestPaymentAmount)
-	if paymentErr != nil {
-		return nil, fmt.Errorf("Unable to make payment for initial DHT offers discovery, error: %s ", paymentErr.Error())
-	}
-	if needTopup {
-		if err := c.PaymentMgr().Topup(entryGateway.GetAddress(), c.Settings.topUpAmount); err != nil {
-			return nil, fmt.Errorf("Unable to topup while paying for initial offer DHT discovery, error: %s ", err.Error())
-		}
-		paymentChannel, voucher, needTopup, paymentErr = c.PaymentMgr().Pay(entryGateway.GetAddress(), defaultPaymentLane, initialRequestPaymentAmount)
-		if paymentErr != nil {
-			return nil, fmt.Errorf("Unable to make payment for initial DHT offers discovery, with topup first, error: %s ", paymentErr.Error())
-		}
+	prices, err := c.gatewayPrices(ctx, entryGateway.GetNodeID())
+	if err != nil {
+		return nil, err
 	}
-	logging.Info("Successful initial payment for DHT offers discovery, payment channel: %s, voucher: %s", paymentChannel, voucher)
+	lanes := c.newLaneSession(entryGateway.GetNodeID())
+	defer lanes.close()
+	discoverLane := lanes.lane(RequestDHTDiscover)
+	initialRequestPaymentAmount := new(big.Int).Mul(big.NewInt(numDHT), prices.SearchPrice)
 
 	// TODO need to do nonce management
-	nonce := rand.Int63()
+	nonce := c.random.nonce()
 	ttl := time.Now().Unix() + c.Settings.EstablishmentTTL()
-	contactedGateways, contactedResp, uncontactable, err := c.clientApi.RequestDHTDiscoverV2(entryGateway, contentID, nonce, ttl, numDHT, false, paymentChannel, voucher)
+	var contactedGateways []nodeid.NodeID
+	var contactedResp []fcrmessages.FCRMessage
+	var uncontactable []nodeid.NodeID
+	sendDiscover := func(paymentChannel string, voucher string) (err error) {
+		contactedGateways, contactedResp, uncontactable, err = c.clientApi.RequestDHTDiscoverV2(ctx, entryGateway, contentID, nonce, ttl, numDHT, false, paymentChannel, voucher)
+		if err != nil {
+			return err
+		}
+		return dhtSubResponsesPaymentRequired(entryGateway, contactedResp)
+	}
+	err = c.payAndSend(ctx, entryGateway, discoverLane, initialRequestPaymentAmount, initialRequestPaymentAmount, sendDiscover)
+	var paymentErr *paymentError
+	if errors.As(err, &paymentErr) {
+		return nil, fmt.Errorf("Unable to make payment for initial DHT offers discovery, error: %s ", paymentErr.Error())
+	}
+	var paymentRequired *clientapi.PaymentRequiredError
+	if errors.As(err, &paymentRequired) {
+		return nil, err
+	}
 	if err != nil {
-		logging.Warn("GatewayDHTDiscovery error. Gateway: %s, Error: %s", entryGateway.GetNodeID(), err)
+		c.logger.Warn("GatewayDHTDiscovery error", "gateway_id", entryGateway.GetNodeID(), "error", err)
 		return nil, errors.New("error in requesting dht discovery")
 	}
 	for i := 0; i < len(uncontactable); i++ {
-		logging.Warn("Gateway: %v is uncontactable.", uncontactable[i].ToString())
+		c.logger.Warn("Gateway is uncontactable", "gateway_id", uncontactable[i].ToString())
 	}
 
 	var addedSubOffersCount int
@@ -473,33 +562,15 @@ func (c *FilecoinRetrievalClient) FindOffersDHTDiscoveryV2(contentID *cid.Conten
 		contactedGatewayID := contactedGateways[i]
 		resp := contactedResp[i]
 		// Verify the sub response
-		// Get gateway's pubkey
-		gateway := c.registerMgr.GetGateway(&contactedGatewayID)
-		if gateway == nil {
-			logging.Error("Error in getting gateway info.")
-			continue
-		}
-		if !validateGatewayInfo(gateway) {
-			logging.Error("Gateway register info not valid.")
-			continue
-		}
-		pubKey, err := gateway.GetSigningKey()
-		if err != nil {
-			logging.Error("Fail to obtain public key.")
-			continue
-		}
-		if resp.Verify(pubKey) != nil {
-			logging.Error("Fail to verify sub response.")
+		if err := c.verifyGatewaySubResponse(ctx, &contactedGatewayID, &resp); err != nil {
+			c.logger.Error("Gateway sub response rejected", "gateway_id", contactedGatewayID.ToString(), "error", err)
 			continue
 		}
-		_, _, found, offerDigests, _, paymentRequired, paymentChannelAddrToTopup, err := fcrmessages.DecodeGatewayDHTDiscoverResponseV2(&resp)
+		_, _, found, offerDigests, _, _, _, err := fcrmessages.DecodeGatewayDHTDiscoverResponseV2(&resp)
 		if err != nil {
-			logging.Error("Fail to decode response")
+			c.logger.Error("Fail to decode response", "gateway_id", contactedGatewayID.ToString(), "error", err)
 			continue
 		}
-		if paymentRequired {
-			return nil, fmt.Errorf("payment required, in order to proceed topup your balance for payment channel address: %d", paymentChannelAddrToTopup)
-		}
 		if !found {
 			return offersMap, nil
 		}
@@ -521,24 +592,18 @@ func (c *FilecoinRetrievalClient) FindOffersDHTDiscoveryV2(contentID *cid.Conten
 	for _, entry := range offersDigestsFromAllGateways {
 		unit += len(entry)
 	}
-	offerRequestPaymentAmount := new(big.Int).Mul(big.NewInt(int64(unit)), c.Settings.offerPrice)
+	offerRequestPaymentAmount := new(big.Int).Mul(big.NewInt(int64(unit)), prices.OfferPrice)
 
-	paymentChannel, voucher, needTopup, paymentErr = c.PaymentMgr().Pay(entryGateway.GetAddress(), defaultPaymentLane, offerRequestPaymentAmount)
-	if paymentErr != nil {
-		return nil, fmt.Errorf("Unable to make payment for initial DHT offers discovery, error: %s ", paymentErr.Error())
+	offerLane := lanes.lane(RequestDHTOffer)
+	var allGatewaysOffers []clientapi.GatewaySubOffers
+	sendOffer := func(paymentChannel string, voucher string) (err error) {
+		allGatewaysOffers, err = c.clientApi.RequestDHTOfferDiscover(ctx, entryGateway, contactedGateways, contentID, c.random.nonce(), offersDigestsFromAllGateways, paymentChannel, voucher)
+		return err
 	}
-	if needTopup {
-		if err := c.PaymentMgr().Topup(entryGateway.GetAddress(), c.Settings.topUpAmount); err != nil {
-			return nil, fmt.Errorf("Unable to topup while paying for initial offer DHT discovery, error: %s ", err.Error())
-		}
-		paymentChannel, voucher, needTopup, paymentErr = c.PaymentMgr().Pay(entryGateway.GetAddress(), defaultPaymentLane, offerRequestPaymentAmount)
-		if paymentErr != nil {
-			return nil, fmt.Errorf("Unable to make payment for initial DHT offers discovery, with topup first, error: %s ", paymentErr.Error())
-		}
+	discoverError := c.payAndSend(ctx, entryGateway, offerLane, offerRequestPaymentAmount, offerRequestPaymentAmount, sendOffer)
+	if errors.As(discoverError, &paymentErr) {
+		return nil, fmt.Errorf("Unable to make payment for DHT offers discovery, error: %s ", paymentErr.Error())
 	}
-	logging.Info("Successful initial payment for DHT offers discovery, payment channel: %s, voucher: %s", paymentChannel, voucher)
-
-	allGatewaysOffers, discoverError := c.clientApi.RequestDHTOfferDiscover(entryGateway, contactedGateways, contentID, nonce, offersDigestsFromAllGateways, paymentChannel, voucher)
 	if discoverError != nil {
 		return nil, fmt.Errorf("error getting sub-offers from their digests: %s", discoverError)
 	}
@@ -549,123 +614,155 @@ func (c *FilecoinRetrievalClient) FindOffersDHTDiscoveryV2(contentID *cid.Conten
 	return offersMap, nil
 }
 
-// FindDHTOfferAck finds offer ack for a cid, gateway pair
+// FindDHTOfferAck finds offer ack for a cid, gateway pair.
+// Returns false if the provider has no ack, and an error if the ack fails verification.
 func (c *FilecoinRetrievalClient) FindDHTOfferAck(contentID *cid.ContentID, gatewayID *nodeid.NodeID, providerID *nodeid.NodeID) (bool, error) {
+	return c.FindDHTOfferAckContext(context.Background(), contentID, gatewayID, providerID)
+}
+
+// FindDHTOfferAckContext is FindDHTOfferAck with a context: the call stops when ctx is done,
+// and is traced as a child of the span of ctx.
+func (c *FilecoinRetrievalClient) FindDHTOfferAckContext(ctx context.Context, contentID *cid.ContentID, gatewayID *nodeid.NodeID, providerID *nodeid.NodeID) (bool, error) {
+	proof, err := c.FindDHTOfferAckProofContext(ctx, contentID, gatewayID, providerID)
+	return proof != nil, err
+}
+
+// FindDHTOfferAckProof finds offer ack for a cid, gateway pair, and returns the proof of the acknowledgement once verified,
+// see VerifyDHTOfferAck. Returns nil if the provider has no ack.
+func (c *FilecoinRetrievalClient) FindDHTOfferAckProof(contentID *cid.ContentID, gatewayID *nodeid.NodeID, providerID *nodeid.NodeID) (*DHTOfferAckProof, error) {
+	return c.FindDHTOfferAckProofContext(context.Background(), contentID, gatewayID, providerID)
+}
+
+// FindDHTOfferAckProofContext is FindDHTOfferAckProof with a context: the call stops when ctx is done,
+// and is traced as a child of the span of ctx.
+func (c *FilecoinRetrievalClient) FindDHTOfferAckProofContext(ctx context.Context, contentID *cid.ContentID, gatewayID *nodeid.NodeID, providerID *nodeid.NodeID) (*DHTOfferAckProof, error) {
+	ctx, span := startSpan(ctx, "fcrclient.FindDHTOfferAck",
+		clientapi.AttributeCID.String(contentID.ToString()),
+		clientapi.AttributeGatewayID.String(gatewayID.ToString()),
+		clientapi.AttributeProviderID.String(providerID.ToString()))
+	proof, err := c.findDHTOfferAck(ctx, contentID, gatewayID, providerID)
+	endSpan(span, err)
+	return proof, err
+}
+
+// findDHTOfferAck is FindDHTOfferAckProof within the given context.
+func (c *FilecoinRetrievalClient) findDHTOfferAck(ctx context.Context, contentID *cid.ContentID, gatewayID *nodeid.NodeID, providerID *nodeid.NodeID) (*DHTOfferAckProof, error) {
 	provider := c.registerMgr.GetProvider(providerID)
 	if provider == nil {
-		logging.Error("Error getting registered provider %v", providerID)
-		return false, errors.New("provider not found inside register")
+		c.logger.Error("Error getting registered provider", "provider_id", providerID.ToString())
+		return nil, errors.New("provider not found inside register")
 	}
-	if !validateProviderInfo(provider) {
-		logging.Error("Register info not valid.")
-		return false, errors.New("invalid register info")
+	if !c.validProvider(provider) {
+		c.logger.Error("Register info not valid")
+		return nil, errors.New("invalid register info")
 	}
 
-	found, request, ack, err := c.clientApi.RequestDHTOfferAck(provider, contentID, gatewayID)
+	found, request, ack, err := c.clientApi.RequestDHTOfferAck(ctx, provider, contentID, gatewayID)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	if !found {
-		return false, nil
+		return nil, nil
 	}
-	// We need to verify	1, request is indeed a request, ack is indeed an ack.
-	//						2, request is signed by provider, ack is signed by gateway
-	//						3, ack and request has same nonce
-	// TODO: We will have to also include the hash of the request inside the ack, so the hash can be checked
 
-	// Get provider pub key and gw pub key
 	// Get gateway's pubkey
 	gateway := c.registerMgr.GetGateway(gatewayID)
 	if gateway == nil {
-		logging.Error("Error in getting gateway info.")
-		return false, errors.New("error in getting gateway info")
+		c.logger.Error("Error in getting gateway info", "gateway_id", gatewayID.ToString())
+		return nil, errors.New("error in getting gateway info")
 	}
-	if !validateGatewayInfo(gateway) {
-		logging.Error("Gateway register info not valid.")
-		return false, errors.New("gateway register info not valid")
+	if !c.validGateway(gateway) {
+		c.logger.Error("Gateway register info not valid", "gateway_id", gatewayID.ToString())
+		return nil, errors.New("gateway register info not valid")
 	}
 	gwPubKey, err := gateway.GetSigningKey()
 	if err != nil {
-		logging.Error("Fail to obtain public key.")
-		return false, errors.New("fail to obtain public key")
+		c.logger.Error("Fail to obtain public key", "error", err)
+		return nil, errors.New("fail to obtain public key")
 	}
 	// Get provider's pubkey
 	pvdPubKey, err := provider.GetSigningKey()
 	if err != nil {
-		logging.Error("Fail to obtain public key.")
-		return false, errors.New("fail to obtain public key")
-	}
-	// Verify the request.
-	if request.Verify(pvdPubKey) != nil {
-		return false, errors.New("error in verifying request")
-	}
-	// Verify the offer indeed contains the given cid
-	_, _, offers, err := fcrmessages.DecodeProviderPublishDHTOfferRequest(request)
-	if err != nil {
-		return false, err
-	}
-	found = false
-	for _, offer := range offers {
-		if offer.GetCIDs()[0].ToString() == contentID.ToString() {
-			found = true
-			break
-		}
-	}
-	if !found {
-		return false, errors.New("initial request does not contain the given cid")
-	}
-	// Verify the ack
-	if ack.Verify(gwPubKey) != nil {
-		return false, errors.New("error in verifying the ack")
-	}
-	_, signature, err := fcrmessages.DecodeProviderPublishDHTOfferResponse(ack)
-	if err != nil {
-		return false, err
+		c.logger.Error("Fail to obtain public key", "error", err)
+		return nil, errors.New("fail to obtain public key")
 	}
-	// Verify ack against request
-	ok, err := fcrcrypto.VerifyMessage(gwPubKey, signature, request.GetMessageBody())
+	proof, err := VerifyDHTOfferAck(contentID, gatewayID, providerID, request, ack, pvdPubKey, gwPubKey)
 	if err != nil {
-		return false, err
+		return nil, &ackRejectionError{err.Error()}
 	}
-	return ok, nil
+	c.watcher.watchProvider(provider)
+	return proof, nil
 }
 
 // FindOffersStandardDiscoveryV2 finds offer using standard discovery from given gateways
 func (c *FilecoinRetrievalClient) FindOffersStandardDiscoveryV2(contentID *cid.ContentID, gatewayID *nodeid.NodeID, maxOffers int) ([]cidoffer.SubCIDOffer, error) {
+	return c.FindOffersStandardDiscoveryV2Context(context.Background(), contentID, gatewayID, maxOffers)
+}
+
+// FindOffersStandardDiscoveryV2Context is FindOffersStandardDiscoveryV2 with a context: the call stops when ctx is done,
+// and is traced as a child of the span of ctx.
+func (c *FilecoinRetrievalClient) FindOffersStandardDiscoveryV2Context(ctx context.Context, contentID *cid.ContentID, gatewayID *nodeid.NodeID, maxOffers int) ([]cidoffer.SubCIDOffer, error) {
+	ctx, span := startSpan(ctx, "fcrclient.FindOffersStandardDiscoveryV2",
+		clientapi.AttributeCID.String(contentID.ToString()),
+		clientapi.AttributeGatewayID.String(gatewayID.ToString()))
+	offers, err := c.findOffersStandardDiscoveryV2(ctx, contentID, gatewayID, maxOffers)
+	endSpan(span, err)
+	return offers, err
+}
+
+// findOffersStandardDiscoveryV2 is FindOffersStandardDiscoveryV2 within the given context.
+func (c *FilecoinRetrievalClient) findOffersStandardDiscoveryV2(ctx context.Context, contentID *cid.ContentID, gatewayID *nodeid.NodeID, maxOffers int) ([]cidoffer.SubCIDOffer, error) {
+	gw, err := c.getActiveGateway(gatewayID)
+	if err != nil {
+		return make([]cidoffer.SubCIDOffer, 0), err
+	}
+	return c.standardDiscoveryV2(ctx, gw, contentID, maxOffers, nil)
+}
+
+// getActiveGateway returns the registration of an active gateway.
+func (c *FilecoinRetrievalClient) getActiveGateway(gatewayID *nodeid.NodeID) (register.GatewayRegistrar, error) {
 	c.ActiveGatewaysLock.RLock()
 	defer c.ActiveGatewaysLock.RUnlock()
-
 	gw, exists := c.ActiveGateways[gatewayID.ToString()]
 	if !exists {
-		return make([]cidoffer.SubCIDOffer, 0), errors.New("given gatewayID is not in active nodes map")
+		return nil, errors.New("given gatewayID is not in active nodes map")
 	}
+	return gw, nil
+}
 
+// standardDiscoveryV2 pays for and requests the offer digests of a CID from a gateway, then pays for and
+// requests at most maxOffers of the corresponding offers. expected is the amount the caller expects to spend with
+// the gateway, passed on to the top up policy; the cost of this discovery is used if nil.
+func (c *FilecoinRetrievalClient) standardDiscoveryV2(ctx context.Context, gw register.GatewayRegistrar, contentID *cid.ContentID, maxOffers int, expected *big.Int) ([]cidoffer.SubCIDOffer, error) {
 	cidOffers := make([]cidoffer.SubCIDOffer, 0)
 
-	// It will call the payment manager to pay for the initial request
-	paychAddr, voucher, topup, err := c.paymentMgr.Pay(gw.GetAddress(), 0, c.Settings.searchPrice)
+	prices, err := c.gatewayPrices(ctx, gw.GetNodeID())
 	if err != nil {
-		return cidOffers, fmt.Errorf("error paying gateway ID: %s; error:%s", gw.GetNodeID(), err.Error())
+		return cidOffers, err
 	}
-
-	// There isn't any balance in the payment channel, need to topup (create)
-	if topup == true {
-		// If topup failed, then probably there is not enough balance, return detailed error.
-		err = c.PaymentMgr().Topup(gw.GetAddress(), c.Settings.topUpAmount)
-		if err != nil {
-			return make([]cidoffer.SubCIDOffer, 0), fmt.Errorf("error to topup payment channel for gateway ID: %s;  error: %s", gw.GetNodeID(), err.Error())
-		}
-		paychAddr, voucher, topup, err = c.paymentMgr.Pay(gw.GetAddress(), 0, c.Settings.searchPrice)
-		if err != nil {
-			return cidOffers, fmt.Errorf("topup successed but error paying gateway ID: %s; error:%s", gw.GetNodeID(), err.Error())
-		}
+	if expected == nil {
+		expected = new(big.Int).Mul(big.NewInt(int64(maxOffers)), prices.OfferPrice)
+		expected.Add(expected, prices.SearchPrice)
 	}
 
+	lanes := c.newLaneSession(gw.GetNodeID())
+	defer lanes.close()
+	discoverLane := lanes.lane(RequestStandardDiscover)
+
 	// It pays for the first request to get a list of offer digests.
 	// TODO need to do nonce management
-	offerDigests, err := c.clientApi.RequestStandardDiscoverV2(gw, contentID, rand.Int63(), time.Now().Unix()+c.Settings.EstablishmentTTL(), paychAddr, voucher)
+	var offerDigests [][cidoffer.CIDOfferDigestSize]byte
+	sendDiscover := func(paychAddr string, voucher string) (err error) {
+		offerDigests, err = c.clientApi.RequestStandardDiscoverV2(ctx, gw, contentID, c.random.nonce(), time.Now().Unix()+c.Settings.EstablishmentTTL(), paychAddr, voucher)
+		return err
+	}
+	err = c.payAndSend(ctx, gw, discoverLane, prices.SearchPrice, expected, sendDiscover)
+	var paymentErr *paymentError
+	if errors.As(err, &paymentErr) {
+		return cidOffers, paymentErr.err
+	}
 	if err != nil {
-		return make([]cidoffer.SubCIDOffer, 0), fmt.Errorf("error getting offer from gateway: %s;  error: %s", gw.GetNodeID(), err.Error())
+		return cidOffers, fmt.Errorf("error getting offer from gateway: %s;  error: %s", gw.GetNodeID(), err.Error())
 	}
 	if len(offerDigests) == 0 {
 		// No offer found
@@ -679,69 +776,27 @@ func (c *FilecoinRetrievalClient) FindOffersStandardDiscoveryV2(contentID *cid.C
 	}
 
 	lenOffers := new(big.Int).SetInt64(int64(len(offerDigests)))
-	expectedAmount := lenOffers.Mul(lenOffers, c.Settings.offerPrice)
+	expectedAmount := lenOffers.Mul(lenOffers, prices.OfferPrice)
 
-	paychAddr, voucher, topup, err = c.paymentMgr.Pay(gw.GetAddress(), 0, expectedAmount)
-	if err != nil {
-		return cidOffers, fmt.Errorf("error paying expected amount to gateway ID: %s; error:%s", gw.GetNodeID(), err.Error())
+	remaining := new(big.Int).Sub(expected, prices.SearchPrice)
+	offerLane := lanes.lane(RequestStandardOffer)
+	var offers []cidoffer.SubCIDOffer
+	sendOffer := func(paychAddr string, voucher string) (err error) {
+		offers, err = c.clientApi.RequestStandardDiscoverOffer(ctx, gw, contentID, c.random.nonce(), time.Now().Unix()+c.Settings.EstablishmentTTL(), offerDigests, paychAddr, voucher)
+		return err
 	}
-
-	// There isn't any balance in the payment channel, need to topup (create)
-	if topup == true {
-		// If topup failed, then there is not enough balance, return error.
-		err = c.PaymentMgr().Topup(gw.GetAddress(), c.Settings.TopUpAmount())
-		if err != nil {
-			return make([]cidoffer.SubCIDOffer, 0), fmt.Errorf("error to topup payment channel for gateway ID: %s;  error: %s", gw.GetNodeID(), err.Error())
-		}
-		paychAddr, voucher, topup, err = c.paymentMgr.Pay(gw.GetAddress(), 0, expectedAmount)
-		if err != nil {
-			return cidOffers, fmt.Errorf("topup successed but error paying gateway ID: %s; error:%s", gw.GetNodeID(), err.Error())
-		}
+	err = c.payAndSend(ctx, gw, offerLane, expectedAmount, remaining, sendOffer)
+	if errors.As(err, &paymentErr) {
+		return cidOffers, paymentErr.err
+	}
+	if err != nil {
+		return cidOffers, fmt.Errorf("error getting offers from gateway: %s;  error: %s", gw.GetNodeID(), err.Error())
 	}
 
-	offers, err := c.clientApi.RequestStandardDiscoverOffer(gw, contentID, rand.Int63(), time.Now().Unix()+c.Settings.EstablishmentTTL(), offerDigests, paychAddr, voucher)
-
-	var validOffers []cidoffer.SubCIDOffer
 	// Verify the offer one by one
-	for _, offer := range offers {
-		// Get provider's pubkey
-		provider := c.registerMgr.GetProvider(offer.GetProviderID())
-		if provider == nil {
-			logging.Error("Error getting provider info.")
-			continue
-		}
-		if !validateProviderInfo(provider) {
-			logging.Error("Provider register info not valid.")
-			continue
-		}
-		if err != nil {
-			logging.Error("Offer signature fail to verify 1, with error: " + fmt.Sprintf("%v", err))
-			continue
-		}
-		pubKey, err := provider.GetSigningKey()
-		if err != nil {
-			logging.Error("Fail to obtain public key.")
-			continue
-		}
-		// Verify the offer sig
-		err = offer.Verify(pubKey)
-		if err != nil {
-			logging.Error("Offer signature fail to verify 2, with error: " + fmt.Sprintf("%v", err))
-			continue
-		}
-		// Now Verify the merkle proof
-		if offer.VerifyMerkleProof() != nil {
-			logging.Error("Merkle proof verification failed.")
-			continue
-		}
-		// Offer pass verification
-		logging.Info("Offer pass every verification, added to result")
-
-		validOffers = append(validOffers, offer)
-		if len(validOffers) == maxOffers {
-			break
-		}
+	validOffers := c.verifiedSubCIDOffers(ctx, offers)
+	if len(validOffers) > maxOffers {
+		validOffers = validOffers[:maxOffers]
 	}
-
 	return validOffers, nil
 }
@@ -0,0 +1,213 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrcrypto"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+	"github.com/golang/mock/gomock"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi"
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi/mocks"
+	"github.com/ConsenSys/fc-retrieval-client/pkg/fcrlogger"
+)
+
+// newActiveGatewayClient creates a client using api, with gateway active, and provider and the other gateways in
+// the register.
+func newActiveGatewayClient(t *testing.T, api *mocks.MockClientApi, gateway *testNode, provider *testNode, others ...*testNode) *FilecoinRetrievalClient {
+	t.Helper()
+	gatewayEntry := gateway.gateway(t)
+	gateways := []register.GatewayRegistrar{gatewayEntry}
+	for _, other := range others {
+		gateways = append(gateways, other.gateway(t))
+	}
+	registerMgr := newTestRegister(gateways, []register.ProviderRegistrar{provider.provider(t)})
+	c := newTestClientWithRegister(t, registerMgr, func(builder *SettingsBuilder) {
+		builder.SetClientApi(api)
+	})
+	api.EXPECT().RequestEstablishment(gomock.Any(), gatewayEntry, gomock.Any(), c.Settings.ClientID(), gomock.Any()).Return(nil)
+	if c.AddGatewaysToUse([]*nodeid.NodeID{gateway.id}) != 1 || c.AddActiveGateways([]*nodeid.NodeID{gateway.id}) != 1 {
+		t.Fatal("gateway not activated")
+	}
+	return c
+}
+
+// signedMessage signs msg with the signing key of node.
+func signedMessage(t *testing.T, node *testNode, msg *fcrmessages.FCRMessage, err error) fcrmessages.FCRMessage {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.Sign(node.signingKey, fcrcrypto.InitialKeyVersion()); err != nil {
+		t.Fatal(err)
+	}
+	return *msg
+}
+
+// dhtOfferDigests returns the response of a contacted gateway of a DHT discovery v2, with one offer digest.
+func dhtOfferDigests(t *testing.T, node *testNode, contentID *cid.ContentID, digest byte) fcrmessages.FCRMessage {
+	t.Helper()
+	msg, err := fcrmessages.EncodeGatewayDHTDiscoverResponseV2(contentID, 1, true, [][cidoffer.CIDOfferDigestSize]byte{{digest}}, nil, false, 0)
+	return signedMessage(t, node, msg, err)
+}
+
+// newPayingActiveGatewayClient creates a client paying through a test payment channel, with entry active, and provider and the
+// contacted gateways in the register.
+func newPayingActiveGatewayClient(t *testing.T, api *mocks.MockClientApi, entry *testNode, provider *testNode, contacted ...*testNode) *FilecoinRetrievalClient {
+	t.Helper()
+	c := newActiveGatewayClient(t, api, entry, provider, contacted...)
+	c.payer = &testPayer{channel: "f01234"}
+	return c
+}
+
+func TestFindOffersStandardDiscoveryKeepsVerifiedOffers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	api := mocks.NewMockClientApi(ctrl)
+	gateway, provider := newTestNode(t), newTestNode(t)
+	c := newActiveGatewayClient(t, api, gateway, provider)
+
+	contentID := cid.NewRandomContentID()
+	valid := newTestOffer(t, provider.id, provider.signingKey, contentID, 10)
+	forged := newTestOffer(t, provider.id, newTestKey(t), contentID, 1)
+	unknown := newTestOffer(t, nodeid.NewRandomNodeID(), provider.signingKey, contentID, 1)
+	api.EXPECT().RequestStandardDiscover(gomock.Any(), gomock.Any(), contentID, gomock.Any(), gomock.Any(), "", "").
+		Return([]cidoffer.SubCIDOffer{forged, valid, unknown}, nil)
+
+	offers, err := c.FindOffersStandardDiscovery(contentID, gateway.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offers) != 1 || offers[0].GetSignature() != valid.GetSignature() {
+		t.Fatalf("expected only the offer signed by the provider, got %d offers", len(offers))
+	}
+}
+
+func TestFindOffersStandardDiscoveryFailsWithGateway(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	api := mocks.NewMockClientApi(ctrl)
+	gateway, provider := newTestNode(t), newTestNode(t)
+	c := newActiveGatewayClient(t, api, gateway, provider)
+
+	contentID := cid.NewRandomContentID()
+	api.EXPECT().RequestStandardDiscover(gomock.Any(), gomock.Any(), contentID, gomock.Any(), gomock.Any(), "", "").
+		Return(nil, errors.New("gateway unavailable"))
+	if _, err := c.FindOffersStandardDiscovery(contentID, gateway.id); err == nil {
+		t.Fatal("failed discovery reported no error")
+	}
+	if _, err := c.FindOffersStandardDiscovery(contentID, nodeid.NewRandomNodeID()); err == nil {
+		t.Fatal("discovery through a gateway not active reported no error")
+	}
+}
+
+func TestAddGatewaysToUseRequiresValidRegisterEntry(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	api := mocks.NewMockClientApi(ctrl)
+	gateway := newTestNode(t)
+	invalid := gateway.gateway(t).Serialize()
+	invalid.RegionCode = ""
+	registerMgr := newTestRegister([]register.GatewayRegistrar{&invalid}, nil)
+	c := newTestClientWithRegister(t, registerMgr, func(builder *SettingsBuilder) {
+		builder.SetClientApi(api)
+	})
+	if c.AddGatewaysToUse([]*nodeid.NodeID{gateway.id, nodeid.NewRandomNodeID()}) != 0 {
+		t.Fatal("gateway with an invalid or missing register entry added")
+	}
+}
+
+// recordingLogger - a logger keeping the messages logged at the error level, with their keys and values
+type recordingLogger struct {
+	lock   sync.Mutex
+	errors map[string][]interface{}
+}
+
+func (l *recordingLogger) Debug(msg string, keysAndValues ...interface{}) {}
+
+func (l *recordingLogger) Info(msg string, keysAndValues ...interface{}) {}
+
+func (l *recordingLogger) Warn(msg string, keysAndValues ...interface{}) {}
+
+func (l *recordingLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.errors[msg] = keysAndValues
+}
+
+func TestClientLogsWithConfiguredLogger(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	api := mocks.NewMockClientApi(ctrl)
+	gateway, provider := newTestNode(t), newTestNode(t)
+	gatewayEntry := gateway.gateway(t)
+	registerMgr := newTestRegister([]register.GatewayRegistrar{gatewayEntry}, []register.ProviderRegistrar{provider.provider(t)})
+	logger := &recordingLogger{errors: make(map[string][]interface{})}
+	c := newTestClientWithRegister(t, registerMgr, func(builder *SettingsBuilder) {
+		builder.SetClientApi(api)
+		builder.SetLogger(logger)
+	})
+	if c.Logger() != fcrlogger.Logger(logger) {
+		t.Fatal("client not logging with the logger of the settings")
+	}
+	api.EXPECT().RequestEstablishment(gomock.Any(), gatewayEntry, gomock.Any(), c.Settings.ClientID(), gomock.Any()).Return(nil)
+	if c.AddGatewaysToUse([]*nodeid.NodeID{gateway.id}) != 1 || c.AddActiveGateways([]*nodeid.NodeID{gateway.id}) != 1 {
+		t.Fatal("gateway not activated")
+	}
+
+	contentID := cid.NewRandomContentID()
+	forged := newTestOffer(t, provider.id, newTestKey(t), contentID, 1)
+	api.EXPECT().RequestStandardDiscover(gomock.Any(), gomock.Any(), contentID, gomock.Any(), gomock.Any(), "", "").
+		Return([]cidoffer.SubCIDOffer{forged}, nil)
+	if _, err := c.FindOffersStandardDiscovery(contentID, gateway.id); err != nil {
+		t.Fatal(err)
+	}
+	keysAndValues, logged := logger.errors["Offer rejected"]
+	if !logged || len(keysAndValues) < 2 || keysAndValues[0] != "provider_id" || keysAndValues[1] != provider.id.ToString() {
+		t.Fatalf("rejected offer not logged with its provider, got %v", keysAndValues)
+	}
+}
+
+func TestFindOffersDHTDiscoveryV2UsesFreshNonces(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	api := mocks.NewMockClientApi(ctrl)
+	entry, provider, first := newTestNode(t), newTestNode(t), newTestNode(t)
+	c := newPayingActiveGatewayClient(t, api, entry, provider, first)
+
+	contentID := cid.NewRandomContentID()
+	var discoverNonce int64
+	api.EXPECT().RequestDHTDiscoverV2(gomock.Any(), gomock.Any(), contentID, gomock.Any(), gomock.Any(), int64(1), false, "f01234", gomock.Any()).
+		DoAndReturn(func(ctx context.Context, gw register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, numDHT int64, incrementalResult bool, paychAddr string, voucher string) ([]nodeid.NodeID, []fcrmessages.FCRMessage, []nodeid.NodeID, error) {
+			discoverNonce = nonce
+			return []nodeid.NodeID{*first.id}, []fcrmessages.FCRMessage{dhtOfferDigests(t, first, contentID, 1)}, nil, nil
+		})
+	api.EXPECT().RequestDHTOfferDiscover(gomock.Any(), gomock.Any(), gomock.Any(), contentID, gomock.Any(), gomock.Any(), "f01234", gomock.Any()).
+		DoAndReturn(func(ctx context.Context, gw register.GatewayRegistrar, gatewayIDs []nodeid.NodeID, contentID *cid.ContentID, nonce int64, digests [][][cidoffer.CIDOfferDigestSize]byte, paychAddr string, voucher string) ([]clientapi.GatewaySubOffers, error) {
+			if nonce == discoverNonce {
+				t.Error("offer request reuses the nonce of the discovery")
+			}
+			return nil, nil
+		})
+
+	if _, err := c.FindOffersDHTDiscoveryV2(contentID, entry.id, 1, 10); err != nil {
+		t.Fatal(err)
+	}
+}
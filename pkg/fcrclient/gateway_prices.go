@@ -0,0 +1,188 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrcrypto"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+)
+
+// GatewayPricesPath is the path at which a gateway publishes its prices on its client network address, for the
+// GatewayPriceSource.
+const GatewayPricesPath = "/v1/prices"
+
+// maxGatewayPricesSize is the maximum size of the prices published by a gateway.
+const maxGatewayPricesSize = 4096
+
+// GatewayLookup returns the register entry of a gateway, nil if it is not registered. Register implements it.
+type GatewayLookup interface {
+	GetGateway(id *nodeid.NodeID) register.GatewayRegistrar
+}
+
+// PriceSources - price sources asked in order, the first one knowing the prices of a gateway giving them
+type PriceSources []PriceSource
+
+// GatewayPrices implements PriceSource
+func (s PriceSources) GatewayPrices(ctx context.Context, gatewayID string) (*GatewayPrices, error) {
+	for _, source := range s {
+		prices, err := source.GatewayPrices(ctx, gatewayID)
+		if err != nil || prices != nil {
+			return prices, err
+		}
+	}
+	return nil, nil
+}
+
+// GatewayPriceSource - a PriceSource asking each gateway for its prices.
+// This is experimental: the client protocol has no message carrying prices, so the prices are read over HTTP
+// from GatewayPricesPath on the client network address of the gateway, as the JSON object of SignGatewayPrices.
+// The prices must be signed by the gateway with the signing key of its register entry and still be valid: they
+// are read outside the client transport, so prices whose signature does not verify are refused. The prices of
+// a gateway which does not publish them are not known.
+type GatewayPriceSource struct {
+	gateways   GatewayLookup
+	httpClient *http.Client
+}
+
+// gatewayPricesResponse - the prices published by a gateway, signed by the gateway
+type gatewayPricesResponse struct {
+	GatewayID   string `json:"gateway_id"`
+	SearchPrice string `json:"search_price"`
+	OfferPrice  string `json:"offer_price"`
+	// ValidUntil is the unix time after which the prices are no longer valid
+	ValidUntil int64 `json:"valid_until"`
+	// Signature is the hex encoded signature of the prices by the signing key of the gateway
+	Signature string `json:"signature"`
+}
+
+// signedContent returns what the signature of the prices signs.
+func (r *gatewayPricesResponse) signedContent() []byte {
+	return []byte(fmt.Sprintf("%s:%s:%s:%d", strings.ToLower(r.GatewayID), r.SearchPrice, r.OfferPrice, r.ValidUntil))
+}
+
+// SignGatewayPrices returns the prices of a gateway, valid until validUntil and signed with its signing key, as
+// the gateway publishes them at GatewayPricesPath for the GatewayPriceSource. A nil price is not published.
+func SignGatewayPrices(signingKey *fcrcrypto.KeyPair, gatewayID *nodeid.NodeID, prices GatewayPrices, validUntil time.Time) ([]byte, error) {
+	published := gatewayPricesResponse{GatewayID: gatewayID.ToString(), ValidUntil: validUntil.Unix()}
+	if prices.SearchPrice != nil {
+		published.SearchPrice = prices.SearchPrice.String()
+	}
+	if prices.OfferPrice != nil {
+		published.OfferPrice = prices.OfferPrice.String()
+	}
+	signature, err := signingKey.Sign(published.signedContent())
+	if err != nil {
+		return nil, fmt.Errorf("error signing the prices: %s", err.Error())
+	}
+	published.Signature = hex.EncodeToString(signature)
+	return json.Marshal(published)
+}
+
+// verify checks the prices are the prices of gateway, signed with its signing key, and still valid.
+func (r *gatewayPricesResponse) verify(gateway register.GatewayRegistrar) error {
+	if !strings.EqualFold(r.GatewayID, gateway.GetNodeID()) {
+		return errors.New("prices of another gateway")
+	}
+	if time.Now().Unix() > r.ValidUntil {
+		return errors.New("prices no longer valid")
+	}
+	signingKey, err := gateway.GetSigningKey()
+	if err != nil {
+		return fmt.Errorf("error getting the signing key: %s", err.Error())
+	}
+	signature, err := hex.DecodeString(r.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %s", err.Error())
+	}
+	ok, err := signingKey.Verify(signature, r.signedContent())
+	if err != nil || !ok {
+		return errors.New("signature of the prices does not verify")
+	}
+	return nil
+}
+
+// NewGatewayPriceSource creates a price source asking the gateways found with gateways for their prices.
+// A nil httpClient means http.DefaultClient.
+func NewGatewayPriceSource(gateways GatewayLookup, httpClient *http.Client) *GatewayPriceSource {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &GatewayPriceSource{gateways: gateways, httpClient: httpClient}
+}
+
+// GatewayPrices implements PriceSource
+func (s *GatewayPriceSource) GatewayPrices(ctx context.Context, gatewayID string) (*GatewayPrices, error) {
+	id, err := nodeid.NewNodeIDFromHexString(gatewayID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gateway id %s: %s", gatewayID, err.Error())
+	}
+	gateway := s.gateways.GetGateway(id)
+	if gateway == nil {
+		return nil, fmt.Errorf("gateway %s is not in the register", gatewayID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+gateway.GetNetworkInfoClient()+GatewayPricesPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error asking gateway %s for its prices: %s", gatewayID, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// The gateway does not publish its prices
+		return nil, nil
+	}
+	var published gatewayPricesResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxGatewayPricesSize)).Decode(&published); err != nil {
+		return nil, fmt.Errorf("error decoding the prices of gateway %s: %s", gatewayID, err.Error())
+	}
+	if err := published.verify(gateway); err != nil {
+		return nil, fmt.Errorf("prices of gateway %s refused: %s", gatewayID, err.Error())
+	}
+	prices := &GatewayPrices{}
+	if prices.SearchPrice, err = parsePublishedPrice(published.SearchPrice); err != nil {
+		return nil, fmt.Errorf("invalid search price of gateway %s: %s", gatewayID, err.Error())
+	}
+	if prices.OfferPrice, err = parsePublishedPrice(published.OfferPrice); err != nil {
+		return nil, fmt.Errorf("invalid offer price of gateway %s: %s", gatewayID, err.Error())
+	}
+	return prices, nil
+}
+
+// parsePublishedPrice parses a price in attoFIL, nil if it is not published.
+func parsePublishedPrice(price string) (*big.Int, error) {
+	if price == "" {
+		return nil, nil
+	}
+	amount, ok := new(big.Int).SetString(price, 10)
+	if !ok || amount.Sign() < 0 {
+		return nil, fmt.Errorf("%q is not an amount of attoFIL", price)
+	}
+	return amount, nil
+}
@@ -0,0 +1,147 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+)
+
+// signedPrices returns the prices of gateway signed with its signing key, valid for a minute.
+func signedPrices(t *testing.T, gateway *testNode, searchPrice int64, offerPrice int64) string {
+	t.Helper()
+	body, err := SignGatewayPrices(gateway.signingKey, gateway.id, GatewayPrices{SearchPrice: big.NewInt(searchPrice), OfferPrice: big.NewInt(offerPrice)}, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// newPublishingGateway starts a server answering the prices of a gateway with body, or not found if body is empty,
+// and returns the register with the gateway listening on it.
+func newPublishingGateway(t *testing.T, gateway *testNode, body string) *testRegister {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != GatewayPricesPath || body == "" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	entry := gateway.gateway(t).Serialize()
+	entry.NetworkInfoClient = strings.TrimPrefix(server.URL, "http://")
+	return newTestRegister([]register.GatewayRegistrar{&entry}, nil)
+}
+
+// newAskingClient creates a client asking the gateways of registerMgr for their prices, with the maximum search price.
+func newAskingClient(t *testing.T, registerMgr *testRegister, maxSearchPrice int64) *FilecoinRetrievalClient {
+	t.Helper()
+	return newTestClientWithRegister(t, registerMgr, func(builder *SettingsBuilder) {
+		builder.SetPriceSource(NewGatewayPriceSource(registerMgr, nil))
+		builder.SetMaxPrices(big.NewInt(maxSearchPrice), nil)
+	})
+}
+
+func TestGatewayPriceSourcePaysPublishedPrices(t *testing.T) {
+	gateway := newTestNode(t)
+	c := newAskingClient(t, newPublishingGateway(t, gateway, signedPrices(t, gateway, 1000, 20)), 5000)
+
+	prices, err := c.GatewayPrices(gateway.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prices.SearchPrice.Int64() != 1000 || prices.OfferPrice.Int64() != 20 {
+		t.Fatalf("expected the published prices, got %s and %s", prices.SearchPrice, prices.OfferPrice)
+	}
+}
+
+func TestGatewayPriceSourceUnpublishedPrices(t *testing.T) {
+	gateway := newTestNode(t)
+	c := newAskingClient(t, newPublishingGateway(t, gateway, ""), 1e18)
+
+	prices, err := c.GatewayPrices(gateway.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prices.SearchPrice.Cmp(c.Settings.SearchPrice()) != 0 || prices.OfferPrice.Cmp(c.Settings.OfferPrice()) != 0 {
+		t.Fatal("gateway not publishing its prices not paid the prices of the settings")
+	}
+}
+
+func TestGatewayPriceSourceChecksMaximum(t *testing.T) {
+	gateway := newTestNode(t)
+	c := newAskingClient(t, newPublishingGateway(t, gateway, signedPrices(t, gateway, 1000, 20)), 999)
+
+	var tooHigh *PriceTooHighError
+	if _, err := c.GatewayPrices(gateway.id); !errors.As(err, &tooHigh) || tooHigh.Kind != "search" {
+		t.Fatalf("expected the search price to be too high, got %v", err)
+	}
+}
+
+func TestGatewayPriceSourceRejectsInvalidPrices(t *testing.T) {
+	gateway := newTestNode(t)
+	registerMgr := newPublishingGateway(t, gateway, signedPrices(t, gateway, -1, 20))
+	if _, err := NewGatewayPriceSource(registerMgr, nil).GatewayPrices(context.Background(), gateway.id.ToString()); err == nil {
+		t.Fatal("negative price accepted")
+	}
+}
+
+func TestGatewayPriceSourceRejectsTamperedPrices(t *testing.T) {
+	gateway, other := newTestNode(t), newTestNode(t)
+	expired, err := SignGatewayPrices(gateway.signingKey, gateway.id, GatewayPrices{SearchPrice: big.NewInt(1000)}, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range map[string]string{
+		"tampered":        strings.Replace(signedPrices(t, gateway, 1000, 20), `"1000"`, `"9000"`, 1),
+		"unsigned":        `{"search_price": "1000", "offer_price": "20"}`,
+		"another gateway": signedPrices(t, other, 1000, 20),
+		"another key":     strings.Replace(signedPrices(t, other, 1000, 20), other.id.ToString(), gateway.id.ToString(), 1),
+		"no longer valid": string(expired),
+	} {
+		registerMgr := newPublishingGateway(t, gateway, body)
+		if _, err := NewGatewayPriceSource(registerMgr, nil).GatewayPrices(context.Background(), gateway.id.ToString()); err == nil {
+			t.Fatalf("%s prices accepted", name)
+		}
+	}
+}
+
+func TestPriceSourcesFirstKnownPrices(t *testing.T) {
+	gateway, other := newTestNode(t), newTestNode(t)
+	first, second := NewStaticPriceSource(), NewStaticPriceSource()
+	first.Set(gateway.id, GatewayPrices{SearchPrice: big.NewInt(1), OfferPrice: big.NewInt(1)})
+	second.Set(gateway.id, GatewayPrices{SearchPrice: big.NewInt(2), OfferPrice: big.NewInt(2)})
+	second.Set(other.id, GatewayPrices{SearchPrice: big.NewInt(3), OfferPrice: big.NewInt(3)})
+	sources := PriceSources{first, second}
+
+	prices, err := sources.GatewayPrices(context.Background(), gateway.id.ToString())
+	if err != nil || prices.SearchPrice.Int64() != 1 {
+		t.Fatalf("expected the prices of the first source, got %v, %v", prices, err)
+	}
+	prices, err = sources.GatewayPrices(context.Background(), other.id.ToString())
+	if err != nil || prices.SearchPrice.Int64() != 3 {
+		t.Fatalf("expected the prices of the second source, got %v, %v", prices, err)
+	}
+}
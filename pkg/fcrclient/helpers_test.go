@@ -0,0 +1,182 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrcrypto"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/fcrlogger"
+)
+
+// newTestSettings returns a settings builder with fresh keys and no logging, configured by configure if not nil.
+func newTestSettings(t *testing.T, configure func(*SettingsBuilder)) *ClientSettings {
+	t.Helper()
+	blockchainKey, err := fcrcrypto.GenerateBlockchainKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	retrievalKey, err := fcrcrypto.GenerateRetrievalV1KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := CreateSettings()
+	builder.SetBlockchainPrivateKey(blockchainKey)
+	builder.SetRetrievalPrivateKey(retrievalKey, fcrcrypto.InitialKeyVersion())
+	builder.SetLogger(fcrlogger.NewNopLogger())
+	if configure != nil {
+		configure(builder)
+	}
+	return builder.Build()
+}
+
+// newTestClient creates a client with no register, configured by configure if not nil.
+func newTestClient(t *testing.T, configure func(*SettingsBuilder)) *FilecoinRetrievalClient {
+	t.Helper()
+	return newTestClientWithRegister(t, nil, configure)
+}
+
+// newTestClientWithRegister creates a client reading the entries of registerMgr, configured by configure if not nil.
+func newTestClientWithRegister(t *testing.T, registerMgr Register, configure func(*SettingsBuilder)) *FilecoinRetrievalClient {
+	t.Helper()
+	c, err := NewFilecoinRetrievalClient(*newTestSettings(t, configure), registerMgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// testNode - a gateway or provider of the tests, with its keys
+type testNode struct {
+	id         *nodeid.NodeID
+	rootKey    *fcrcrypto.KeyPair
+	signingKey *fcrcrypto.KeyPair
+}
+
+// newTestNode creates a node with fresh keys.
+func newTestNode(t *testing.T) *testNode {
+	t.Helper()
+	return &testNode{id: nodeid.NewRandomNodeID(), rootKey: newTestKey(t), signingKey: newTestKey(t)}
+}
+
+// gateway returns the register entry of the node as a gateway.
+func (n *testNode) gateway(t *testing.T) register.GatewayRegistrar {
+	t.Helper()
+	return register.NewGatewayRegister(n.id.ToString(), "127.0.0.1", encodedPublicKey(t, n.rootKey), encodedPublicKey(t, n.signingKey),
+		"US", "9010", "9011", "9012", "9013")
+}
+
+// provider returns the register entry of the node as a provider.
+func (n *testNode) provider(t *testing.T) register.ProviderRegistrar {
+	t.Helper()
+	return register.NewProviderRegister(n.id.ToString(), "127.0.0.1", encodedPublicKey(t, n.rootKey), encodedPublicKey(t, n.signingKey),
+		"US", "9030", "9032", "9033")
+}
+
+// testRegister - a Register with fixed entries
+type testRegister struct {
+	gateways  map[string]register.GatewayRegistrar
+	providers map[string]register.ProviderRegistrar
+}
+
+// newTestRegister creates a register with the given entries.
+func newTestRegister(gateways []register.GatewayRegistrar, providers []register.ProviderRegistrar) *testRegister {
+	r := &testRegister{
+		gateways:  make(map[string]register.GatewayRegistrar),
+		providers: make(map[string]register.ProviderRegistrar),
+	}
+	for _, gateway := range gateways {
+		r.gateways[gateway.GetNodeID()] = gateway
+	}
+	for _, provider := range providers {
+		r.providers[provider.GetNodeID()] = provider
+	}
+	return r
+}
+
+func (r *testRegister) Refresh() {}
+
+func (r *testRegister) GetGateway(id *nodeid.NodeID) register.GatewayRegistrar {
+	return r.gateways[id.ToString()]
+}
+
+func (r *testRegister) GetProvider(id *nodeid.NodeID) register.ProviderRegistrar {
+	return r.providers[id.ToString()]
+}
+
+func (r *testRegister) GetAllGateways() []register.GatewayRegistrar {
+	res := make([]register.GatewayRegistrar, 0, len(r.gateways))
+	for _, gateway := range r.gateways {
+		res = append(res, gateway)
+	}
+	return res
+}
+
+func (r *testRegister) GetAllProviders() []register.ProviderRegistrar {
+	res := make([]register.ProviderRegistrar, 0, len(r.providers))
+	for _, provider := range r.providers {
+		res = append(res, provider)
+	}
+	return res
+}
+
+func (r *testRegister) GetGatewaysNearCID(cID *cid.ContentID, numDHT int, notAllowed *nodeid.NodeID) ([]register.GatewayRegistrar, error) {
+	return nil, errors.New("no DHT in the test register")
+}
+
+// newTestKey generates a key pair.
+func newTestKey(t *testing.T) *fcrcrypto.KeyPair {
+	t.Helper()
+	key, err := fcrcrypto.GenerateRetrievalV1KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// encodedPublicKey returns the hex encoded public key of a key pair.
+func encodedPublicKey(t *testing.T, key *fcrcrypto.KeyPair) string {
+	t.Helper()
+	encoded, err := key.EncodePublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+// newTestOffer returns an offer of contentID by a provider, with price, signed with signingKey.
+func newTestOffer(t *testing.T, providerID *nodeid.NodeID, signingKey *fcrcrypto.KeyPair, contentID *cid.ContentID, price uint64) cidoffer.SubCIDOffer {
+	t.Helper()
+	offer, err := cidoffer.NewCIDOffer(providerID, []cid.ContentID{*contentID, *cid.NewRandomContentID()}, price, time.Now().Add(time.Hour).Unix(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := offer.Sign(signingKey, fcrcrypto.InitialKeyVersion()); err != nil {
+		t.Fatal(err)
+	}
+	subOffer, err := offer.GenerateSubCIDOffer(contentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *subOffer
+}
@@ -0,0 +1,269 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi"
+)
+
+// Offer verification outcomes, used as label values of the offers metric.
+const (
+	offerAccepted                    = "accepted"
+	offerRejectedProviderNotFound    = "provider_not_found"
+	offerRejectedInvalidRegistration = "invalid_provider_registration"
+	offerRejectedInvalidKey          = "invalid_provider_key"
+	offerRejectedInvalidSignature    = "invalid_signature"
+	offerRejectedInvalidMerkleProof  = "invalid_merkle_proof"
+)
+
+// ClientMetrics holds the Prometheus metrics of the client operations.
+// It implements prometheus.Collector, register it with a prometheus.Registerer to expose the metrics.
+// A nil *ClientMetrics is valid and records nothing.
+type ClientMetrics struct {
+	establishments    *prometheus.CounterVec
+	requestDuration   *prometheus.HistogramVec
+	offers            *prometheus.CounterVec
+	payments          *prometheus.CounterVec
+	paymentAmount     *prometheus.CounterVec
+	topups            *prometheus.CounterVec
+	topupAmount       *prometheus.CounterVec
+	activeGatewaysDsc *prometheus.Desc
+
+	activeGatewaysFunc     func() int
+	activeGatewaysFuncLock sync.RWMutex
+}
+
+// NewClientMetrics creates the client metrics, with names prefixed by the given namespace.
+func NewClientMetrics(namespace string) *ClientMetrics {
+	return &ClientMetrics{
+		establishments: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "establishment_attempts_total",
+			Help:      "Number of establishment attempts with gateways, by gateway and result.",
+		}, []string{"gateway", "result"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "gateway_request_duration_seconds",
+			Help:      "Duration of the requests sent to gateways and providers, by node, method and result.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"gateway", "method", "result"}),
+		offers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "offers_total",
+			Help:      "Number of offers received, by verification outcome.",
+		}, []string{"outcome"}),
+		payments: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "payments_total",
+			Help:      "Number of payments made to gateways, by gateway.",
+		}, []string{"gateway"}),
+		paymentAmount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "payment_amount_attofil_total",
+			Help:      "Amount paid to gateways in attoFIL, by gateway.",
+		}, []string{"gateway"}),
+		topups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "topups_total",
+			Help:      "Number of payment channel top ups, by gateway.",
+		}, []string{"gateway"}),
+		topupAmount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "topup_amount_attofil_total",
+			Help:      "Amount added to payment channels in attoFIL, by gateway.",
+		}, []string{"gateway"}),
+		activeGatewaysDsc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "active_gateways"),
+			"Number of active gateways.",
+			nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (m *ClientMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.establishments.Describe(ch)
+	m.requestDuration.Describe(ch)
+	m.offers.Describe(ch)
+	m.payments.Describe(ch)
+	m.paymentAmount.Describe(ch)
+	m.topups.Describe(ch)
+	m.topupAmount.Describe(ch)
+	ch <- m.activeGatewaysDsc
+}
+
+// Collect implements prometheus.Collector
+func (m *ClientMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.establishments.Collect(ch)
+	m.requestDuration.Collect(ch)
+	m.offers.Collect(ch)
+	m.payments.Collect(ch)
+	m.paymentAmount.Collect(ch)
+	m.topups.Collect(ch)
+	m.topupAmount.Collect(ch)
+
+	m.activeGatewaysFuncLock.RLock()
+	activeGatewaysFunc := m.activeGatewaysFunc
+	m.activeGatewaysFuncLock.RUnlock()
+	if activeGatewaysFunc != nil {
+		ch <- prometheus.MustNewConstMetric(m.activeGatewaysDsc, prometheus.GaugeValue, float64(activeGatewaysFunc()))
+	}
+}
+
+// setActiveGatewaysFunc sets the function reporting the number of active gateways.
+func (m *ClientMetrics) setActiveGatewaysFunc(f func() int) {
+	if m == nil {
+		return
+	}
+	m.activeGatewaysFuncLock.Lock()
+	defer m.activeGatewaysFuncLock.Unlock()
+	m.activeGatewaysFunc = f
+}
+
+// observeEstablishment records an establishment attempt with a gateway.
+func (m *ClientMetrics) observeEstablishment(gatewayID string, err error) {
+	if m == nil {
+		return
+	}
+	m.establishments.WithLabelValues(gatewayID, resultLabel(err)).Inc()
+}
+
+// observeRequest records the duration of a request sent to a gateway or a provider.
+func (m *ClientMetrics) observeRequest(nodeID string, method string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.requestDuration.WithLabelValues(nodeID, method, resultLabel(err)).Observe(time.Since(start).Seconds())
+}
+
+// observeOffer records the verification outcome of an offer.
+func (m *ClientMetrics) observeOffer(outcome string) {
+	if m == nil {
+		return
+	}
+	m.offers.WithLabelValues(outcome).Inc()
+}
+
+// observePayment records a payment made to a gateway.
+func (m *ClientMetrics) observePayment(gatewayID string, amount *big.Int) {
+	if m == nil {
+		return
+	}
+	m.payments.WithLabelValues(gatewayID).Inc()
+	m.paymentAmount.WithLabelValues(gatewayID).Add(bigIntToFloat(amount))
+}
+
+// observeTopup records a top up of the payment channel with a gateway.
+func (m *ClientMetrics) observeTopup(gatewayID string, amount *big.Int) {
+	if m == nil {
+		return
+	}
+	m.topups.WithLabelValues(gatewayID).Inc()
+	m.topupAmount.WithLabelValues(gatewayID).Add(bigIntToFloat(amount))
+}
+
+// resultLabel returns the result label value for an error.
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// bigIntToFloat converts an amount to a float64, which is precise enough for metrics.
+func bigIntToFloat(amount *big.Int) float64 {
+	f, _ := new(big.Float).SetInt(amount).Float64()
+	return f
+}
+
+// instrumentedClientApi is a clientapi.ClientApi recording the duration and the result of every request.
+type instrumentedClientApi struct {
+	clientApi clientapi.ClientApi
+	metrics   *ClientMetrics
+}
+
+// newInstrumentedClientApi wraps a client api so that its requests are recorded by the given metrics.
+func newInstrumentedClientApi(clientApi clientapi.ClientApi, metrics *ClientMetrics) clientapi.ClientApi {
+	return &instrumentedClientApi{clientApi: clientApi, metrics: metrics}
+}
+
+func (i *instrumentedClientApi) RequestDHTOfferDiscover(ctx context.Context, gatewayInfo register.GatewayRegistrar, gatewayIDs []nodeid.NodeID, contentID *cid.ContentID, nonce int64, offersDigests [][][cidoffer.CIDOfferDigestSize]byte, paymentChannelAddr string, voucher string) ([]clientapi.GatewaySubOffers, error) {
+	start := time.Now()
+	res, err := i.clientApi.RequestDHTOfferDiscover(ctx, gatewayInfo, gatewayIDs, contentID, nonce, offersDigests, paymentChannelAddr, voucher)
+	i.metrics.observeRequest(gatewayInfo.GetNodeID(), "dht_offer_discover", start, err)
+	return res, err
+}
+
+func (i *instrumentedClientApi) RequestDHTDiscover(ctx context.Context, gatewayInfo register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, numDHT int64, incrementalResult bool, paychAddr string, voucher string) ([]nodeid.NodeID, []fcrmessages.FCRMessage, []nodeid.NodeID, error) {
+	start := time.Now()
+	contacted, contactedResp, uncontactable, err := i.clientApi.RequestDHTDiscover(ctx, gatewayInfo, contentID, nonce, ttl, numDHT, incrementalResult, paychAddr, voucher)
+	i.metrics.observeRequest(gatewayInfo.GetNodeID(), "dht_discover", start, err)
+	return contacted, contactedResp, uncontactable, err
+}
+
+func (i *instrumentedClientApi) RequestDHTDiscoverV2(ctx context.Context, gatewayInfo register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, numDHT int64, incrementalResult bool, paychAddr string, voucher string) ([]nodeid.NodeID, []fcrmessages.FCRMessage, []nodeid.NodeID, error) {
+	start := time.Now()
+	contacted, contactedResp, uncontactable, err := i.clientApi.RequestDHTDiscoverV2(ctx, gatewayInfo, contentID, nonce, ttl, numDHT, incrementalResult, paychAddr, voucher)
+	i.metrics.observeRequest(gatewayInfo.GetNodeID(), "dht_discover_v2", start, err)
+	return contacted, contactedResp, uncontactable, err
+}
+
+func (i *instrumentedClientApi) RequestDHTOfferAck(ctx context.Context, providerInfo register.ProviderRegistrar, contentID *cid.ContentID, gatewayID *nodeid.NodeID) (bool, *fcrmessages.FCRMessage, *fcrmessages.FCRMessage, error) {
+	start := time.Now()
+	found, request, ack, err := i.clientApi.RequestDHTOfferAck(ctx, providerInfo, contentID, gatewayID)
+	i.metrics.observeRequest(providerInfo.GetNodeID(), "dht_offer_ack", start, err)
+	return found, request, ack, err
+}
+
+func (i *instrumentedClientApi) RequestEstablishment(ctx context.Context, gatewayInfo register.GatewayRegistrar, challenge []byte, clientID *nodeid.NodeID, ttl int64) error {
+	start := time.Now()
+	err := i.clientApi.RequestEstablishment(ctx, gatewayInfo, challenge, clientID, ttl)
+	i.metrics.observeRequest(gatewayInfo.GetNodeID(), "establishment", start, err)
+	i.metrics.observeEstablishment(gatewayInfo.GetNodeID(), err)
+	return err
+}
+
+func (i *instrumentedClientApi) RequestStandardDiscoverOffer(ctx context.Context, gatewayInfo register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, offerDigests [][cidoffer.CIDOfferDigestSize]byte, paychAddr string, voucher string) ([]cidoffer.SubCIDOffer, error) {
+	start := time.Now()
+	offers, err := i.clientApi.RequestStandardDiscoverOffer(ctx, gatewayInfo, contentID, nonce, ttl, offerDigests, paychAddr, voucher)
+	i.metrics.observeRequest(gatewayInfo.GetNodeID(), "standard_discover_offer", start, err)
+	return offers, err
+}
+
+func (i *instrumentedClientApi) RequestStandardDiscover(ctx context.Context, gatewayInfo register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, paychAddr string, voucher string) ([]cidoffer.SubCIDOffer, error) {
+	start := time.Now()
+	offers, err := i.clientApi.RequestStandardDiscover(ctx, gatewayInfo, contentID, nonce, ttl, paychAddr, voucher)
+	i.metrics.observeRequest(gatewayInfo.GetNodeID(), "standard_discover", start, err)
+	return offers, err
+}
+
+func (i *instrumentedClientApi) RequestStandardDiscoverV2(ctx context.Context, gatewayInfo register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, paychAddr string, voucher string) ([][cidoffer.CIDOfferDigestSize]byte, error) {
+	start := time.Now()
+	digests, err := i.clientApi.RequestStandardDiscoverV2(ctx, gatewayInfo, contentID, nonce, ttl, paychAddr, voucher)
+	i.metrics.observeRequest(gatewayInfo.GetNodeID(), "standard_discover_v2", start, err)
+	return digests, err
+}
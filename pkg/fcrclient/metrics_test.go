@@ -0,0 +1,90 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi/mocks"
+)
+
+func TestMetricsRecordDiscovery(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	api := mocks.NewMockClientApi(ctrl)
+	gateway, provider := newTestNode(t), newTestNode(t)
+	gatewayEntry := gateway.gateway(t)
+	metrics := NewClientMetrics("fcr")
+	registerMgr := newTestRegister([]register.GatewayRegistrar{gatewayEntry}, []register.ProviderRegistrar{provider.provider(t)})
+	c := newTestClientWithRegister(t, registerMgr, func(builder *SettingsBuilder) {
+		builder.SetClientApi(api)
+		builder.SetMetrics(metrics)
+	})
+	api.EXPECT().RequestEstablishment(gomock.Any(), gatewayEntry, gomock.Any(), c.Settings.ClientID(), gomock.Any()).Return(nil)
+	if c.AddGatewaysToUse([]*nodeid.NodeID{gateway.id}) != 1 || c.AddActiveGateways([]*nodeid.NodeID{gateway.id}) != 1 {
+		t.Fatal("gateway not activated")
+	}
+
+	contentID := cid.NewRandomContentID()
+	valid := newTestOffer(t, provider.id, provider.signingKey, contentID, 10)
+	forged := newTestOffer(t, provider.id, newTestKey(t), contentID, 1)
+	unknown := newTestOffer(t, nodeid.NewRandomNodeID(), provider.signingKey, contentID, 1)
+	api.EXPECT().RequestStandardDiscover(gomock.Any(), gomock.Any(), contentID, gomock.Any(), gomock.Any(), "", "").
+		Return([]cidoffer.SubCIDOffer{valid, forged, unknown}, nil)
+	if _, err := c.FindOffersStandardDiscovery(contentID, gateway.id); err != nil {
+		t.Fatal(err)
+	}
+
+	gatewayID := gatewayEntry.GetNodeID()
+	if n := testutil.ToFloat64(metrics.establishments.WithLabelValues(gatewayID, "success")); n != 1 {
+		t.Fatalf("expected one successful establishment, got %v", n)
+	}
+	for outcome, expected := range map[string]float64{
+		offerAccepted:                 1,
+		offerRejectedInvalidSignature: 1,
+		offerRejectedProviderNotFound: 1,
+	} {
+		if n := testutil.ToFloat64(metrics.offers.WithLabelValues(outcome)); n != expected {
+			t.Fatalf("expected %v offers %s, got %v", expected, outcome, n)
+		}
+	}
+	if n := testutil.CollectAndCount(metrics.requestDuration); n != 2 {
+		t.Fatalf("expected the establishment and the discovery timed, got %d series", n)
+	}
+
+	expected := `
+# HELP fcr_active_gateways Number of active gateways.
+# TYPE fcr_active_gateways gauge
+fcr_active_gateways 1
+`
+	if err := testutil.CollectAndCompare(metrics, strings.NewReader(expected), "fcr_active_gateways"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNilMetricsRecordNothing(t *testing.T) {
+	var metrics *ClientMetrics
+	metrics.observeEstablishment("gateway", nil)
+	metrics.observeOffer(offerAccepted)
+	metrics.setActiveGatewaysFunc(func() int { return 1 })
+}
@@ -0,0 +1,127 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrcrypto"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+)
+
+// DHTOfferAckProof - evidence that a gateway acknowledged the DHT offers of a provider for a CID.
+// Request is the publish request signed by the provider, and Ack the acknowledgement signed by the gateway.
+// The ack carries the gateway signature of the request body, which binds the ack to RequestHash.
+type DHTOfferAckProof struct {
+	ContentID   *cid.ContentID
+	GatewayID   *nodeid.NodeID
+	ProviderID  *nodeid.NodeID
+	Nonce       int64
+	RequestHash []byte
+	Offers      []cidoffer.CIDOffer
+	Request     *fcrmessages.FCRMessage
+	Ack         *fcrmessages.FCRMessage
+	VerifiedAt  time.Time
+}
+
+// ackRejectionError - an ack was found but failed verification
+type ackRejectionError struct {
+	message string
+}
+
+func (e *ackRejectionError) Error() string {
+	return e.message
+}
+
+// VerifyDHTOfferAck verifies a DHT offer publish request and its ack, given the signing keys of the provider and the
+// gateway, and returns the proof they make. The request must be signed by the provider, come from the provider and
+// contain at least one offer of the provider for the CID. The ack must be signed by the gateway, have the nonce of
+// the request and carry the gateway signature of the request.
+func VerifyDHTOfferAck(
+	contentID *cid.ContentID,
+	gatewayID *nodeid.NodeID,
+	providerID *nodeid.NodeID,
+	request *fcrmessages.FCRMessage,
+	ack *fcrmessages.FCRMessage,
+	providerKey *fcrcrypto.KeyPair,
+	gatewayKey *fcrcrypto.KeyPair,
+) (*DHTOfferAckProof, error) {
+	if request == nil || ack == nil {
+		return nil, errors.New("missing request or ack")
+	}
+	// Verify the request
+	if err := request.Verify(providerKey); err != nil {
+		return nil, fmt.Errorf("error in verifying request: %s", err.Error())
+	}
+	requestProviderID, nonce, offers, err := fcrmessages.DecodeProviderPublishDHTOfferRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	if requestProviderID == nil || requestProviderID.ToString() != providerID.ToString() {
+		return nil, errors.New("initial request is not from the given provider")
+	}
+	// Keep the offers of the provider containing the cid
+	matching := make([]cidoffer.CIDOffer, 0)
+	for _, offer := range offers {
+		if offer.GetProviderID().ToString() != providerID.ToString() {
+			return nil, errors.New("initial request contains an offer of another provider")
+		}
+		for _, offerCID := range offer.GetCIDs() {
+			if offerCID.ToString() == contentID.ToString() {
+				matching = append(matching, offer)
+				break
+			}
+		}
+	}
+	if len(matching) == 0 {
+		return nil, errors.New("initial request does not contain the given cid")
+	}
+	// Verify the ack
+	if err := ack.Verify(gatewayKey); err != nil {
+		return nil, fmt.Errorf("error in verifying the ack: %s", err.Error())
+	}
+	ackNonce, signature, err := fcrmessages.DecodeProviderPublishDHTOfferResponse(ack)
+	if err != nil {
+		return nil, err
+	}
+	if ackNonce != nonce {
+		return nil, fmt.Errorf("ack nonce %d does not match request nonce %d", ackNonce, nonce)
+	}
+	// Verify the ack is bound to the request
+	ok, err := fcrcrypto.VerifyMessage(gatewayKey, signature, request.GetMessageBody())
+	if err != nil {
+		return nil, fmt.Errorf("error in verifying the request signature inside the ack: %s", err.Error())
+	}
+	if !ok {
+		return nil, errors.New("ack does not sign the initial request")
+	}
+	return &DHTOfferAckProof{
+		ContentID:   contentID,
+		GatewayID:   gatewayID,
+		ProviderID:  providerID,
+		Nonce:       nonce,
+		RequestHash: fcrcrypto.RetrievalV1Hash(request.GetMessageBody()),
+		Offers:      matching,
+		Request:     request,
+		Ack:         ack,
+		VerifiedAt:  time.Now(),
+	}, nil
+}
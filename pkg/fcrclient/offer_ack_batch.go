@@ -0,0 +1,168 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi"
+)
+
+// OfferAckVerdict - the outcome of checking the ack of a gateway for the DHT offer of a provider
+type OfferAckVerdict string
+
+// Offer ack verdicts
+const (
+	// OfferAckVerified - the gateway acknowledged the offer and the ack passed verification.
+	OfferAckVerified OfferAckVerdict = "verified"
+	// OfferAckMissing - the provider has no ack of the gateway for the CID.
+	OfferAckMissing OfferAckVerdict = "missing"
+	// OfferAckInvalid - the provider returned an ack which failed verification.
+	OfferAckInvalid OfferAckVerdict = "invalid"
+	// OfferAckError - the ack could not be requested.
+	OfferAckError OfferAckVerdict = "error"
+)
+
+// maxDHTNeighbourhood is the maximum number of gateways the register returns around a CID.
+const maxDHTNeighbourhood = 16
+
+// OfferAckResult - the verdict for one gateway and provider pair, with the proof when verified,
+// or the error otherwise
+type OfferAckResult struct {
+	GatewayID  *nodeid.NodeID
+	ProviderID *nodeid.NodeID
+	Verdict    OfferAckVerdict
+	Proof      *DHTOfferAckProof
+	Err        error
+}
+
+// OfferAckMatrix - the results of checking acks for a CID, by gateway ID then provider ID
+type OfferAckMatrix struct {
+	ContentID *cid.ContentID
+	Results   map[string]map[string]*OfferAckResult
+}
+
+// Verdict returns the verdict for a gateway and provider pair, and false if the pair was not checked.
+func (m *OfferAckMatrix) Verdict(gatewayID *nodeid.NodeID, providerID *nodeid.NodeID) (OfferAckVerdict, bool) {
+	result, exists := m.Results[gatewayID.ToString()][providerID.ToString()]
+	if !exists {
+		return "", false
+	}
+	return result.Verdict, true
+}
+
+// FindDHTOfferAcksBatch checks, for one CID, the acks of every given gateway for the DHT offers of every given
+// provider. If no gateway is given, the gateways of the DHT neighbourhood of the CID in the register are checked.
+// At most maxConcurrent pairs are checked in parallel, zero meaning the default.
+// A failure for one pair does not affect the others.
+func (c *FilecoinRetrievalClient) FindDHTOfferAcksBatch(contentID *cid.ContentID, gatewayIDs []*nodeid.NodeID, providerIDs []*nodeid.NodeID, maxConcurrent int) (*OfferAckMatrix, error) {
+	return c.FindDHTOfferAcksBatchContext(context.Background(), contentID, gatewayIDs, providerIDs, maxConcurrent)
+}
+
+// FindDHTOfferAcksBatchContext is FindDHTOfferAcksBatch with a context: the call stops when ctx is done,
+// and is traced as a child of the span of ctx.
+func (c *FilecoinRetrievalClient) FindDHTOfferAcksBatchContext(ctx context.Context, contentID *cid.ContentID, gatewayIDs []*nodeid.NodeID, providerIDs []*nodeid.NodeID, maxConcurrent int) (*OfferAckMatrix, error) {
+	ctx, span := startSpan(ctx, "fcrclient.FindDHTOfferAcksBatch",
+		clientapi.AttributeCID.String(contentID.ToString()),
+		attribute.Int("fcr.num_gateways", len(gatewayIDs)),
+		attribute.Int("fcr.num_providers", len(providerIDs)))
+	matrix, err := c.findDHTOfferAcksBatch(ctx, contentID, gatewayIDs, providerIDs, maxConcurrent)
+	endSpan(span, err)
+	return matrix, err
+}
+
+// findDHTOfferAcksBatch is FindDHTOfferAcksBatch within the given context.
+func (c *FilecoinRetrievalClient) findDHTOfferAcksBatch(ctx context.Context, contentID *cid.ContentID, gatewayIDs []*nodeid.NodeID, providerIDs []*nodeid.NodeID, maxConcurrent int) (*OfferAckMatrix, error) {
+	if len(providerIDs) == 0 {
+		return nil, errors.New("no provider to check the acks of")
+	}
+	if len(gatewayIDs) == 0 {
+		neighbours, err := c.registerMgr.GetGatewaysNearCID(contentID, maxDHTNeighbourhood, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error getting the gateways near CID %s: %s", contentID.ToString(), err.Error())
+		}
+		for _, gateway := range neighbours {
+			gatewayID, err := nodeid.NewNodeIDFromHexString(gateway.GetNodeID())
+			if err != nil {
+				c.logger.Error("Error in generating node id", "gateway_id", gateway.GetNodeID())
+				continue
+			}
+			gatewayIDs = append(gatewayIDs, gatewayID)
+		}
+	}
+	if len(gatewayIDs) == 0 {
+		return nil, errors.New("no gateway to check the acks of")
+	}
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultBatchMaxConcurrentGateways
+	}
+
+	matrix := &OfferAckMatrix{
+		ContentID: contentID,
+		Results:   make(map[string]map[string]*OfferAckResult),
+	}
+	for _, gatewayID := range gatewayIDs {
+		matrix.Results[gatewayID.ToString()] = make(map[string]*OfferAckResult)
+	}
+
+	var resultsLock sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, maxConcurrent)
+	for _, gatewayID := range gatewayIDs {
+		for _, providerID := range providerIDs {
+			wg.Add(1)
+			go func(gatewayID *nodeid.NodeID, providerID *nodeid.NodeID) {
+				defer wg.Done()
+				semaphore <- struct{}{}
+				defer func() { <-semaphore }()
+				result := c.checkDHTOfferAck(ctx, contentID, gatewayID, providerID)
+				resultsLock.Lock()
+				matrix.Results[gatewayID.ToString()][providerID.ToString()] = result
+				resultsLock.Unlock()
+			}(gatewayID, providerID)
+		}
+	}
+	wg.Wait()
+	return matrix, nil
+}
+
+// checkDHTOfferAck finds and verifies the ack of a gateway for the DHT offer of a provider, and gives its verdict.
+func (c *FilecoinRetrievalClient) checkDHTOfferAck(ctx context.Context, contentID *cid.ContentID, gatewayID *nodeid.NodeID, providerID *nodeid.NodeID) *OfferAckResult {
+	result := &OfferAckResult{GatewayID: gatewayID, ProviderID: providerID}
+	proof, err := c.findDHTOfferAck(ctx, contentID, gatewayID, providerID)
+	var rejection *ackRejectionError
+	switch {
+	case errors.As(err, &rejection):
+		result.Verdict = OfferAckInvalid
+		result.Err = err
+	case err != nil:
+		result.Verdict = OfferAckError
+		result.Err = err
+	case proof == nil:
+		result.Verdict = OfferAckMissing
+	default:
+		result.Verdict = OfferAckVerified
+		result.Proof = proof
+	}
+	return result
+}
@@ -0,0 +1,107 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+	"github.com/golang/mock/gomock"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi/mocks"
+)
+
+func TestFindDHTOfferAcksBatchGivesVerdictPerPair(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	api := mocks.NewMockClientApi(ctrl)
+	acking, silent, forging, unreachable, provider := newTestNode(t), newTestNode(t), newTestNode(t), newTestNode(t), newTestNode(t)
+	registerMgr := newTestRegister(
+		[]register.GatewayRegistrar{acking.gateway(t), silent.gateway(t), forging.gateway(t), unreachable.gateway(t)},
+		[]register.ProviderRegistrar{provider.provider(t)})
+	c := newTestClientWithRegister(t, registerMgr, func(builder *SettingsBuilder) {
+		builder.SetClientApi(api)
+	})
+	contentID := cid.NewRandomContentID()
+	request := newTestPublishRequest(t, provider, provider, 3, *contentID)
+
+	var lock sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	api.EXPECT().RequestDHTOfferAck(gomock.Any(), gomock.Any(), contentID, gomock.Any()).Times(4).
+		DoAndReturn(func(ctx context.Context, providerInfo register.ProviderRegistrar, contentID *cid.ContentID, gatewayID *nodeid.NodeID) (bool, *fcrmessages.FCRMessage, *fcrmessages.FCRMessage, error) {
+			lock.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			lock.Unlock()
+			defer func() {
+				lock.Lock()
+				inFlight--
+				lock.Unlock()
+			}()
+			switch gatewayID.ToString() {
+			case acking.id.ToString():
+				return true, request, newTestPublishAck(t, acking, request, 3), nil
+			case forging.id.ToString():
+				return true, request, newTestPublishAck(t, provider, request, 3), nil
+			case unreachable.id.ToString():
+				return false, nil, nil, errors.New("provider unavailable")
+			}
+			return false, nil, nil, nil
+		})
+
+	gatewayIDs := []*nodeid.NodeID{acking.id, silent.id, forging.id, unreachable.id}
+	matrix, err := c.FindDHTOfferAcksBatch(contentID, gatewayIDs, []*nodeid.NodeID{provider.id}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for gateway, expected := range map[*testNode]OfferAckVerdict{
+		acking:      OfferAckVerified,
+		silent:      OfferAckMissing,
+		forging:     OfferAckInvalid,
+		unreachable: OfferAckError,
+	} {
+		if verdict, checked := matrix.Verdict(gateway.id, provider.id); !checked || verdict != expected {
+			t.Fatalf("expected verdict %s, got %s", expected, verdict)
+		}
+	}
+	if matrix.Results[acking.id.ToString()][provider.id.ToString()].Proof == nil {
+		t.Fatal("verified ack without its proof")
+	}
+	if maxInFlight > 2 {
+		t.Fatalf("expected at most 2 acks requested at once, got %d", maxInFlight)
+	}
+	if _, checked := matrix.Verdict(acking.id, nodeid.NewRandomNodeID()); checked {
+		t.Fatal("verdict for a pair not checked")
+	}
+}
+
+func TestFindDHTOfferAcksBatchRequiresPairs(t *testing.T) {
+	c := newTestClientWithRegister(t, newTestRegister(nil, nil), nil)
+	contentID := cid.NewRandomContentID()
+	if _, err := c.FindDHTOfferAcksBatch(contentID, []*nodeid.NodeID{nodeid.NewRandomNodeID()}, nil, 0); err == nil {
+		t.Fatal("batch without provider accepted")
+	}
+	if _, err := c.FindDHTOfferAcksBatch(contentID, nil, []*nodeid.NodeID{nodeid.NewRandomNodeID()}, 0); err == nil {
+		t.Fatal("batch without gateway nor DHT neighbourhood accepted")
+	}
+}
@@ -0,0 +1,133 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrcrypto"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+	"github.com/golang/mock/gomock"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi/mocks"
+)
+
+// newTestPublishRequest returns the DHT offer publish request of provider for the CIDs, signed by signer.
+func newTestPublishRequest(t *testing.T, provider *testNode, signer *testNode, nonce int64, cids ...cid.ContentID) *fcrmessages.FCRMessage {
+	t.Helper()
+	offer, err := cidoffer.NewCIDOffer(provider.id, cids, 1, time.Now().Add(time.Hour).Unix(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := offer.Sign(provider.signingKey, fcrcrypto.InitialKeyVersion()); err != nil {
+		t.Fatal(err)
+	}
+	request, err := fcrmessages.EncodeProviderPublishDHTOfferRequest(provider.id, nonce, []cidoffer.CIDOffer{*offer})
+	msg := signedMessage(t, signer, request, err)
+	return &msg
+}
+
+// newTestPublishAck returns the ack of gateway for request, with nonce.
+func newTestPublishAck(t *testing.T, gateway *testNode, request *fcrmessages.FCRMessage, nonce int64) *fcrmessages.FCRMessage {
+	t.Helper()
+	signature, err := fcrcrypto.SignMessage(gateway.signingKey, fcrcrypto.InitialKeyVersion(), request.GetMessageBody())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack, err := fcrmessages.EncodeProviderPublishDHTOfferResponse(nonce, signature)
+	msg := signedMessage(t, gateway, ack, err)
+	return &msg
+}
+
+func TestVerifyDHTOfferAck(t *testing.T) {
+	gateway, provider := newTestNode(t), newTestNode(t)
+	contentID := cid.NewRandomContentID()
+	request := newTestPublishRequest(t, provider, provider, 42, *contentID)
+	ack := newTestPublishAck(t, gateway, request, 42)
+
+	proof, err := VerifyDHTOfferAck(contentID, gateway.id, provider.id, request, ack, provider.signingKey, gateway.signingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proof.Nonce != 42 || len(proof.Offers) != 1 {
+		t.Fatalf("unexpected proof, nonce %d and %d offers", proof.Nonce, len(proof.Offers))
+	}
+	if !bytes.Equal(proof.RequestHash, fcrcrypto.RetrievalV1Hash(request.GetMessageBody())) {
+		t.Fatal("proof not bound to the hash of the request")
+	}
+}
+
+func TestVerifyDHTOfferAckRejectsInvalidAcks(t *testing.T) {
+	gateway, provider, other := newTestNode(t), newTestNode(t), newTestNode(t)
+	contentID := cid.NewRandomContentID()
+	request := newTestPublishRequest(t, provider, provider, 42, *contentID)
+	otherRequest := newTestPublishRequest(t, provider, provider, 42, *cid.NewRandomContentID(), *contentID)
+
+	for name, test := range map[string]struct {
+		request *fcrmessages.FCRMessage
+		ack     *fcrmessages.FCRMessage
+	}{
+		"request not signed by the provider": {newTestPublishRequest(t, provider, other, 42, *contentID), newTestPublishAck(t, gateway, request, 42)},
+		"request of another provider":        {newTestPublishRequest(t, other, provider, 42, *contentID), newTestPublishAck(t, gateway, request, 42)},
+		"request without the cid":            {newTestPublishRequest(t, provider, provider, 42, *cid.NewRandomContentID()), newTestPublishAck(t, gateway, request, 42)},
+		"ack not signed by the gateway":      {request, newTestPublishAck(t, other, request, 42)},
+		"ack with another nonce":             {request, newTestPublishAck(t, gateway, request, 43)},
+		"ack of another request":             {request, newTestPublishAck(t, gateway, otherRequest, 42)},
+		"missing ack":                        {request, nil},
+	} {
+		if _, err := VerifyDHTOfferAck(contentID, gateway.id, provider.id, test.request, test.ack, provider.signingKey, gateway.signingKey); err == nil {
+			t.Fatalf("%s accepted", name)
+		}
+	}
+}
+
+func TestFindDHTOfferAckProofRejectsForgedAcks(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	api := mocks.NewMockClientApi(ctrl)
+	gateway, provider := newTestNode(t), newTestNode(t)
+	providerEntry := provider.provider(t)
+	registerMgr := newTestRegister([]register.GatewayRegistrar{gateway.gateway(t)}, []register.ProviderRegistrar{providerEntry})
+	c := newTestClientWithRegister(t, registerMgr, func(builder *SettingsBuilder) {
+		builder.SetClientApi(api)
+	})
+	contentID := cid.NewRandomContentID()
+	request := newTestPublishRequest(t, provider, provider, 7, *contentID)
+
+	api.EXPECT().RequestDHTOfferAck(gomock.Any(), providerEntry, contentID, gateway.id).
+		Return(true, request, newTestPublishAck(t, gateway, request, 7), nil)
+	proof, err := c.FindDHTOfferAckProof(contentID, gateway.id, provider.id)
+	if err != nil || proof == nil || proof.Nonce != 7 {
+		t.Fatalf("expected the proof of the ack, got %v, %v", proof, err)
+	}
+
+	api.EXPECT().RequestDHTOfferAck(gomock.Any(), providerEntry, contentID, gateway.id).
+		Return(true, request, newTestPublishAck(t, newTestNode(t), request, 7), nil)
+	var rejection *ackRejectionError
+	if _, err := c.FindDHTOfferAckProof(contentID, gateway.id, provider.id); !errors.As(err, &rejection) {
+		t.Fatalf("expected the forged ack rejected, got %v", err)
+	}
+
+	api.EXPECT().RequestDHTOfferAck(gomock.Any(), providerEntry, contentID, gateway.id).Return(false, nil, nil, nil)
+	if proof, err := c.FindDHTOfferAckProof(contentID, gateway.id, provider.id); err != nil || proof != nil {
+		t.Fatalf("expected no proof without ack, got %v, %v", proof, err)
+	}
+}
@@ -0,0 +1,338 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+)
+
+// neutralScore is the score of an offer which can't be compared with the others on a criterion.
+const neutralScore = 0.5
+
+// OfferCandidate - relation between a Sub-CID Offer and the Gateway the offer was received through
+type OfferCandidate struct {
+	GatewayID *nodeid.NodeID
+	Offer     cidoffer.SubCIDOffer
+}
+
+// RankedOffer - an offer candidate together with the score given to it by an OfferRanker
+type RankedOffer struct {
+	OfferCandidate
+	Score float64
+}
+
+// OfferRankingCriterion scores a set of offer candidates.
+// The returned slice has one score per candidate, in the same order, normalised to [0, 1] where higher is better.
+type OfferRankingCriterion interface {
+	Score(candidates []OfferCandidate) []float64
+}
+
+// PricePerByteCriterion prefers the offers with the lowest price per byte.
+// SizeOf returns the size in bytes of the content behind a CID, and false if the size is unknown.
+// If SizeOf is nil, the prices of the offers are compared as they are. Otherwise, the offers whose size is unknown
+// can't be compared with the others and are given a neutral score of 0.5.
+type PricePerByteCriterion struct {
+	SizeOf func(contentID *cid.ContentID) (uint64, bool)
+}
+
+// Score implements OfferRankingCriterion
+func (p PricePerByteCriterion) Score(candidates []OfferCandidate) []float64 {
+	values := make([]float64, len(candidates))
+	known := make([]bool, len(candidates))
+	for i := range candidates {
+		offer := &candidates[i].Offer
+		values[i] = float64(offer.GetPrice())
+		known[i] = true
+		if p.SizeOf != nil {
+			size, ok := p.SizeOf(offer.GetSubCID())
+			known[i] = ok && size > 0
+			if known[i] {
+				values[i] = values[i] / float64(size)
+			}
+		}
+	}
+	return normaliseKnown(values, known, false, neutralScore)
+}
+
+// ExpiryMarginCriterion prefers the offers which stay valid the longest.
+// Offers expiring in less than MinMargin are given a score of zero.
+type ExpiryMarginCriterion struct {
+	MinMargin time.Duration
+}
+
+// Score implements OfferRankingCriterion
+func (e ExpiryMarginCriterion) Score(candidates []OfferCandidate) []float64 {
+	now := time.Now().Unix()
+	values := make([]float64, len(candidates))
+	tooShort := make([]bool, len(candidates))
+	for i := range candidates {
+		margin := candidates[i].Offer.GetExpiry() - now
+		if margin < int64(e.MinMargin.Seconds()) {
+			tooShort[i] = true
+		}
+		values[i] = float64(margin)
+	}
+	scores := normaliseHigherIsBetter(values)
+	for i := range scores {
+		if tooShort[i] {
+			scores[i] = 0
+		}
+	}
+	return scores
+}
+
+// QualityOfServiceCriterion prefers the offers with the highest quality of service.
+type QualityOfServiceCriterion struct{}
+
+// Score implements OfferRankingCriterion
+func (q QualityOfServiceCriterion) Score(candidates []OfferCandidate) []float64 {
+	values := make([]float64, len(candidates))
+	for i := range candidates {
+		values[i] = float64(candidates[i].Offer.GetQoS())
+	}
+	return normaliseHigherIsBetter(values)
+}
+
+// ProviderRegionCriterion prefers the offers from providers located in the given region.
+// RegionOf returns the region code of a provider, and an empty string if it is unknown.
+// When Distances is set, providers in other regions score higher the closer they are to the region.
+type ProviderRegionCriterion struct {
+	Region    string
+	RegionOf  func(providerID *nodeid.NodeID) string
+	Distances RegionDistanceModel
+}
+
+// Score implements OfferRankingCriterion
+func (r ProviderRegionCriterion) Score(candidates []OfferCandidate) []float64 {
+	scores := make([]float64, len(candidates))
+	if r.RegionOf == nil {
+		return scores
+	}
+	for i := range candidates {
+		region := r.RegionOf(candidates[i].Offer.GetProviderID())
+		if strings.EqualFold(region, r.Region) {
+			scores[i] = 1
+			continue
+		}
+		if r.Distances == nil || region == "" {
+			continue
+		}
+		if distance, known := r.Distances.Distance(r.Region, region); known {
+			scores[i] = math.Max(0, 1-distance/maxRegionDistance)
+		}
+	}
+	return scores
+}
+
+// ProviderReputationCriterion prefers the offers from providers with the best reputation.
+// Reputation returns the reputation of a provider, and false if it is unknown.
+// Providers with unknown reputation are given a score of zero.
+type ProviderReputationCriterion struct {
+	Reputation func(providerID *nodeid.NodeID) (int64, bool)
+}
+
+// Score implements OfferRankingCriterion
+func (r ProviderReputationCriterion) Score(candidates []OfferCandidate) []float64 {
+	values := make([]float64, len(candidates))
+	known := make([]bool, len(candidates))
+	for i := range candidates {
+		if r.Reputation == nil {
+			continue
+		}
+		if reputation, ok := r.Reputation(candidates[i].Offer.GetProviderID()); ok {
+			values[i] = float64(reputation)
+			known[i] = true
+		}
+	}
+	return normaliseKnown(values, known, true, 0)
+}
+
+// weightedCriterion - an offer ranking criterion with its weight
+type weightedCriterion struct {
+	criterion OfferRankingCriterion
+	weight    float64
+}
+
+// OfferRanker ranks offer candidates using a weighted sum of ranking criteria.
+type OfferRanker struct {
+	criteria []weightedCriterion
+}
+
+// NewOfferRanker creates an offer ranker with no criteria.
+func NewOfferRanker() *OfferRanker {
+	return &OfferRanker{}
+}
+
+// NewDefaultOfferRanker creates an offer ranker that prefers cheap offers, and among
+// equally priced offers, the ones that stay valid the longest.
+func NewDefaultOfferRanker() *OfferRanker {
+	r := NewOfferRanker()
+	r.AddCriterion(PricePerByteCriterion{}, defaultPriceRankingWeight)
+	r.AddCriterion(ExpiryMarginCriterion{}, defaultExpiryRankingWeight)
+	return r
+}
+
+// AddCriterion adds a ranking criterion with the given weight.
+func (r *OfferRanker) AddCriterion(criterion OfferRankingCriterion, weight float64) {
+	r.criteria = append(r.criteria, weightedCriterion{criterion: criterion, weight: weight})
+}
+
+// Rank scores the given candidates and returns them ordered from the best to the worst.
+// Expired offers are left out of the result. Returns an error if a criterion does not give one score per offer.
+func (r *OfferRanker) Rank(candidates []OfferCandidate) ([]RankedOffer, error) {
+	valid := make([]OfferCandidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		if candidate.Offer.HasExpired() {
+			continue
+		}
+		valid = append(valid, candidate)
+	}
+
+	ranked := make([]RankedOffer, len(valid))
+	for i := range valid {
+		ranked[i] = RankedOffer{OfferCandidate: valid[i]}
+	}
+	for _, wc := range r.criteria {
+		scores := wc.criterion.Score(valid)
+		if len(scores) != len(valid) {
+			return nil, fmt.Errorf("ranking criterion %T gave %d scores for %d offers", wc.criterion, len(scores), len(valid))
+		}
+		for i := range ranked {
+			ranked[i].Score += wc.weight * scores[i]
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+	return ranked, nil
+}
+
+// SelectBestOffer returns the best of the given offer candidates according to the given ranker.
+// If ranker is nil, the default offer ranker is used.
+func SelectBestOffer(candidates []OfferCandidate, ranker *OfferRanker) (*RankedOffer, error) {
+	if ranker == nil {
+		ranker = NewDefaultOfferRanker()
+	}
+	ranked, err := ranker.Rank(candidates)
+	if err != nil {
+		return nil, err
+	}
+	if len(ranked) == 0 {
+		return nil, errors.New("no valid offer to select from")
+	}
+	return &ranked[0], nil
+}
+
+// OfferCandidatesFromStandardDiscovery converts the result of a standard discovery into offer candidates.
+func OfferCandidatesFromStandardDiscovery(gatewayID *nodeid.NodeID, offers []cidoffer.SubCIDOffer) []OfferCandidate {
+	candidates := make([]OfferCandidate, 0, len(offers))
+	for _, offer := range offers {
+		candidates = append(candidates, OfferCandidate{GatewayID: gatewayID, Offer: offer})
+	}
+	return candidates
+}
+
+// OfferCandidatesFromDHTDiscovery converts the result of a DHT discovery into offer candidates.
+func OfferCandidatesFromDHTDiscovery(offersMap map[string]*[]cidoffer.SubCIDOffer) []OfferCandidate {
+	candidates := make([]OfferCandidate, 0)
+	for gatewayID, offers := range offersMap {
+		if offers == nil {
+			continue
+		}
+		id, err := nodeid.NewNodeIDFromHexString(gatewayID)
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, OfferCandidatesFromStandardDiscovery(id, *offers)...)
+	}
+	return candidates
+}
+
+// NewProviderRegionCriterion creates a ranking criterion preferring providers in or near the given region,
+// looking up the region of providers in the register.
+func (c *FilecoinRetrievalClient) NewProviderRegionCriterion(region string) ProviderRegionCriterion {
+	return ProviderRegionCriterion{
+		Region:    region,
+		Distances: c.Settings.RegionDistanceModel(),
+		RegionOf: func(providerID *nodeid.NodeID) string {
+			provider := c.registerMgr.GetProvider(providerID)
+			if provider == nil {
+				return ""
+			}
+			return provider.GetRegionCode()
+		},
+	}
+}
+
+// normaliseHigherIsBetter maps values onto [0, 1], giving 1 to the highest value.
+func normaliseHigherIsBetter(values []float64) []float64 {
+	return normalise(values, true)
+}
+
+// normaliseKnown maps the known values onto [0, 1], the unknown values being left out of the scaling and given
+// unknownScore.
+func normaliseKnown(values []float64, known []bool, higherIsBetter bool, unknownScore float64) []float64 {
+	knownValues := make([]float64, 0, len(values))
+	for i, v := range values {
+		if known[i] {
+			knownValues = append(knownValues, v)
+		}
+	}
+	knownScores := normalise(knownValues, higherIsBetter)
+	scores := make([]float64, len(values))
+	for i := range values {
+		if !known[i] {
+			scores[i] = unknownScore
+			continue
+		}
+		scores[i] = knownScores[0]
+		knownScores = knownScores[1:]
+	}
+	return scores
+}
+
+// normalise maps values onto [0, 1] using min-max scaling. If all the values are equal, they are all given 1.
+func normalise(values []float64, higherIsBetter bool) []float64 {
+	scores := make([]float64, len(values))
+	if len(values) == 0 {
+		return scores
+	}
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+	}
+	for i, v := range values {
+		switch {
+		case max == min:
+			scores[i] = 1
+		case higherIsBetter:
+			scores[i] = (v - min) / (max - min)
+		default:
+			scores[i] = (max - v) / (max - min)
+		}
+	}
+	return scores
+}
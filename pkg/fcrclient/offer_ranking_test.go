@@ -0,0 +1,104 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"testing"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+)
+
+// newTestCandidates returns a candidate for each price, with offers of distinct CIDs from distinct providers.
+func newTestCandidates(t *testing.T, prices ...uint64) []OfferCandidate {
+	t.Helper()
+	gatewayID := nodeid.NewRandomNodeID()
+	candidates := make([]OfferCandidate, 0, len(prices))
+	for _, price := range prices {
+		offer := newTestOffer(t, nodeid.NewRandomNodeID(), newTestKey(t), cid.NewRandomContentID(), price)
+		candidates = append(candidates, OfferCandidate{GatewayID: gatewayID, Offer: offer})
+	}
+	return candidates
+}
+
+// fixedScores - a ranking criterion giving fixed scores, whatever the candidates
+type fixedScores []float64
+
+func (f fixedScores) Score(candidates []OfferCandidate) []float64 {
+	return f
+}
+
+func TestPricePerByteScoresUnknownSizesNeutrally(t *testing.T) {
+	candidates := newTestCandidates(t, 100, 400, 1)
+	sizes := map[string]uint64{
+		candidates[0].Offer.GetSubCID().ToString(): 100,
+		candidates[1].Offer.GetSubCID().ToString(): 100,
+	}
+	criterion := PricePerByteCriterion{SizeOf: func(contentID *cid.ContentID) (uint64, bool) {
+		size, ok := sizes[contentID.ToString()]
+		return size, ok
+	}}
+
+	scores := criterion.Score(candidates)
+	if scores[0] != 1 || scores[1] != 0 {
+		t.Fatalf("expected the offers of known size scored against each other, got %v", scores)
+	}
+	if scores[2] != neutralScore {
+		t.Fatalf("expected the offer of unknown size scored neutrally, got %v", scores[2])
+	}
+}
+
+func TestReputationLeavesUnknownProvidersOutOfNormalisation(t *testing.T) {
+	candidates := newTestCandidates(t, 1, 1, 1)
+	reputations := map[string]int64{
+		candidates[0].Offer.GetProviderID().ToString(): 10,
+		candidates[1].Offer.GetProviderID().ToString(): 20,
+	}
+	criterion := ProviderReputationCriterion{Reputation: func(providerID *nodeid.NodeID) (int64, bool) {
+		reputation, ok := reputations[providerID.ToString()]
+		return reputation, ok
+	}}
+
+	scores := criterion.Score(candidates)
+	if scores[0] != 0 || scores[1] != 1 || scores[2] != 0 {
+		t.Fatalf("expected the known reputations scaled between themselves and zero for the unknown one, got %v", scores)
+	}
+}
+
+func TestRankRejectsCriterionWithWrongNumberOfScores(t *testing.T) {
+	candidates := newTestCandidates(t, 1, 2)
+	ranker := NewOfferRanker()
+	ranker.AddCriterion(fixedScores{1}, 1)
+
+	if _, err := ranker.Rank(candidates); err == nil {
+		t.Fatal("criterion giving a score for one of two offers accepted")
+	}
+	if _, err := SelectBestOffer(candidates, ranker); err == nil {
+		t.Fatal("best offer selected with an invalid criterion")
+	}
+}
+
+func TestSelectBestOfferPrefersCheapOffers(t *testing.T) {
+	candidates := newTestCandidates(t, 30, 10, 20)
+
+	best, err := SelectBestOffer(candidates, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if best.Offer.GetPrice() != 10 {
+		t.Fatalf("expected the cheapest offer, got price %d", best.Offer.GetPrice())
+	}
+}
@@ -0,0 +1,129 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+)
+
+// offerRejectionError - the reason an offer failed verification
+type offerRejectionError struct {
+	reason  string
+	message string
+}
+
+func (e *offerRejectionError) Error() string {
+	return e.message
+}
+
+// verifySubCIDOffer checks the offer is signed by its provider and that its merkle proof is valid.
+// The error returned when the offer fails verification is an *offerRejectionError.
+func (c *FilecoinRetrievalClient) verifySubCIDOffer(offer *cidoffer.SubCIDOffer) error {
+	// Get provider's pubkey
+	provider := c.registerMgr.GetProvider(offer.GetProviderID())
+	if provider == nil {
+		return &offerRejectionError{offerRejectedProviderNotFound, "error getting provider info"}
+	}
+	if !c.validProvider(provider) {
+		return &offerRejectionError{offerRejectedInvalidRegistration, "provider register info not valid"}
+	}
+	pubKey, err := provider.GetSigningKey()
+	if err != nil {
+		return &offerRejectionError{offerRejectedInvalidKey, "fail to obtain public key"}
+	}
+	// Verify the offer sig
+	if err := offer.Verify(pubKey); err != nil {
+		return &offerRejectionError{offerRejectedInvalidSignature, fmt.Sprintf("offer signature fail to verify, with error: %v", err)}
+	}
+	// Now Verify the merkle proof
+	if offer.VerifyMerkleProof() != nil {
+		return &offerRejectionError{offerRejectedInvalidMerkleProof, "merkle proof verification failed"}
+	}
+	c.watcher.watchProvider(provider)
+	return nil
+}
+
+// verifyGatewaySubResponse checks a response relayed through the DHT is signed by the gateway which produced it.
+func (c *FilecoinRetrievalClient) verifyGatewaySubResponse(ctx context.Context, gatewayID *nodeid.NodeID, resp *fcrmessages.FCRMessage) error {
+	_, span := startSpan(ctx, "fcrclient.VerifyGatewayResponse", clientapi.AttributeGatewayID.String(gatewayID.ToString()))
+	err := c.checkGatewaySubResponse(gatewayID, resp)
+	endSpan(span, err)
+	return err
+}
+
+// checkGatewaySubResponse is verifyGatewaySubResponse without tracing.
+func (c *FilecoinRetrievalClient) checkGatewaySubResponse(gatewayID *nodeid.NodeID, resp *fcrmessages.FCRMessage) error {
+	// Get gateway's pubkey
+	gateway := c.registerMgr.GetGateway(gatewayID)
+	if gateway == nil {
+		return errors.New("error in getting gateway info")
+	}
+	if !c.validGateway(gateway) {
+		return errors.New("gateway register info not valid")
+	}
+	pubKey, err := gateway.GetSigningKey()
+	if err != nil {
+		return errors.New("fail to obtain public key")
+	}
+	if resp.Verify(pubKey) != nil {
+		return errors.New("fail to verify sub response")
+	}
+	return nil
+}
+
+// verifiedSubCIDOffers returns the offers which pass verification, logging the ones which don't.
+func (c *FilecoinRetrievalClient) verifiedSubCIDOffers(ctx context.Context, offers []cidoffer.SubCIDOffer) []cidoffer.SubCIDOffer {
+	_, span := startSpan(ctx, "fcrclient.VerifyOffers", clientapi.AttributeNumOffers.Int(len(offers)))
+	defer span.End()
+	verified := make([]cidoffer.SubCIDOffer, 0)
+	for _, offer := range offers {
+		if err := c.verifySubCIDOffer(&offer); err != nil {
+			c.logger.Error("Offer rejected", "provider_id", offer.GetProviderID().ToString(), "error", err)
+			reason := ""
+			var rejection *offerRejectionError
+			if errors.As(err, &rejection) {
+				reason = rejection.reason
+				c.Settings.metrics.observeOffer(rejection.reason)
+			}
+			c.events.publish(OfferRejectedEvent{now(), offer.GetProviderID().ToString(), offer.GetSubCID().ToString(), reason, err})
+			continue
+		}
+		c.Settings.metrics.observeOffer(offerAccepted)
+		// Offer pass verification
+		verified = append(verified, offer)
+		c.logger.Info("Offer pass every verification, added to result", "provider_id", offer.GetProviderID().ToString())
+	}
+	return verified
+}
+
+// decodeGatewayDHTDiscoverResponse verifies a DHT discover sub response of a gateway and returns its verified offers.
+func (c *FilecoinRetrievalClient) decodeGatewayDHTDiscoverResponse(ctx context.Context, gatewayID *nodeid.NodeID, resp *fcrmessages.FCRMessage) ([]cidoffer.SubCIDOffer, error) {
+	if err := c.verifyGatewaySubResponse(ctx, gatewayID, resp); err != nil {
+		return nil, err
+	}
+	_, _, _, offers, _, err := fcrmessages.DecodeGatewayDHTDiscoverResponse(resp)
+	if err != nil {
+		return nil, fmt.Errorf("fail to decode response: %s", err.Error())
+	}
+	return c.verifiedSubCIDOffers(ctx, offers), nil
+}
@@ -0,0 +1,141 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+)
+
+// paymentManager - the operations of the payment manager gateways are paid with, implemented by
+// fcrpaymentmgr.FCRPaymentMgr
+type paymentManager interface {
+	Topup(recipient string, amount *big.Int) error
+	Pay(recipient string, lane uint64, amount *big.Int) (string, string, bool, error)
+}
+
+// paymentManager returns the payment manager gateways are paid with, nil if it is not available.
+func (c *FilecoinRetrievalClient) paymentManager() paymentManager {
+	if c.payer != nil {
+		return c.payer
+	}
+	if paymentMgr := c.PaymentMgr(); paymentMgr != nil {
+		return paymentMgr
+	}
+	return nil
+}
+
+// payGateway pays the given amount to a gateway on the given lane, topping up the payment channel first
+// as the top up policy decides. It returns the payment channel address and the voucher.
+func (c *FilecoinRetrievalClient) payGateway(ctx context.Context, gw register.GatewayRegistrar, lane uint64, amount *big.Int) (string, string, error) {
+	return c.payGatewayExpecting(ctx, gw, lane, amount, amount)
+}
+
+// payGatewayExpecting is payGateway for an operation expecting to spend the given amount with the gateway, the
+// payment included.
+func (c *FilecoinRetrievalClient) payGatewayExpecting(ctx context.Context, gw register.GatewayRegistrar, lane uint64, amount *big.Int, expected *big.Int) (string, string, error) {
+	_, span := startSpan(ctx, "fcrclient.Pay", clientapi.AttributeGatewayID.String(gw.GetNodeID()), amountAttribute(amount))
+	paychAddr, voucher, err := c.payGatewayNoTrace(gw, lane, amount, expected)
+	endSpan(span, err)
+	return paychAddr, voucher, err
+}
+
+// payGatewayNoTrace pays a gateway, topping up the payment channel as the top up policy decides. The channel must
+// be open, and is not settled until the payment is made.
+func (c *FilecoinRetrievalClient) payGatewayNoTrace(gw register.GatewayRegistrar, lane uint64, amount *big.Int, expected *big.Int) (paychAddr string, voucher string, err error) {
+	err = c.useOpenChannel(gw.GetNodeID(), func() (err error) {
+		paychAddr, voucher, err = c.payOpenChannel(gw, lane, amount, expected)
+		return err
+	})
+	return paychAddr, voucher, err
+}
+
+// payOpenChannel is payGatewayNoTrace with the payment channel known to be open.
+func (c *FilecoinRetrievalClient) payOpenChannel(gw register.GatewayRegistrar, lane uint64, amount *big.Int, expected *big.Int) (string, string, error) {
+	paymentMgr := c.paymentManager()
+	if paymentMgr == nil {
+		return "", "", errors.New("payment manager not available")
+	}
+	if expected == nil || expected.Cmp(amount) < 0 {
+		expected = amount
+	}
+	request := TopupRequest{GatewayID: gw.GetNodeID(), Pending: amount, Expected: expected, TopUpAmount: c.Settings.TopUpAmount()}
+
+	// The policy may top up the channel before it runs out
+	request.Balance = c.channelBalance(gw.GetNodeID())
+	topUpAmount, err := c.Settings.TopupPolicy().TopupAmount(request)
+	if err != nil {
+		return "", "", fmt.Errorf("error to topup payment channel for gateway ID: %s; error: %s", gw.GetNodeID(), err.Error())
+	}
+	if topUpAmount.Sign() > 0 {
+		if err = c.topupGateway(paymentMgr, gw, topUpAmount); err != nil {
+			return "", "", err
+		}
+	}
+	paychAddr, voucher, topup, err := paymentMgr.Pay(gw.GetAddress(), lane, amount)
+	if err != nil {
+		return "", "", fmt.Errorf("error paying gateway ID: %s; error: %s", gw.GetNodeID(), err.Error())
+	}
+	if !topup {
+		c.paymentMade(gw, paychAddr, lane, amount)
+		return paychAddr, voucher, nil
+	}
+	// There isn't enough balance in the payment channel, need to topup (create)
+	request.Balance = c.channelBalance(gw.GetNodeID())
+	request.NeedTopup = true
+	topUpAmount, err = c.Settings.TopupPolicy().TopupAmount(request)
+	if err != nil {
+		return "", "", fmt.Errorf("error to topup payment channel for gateway ID: %s; error: %s", gw.GetNodeID(), err.Error())
+	}
+	if topUpAmount.Sign() <= 0 {
+		return "", "", fmt.Errorf("balance not enough to pay gateway ID: %s and the topup policy gives no topup", gw.GetNodeID())
+	}
+	if err = c.topupGateway(paymentMgr, gw, topUpAmount); err != nil {
+		return "", "", err
+	}
+	paychAddr, voucher, topup, err = paymentMgr.Pay(gw.GetAddress(), lane, amount)
+	if err != nil {
+		return "", "", fmt.Errorf("topup succeeded but error paying gateway ID: %s; error: %s", gw.GetNodeID(), err.Error())
+	}
+	if topup {
+		return "", "", fmt.Errorf("topup succeeded but balance still not enough to pay gateway ID: %s", gw.GetNodeID())
+	}
+	c.paymentMade(gw, paychAddr, lane, amount)
+	return paychAddr, voucher, nil
+}
+
+// topupGateway tops up (or creates) the payment channel to a gateway.
+func (c *FilecoinRetrievalClient) topupGateway(paymentMgr paymentManager, gw register.GatewayRegistrar, topUpAmount *big.Int) error {
+	if err := paymentMgr.Topup(gw.GetAddress(), topUpAmount); err != nil {
+		return fmt.Errorf("error to topup payment channel for gateway ID: %s; error: %s", gw.GetNodeID(), err.Error())
+	}
+	c.recordTopup(gw.GetNodeID(), topUpAmount)
+	c.events.publish(TopupPerformedEvent{now(), gw.GetNodeID(), new(big.Int).Set(topUpAmount)})
+	c.Settings.metrics.observeTopup(gw.GetNodeID(), topUpAmount)
+	return nil
+}
+
+// paymentMade records a payment to a gateway.
+func (c *FilecoinRetrievalClient) paymentMade(gw register.GatewayRegistrar, paychAddr string, lane uint64, amount *big.Int) {
+	c.recordPayment(gw.GetNodeID(), paychAddr, lane, amount)
+	c.events.publish(PaymentMadeEvent{now(), gw.GetNodeID(), paychAddr, lane, new(big.Int).Set(amount)})
+	c.Settings.metrics.observePayment(gw.GetNodeID(), amount)
+}
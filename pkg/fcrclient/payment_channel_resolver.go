@@ -0,0 +1,150 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxLotusResponseSize is the maximum size of a response of the lotus node read by the lotus resolver.
+const maxLotusResponseSize = 4096
+
+// PaymentChannelResolver resolves the address of a payment channel to the ID of its actor. Gateways answering
+// payment required give the payment channel to top up as an actor ID, while the payment manager gives the
+// robust address of the channels it creates, such as f2...
+type PaymentChannelResolver interface {
+	LookupID(ctx context.Context, paymentChannel string) (int64, error)
+}
+
+// lotusResolver - a PaymentChannelResolver asking a lotus node with StateLookupID
+type lotusResolver struct {
+	lotusAP        string
+	lotusAuthToken string
+	client         *http.Client
+}
+
+// NewLotusPaymentChannelResolver creates a PaymentChannelResolver asking the lotus node at lotusAP. A websocket
+// address is asked over HTTP, at the same path.
+func NewLotusPaymentChannelResolver(lotusAP string, lotusAuthToken string) PaymentChannelResolver {
+	if strings.HasPrefix(lotusAP, "ws://") || strings.HasPrefix(lotusAP, "wss://") {
+		lotusAP = "http" + strings.TrimPrefix(lotusAP, "ws")
+	}
+	return &lotusResolver{lotusAP: lotusAP, lotusAuthToken: lotusAuthToken, client: &http.Client{}}
+}
+
+// lotusRequest - a JSON-RPC request to a lotus node
+type lotusRequest struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+// lotusResponse - the JSON-RPC response of a lotus node
+type lotusResponse struct {
+	Result string `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// LookupID implements PaymentChannelResolver
+func (r *lotusResolver) LookupID(ctx context.Context, paymentChannel string) (int64, error) {
+	if r.lotusAP == "" {
+		return 0, errors.New("no lotus API address to resolve payment channels with")
+	}
+	body, err := json.Marshal(lotusRequest{JSONRPC: "2.0", ID: 1, Method: "Filecoin.StateLookupID", Params: []interface{}{paymentChannel, nil}})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.lotusAP, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.lotusAuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+r.lotusAuthToken)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error looking up payment channel %s: %s", paymentChannel, err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("error looking up payment channel %s: lotus answered %s", paymentChannel, resp.Status)
+	}
+	var res lotusResponse
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxLotusResponseSize)).Decode(&res); err != nil {
+		return 0, fmt.Errorf("error decoding the ID of payment channel %s: %s", paymentChannel, err.Error())
+	}
+	if res.Error != nil {
+		return 0, fmt.Errorf("error looking up payment channel %s: %s", paymentChannel, res.Error.Message)
+	}
+	id, ok := actorID(res.Result)
+	if !ok {
+		return 0, fmt.Errorf("lotus resolved payment channel %s to %q, not an ID address", paymentChannel, res.Result)
+	}
+	return id, nil
+}
+
+// actorID returns the actor ID of an ID address, such as f01234, false if addr is not an ID address.
+func actorID(addr string) (int64, bool) {
+	if len(addr) < 3 || (addr[0] != 'f' && addr[0] != 't') || addr[1] != '0' {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(addr[2:], 10, 63)
+	return int64(id), err == nil
+}
+
+// paymentChannelResolver returns the resolver of the settings, a lotus resolver using the lotus node payments
+// are made with if not set.
+func (c *FilecoinRetrievalClient) paymentChannelResolver() PaymentChannelResolver {
+	if resolver := c.Settings.PaymentChannelResolver(); resolver != nil {
+		return resolver
+	}
+	return NewLotusPaymentChannelResolver(c.Settings.LotusAP(), c.Settings.LotusAuthToken())
+}
+
+// paymentChannelID returns the actor ID of the payment channel at paychAddr. An address other than an ID
+// address is resolved once, and its ID kept for the life of the client.
+func (c *FilecoinRetrievalClient) paymentChannelID(ctx context.Context, paychAddr string) (int64, error) {
+	if id, ok := actorID(paychAddr); ok {
+		return id, nil
+	}
+	c.paymentStatusLock.RLock()
+	id, resolved := c.paymentChannelIDs[paychAddr]
+	c.paymentStatusLock.RUnlock()
+	if resolved {
+		return id, nil
+	}
+	id, err := c.paymentChannelResolver().LookupID(ctx, paychAddr)
+	if err != nil {
+		return 0, err
+	}
+	c.paymentStatusLock.Lock()
+	c.paymentChannelIDs[paychAddr] = id
+	c.paymentStatusLock.Unlock()
+	return id, nil
+}
@@ -0,0 +1,71 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// lotusServer starts a lotus node answering StateLookupID with result, or with an error if result is empty.
+func lotusServer(t *testing.T, result string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req lotusRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != "Filecoin.StateLookupID" || len(req.Params) != 2 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if result == "" {
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":1,"message":"actor not found"}}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": result})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestLotusPaymentChannelResolver(t *testing.T) {
+	id, err := NewLotusPaymentChannelResolver(lotusServer(t, "f01234").URL, "token").LookupID(context.Background(), robustChannel)
+	if err != nil || id != 1234 {
+		t.Fatalf("expected 1234, got %d, %v", id, err)
+	}
+	for _, resolver := range []PaymentChannelResolver{
+		NewLotusPaymentChannelResolver(lotusServer(t, "").URL, "token"),
+		NewLotusPaymentChannelResolver(lotusServer(t, robustChannel).URL, "token"),
+		NewLotusPaymentChannelResolver(lotusServer(t, "f01234").URL, "wrong"),
+		NewLotusPaymentChannelResolver("", ""),
+	} {
+		if _, err := resolver.LookupID(context.Background(), robustChannel); err == nil {
+			t.Fatal("payment channel resolved without an ID address from lotus")
+		}
+	}
+}
+
+func TestLotusResolverOverWebsocketAddress(t *testing.T) {
+	resolver := NewLotusPaymentChannelResolver("ws://127.0.0.1:1234/rpc/v0", "").(*lotusResolver)
+	if resolver.lotusAP != "http://127.0.0.1:1234/rpc/v0" {
+		t.Fatalf("websocket address not asked over HTTP: %s", resolver.lotusAP)
+	}
+}
@@ -0,0 +1,240 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+)
+
+// PaymentChannelState - the lifecycle state of the payment channel to a gateway
+type PaymentChannelState string
+
+const (
+	// PaymentChannelOpen - the channel is used to pay the gateway
+	PaymentChannelOpen PaymentChannelState = "open"
+	// PaymentChannelSettling - the settle message is being sent
+	PaymentChannelSettling PaymentChannelState = "settling"
+	// PaymentChannelSettled - the settlement period started, the gateway can redeem its vouchers until it ends
+	PaymentChannelSettled PaymentChannelState = "settled"
+	// PaymentChannelCollected - the channel was collected and the remaining balance returned to the client
+	PaymentChannelCollected PaymentChannelState = "collected"
+)
+
+// PaymentChannelInfo - a payment channel created by this client
+type PaymentChannelInfo struct {
+	GatewayID      string
+	PaymentChannel string
+	// Balance is the amount the channel was topped up with
+	Balance *big.Int
+	// Redeemed is the amount of the vouchers given to the gateway
+	Redeemed  *big.Int
+	State     PaymentChannelState
+	SettledAt time.Time
+}
+
+// PaymentChannels returns the payment channels created since the client was created, sorted by gateway ID.
+func (c *FilecoinRetrievalClient) PaymentChannels() []PaymentChannelInfo {
+	c.paymentStatusLock.RLock()
+	defer c.paymentStatusLock.RUnlock()
+
+	res := make([]PaymentChannelInfo, 0, len(c.paymentStatus))
+	for _, status := range c.paymentStatus {
+		if status.PaymentChannel == "" {
+			continue
+		}
+		res = append(res, PaymentChannelInfo{
+			GatewayID:      status.GatewayID,
+			PaymentChannel: status.PaymentChannel,
+			Balance:        new(big.Int).Set(status.ToppedUp),
+			Redeemed:       new(big.Int).Set(status.Paid),
+			State:          status.ChannelState,
+			SettledAt:      status.SettledAt,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].GatewayID < res[j].GatewayID })
+	return res
+}
+
+// SettlePaymentChannel settles the payment channel to a gateway which is no longer in the gateways to use.
+// Once settled, the channel can no longer pay the gateway, and can be collected at the end of the settlement period.
+func (c *FilecoinRetrievalClient) SettlePaymentChannel(gatewayID *nodeid.NodeID) error {
+	return c.settlePaymentChannel(context.Background(), gatewayID.ToString())
+}
+
+// CollectPaymentChannel collects the settled payment channel to a gateway, returning the remaining balance to the client.
+// It fails until the settlement period of the channel is over.
+func (c *FilecoinRetrievalClient) CollectPaymentChannel(gatewayID *nodeid.NodeID) error {
+	return c.collectPaymentChannel(context.Background(), gatewayID.ToString())
+}
+
+// settlePaymentChannel settles the payment channel to a gateway. The channel stops being open once the payments
+// in progress with it are made, and before the settle message is sent.
+func (c *FilecoinRetrievalClient) settlePaymentChannel(ctx context.Context, gatewayID string) error {
+	gatewayID = strings.ToLower(gatewayID)
+	paymentChannel, err := c.stopPayments(gatewayID)
+	if err != nil {
+		return err
+	}
+	settler, err := c.ChannelSettler()
+	if err == nil {
+		err = settler.Settle(ctx, paymentChannel)
+	}
+	if err != nil {
+		c.setChannelState(gatewayID, PaymentChannelOpen)
+		return fmt.Errorf("error settling payment channel %s of gateway %s: %s", paymentChannel, gatewayID, err.Error())
+	}
+	c.setChannelState(gatewayID, PaymentChannelSettled)
+	c.logger.Info("Payment channel settled", "gateway_id", gatewayID, "payment_channel", paymentChannel)
+	c.events.publish(PaymentChannelSettledEvent{now(), gatewayID, paymentChannel})
+	return nil
+}
+
+// stopPayments moves the payment channel to a gateway which is not in the gateways to use from open to settling,
+// waiting for the payments in progress with the channel. Returns the payment channel address.
+func (c *FilecoinRetrievalClient) stopPayments(gatewayID string) (string, error) {
+	lock := c.channelLock(gatewayID)
+	lock.Lock()
+	defer lock.Unlock()
+	c.GatewaysToUseLock.RLock()
+	defer c.GatewaysToUseLock.RUnlock()
+	if _, inUse := c.GatewaysToUse[gatewayID]; inUse {
+		return "", fmt.Errorf("gateway %s is in the gateways to use, remove it before settling its payment channel", gatewayID)
+	}
+	return c.moveChannelState(gatewayID, PaymentChannelOpen, PaymentChannelSettling)
+}
+
+// collectPaymentChannel collects the settled payment channel to a gateway.
+func (c *FilecoinRetrievalClient) collectPaymentChannel(ctx context.Context, gatewayID string) error {
+	gatewayID = strings.ToLower(gatewayID)
+	paymentChannel, err := c.moveChannelState(gatewayID, PaymentChannelSettled, PaymentChannelCollected)
+	if err != nil {
+		return err
+	}
+	settler, err := c.ChannelSettler()
+	if err == nil {
+		err = settler.Collect(ctx, paymentChannel)
+	}
+	if err != nil {
+		c.setChannelState(gatewayID, PaymentChannelSettled)
+		return fmt.Errorf("error collecting payment channel %s of gateway %s: %s", paymentChannel, gatewayID, err.Error())
+	}
+	c.logger.Info("Payment channel collected", "gateway_id", gatewayID, "payment_channel", paymentChannel)
+	c.events.publish(PaymentChannelCollectedEvent{now(), gatewayID, paymentChannel})
+	return nil
+}
+
+// moveChannelState changes the state of the payment channel to a gateway, if it is in the expected state.
+// Returns the payment channel address.
+func (c *FilecoinRetrievalClient) moveChannelState(gatewayID string, from PaymentChannelState, to PaymentChannelState) (string, error) {
+	c.paymentStatusLock.Lock()
+	defer c.paymentStatusLock.Unlock()
+	status, exists := c.paymentStatus[gatewayID]
+	if !exists || status.PaymentChannel == "" {
+		return "", fmt.Errorf("no payment channel to gateway %s", gatewayID)
+	}
+	if status.ChannelState != from {
+		return "", fmt.Errorf("payment channel %s of gateway %s is %s, expected %s", status.PaymentChannel, gatewayID, status.ChannelState, from)
+	}
+	status.ChannelState = to
+	return status.PaymentChannel, nil
+}
+
+// setChannelState sets the state of the payment channel to a gateway.
+func (c *FilecoinRetrievalClient) setChannelState(gatewayID string, state PaymentChannelState) {
+	c.paymentStatusLock.Lock()
+	defer c.paymentStatusLock.Unlock()
+	status := c.gatewayPaymentStatus(gatewayID)
+	status.ChannelState = state
+	if state == PaymentChannelSettled && status.SettledAt.IsZero() {
+		status.SettledAt = time.Now()
+	}
+}
+
+// channelLock returns the lock of the payment channel to a gateway. Payments and top ups hold it for reading
+// while they use the channel, and the channel is only settled holding it for writing, so that no payment is made
+// with a channel which is being settled.
+func (c *FilecoinRetrievalClient) channelLock(gatewayID string) *sync.RWMutex {
+	gatewayID = strings.ToLower(gatewayID)
+	c.paymentStatusLock.Lock()
+	defer c.paymentStatusLock.Unlock()
+	lock, exists := c.channelLocks[gatewayID]
+	if !exists {
+		lock = &sync.RWMutex{}
+		c.channelLocks[gatewayID] = lock
+	}
+	return lock
+}
+
+// useOpenChannel calls use holding the lock of the payment channel to a gateway for reading, if the channel is
+// open. Returns an error without calling use otherwise.
+func (c *FilecoinRetrievalClient) useOpenChannel(gatewayID string, use func() error) error {
+	lock := c.channelLock(gatewayID)
+	lock.RLock()
+	defer lock.RUnlock()
+	if !c.channelOpen(gatewayID) {
+		return fmt.Errorf("payment channel of gateway ID: %s is settled", gatewayID)
+	}
+	return use()
+}
+
+// channelOpen returns true if the payment channel to a gateway can be used, a gateway with no channel yet included.
+func (c *FilecoinRetrievalClient) channelOpen(gatewayID string) bool {
+	c.paymentStatusLock.RLock()
+	defer c.paymentStatusLock.RUnlock()
+	status, exists := c.paymentStatus[strings.ToLower(gatewayID)]
+	return !exists || status.ChannelState == PaymentChannelOpen
+}
+
+// settleRemoved settles in the background the open payment channels of gateways removed from the gateways to use,
+// if a settler is set.
+func (c *FilecoinRetrievalClient) settleRemoved(gatewayIDs []string) {
+	if c.Settings.ChannelSettler() == nil {
+		return
+	}
+	for _, gatewayID := range gatewayIDs {
+		c.paymentStatusLock.RLock()
+		status, exists := c.paymentStatus[gatewayID]
+		open := exists && status.PaymentChannel != "" && status.ChannelState == PaymentChannelOpen
+		c.paymentStatusLock.RUnlock()
+		if !open {
+			continue
+		}
+		go func(gatewayID string) {
+			if err := c.settlePaymentChannel(context.Background(), gatewayID); err != nil {
+				c.logger.Error("Error settling the payment channel of a removed gateway", "gateway_id", gatewayID, "error", err)
+			}
+		}(gatewayID)
+	}
+}
+
+// ChannelSettler returns the settler of the payment channels set in the settings. Settling needs one: the client
+// does not send chain messages itself, lotussettler.NewChannelSettler creates one using a lotus node.
+func (c *FilecoinRetrievalClient) ChannelSettler() (ChannelSettler, error) {
+	settler := c.Settings.ChannelSettler()
+	if settler == nil {
+		return nil, errors.New("no channel settler set to settle payment channels with")
+	}
+	return settler, nil
+}
@@ -0,0 +1,214 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+	"github.com/golang/mock/gomock"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi/mocks"
+)
+
+// testSettler - a ChannelSettler recording the channels it settles and collects
+type testSettler struct {
+	settled   chan string
+	collected chan string
+}
+
+func newTestSettler() *testSettler {
+	return &testSettler{settled: make(chan string, 10), collected: make(chan string, 10)}
+}
+
+func (s *testSettler) Settle(ctx context.Context, paymentChannel string) error {
+	s.settled <- paymentChannel
+	return nil
+}
+
+func (s *testSettler) Collect(ctx context.Context, paymentChannel string) error {
+	s.collected <- paymentChannel
+	return nil
+}
+
+// expectSettled waits for the settlement of paymentChannel, failing if another channel or none is settled.
+func (s *testSettler) expectSettled(t *testing.T, paymentChannel string) {
+	t.Helper()
+	select {
+	case settled := <-s.settled:
+		if settled != paymentChannel {
+			t.Fatalf("settled %s, expected %s", settled, paymentChannel)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("payment channel %s not settled", paymentChannel)
+	}
+}
+
+// expectNoSettlement fails if a payment channel is settled within a short time.
+func (s *testSettler) expectNoSettlement(t *testing.T) {
+	t.Helper()
+	select {
+	case settled := <-s.settled:
+		t.Fatalf("payment channel %s settled", settled)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// newChannelTestClient creates a client with a payment channel to gateway, which is in the gateways to use.
+func newChannelTestClient(t *testing.T, registerMgr *testRegister, gateway *testNode, configure func(*SettingsBuilder)) *FilecoinRetrievalClient {
+	t.Helper()
+	c := newTestClientWithRegister(t, registerMgr, func(builder *SettingsBuilder) {
+		builder.SetClientApi(mocks.NewMockClientApi(gomock.NewController(t)))
+		if configure != nil {
+			configure(builder)
+		}
+	})
+	if c.AddGatewaysToUse([]*nodeid.NodeID{gateway.id}) != 1 {
+		t.Fatal("gateway not added")
+	}
+	c.paymentStatusLock.Lock()
+	c.gatewayPaymentStatus(gateway.id.ToString()).PaymentChannel = "t2channel"
+	c.paymentStatusLock.Unlock()
+	return c
+}
+
+func TestSettleAndCollect(t *testing.T) {
+	gateway := newTestNode(t)
+	settler := newTestSettler()
+	registerMgr := newTestRegister([]register.GatewayRegistrar{gateway.gateway(t)}, nil)
+	c := newChannelTestClient(t, registerMgr, gateway, func(builder *SettingsBuilder) {
+		builder.SetChannelSettler(settler)
+		builder.SetSettleOnRemove(false)
+	})
+	if c.RemoveGatewaysToUse([]*nodeid.NodeID{gateway.id}) != 1 {
+		t.Fatal("gateway not removed")
+	}
+	settler.expectNoSettlement(t)
+
+	if err := c.SettlePaymentChannel(gateway.id); err != nil {
+		t.Fatal(err)
+	}
+	settler.expectSettled(t, "t2channel")
+	if err := c.CollectPaymentChannel(gateway.id); err != nil {
+		t.Fatal(err)
+	}
+	if channels := c.PaymentChannels(); len(channels) != 1 || channels[0].State != PaymentChannelCollected {
+		t.Fatalf("unexpected payment channels: %+v", channels)
+	}
+}
+
+func TestSettleOnRemove(t *testing.T) {
+	gateway := newTestNode(t)
+	settler := newTestSettler()
+	registerMgr := newTestRegister([]register.GatewayRegistrar{gateway.gateway(t)}, nil)
+	c := newChannelTestClient(t, registerMgr, gateway, func(builder *SettingsBuilder) {
+		builder.SetChannelSettler(settler)
+	})
+	if err := c.SettlePaymentChannel(gateway.id); err == nil {
+		t.Fatal("payment channel of a gateway in use settled")
+	}
+	c.RemoveGatewaysToUse([]*nodeid.NodeID{gateway.id})
+	settler.expectSettled(t, "t2channel")
+}
+
+func TestRegisterWatcherNeverSettles(t *testing.T) {
+	gateway := newTestNode(t)
+	settler := newTestSettler()
+	registerMgr := newTestRegister([]register.GatewayRegistrar{gateway.gateway(t)}, nil)
+	c := newChannelTestClient(t, registerMgr, gateway, func(builder *SettingsBuilder) {
+		builder.SetChannelSettler(settler)
+	})
+	delete(registerMgr.gateways, gateway.id.ToString())
+	c.CheckRegister()
+	if len(c.GetGatewaysToUse()) != 0 {
+		t.Fatal("gateway no longer in the register not removed")
+	}
+	settler.expectNoSettlement(t)
+}
+
+func TestSettleRequiresSettler(t *testing.T) {
+	gateway := newTestNode(t)
+	registerMgr := newTestRegister([]register.GatewayRegistrar{gateway.gateway(t)}, nil)
+	c := newChannelTestClient(t, registerMgr, gateway, nil)
+	c.RemoveGatewaysToUse([]*nodeid.NodeID{gateway.id})
+	if err := c.SettlePaymentChannel(gateway.id); err == nil {
+		t.Fatal("payment channel settled without a settler")
+	}
+	if channels := c.PaymentChannels(); len(channels) != 1 || channels[0].State != PaymentChannelOpen {
+		t.Fatalf("failed settlement changed the channel: %+v", channels)
+	}
+}
+
+func TestSettledGatewayNotAddedAgain(t *testing.T) {
+	gateway := newTestNode(t)
+	settler := newTestSettler()
+	registerMgr := newTestRegister([]register.GatewayRegistrar{gateway.gateway(t)}, nil)
+	c := newChannelTestClient(t, registerMgr, gateway, func(builder *SettingsBuilder) {
+		builder.SetChannelSettler(settler)
+	})
+	c.RemoveGatewaysToUse([]*nodeid.NodeID{gateway.id})
+	settler.expectSettled(t, "t2channel")
+
+	if c.AddGatewaysToUse([]*nodeid.NodeID{gateway.id}) != 0 || len(c.GetGatewaysToUse()) != 0 {
+		t.Fatal("gateway with a settled payment channel added again")
+	}
+	c.payer = &testPayer{channel: "t2channel"}
+	if _, _, err := c.payGateway(context.Background(), gateway.gateway(t), 0, big.NewInt(1)); err == nil {
+		t.Fatal("gateway paid with a settled payment channel")
+	}
+}
+
+// blockingPayer - a payment manager whose payments wait to be released
+type blockingPayer struct {
+	testPayer
+	paying  chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingPayer) Pay(recipient string, lane uint64, amount *big.Int) (string, string, bool, error) {
+	p.paying <- struct{}{}
+	<-p.release
+	return p.testPayer.Pay(recipient, lane, amount)
+}
+
+func TestSettlementWaitsForPaymentsInProgress(t *testing.T) {
+	gateway := newTestNode(t)
+	settler := newTestSettler()
+	registerMgr := newTestRegister([]register.GatewayRegistrar{gateway.gateway(t)}, nil)
+	c := newChannelTestClient(t, registerMgr, gateway, func(builder *SettingsBuilder) {
+		builder.SetChannelSettler(settler)
+	})
+	payer := &blockingPayer{testPayer: testPayer{channel: "t2channel"}, paying: make(chan struct{}), release: make(chan struct{})}
+	c.payer = payer
+	paid := make(chan error, 1)
+	go func() {
+		_, _, err := c.payGateway(context.Background(), gateway.gateway(t), 0, big.NewInt(1))
+		paid <- err
+	}()
+	<-payer.paying
+
+	c.RemoveGatewaysToUse([]*nodeid.NodeID{gateway.id})
+	settler.expectNoSettlement(t)
+	close(payer.release)
+	if err := <-paid; err != nil {
+		t.Fatalf("payment in progress failed: %s", err.Error())
+	}
+	settler.expectSettled(t, "t2channel")
+}
@@ -0,0 +1,171 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"math/big"
+	"sort"
+	"time"
+)
+
+// LaneAllocation - how payments to a gateway are spread over the lanes of its payment channel
+type LaneAllocation string
+
+const (
+	// LaneAllocationSingle - every payment uses lane 0, so concurrent operations with a gateway contend for it
+	LaneAllocationSingle LaneAllocation = "single"
+	// LaneAllocationRequestType - each request type uses its own lane
+	LaneAllocationRequestType LaneAllocation = "request-type"
+	// LaneAllocationSession - each operation uses a lane no other running operation with the gateway uses, as long
+	// as the maximum number of lanes allows it
+	LaneAllocationSession LaneAllocation = "session"
+)
+
+// RequestType - the type of a paid request
+type RequestType string
+
+const (
+	// RequestStandardDiscover - the request of the offer digests of standard discovery
+	RequestStandardDiscover RequestType = "standard_discover"
+	// RequestStandardOffer - the request of the offers of standard discovery
+	RequestStandardOffer RequestType = "standard_offer"
+	// RequestDHTDiscover - the request of the offer digests of DHT discovery
+	RequestDHTDiscover RequestType = "dht_discover"
+	// RequestDHTOffer - the request of the offers of DHT discovery
+	RequestDHTOffer RequestType = "dht_offer"
+)
+
+// requestTypeLanes - the lanes of the request types, for LaneAllocationRequestType
+var requestTypeLanes = map[RequestType]uint64{
+	RequestStandardDiscover: 0,
+	RequestStandardOffer:    1,
+	RequestDHTDiscover:      2,
+	RequestDHTOffer:         3,
+}
+
+// PaymentLaneStatus - the payments made on a lane of the payment channel to a gateway
+type PaymentLaneStatus struct {
+	Lane uint64
+	// RequestType is the request type the lane is for, with LaneAllocationRequestType.
+	RequestType RequestType
+	Paid        *big.Int
+	Payments    int
+	// Sessions is the number of running operations using the lane.
+	Sessions    int
+	LastPayment time.Time
+}
+
+// laneSession - the lanes an operation with a gateway pays on
+type laneSession struct {
+	c         *FilecoinRetrievalClient
+	gatewayID string
+	lanes     []uint64
+}
+
+// newLaneSession starts an operation with a gateway. The session must be closed once the operation is over.
+func (c *FilecoinRetrievalClient) newLaneSession(gatewayID string) *laneSession {
+	return &laneSession{c: c, gatewayID: gatewayID}
+}
+
+// lane returns the lane to pay a request of the session on, allocating it on first use.
+func (s *laneSession) lane(requestType RequestType) uint64 {
+	c := s.c
+	c.paymentStatusLock.Lock()
+	defer c.paymentStatusLock.Unlock()
+	status := c.gatewayPaymentStatus(s.gatewayID)
+
+	var lane uint64
+	switch c.Settings.LaneAllocation() {
+	case LaneAllocationRequestType:
+		lane = requestTypeLanes[requestType]
+	case LaneAllocationSession:
+		if len(s.lanes) > 0 {
+			return s.lanes[0]
+		}
+		lane = leastUsedLane(status, uint64(c.Settings.MaxPaymentLanes()))
+	default:
+		lane = defaultPaymentLane
+	}
+	for _, used := range s.lanes {
+		if used == lane {
+			return lane
+		}
+	}
+	laneStatus := status.paymentLane(lane)
+	if c.Settings.LaneAllocation() == LaneAllocationRequestType {
+		laneStatus.RequestType = requestType
+	}
+	laneStatus.Sessions++
+	s.lanes = append(s.lanes, lane)
+	return lane
+}
+
+// close ends the operation, releasing its lanes.
+func (s *laneSession) close() {
+	c := s.c
+	c.paymentStatusLock.Lock()
+	defer c.paymentStatusLock.Unlock()
+	status := c.gatewayPaymentStatus(s.gatewayID)
+	for _, lane := range s.lanes {
+		status.paymentLane(lane).Sessions--
+	}
+	s.lanes = nil
+}
+
+// leastUsedLane returns the lowest lane below maxLanes no session uses or, if all are used, the one used by the
+// fewest sessions.
+func leastUsedLane(status *GatewayPaymentStatus, maxLanes uint64) uint64 {
+	if maxLanes == 0 {
+		maxLanes = 1
+	}
+	best := uint64(0)
+	bestSessions := -1
+	for lane := uint64(0); lane < maxLanes; lane++ {
+		sessions := 0
+		if laneStatus, exists := status.lanes[lane]; exists {
+			sessions = laneStatus.Sessions
+		}
+		if sessions == 0 {
+			return lane
+		}
+		if bestSessions < 0 || sessions < bestSessions {
+			best, bestSessions = lane, sessions
+		}
+	}
+	return best
+}
+
+// paymentLane returns the status of a lane, creating it if needed. The payment status lock must be held.
+func (s *GatewayPaymentStatus) paymentLane(lane uint64) *PaymentLaneStatus {
+	laneStatus, exists := s.lanes[lane]
+	if !exists {
+		laneStatus = &PaymentLaneStatus{Lane: lane, Paid: big.NewInt(0)}
+		s.lanes[lane] = laneStatus
+	}
+	return laneStatus
+}
+
+// copyLanes returns a copy of the lanes of a gateway, sorted by lane.
+func (s *GatewayPaymentStatus) copyLanes() []PaymentLaneStatus {
+	res := make([]PaymentLaneStatus, 0, len(s.lanes))
+	for _, laneStatus := range s.lanes {
+		entry := *laneStatus
+		entry.Paid = new(big.Int).Set(laneStatus.Paid)
+		res = append(res, entry)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Lane < res[j].Lane })
+	return res
+}
@@ -0,0 +1,92 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"math/big"
+	"testing"
+)
+
+// newLaneTestClient creates a client allocating lanes with allocation, at most maxLanes.
+func newLaneTestClient(t *testing.T, allocation LaneAllocation, maxLanes int) *FilecoinRetrievalClient {
+	t.Helper()
+	return newTestClient(t, func(builder *SettingsBuilder) {
+		builder.SetLaneAllocation(allocation, maxLanes)
+	})
+}
+
+func TestSessionLanes(t *testing.T) {
+	c := newLaneTestClient(t, LaneAllocationSession, 2)
+	first, second, third := c.newLaneSession("gateway"), c.newLaneSession("gateway"), c.newLaneSession("gateway")
+	if first.lane(RequestStandardDiscover) != 0 || first.lane(RequestStandardOffer) != 0 {
+		t.Fatal("session not paying every request on its lane")
+	}
+	if second.lane(RequestStandardDiscover) != 1 {
+		t.Fatal("concurrent session not given a lane of its own")
+	}
+	if lane := third.lane(RequestStandardDiscover); lane != 0 {
+		t.Fatalf("expected the lanes shared once all are used, got lane %d", lane)
+	}
+	if other := c.newLaneSession("other"); other.lane(RequestStandardDiscover) != 0 {
+		t.Fatal("lanes of a gateway used for another one")
+	}
+	first.close()
+	third.close()
+	if fourth := c.newLaneSession("gateway"); fourth.lane(RequestDHTDiscover) != 0 {
+		t.Fatal("lane released by the closed sessions not reused")
+	}
+}
+
+func TestRequestTypeAndSingleLanes(t *testing.T) {
+	c := newLaneTestClient(t, LaneAllocationRequestType, 1)
+	session := c.newLaneSession("gateway")
+	for requestType, expected := range requestTypeLanes {
+		if lane := session.lane(requestType); lane != expected {
+			t.Fatalf("expected %s requests on lane %d, got %d", requestType, expected, lane)
+		}
+	}
+	session.close()
+
+	c = newLaneTestClient(t, LaneAllocationSingle, 8)
+	first, second := c.newLaneSession("gateway"), c.newLaneSession("gateway")
+	if first.lane(RequestStandardDiscover) != defaultPaymentLane || second.lane(RequestDHTOffer) != defaultPaymentLane {
+		t.Fatal("payments not all on the default lane")
+	}
+}
+
+func TestPaymentStatusByLane(t *testing.T) {
+	c := newLaneTestClient(t, LaneAllocationRequestType, 1)
+	gw := newTestNode(t).gateway(t)
+	session := c.newLaneSession(gw.GetNodeID())
+	c.paymentMade(gw, "f01234", session.lane(RequestStandardDiscover), big.NewInt(10))
+	c.paymentMade(gw, "f01234", session.lane(RequestStandardOffer), big.NewInt(3))
+	c.paymentMade(gw, "f01234", session.lane(RequestStandardOffer), big.NewInt(4))
+
+	status := c.PaymentStatus()
+	if len(status) != 1 || status[0].Paid.Int64() != 17 {
+		t.Fatalf("expected 17 paid to the gateway, got %+v", status)
+	}
+	lanes := status[0].Lanes
+	if len(lanes) != 2 {
+		t.Fatalf("expected two lanes, got %d", len(lanes))
+	}
+	if lanes[0].Paid.Int64() != 10 || lanes[0].Payments != 1 || lanes[0].RequestType != RequestStandardDiscover {
+		t.Fatalf("unexpected first lane %+v", lanes[0])
+	}
+	if lanes[1].Paid.Int64() != 7 || lanes[1].Payments != 2 || lanes[1].Sessions != 1 || lanes[1].LastPayment.IsZero() {
+		t.Fatalf("unexpected second lane %+v", lanes[1])
+	}
+}
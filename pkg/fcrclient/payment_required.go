@@ -0,0 +1,150 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi"
+)
+
+// paymentError - the payment made before sending a request to a gateway failed, so the request was not sent
+type paymentError struct {
+	err error
+}
+
+func (e *paymentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *paymentError) Unwrap() error {
+	return e.err
+}
+
+// payAndSend pays amount to a gateway on a lane and sends the request with send, given the payment channel and
+// voucher. Every attempt to send the request is made from here: while the gateway answers payment required for
+// the payment channel in use, the channel is topped up, within the payment required budget, and the request is
+// paid again and sent again with the fresh voucher, up to the payment required retries of the settings. A gateway
+// asking to top up another payment channel is never topped up: the channel in use is resolved to the ID of its
+// actor with the payment channel resolver to compare it with the channel the gateway asks to top up.
+// expected is passed on to the top up policy, as for payGatewayExpecting. Returns a *paymentError if the first
+// payment fails, otherwise the error of the last attempt, the PaymentRequiredError if the channel could not be
+// topped up.
+func (c *FilecoinRetrievalClient) payAndSend(ctx context.Context, gw register.GatewayRegistrar, lane uint64, amount *big.Int, expected *big.Int, send func(paychAddr string, voucher string) error) error {
+	paychAddr, voucher, err := c.payGatewayExpecting(ctx, gw, lane, amount, expected)
+	if err != nil {
+		return &paymentError{err}
+	}
+	for retry := 0; ; retry++ {
+		err = send(paychAddr, voucher)
+		var paymentRequired *clientapi.PaymentRequiredError
+		if !errors.As(err, &paymentRequired) {
+			return err
+		}
+		c.events.publish(PaymentRequiredEvent{now(), gw.GetNodeID(), fmt.Sprint(paymentRequired.PaymentChannel)})
+		same, resolveErr := c.samePaymentChannel(ctx, paychAddr, paymentRequired.PaymentChannel)
+		if resolveErr != nil {
+			return fmt.Errorf("gateway %s requires a top up of payment channel %d, error resolving the payment channel %s in use: %s", gw.GetNodeID(), paymentRequired.PaymentChannel, paychAddr, resolveErr.Error())
+		}
+		if !same {
+			return fmt.Errorf("gateway %s requires a top up of payment channel %d, not of the payment channel %s in use", gw.GetNodeID(), paymentRequired.PaymentChannel, paychAddr)
+		}
+		if retry >= c.Settings.PaymentRequiredRetries() {
+			return err
+		}
+		c.logger.Warn("Gateway answered payment required, topping up", "gateway_id", gw.GetNodeID(), "payment_channel", paychAddr)
+		if topupErr := c.topupOnPaymentRequired(gw, amount, expected); topupErr != nil {
+			c.logger.Error("Error topping up after payment required", "gateway_id", gw.GetNodeID(), "error", topupErr)
+			return err
+		}
+		var payErr error
+		paychAddr, voucher, payErr = c.payGatewayExpecting(ctx, gw, lane, amount, expected)
+		if payErr != nil {
+			c.logger.Error("Error paying again after topup", "gateway_id", gw.GetNodeID(), "error", payErr)
+			return err
+		}
+	}
+}
+
+// samePaymentChannel returns true if the payment channel a gateway asks to top up, given as an actor ID, is the
+// payment channel at paychAddr, resolved to the ID of its actor.
+func (c *FilecoinRetrievalClient) samePaymentChannel(ctx context.Context, paychAddr string, paymentChannel int64) (bool, error) {
+	id, err := c.paymentChannelID(ctx, paychAddr)
+	if err != nil {
+		return false, err
+	}
+	return id == paymentChannel, nil
+}
+
+// topupOnPaymentRequired tops up the payment channel to a gateway which answered payment required. The gateway
+// knows better than the client the balance of the channel, so the top up policy is asked as if the balance was
+// empty. The top up is capped by what is left of the payment required budget, and fails if that is not enough
+// for the payment.
+func (c *FilecoinRetrievalClient) topupOnPaymentRequired(gw register.GatewayRegistrar, amount *big.Int, expected *big.Int) error {
+	if expected == nil || expected.Cmp(amount) < 0 {
+		expected = amount
+	}
+	topUpAmount, err := c.Settings.TopupPolicy().TopupAmount(TopupRequest{
+		GatewayID:   gw.GetNodeID(),
+		Pending:     amount,
+		Expected:    expected,
+		Balance:     big.NewInt(0),
+		TopUpAmount: c.Settings.TopUpAmount(),
+		NeedTopup:   true,
+	})
+	if err != nil {
+		return fmt.Errorf("error to topup payment channel for gateway ID: %s; error: %s", gw.GetNodeID(), err.Error())
+	}
+	if topUpAmount.Cmp(amount) < 0 {
+		topUpAmount = new(big.Int).Set(amount)
+	}
+	left := new(big.Int).Sub(c.Settings.PaymentRequiredBudget(), c.toppedUpOnRequest(gw.GetNodeID()))
+	if left.Cmp(amount) < 0 {
+		return fmt.Errorf("payment required budget of %s for gateway ID: %s is spent", c.Settings.PaymentRequiredBudget().String(), gw.GetNodeID())
+	}
+	if topUpAmount.Cmp(left) > 0 {
+		topUpAmount = left
+	}
+	paymentMgr := c.paymentManager()
+	if paymentMgr == nil {
+		return errors.New("payment manager not available")
+	}
+	return c.useOpenChannel(gw.GetNodeID(), func() error {
+		if err := c.topupGateway(paymentMgr, gw, topUpAmount); err != nil {
+			return err
+		}
+		c.recordTopupOnRequest(gw.GetNodeID(), topUpAmount)
+		return nil
+	})
+}
+
+// dhtSubResponsesPaymentRequired returns a PaymentRequiredError if one of the sub responses of a DHT discovery
+// through the entry gateway answers payment required.
+func dhtSubResponsesPaymentRequired(entryGateway register.GatewayRegistrar, contactedResp []fcrmessages.FCRMessage) error {
+	for i := range contactedResp {
+		_, _, _, _, _, paymentRequired, paymentChannelAddrToTopup, err := fcrmessages.DecodeGatewayDHTDiscoverResponseV2(&contactedResp[i])
+		if err == nil && paymentRequired {
+			return clientapi.NewPaymentRequiredError(entryGateway.GetNodeID(), paymentChannelAddrToTopup)
+		}
+	}
+	return nil
+}
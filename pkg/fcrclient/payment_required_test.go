@@ -0,0 +1,224 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi"
+)
+
+// testPayer - a payment manager paying through a single payment channel, without any chain
+type testPayer struct {
+	channel  string
+	payErr   error
+	topups   []*big.Int
+	payments int
+}
+
+func (p *testPayer) Topup(recipient string, amount *big.Int) error {
+	p.topups = append(p.topups, amount)
+	return nil
+}
+
+func (p *testPayer) Pay(recipient string, lane uint64, amount *big.Int) (string, string, bool, error) {
+	if p.payErr != nil {
+		return "", "", false, p.payErr
+	}
+	p.payments++
+	return p.channel, fmt.Sprintf("voucher-%d", p.payments), false, nil
+}
+
+// testResolver - a payment channel resolver with fixed IDs, counting its lookups
+type testResolver struct {
+	ids     map[string]int64
+	lookups int
+}
+
+func (r *testResolver) LookupID(ctx context.Context, paymentChannel string) (int64, error) {
+	r.lookups++
+	id, exists := r.ids[paymentChannel]
+	if !exists {
+		return 0, fmt.Errorf("no actor at %s", paymentChannel)
+	}
+	return id, nil
+}
+
+// robustChannel is the robust address of a payment channel, as given by the payment manager.
+const robustChannel = "f2kx3ochgx4wpxbdxgs6qmnl5dgxzw4nnqzqw6dbi"
+
+// newPayingTestClient creates a client paying with payer, topping up at most budget on payment required, and the
+// register entry of a gateway.
+func newPayingTestClient(t *testing.T, payer *testPayer, retries int, budget int64) (*FilecoinRetrievalClient, register.GatewayRegistrar) {
+	t.Helper()
+	return newResolvingTestClient(t, payer, nil, retries, budget)
+}
+
+// newResolvingTestClient is newPayingTestClient resolving payment channels with resolver.
+func newResolvingTestClient(t *testing.T, payer *testPayer, resolver PaymentChannelResolver, retries int, budget int64) (*FilecoinRetrievalClient, register.GatewayRegistrar) {
+	t.Helper()
+	c := newTestClient(t, func(builder *SettingsBuilder) {
+		builder.SetPaymentRequiredRetry(retries, big.NewInt(budget))
+		if resolver != nil {
+			builder.SetPaymentChannelResolver(resolver)
+		}
+	})
+	c.payer = payer
+	return c, newTestNode(t).gateway(t)
+}
+
+// paymentRequiredSender returns a send function answering payment required for paymentChannel the first
+// paymentsRequired times, and the vouchers it was sent with.
+func paymentRequiredSender(gw register.GatewayRegistrar, paymentChannel int64, paymentsRequired int) (func(string, string) error, *[]string) {
+	vouchers := make([]string, 0)
+	return func(paychAddr string, voucher string) error {
+		vouchers = append(vouchers, voucher)
+		if len(vouchers) <= paymentsRequired {
+			return clientapi.NewPaymentRequiredError(gw.GetNodeID(), paymentChannel)
+		}
+		return nil
+	}, &vouchers
+}
+
+func TestPayAndSendTopsUpAndRetries(t *testing.T) {
+	payer := &testPayer{channel: "f01234"}
+	c, gw := newPayingTestClient(t, payer, 3, 1000)
+	send, vouchers := paymentRequiredSender(gw, 1234, 1)
+	if err := c.payAndSend(context.Background(), gw, 0, big.NewInt(10), nil, send); err != nil {
+		t.Fatal(err)
+	}
+	if len(*vouchers) != 2 || (*vouchers)[0] == (*vouchers)[1] {
+		t.Fatalf("expected the request sent again with a fresh voucher, sent with %v", *vouchers)
+	}
+	if c.toppedUpOnRequest(gw.GetNodeID()).Sign() <= 0 {
+		t.Fatal("payment channel not topped up after payment required")
+	}
+}
+
+func TestPayAndSendTopsUpThroughRobustAddress(t *testing.T) {
+	payer := &testPayer{channel: robustChannel}
+	resolver := &testResolver{ids: map[string]int64{robustChannel: 1234}}
+	c, gw := newResolvingTestClient(t, payer, resolver, 3, 1_000_000_000_000_000_000)
+	send, vouchers := paymentRequiredSender(gw, 1234, 2)
+	if err := c.payAndSend(context.Background(), gw, 0, big.NewInt(10), nil, send); err != nil {
+		t.Fatal(err)
+	}
+	if len(*vouchers) != 3 || len(payer.topups) != 2 {
+		t.Fatalf("expected 2 top ups and retries, got %d top ups and %d attempts", len(payer.topups), len(*vouchers))
+	}
+	if resolver.lookups != 1 {
+		t.Fatalf("expected the robust address resolved once, resolved %d times", resolver.lookups)
+	}
+}
+
+func TestPayAndSendRefusesUnresolvedPaymentChannel(t *testing.T) {
+	payer := &testPayer{channel: robustChannel}
+	c, gw := newResolvingTestClient(t, payer, &testResolver{}, 3, 1000)
+	send, vouchers := paymentRequiredSender(gw, 1234, 1)
+	if err := c.payAndSend(context.Background(), gw, 0, big.NewInt(10), nil, send); err == nil {
+		t.Fatal("payment required accepted for a payment channel which could not be resolved")
+	}
+	if len(*vouchers) != 1 || len(payer.topups) != 0 {
+		t.Fatal("payment channel topped up without being resolved")
+	}
+}
+
+func TestPayAndSendStopsAfterRetries(t *testing.T) {
+	payer := &testPayer{channel: "t01234"}
+	c, gw := newPayingTestClient(t, payer, 2, 1_000_000_000_000_000_000)
+	send, vouchers := paymentRequiredSender(gw, 1234, 10)
+	err := c.payAndSend(context.Background(), gw, 0, big.NewInt(10), nil, send)
+	var paymentRequired *clientapi.PaymentRequiredError
+	if !errors.As(err, &paymentRequired) {
+		t.Fatalf("expected payment required, got %v", err)
+	}
+	if len(*vouchers) != 3 {
+		t.Fatalf("expected the first attempt and 2 retries, got %d attempts", len(*vouchers))
+	}
+}
+
+func TestPayAndSendStopsWhenBudgetSpent(t *testing.T) {
+	payer := &testPayer{channel: "f01234"}
+	c, gw := newPayingTestClient(t, payer, 10, 15)
+	send, vouchers := paymentRequiredSender(gw, 1234, 10)
+	err := c.payAndSend(context.Background(), gw, 0, big.NewInt(10), nil, send)
+	var paymentRequired *clientapi.PaymentRequiredError
+	if !errors.As(err, &paymentRequired) {
+		t.Fatalf("expected payment required, got %v", err)
+	}
+	if spent := c.toppedUpOnRequest(gw.GetNodeID()); spent.Cmp(big.NewInt(15)) > 0 {
+		t.Fatalf("topped up %s on payment required, over the budget of 15", spent.String())
+	}
+	if len(*vouchers) != 2 {
+		t.Fatalf("expected a single retry within the budget, got %d attempts", len(*vouchers))
+	}
+}
+
+func TestPayAndSendRefusesAnotherPaymentChannel(t *testing.T) {
+	for _, channel := range []string{"f01234", robustChannel} {
+		payer := &testPayer{channel: channel}
+		c, gw := newResolvingTestClient(t, payer, &testResolver{ids: map[string]int64{robustChannel: 1234}}, 3, 1000)
+		topups := len(payer.topups)
+		send, vouchers := paymentRequiredSender(gw, 99, 1)
+		err := c.payAndSend(context.Background(), gw, 0, big.NewInt(10), nil, send)
+		if err == nil {
+			t.Fatalf("payment required for another channel than %s accepted", channel)
+		}
+		if len(*vouchers) != 1 || len(payer.topups) != topups || c.toppedUpOnRequest(gw.GetNodeID()).Sign() != 0 {
+			t.Fatalf("payment channel %s topped up for a payment required of another channel", channel)
+		}
+	}
+}
+
+func TestPayAndSendNeverSendsUnpaid(t *testing.T) {
+	payer := &testPayer{channel: "f01234", payErr: errors.New("no funds")}
+	c, gw := newPayingTestClient(t, payer, 3, 1000)
+	send, vouchers := paymentRequiredSender(gw, 1234, 0)
+	err := c.payAndSend(context.Background(), gw, 0, big.NewInt(10), nil, send)
+	var paymentErr *paymentError
+	if !errors.As(err, &paymentErr) {
+		t.Fatalf("expected a payment error, got %v", err)
+	}
+	if len(*vouchers) != 0 {
+		t.Fatal("request sent without payment")
+	}
+}
+
+func TestActorID(t *testing.T) {
+	tests := []struct {
+		addr string
+		id   int64
+		ok   bool
+	}{
+		{"f01234", 1234, true},
+		{"t01234", 1234, true},
+		{robustChannel, 0, false},
+		{"f0", 0, false},
+		{"", 0, false},
+		{"f0-1", 0, false},
+	}
+	for _, test := range tests {
+		if id, ok := actorID(test.addr); ok != test.ok || (ok && id != test.id) {
+			t.Errorf("actorID(%q) = %d, %v, expected %d, %v", test.addr, id, ok, test.id, test.ok)
+		}
+	}
+}
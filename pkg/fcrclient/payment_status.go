@@ -0,0 +1,130 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"math/big"
+	"sort"
+	"time"
+)
+
+// GatewayPaymentStatus - the payments this client made to a gateway
+type GatewayPaymentStatus struct {
+	GatewayID      string
+	PaymentChannel string
+	Paid           *big.Int
+	ToppedUp       *big.Int
+	// ToppedUpOnRequest is the part of ToppedUp topped up after the gateway answered that payment was required.
+	ToppedUpOnRequest *big.Int
+	Payments          int
+	TopUps            int
+	ChannelState      PaymentChannelState
+	SettledAt         time.Time
+	// Lanes are the lanes of the payment channel used so far.
+	Lanes []PaymentLaneStatus
+
+	lanes map[uint64]*PaymentLaneStatus
+}
+
+// PaymentStatus returns the payments made to each gateway since the client was created, sorted by gateway ID.
+func (c *FilecoinRetrievalClient) PaymentStatus() []GatewayPaymentStatus {
+	c.paymentStatusLock.RLock()
+	defer c.paymentStatusLock.RUnlock()
+
+	res := make([]GatewayPaymentStatus, 0, len(c.paymentStatus))
+	for _, status := range c.paymentStatus {
+		entry := *status
+		entry.Paid = new(big.Int).Set(status.Paid)
+		entry.ToppedUp = new(big.Int).Set(status.ToppedUp)
+		entry.ToppedUpOnRequest = new(big.Int).Set(status.ToppedUpOnRequest)
+		entry.Lanes = status.copyLanes()
+		entry.lanes = nil
+		res = append(res, entry)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].GatewayID < res[j].GatewayID })
+	return res
+}
+
+// recordPayment records a payment made to a gateway through the given payment channel and lane.
+func (c *FilecoinRetrievalClient) recordPayment(gatewayID string, paymentChannel string, lane uint64, amount *big.Int) {
+	c.paymentStatusLock.Lock()
+	defer c.paymentStatusLock.Unlock()
+	status := c.gatewayPaymentStatus(gatewayID)
+	status.PaymentChannel = paymentChannel
+	status.Paid.Add(status.Paid, amount)
+	status.Payments++
+	laneStatus := status.paymentLane(lane)
+	laneStatus.Paid.Add(laneStatus.Paid, amount)
+	laneStatus.Payments++
+	laneStatus.LastPayment = time.Now()
+}
+
+// recordTopup records a top up of the payment channel to a gateway.
+func (c *FilecoinRetrievalClient) recordTopup(gatewayID string, amount *big.Int) {
+	c.paymentStatusLock.Lock()
+	defer c.paymentStatusLock.Unlock()
+	status := c.gatewayPaymentStatus(gatewayID)
+	status.ToppedUp.Add(status.ToppedUp, amount)
+	status.TopUps++
+}
+
+// recordTopupOnRequest records a top up of the payment channel to a gateway answering that payment was required.
+func (c *FilecoinRetrievalClient) recordTopupOnRequest(gatewayID string, amount *big.Int) {
+	c.paymentStatusLock.Lock()
+	defer c.paymentStatusLock.Unlock()
+	status := c.gatewayPaymentStatus(gatewayID)
+	status.ToppedUpOnRequest.Add(status.ToppedUpOnRequest, amount)
+}
+
+// toppedUpOnRequest returns the amount topped up after a gateway answered that payment was required.
+func (c *FilecoinRetrievalClient) toppedUpOnRequest(gatewayID string) *big.Int {
+	c.paymentStatusLock.RLock()
+	defer c.paymentStatusLock.RUnlock()
+	status, exists := c.paymentStatus[gatewayID]
+	if !exists {
+		return big.NewInt(0)
+	}
+	return new(big.Int).Set(status.ToppedUpOnRequest)
+}
+
+// channelBalance returns the amount topped up minus the amount paid to a gateway.
+func (c *FilecoinRetrievalClient) channelBalance(gatewayID string) *big.Int {
+	c.paymentStatusLock.RLock()
+	defer c.paymentStatusLock.RUnlock()
+	status, exists := c.paymentStatus[gatewayID]
+	if !exists {
+		return big.NewInt(0)
+	}
+	return new(big.Int).Sub(status.ToppedUp, status.Paid)
+}
+
+// gatewayPaymentStatus returns the payment status of a gateway, creating it if needed.
+// The payment status lock must be held.
+func (c *FilecoinRetrievalClient) gatewayPaymentStatus(gatewayID string) *GatewayPaymentStatus {
+	status, exists := c.paymentStatus[gatewayID]
+	if !exists {
+		status = &GatewayPaymentStatus{
+			GatewayID:         gatewayID,
+			Paid:              big.NewInt(0),
+			ToppedUp:          big.NewInt(0),
+			ToppedUpOnRequest: big.NewInt(0),
+			ChannelState:      PaymentChannelOpen,
+			lanes:             make(map[uint64]*PaymentLaneStatus),
+		}
+		c.paymentStatus[gatewayID] = status
+	}
+	return status
+}
@@ -0,0 +1,192 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+)
+
+// GatewayPrices - the prices a gateway charges
+type GatewayPrices struct {
+	// SearchPrice is the price of a search. DHT discovery pays it for each gateway contacted.
+	SearchPrice *big.Int
+	// OfferPrice is the price of each offer requested.
+	OfferPrice *big.Int
+}
+
+// PriceSource returns the prices advertised by gateways. The client protocol has no message carrying prices,
+// so they come from outside the protocol, for instance the price lists published by the gateway operators,
+// or the gateways themselves with a GatewayPriceSource.
+type PriceSource interface {
+	// GatewayPrices returns the prices of a gateway, nil if the source does not know them.
+	GatewayPrices(ctx context.Context, gatewayID string) (*GatewayPrices, error)
+}
+
+// StaticPriceSource - a PriceSource with a fixed price list
+type StaticPriceSource struct {
+	prices map[string]GatewayPrices
+	lock   sync.RWMutex
+}
+
+// NewStaticPriceSource creates a price source knowing no price.
+func NewStaticPriceSource() *StaticPriceSource {
+	return &StaticPriceSource{prices: make(map[string]GatewayPrices)}
+}
+
+// Set sets the prices of a gateway.
+func (s *StaticPriceSource) Set(gatewayID *nodeid.NodeID, prices GatewayPrices) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.prices[gatewayID.ToString()] = prices
+}
+
+// GatewayPrices implements PriceSource
+func (s *StaticPriceSource) GatewayPrices(ctx context.Context, gatewayID string) (*GatewayPrices, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	prices, exists := s.prices[strings.ToLower(gatewayID)]
+	if !exists {
+		return nil, nil
+	}
+	return &prices, nil
+}
+
+// PriceTooHighError - the price of a gateway is above the maximum of the settings
+type PriceTooHighError struct {
+	GatewayID string
+	// Kind is "search" or "offer".
+	Kind    string
+	Price   *big.Int
+	Maximum *big.Int
+}
+
+// Error implements error
+func (e *PriceTooHighError) Error() string {
+	return fmt.Sprintf("%s price %s of gateway %s is above the maximum of %s", e.Kind, e.Price.String(), e.GatewayID, e.Maximum.String())
+}
+
+// priceCache - the prices of the gateways, as returned by the price source, for the price cache TTL
+type priceCache struct {
+	entries map[string]cachedPrices
+	lock    sync.Mutex
+}
+
+// cachedPrices - the prices of a gateway and their expiry
+type cachedPrices struct {
+	prices  GatewayPrices
+	expires time.Time
+}
+
+// newPriceCache creates an empty price cache.
+func newPriceCache() *priceCache {
+	return &priceCache{entries: make(map[string]cachedPrices)}
+}
+
+// get returns the prices of a gateway, if cached and not expired.
+func (p *priceCache) get(gatewayID string) (GatewayPrices, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	entry, exists := p.entries[gatewayID]
+	if !exists || time.Now().After(entry.expires) {
+		delete(p.entries, gatewayID)
+		return GatewayPrices{}, false
+	}
+	return entry.prices, true
+}
+
+// set caches the prices of a gateway for the given time.
+func (p *priceCache) set(gatewayID string, prices GatewayPrices, ttl time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.entries[gatewayID] = cachedPrices{prices: prices, expires: time.Now().Add(ttl)}
+}
+
+// remove removes the prices of a gateway.
+func (p *priceCache) remove(gatewayID string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.entries, gatewayID)
+}
+
+// GatewayPrices returns the prices the client pays a gateway, checked against the maximum prices of the settings.
+func (c *FilecoinRetrievalClient) GatewayPrices(gatewayID *nodeid.NodeID) (*GatewayPrices, error) {
+	prices, err := c.gatewayPrices(context.Background(), gatewayID.ToString())
+	if err != nil {
+		return nil, err
+	}
+	return &prices, nil
+}
+
+// SetGatewayPrices records the prices of a gateway learnt by the application, until the price cache TTL
+// expires and the price source is asked again.
+func (c *FilecoinRetrievalClient) SetGatewayPrices(gatewayID *nodeid.NodeID, prices GatewayPrices) error {
+	if prices.SearchPrice == nil || prices.OfferPrice == nil || prices.SearchPrice.Sign() < 0 || prices.OfferPrice.Sign() < 0 {
+		return errors.New("search and offer prices must be set and not negative")
+	}
+	c.prices.set(gatewayID.ToString(), copyPrices(prices), c.Settings.PriceCacheTTL())
+	return nil
+}
+
+// ForgetGatewayPrices removes the cached prices of a gateway, so that the price source is asked again.
+func (c *FilecoinRetrievalClient) ForgetGatewayPrices(gatewayID *nodeid.NodeID) {
+	c.prices.remove(gatewayID.ToString())
+}
+
+// gatewayPrices returns the prices of a gateway: cached, from the price source or, if the source does not
+// know them, the prices of the settings. Returns a PriceTooHighError if a price is above the maximum.
+func (c *FilecoinRetrievalClient) gatewayPrices(ctx context.Context, gatewayID string) (GatewayPrices, error) {
+	gatewayID = strings.ToLower(gatewayID)
+	prices, cached := c.prices.get(gatewayID)
+	if !cached {
+		prices = GatewayPrices{SearchPrice: c.Settings.SearchPrice(), OfferPrice: c.Settings.OfferPrice()}
+		if source := c.Settings.PriceSource(); source != nil {
+			advertised, err := source.GatewayPrices(ctx, gatewayID)
+			if err != nil {
+				return GatewayPrices{}, fmt.Errorf("error getting the prices of gateway %s: %s", gatewayID, err.Error())
+			}
+			if advertised != nil {
+				if advertised.SearchPrice != nil {
+					prices.SearchPrice = advertised.SearchPrice
+				}
+				if advertised.OfferPrice != nil {
+					prices.OfferPrice = advertised.OfferPrice
+				}
+			}
+		}
+		prices = copyPrices(prices)
+		c.prices.set(gatewayID, prices, c.Settings.PriceCacheTTL())
+	}
+	if maximum := c.Settings.MaxSearchPrice(); maximum != nil && prices.SearchPrice.Cmp(maximum) > 0 {
+		return GatewayPrices{}, &PriceTooHighError{GatewayID: gatewayID, Kind: "search", Price: prices.SearchPrice, Maximum: maximum}
+	}
+	if maximum := c.Settings.MaxOfferPrice(); maximum != nil && prices.OfferPrice.Cmp(maximum) > 0 {
+		return GatewayPrices{}, &PriceTooHighError{GatewayID: gatewayID, Kind: "offer", Price: prices.OfferPrice, Maximum: maximum}
+	}
+	return copyPrices(prices), nil
+}
+
+// copyPrices returns a copy of prices sharing no big.Int with it.
+func copyPrices(prices GatewayPrices) GatewayPrices {
+	return GatewayPrices{SearchPrice: new(big.Int).Set(prices.SearchPrice), OfferPrice: new(big.Int).Set(prices.OfferPrice)}
+}
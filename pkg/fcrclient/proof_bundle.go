@@ -0,0 +1,245 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrcrypto"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+)
+
+// ProofBundleVersion is the version of the proof bundle format written by this library.
+const ProofBundleVersion = 1
+
+// ProofBundle - self-contained evidence of offers and DHT offer acks, which can be verified offline with VerifyProofBundle.
+// Each proof carries a snapshot of the register entries whose keys signed it.
+// Bundles are JSON only: the offers and messages are signed over their JSON encoding, which a bundle must keep as is.
+type ProofBundle struct {
+	Version   int          `json:"version"`
+	CreatedAt time.Time    `json:"created_at"`
+	Offers    []OfferProof `json:"offers"`
+	Acks      []AckProof   `json:"acks"`
+}
+
+// OfferProof - a Sub-CID offer, with its merkle proof, and the register entry of its provider.
+// GatewayID is the gateway the offer was received through, if known.
+type OfferProof struct {
+	GatewayID string                    `json:"gateway_id,omitempty"`
+	Offer     cidoffer.SubCIDOffer      `json:"offer"`
+	Provider  register.ProviderRegister `json:"provider"`
+}
+
+// AckProof - a DHT offer publish request signed by a provider, the ack of the gateway, and the register entries
+// of the provider and the gateway.
+type AckProof struct {
+	ContentID   string                    `json:"cid"`
+	Nonce       int64                     `json:"nonce"`
+	RequestHash string                    `json:"request_hash"`
+	Request     *fcrmessages.FCRMessage   `json:"request"`
+	Ack         *fcrmessages.FCRMessage   `json:"ack"`
+	Provider    register.ProviderRegister `json:"provider"`
+	Gateway     register.GatewayRegister  `json:"gateway"`
+}
+
+// NewProofBundle creates an empty proof bundle.
+func NewProofBundle() *ProofBundle {
+	return &ProofBundle{
+		Version:   ProofBundleVersion,
+		CreatedAt: time.Now().UTC(),
+		Offers:    make([]OfferProof, 0),
+		Acks:      make([]AckProof, 0),
+	}
+}
+
+// ParseProofBundle decodes a proof bundle from JSON.
+func ParseProofBundle(data []byte) (*ProofBundle, error) {
+	bundle := ProofBundle{}
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("invalid proof bundle: %s", err.Error())
+	}
+	if bundle.Version != ProofBundleVersion {
+		return nil, fmt.Errorf("unsupported proof bundle version: %d", bundle.Version)
+	}
+	return &bundle, nil
+}
+
+// Marshal encodes the proof bundle as JSON.
+func (b *ProofBundle) Marshal() ([]byte, error) {
+	return json.MarshalIndent(b, "", "  ")
+}
+
+// AddOffers adds the proofs of offers received through a gateway, taking the provider entries from the register.
+// gatewayID may be nil.
+func (c *FilecoinRetrievalClient) AddOffers(bundle *ProofBundle, gatewayID *nodeid.NodeID, offers []cidoffer.SubCIDOffer) error {
+	for _, offer := range offers {
+		provider := c.registerMgr.GetProvider(offer.GetProviderID())
+		if provider == nil {
+			return fmt.Errorf("provider %s not found inside register", offer.GetProviderID().ToString())
+		}
+		proof := OfferProof{Offer: offer, Provider: provider.Serialize()}
+		if gatewayID != nil {
+			proof.GatewayID = gatewayID.ToString()
+		}
+		bundle.Offers = append(bundle.Offers, proof)
+	}
+	return nil
+}
+
+// AddAck adds the proof of a DHT offer ack, taking the provider and gateway entries from the register.
+func (c *FilecoinRetrievalClient) AddAck(bundle *ProofBundle, ack *DHTOfferAckProof) error {
+	provider := c.registerMgr.GetProvider(ack.ProviderID)
+	if provider == nil {
+		return fmt.Errorf("provider %s not found inside register", ack.ProviderID.ToString())
+	}
+	gateway := c.registerMgr.GetGateway(ack.GatewayID)
+	if gateway == nil {
+		return fmt.Errorf("gateway %s not found inside register", ack.GatewayID.ToString())
+	}
+	bundle.Acks = append(bundle.Acks, AckProof{
+		ContentID:   ack.ContentID.ToString(),
+		Nonce:       ack.Nonce,
+		RequestHash: hex.EncodeToString(ack.RequestHash),
+		Request:     ack.Request,
+		Ack:         ack.Ack,
+		Provider:    provider.Serialize(),
+		Gateway:     gateway.Serialize(),
+	})
+	return nil
+}
+
+// VerifyProofBundle verifies every proof of a bundle using only the content of the bundle: offer signatures and
+// merkle proofs against the provider entries, and acks as VerifyDHTOfferAck does against the provider and gateway
+// entries. The register entries must be trusted by anchor, which is required: without it anyone can forge a bundle
+// with their own keys. The signing keys of the entries, which the proofs are verified with, must be certified by
+// the Certifier of anchor, even for pinned nodes, so that a bundle keeping the root key of a node is not enough.
+// Returns the error of the first proof failing verification.
+func VerifyProofBundle(bundle *ProofBundle, anchor *TrustAnchor) error {
+	if anchor == nil {
+		return errors.New("missing trust anchor: the register entries of the bundle can not be trusted")
+	}
+	return verifyProofBundle(bundle, anchor)
+}
+
+// VerifyProofBundleConsistency verifies every proof of a bundle against the keys of the register entries inside
+// the bundle, without any trust anchor. A consistent bundle is not a valid one: its entries may have been forged
+// along with the proofs, so the result only shows the bundle is self-consistent, and untrusted.
+func VerifyProofBundleConsistency(bundle *ProofBundle) error {
+	return verifyProofBundle(bundle, nil)
+}
+
+// verifyProofBundle verifies every proof of a bundle, against the trust anchor if there is one.
+func verifyProofBundle(bundle *ProofBundle, anchor *TrustAnchor) error {
+	if bundle == nil {
+		return errors.New("missing proof bundle")
+	}
+	if bundle.Version != ProofBundleVersion {
+		return fmt.Errorf("unsupported proof bundle version: %d", bundle.Version)
+	}
+	for i := range bundle.Offers {
+		if err := verifyOfferProof(&bundle.Offers[i], anchor); err != nil {
+			return fmt.Errorf("offer %d: %s", i, err.Error())
+		}
+	}
+	for i := range bundle.Acks {
+		if err := verifyAckProof(&bundle.Acks[i], anchor); err != nil {
+			return fmt.Errorf("ack %d: %s", i, err.Error())
+		}
+	}
+	return nil
+}
+
+// verifyOfferProof verifies the proof of an offer.
+func verifyOfferProof(proof *OfferProof, anchor *TrustAnchor) error {
+	if !strings.EqualFold(proof.Provider.NodeID, proof.Offer.GetProviderID().ToString()) {
+		return errors.New("provider entry is not the entry of the offer provider")
+	}
+	signingKey, err := snapshotKeys(proof.Provider.NodeID, proof.Provider.RootSigningKey, proof.Provider.SigningKey, anchor)
+	if err != nil {
+		return fmt.Errorf("provider entry: %s", err.Error())
+	}
+	if err := proof.Offer.Verify(signingKey); err != nil {
+		return fmt.Errorf("offer signature fail to verify, with error: %s", err.Error())
+	}
+	if err := proof.Offer.VerifyMerkleProof(); err != nil {
+		return fmt.Errorf("merkle proof verification failed: %s", err.Error())
+	}
+	return nil
+}
+
+// verifyAckProof verifies the proof of a DHT offer ack.
+func verifyAckProof(proof *AckProof, anchor *TrustAnchor) error {
+	contentID, err := cid.NewContentIDFromHexString(proof.ContentID)
+	if err != nil {
+		return fmt.Errorf("invalid cid: %s", err.Error())
+	}
+	providerID, err := nodeid.NewNodeIDFromHexString(proof.Provider.NodeID)
+	if err != nil {
+		return fmt.Errorf("invalid provider id: %s", err.Error())
+	}
+	gatewayID, err := nodeid.NewNodeIDFromHexString(proof.Gateway.NodeID)
+	if err != nil {
+		return fmt.Errorf("invalid gateway id: %s", err.Error())
+	}
+	providerKey, err := snapshotKeys(proof.Provider.NodeID, proof.Provider.RootSigningKey, proof.Provider.SigningKey, anchor)
+	if err != nil {
+		return fmt.Errorf("provider entry: %s", err.Error())
+	}
+	gatewayKey, err := snapshotKeys(proof.Gateway.NodeID, proof.Gateway.RootSigningKey, proof.Gateway.SigningKey, anchor)
+	if err != nil {
+		return fmt.Errorf("gateway entry: %s", err.Error())
+	}
+	verified, err := VerifyDHTOfferAck(contentID, gatewayID, providerID, proof.Request, proof.Ack, providerKey, gatewayKey)
+	if err != nil {
+		return err
+	}
+	if verified.Nonce != proof.Nonce {
+		return errors.New("nonce does not match the request")
+	}
+	if hex.EncodeToString(verified.RequestHash) != strings.ToLower(proof.RequestHash) {
+		return errors.New("request hash does not match the request")
+	}
+	return nil
+}
+
+// snapshotKeys decodes the keys of a register entry snapshot, verifies them against the trust anchor if there is
+// one, and returns the signing key.
+func snapshotKeys(nodeID string, encodedRootKey string, encodedSigningKey string, anchor *TrustAnchor) (*fcrcrypto.KeyPair, error) {
+	signingKey, err := fcrcrypto.DecodePublicKey(encodedSigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %s", err.Error())
+	}
+	if anchor == nil {
+		return signingKey, nil
+	}
+	rootKey, err := fcrcrypto.DecodePublicKey(encodedRootKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid root signing key: %s", err.Error())
+	}
+	if err := anchor.Verify(nodeID, rootKey, signingKey); err != nil {
+		return nil, fmt.Errorf("not trusted: %s", err.Error())
+	}
+	return signingKey, nil
+}
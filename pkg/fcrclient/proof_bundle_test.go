@@ -0,0 +1,154 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrcrypto"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+)
+
+// newTestOfferProof returns the proof of an offer of a provider, signed with signingKey, with the provider entry
+// giving rootKey and signingKey.
+func newTestOfferProof(t *testing.T, providerID *nodeid.NodeID, rootKey *fcrcrypto.KeyPair, signingKey *fcrcrypto.KeyPair) OfferProof {
+	t.Helper()
+	return OfferProof{
+		Offer: newTestOffer(t, providerID, signingKey, cid.NewRandomContentID(), 10),
+		Provider: register.ProviderRegister{
+			NodeID:         providerID.ToString(),
+			RootSigningKey: encodedPublicKey(t, rootKey),
+			SigningKey:     encodedPublicKey(t, signingKey),
+		},
+	}
+}
+
+// roundTrip encodes and decodes a proof bundle, as an auditor receiving it would.
+func roundTrip(t *testing.T, bundle *ProofBundle) *ProofBundle {
+	t.Helper()
+	data, err := bundle.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := ParseProofBundle(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func TestVerifyProofBundleRequiresAnchor(t *testing.T) {
+	rootKey, signingKey := newTestKey(t), newTestKey(t)
+	providerID := nodeid.NewRandomNodeID()
+	bundle := NewProofBundle()
+	bundle.Offers = append(bundle.Offers, newTestOfferProof(t, providerID, rootKey, signingKey))
+	bundle = roundTrip(t, bundle)
+
+	if err := VerifyProofBundle(bundle, nil); err == nil {
+		t.Fatal("bundle verified without a trust anchor")
+	}
+	if err := VerifyProofBundleConsistency(bundle); err != nil {
+		t.Fatalf("consistent bundle reported inconsistent: %s", err.Error())
+	}
+	if err := VerifyProofBundle(bundle, NewTrustAnchor()); err == nil {
+		t.Fatal("bundle verified by an empty trust anchor")
+	}
+
+	anchor := NewTrustAnchor()
+	if err := anchor.PinRootKey(providerID, encodedPublicKey(t, rootKey)); err != nil {
+		t.Fatal(err)
+	}
+	anchor.Certifier = SigningKeyCertificates{providerID.ToString(): certify(t, rootKey, signingKey)}
+	if err := VerifyProofBundle(bundle, anchor); err != nil {
+		t.Fatalf("trusted bundle not verified: %s", err.Error())
+	}
+}
+
+func TestVerifyProofBundleRejectsForgedEntries(t *testing.T) {
+	rootKey := newTestKey(t)
+	providerID := nodeid.NewRandomNodeID()
+	anchor := NewTrustAnchor()
+	if err := anchor.PinRootKey(providerID, encodedPublicKey(t, rootKey)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A forger signs with their own keys and puts them in the provider entry: the bundle is consistent, not valid
+	forgedRoot, forgedSigning := newTestKey(t), newTestKey(t)
+	bundle := NewProofBundle()
+	bundle.Offers = append(bundle.Offers, newTestOfferProof(t, providerID, forgedRoot, forgedSigning))
+	bundle = roundTrip(t, bundle)
+	if err := VerifyProofBundleConsistency(bundle); err != nil {
+		t.Fatalf("forged bundle reported inconsistent: %s", err.Error())
+	}
+	if err := VerifyProofBundle(bundle, anchor); err == nil {
+		t.Fatal("bundle with forged register entries verified")
+	}
+}
+
+func TestVerifyProofBundleRejectsForgedSigningKeyOfPinnedNode(t *testing.T) {
+	rootKey, signingKey := newTestKey(t), newTestKey(t)
+	providerID := nodeid.NewRandomNodeID()
+	anchor := NewTrustAnchor()
+	if err := anchor.PinRootKey(providerID, encodedPublicKey(t, rootKey)); err != nil {
+		t.Fatal(err)
+	}
+	anchor.Certifier = SigningKeyCertificates{providerID.ToString(): certify(t, rootKey, signingKey)}
+
+	// A forger keeps the pinned root key of the provider and signs the offer with a key of their own
+	forgedSigning := newTestKey(t)
+	bundle := NewProofBundle()
+	bundle.Offers = append(bundle.Offers, newTestOfferProof(t, providerID, rootKey, forgedSigning))
+	bundle = roundTrip(t, bundle)
+	if err := VerifyProofBundleConsistency(bundle); err != nil {
+		t.Fatalf("forged bundle reported inconsistent: %s", err.Error())
+	}
+	if err := VerifyProofBundle(bundle, anchor); err == nil {
+		t.Fatal("bundle with a forged signing key of a pinned node verified")
+	}
+	anchor.Certifier = nil
+	if err := VerifyProofBundle(bundle, anchor); err == nil {
+		t.Fatal("bundle of a pinned node verified without a certifier")
+	}
+}
+
+func TestVerifyProofBundleRejectsTamperedOffers(t *testing.T) {
+	rootKey, signingKey := newTestKey(t), newTestKey(t)
+	providerID := nodeid.NewRandomNodeID()
+	anchor := NewTrustAnchor()
+	if err := anchor.PinRootKey(providerID, encodedPublicKey(t, rootKey)); err != nil {
+		t.Fatal(err)
+	}
+	anchor.Certifier = SigningKeyCertificates{providerID.ToString(): certify(t, rootKey, signingKey)}
+	bundle := NewProofBundle()
+	bundle.Offers = append(bundle.Offers, newTestOfferProof(t, providerID, rootKey, signingKey))
+	data, err := bundle.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered, err := ParseProofBundle([]byte(strings.Replace(string(data), `"price": 10`, `"price": 1`, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyProofBundleConsistency(tampered); err == nil {
+		t.Fatal("tampered offer reported consistent")
+	}
+	if err := VerifyProofBundle(tampered, anchor); err == nil {
+		t.Fatal("tampered offer verified")
+	}
+}
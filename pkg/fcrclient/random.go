@@ -0,0 +1,51 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// randomSource - the source of the request nonces and establishment challenges of the client
+type randomSource struct {
+	rand *rand.Rand
+	lock sync.Mutex
+}
+
+// newRandomSource creates a random source seeded with the nonce seed of the settings or, if not set, the time.
+func newRandomSource(settings ClientSettings) *randomSource {
+	seed, seeded := settings.NonceSeed()
+	if !seeded {
+		seed = time.Now().UnixNano()
+	}
+	return &randomSource{rand: rand.New(rand.NewSource(seed))}
+}
+
+// nonce returns a request nonce.
+func (r *randomSource) nonce() int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.rand.Int63()
+}
+
+// read fills b with random bytes.
+func (r *randomSource) read(b []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.rand.Read(b)
+}
@@ -0,0 +1,225 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrmessages"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi"
+)
+
+// RateLimit - the limits of the requests sent to a node. Zero values mean no limit.
+type RateLimit struct {
+	// RequestsPerSecond is the rate requests are sent at, as a token bucket refilled at this rate.
+	RequestsPerSecond float64
+	// Burst is the size of the token bucket, the number of requests which can be sent at once. 1 if not set.
+	Burst int
+	// MaxInFlight is the maximum number of requests waiting for a response.
+	MaxInFlight int
+}
+
+// enabled returns true if the rate limit limits anything.
+func (l RateLimit) enabled() bool {
+	return l.RequestsPerSecond > 0 || l.MaxInFlight > 0
+}
+
+// rateLimiter - the token buckets and in-flight requests of each node
+type rateLimiter struct {
+	limits func(nodeID string) RateLimit
+	nodes  map[string]*nodeLimiter
+	lock   sync.Mutex
+}
+
+// nodeLimiter - the token bucket and in-flight requests of a node
+type nodeLimiter struct {
+	limit    RateLimit
+	tokens   float64
+	last     time.Time
+	inFlight chan bool
+	lock     sync.Mutex
+}
+
+// newRateLimiter creates a rate limiter taking the limit of each node from limits.
+func newRateLimiter(limits func(nodeID string) RateLimit) *rateLimiter {
+	return &rateLimiter{limits: limits, nodes: make(map[string]*nodeLimiter)}
+}
+
+// node returns the limiter of a node, creating it on first use.
+func (r *rateLimiter) node(nodeID string) *nodeLimiter {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	n, exists := r.nodes[nodeID]
+	if !exists {
+		limit := r.limits(nodeID)
+		if limit.Burst <= 0 {
+			limit.Burst = 1
+		}
+		n = &nodeLimiter{limit: limit, tokens: float64(limit.Burst), last: time.Now()}
+		if limit.MaxInFlight > 0 {
+			n.inFlight = make(chan bool, limit.MaxInFlight)
+		}
+		r.nodes[nodeID] = n
+	}
+	return n
+}
+
+// acquire waits until a request can be sent to a node, and returns the function to call once it is answered.
+// Returns an error if ctx is done before.
+func (r *rateLimiter) acquire(ctx context.Context, nodeID string) (func(), error) {
+	nodeID = strings.ToLower(nodeID)
+	n := r.node(nodeID)
+	if !n.limit.enabled() {
+		return func() {}, nil
+	}
+	release := func() {}
+	if n.inFlight != nil {
+		select {
+		case n.inFlight <- true:
+			release = func() { <-n.inFlight }
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for a request slot of node %s: %s", nodeID, ctx.Err().Error())
+		}
+	}
+	if err := n.take(ctx); err != nil {
+		release()
+		return nil, fmt.Errorf("waiting for the rate limit of node %s: %s", nodeID, err.Error())
+	}
+	return release, nil
+}
+
+// take waits for a token of the bucket.
+func (n *nodeLimiter) take(ctx context.Context) error {
+	if n.limit.RequestsPerSecond <= 0 {
+		return nil
+	}
+	for {
+		n.lock.Lock()
+		now := time.Now()
+		n.tokens += now.Sub(n.last).Seconds() * n.limit.RequestsPerSecond
+		if n.tokens > float64(n.limit.Burst) {
+			n.tokens = float64(n.limit.Burst)
+		}
+		n.last = now
+		if n.tokens >= 1 {
+			n.tokens--
+			n.lock.Unlock()
+			return nil
+		}
+		wait := time.Duration((1 - n.tokens) / n.limit.RequestsPerSecond * float64(time.Second))
+		n.lock.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// rateLimitedClientApi is a clientapi.ClientApi waiting for the rate limit of the node before every request.
+type rateLimitedClientApi struct {
+	clientApi clientapi.ClientApi
+	limiter   *rateLimiter
+}
+
+// newRateLimitedClientApi wraps a client api so that its requests are limited by the given limiter.
+func newRateLimitedClientApi(clientApi clientapi.ClientApi, limiter *rateLimiter) clientapi.ClientApi {
+	return &rateLimitedClientApi{clientApi: clientApi, limiter: limiter}
+}
+
+func (r *rateLimitedClientApi) RequestDHTOfferDiscover(ctx context.Context, gatewayInfo register.GatewayRegistrar, gatewayIDs []nodeid.NodeID, contentID *cid.ContentID, nonce int64, offersDigests [][][cidoffer.CIDOfferDigestSize]byte, paymentChannelAddr string, voucher string) ([]clientapi.GatewaySubOffers, error) {
+	release, err := r.limiter.acquire(ctx, gatewayInfo.GetNodeID())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return r.clientApi.RequestDHTOfferDiscover(ctx, gatewayInfo, gatewayIDs, contentID, nonce, offersDigests, paymentChannelAddr, voucher)
+}
+
+func (r *rateLimitedClientApi) RequestDHTDiscover(ctx context.Context, gatewayInfo register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, numDHT int64, incrementalResult bool, paychAddr string, voucher string) ([]nodeid.NodeID, []fcrmessages.FCRMessage, []nodeid.NodeID, error) {
+	release, err := r.limiter.acquire(ctx, gatewayInfo.GetNodeID())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer release()
+	return r.clientApi.RequestDHTDiscover(ctx, gatewayInfo, contentID, nonce, ttl, numDHT, incrementalResult, paychAddr, voucher)
+}
+
+func (r *rateLimitedClientApi) RequestDHTDiscoverV2(ctx context.Context, gatewayInfo register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, numDHT int64, incrementalResult bool, paychAddr string, voucher string) ([]nodeid.NodeID, []fcrmessages.FCRMessage, []nodeid.NodeID, error) {
+	release, err := r.limiter.acquire(ctx, gatewayInfo.GetNodeID())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer release()
+	return r.clientApi.RequestDHTDiscoverV2(ctx, gatewayInfo, contentID, nonce, ttl, numDHT, incrementalResult, paychAddr, voucher)
+}
+
+func (r *rateLimitedClientApi) RequestDHTOfferAck(ctx context.Context, providerInfo register.ProviderRegistrar, contentID *cid.ContentID, gatewayID *nodeid.NodeID) (bool, *fcrmessages.FCRMessage, *fcrmessages.FCRMessage, error) {
+	release, err := r.limiter.acquire(ctx, providerInfo.GetNodeID())
+	if err != nil {
+		return false, nil, nil, err
+	}
+	defer release()
+	return r.clientApi.RequestDHTOfferAck(ctx, providerInfo, contentID, gatewayID)
+}
+
+func (r *rateLimitedClientApi) RequestEstablishment(ctx context.Context, gatewayInfo register.GatewayRegistrar, challenge []byte, clientID *nodeid.NodeID, ttl int64) error {
+	release, err := r.limiter.acquire(ctx, gatewayInfo.GetNodeID())
+	if err != nil {
+		return err
+	}
+	defer release()
+	return r.clientApi.RequestEstablishment(ctx, gatewayInfo, challenge, clientID, ttl)
+}
+
+func (r *rateLimitedClientApi) RequestStandardDiscoverOffer(ctx context.Context, gatewayInfo register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, offerDigests [][cidoffer.CIDOfferDigestSize]byte, paychAddr string, voucher string) ([]cidoffer.SubCIDOffer, error) {
+	release, err := r.limiter.acquire(ctx, gatewayInfo.GetNodeID())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return r.clientApi.RequestStandardDiscoverOffer(ctx, gatewayInfo, contentID, nonce, ttl, offerDigests, paychAddr, voucher)
+}
+
+func (r *rateLimitedClientApi) RequestStandardDiscover(ctx context.Context, gatewayInfo register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, paychAddr string, voucher string) ([]cidoffer.SubCIDOffer, error) {
+	release, err := r.limiter.acquire(ctx, gatewayInfo.GetNodeID())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return r.clientApi.RequestStandardDiscover(ctx, gatewayInfo, contentID, nonce, ttl, paychAddr, voucher)
+}
+
+func (r *rateLimitedClientApi) RequestStandardDiscoverV2(ctx context.Context, gatewayInfo register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, paychAddr string, voucher string) ([][cidoffer.CIDOfferDigestSize]byte, error) {
+	release, err := r.limiter.acquire(ctx, gatewayInfo.GetNodeID())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return r.clientApi.RequestStandardDiscoverV2(ctx, gatewayInfo, contentID, nonce, ttl, paychAddr, voucher)
+}
@@ -0,0 +1,120 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+	"github.com/golang/mock/gomock"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi/mocks"
+)
+
+// fixedRateLimit returns the limits of a rate limiter limiting every node with limit.
+func fixedRateLimit(limit RateLimit) func(nodeID string) RateLimit {
+	return func(nodeID string) RateLimit { return limit }
+}
+
+// acquireWithin acquires a request slot of a node, giving up after timeout.
+func acquireWithin(limiter *rateLimiter, nodeID string, timeout time.Duration) (func(), error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return limiter.acquire(ctx, nodeID)
+}
+
+func TestRateLimiterBurstsThenWaits(t *testing.T) {
+	limiter := newRateLimiter(fixedRateLimit(RateLimit{RequestsPerSecond: 10, Burst: 2}))
+	for i := 0; i < 2; i++ {
+		if _, err := acquireWithin(limiter, "node", 10*time.Millisecond); err != nil {
+			t.Fatalf("request %d of the burst delayed: %s", i, err.Error())
+		}
+	}
+	if _, err := acquireWithin(limiter, "node", 10*time.Millisecond); err == nil {
+		t.Fatal("request beyond the burst not delayed")
+	}
+	if _, err := acquireWithin(limiter, "other", 10*time.Millisecond); err != nil {
+		t.Fatal("request to another node delayed by the limit of the first one")
+	}
+	if _, err := acquireWithin(limiter, "node", time.Second); err != nil {
+		t.Fatal("request not sent once the bucket refilled")
+	}
+}
+
+func TestRateLimiterCapsRequestsInFlight(t *testing.T) {
+	limiter := newRateLimiter(fixedRateLimit(RateLimit{MaxInFlight: 1}))
+	release, err := acquireWithin(limiter, "NODE", 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acquireWithin(limiter, "node", 10*time.Millisecond); err == nil {
+		t.Fatal("second request in flight to the node accepted")
+	}
+	release()
+	if _, err := acquireWithin(limiter, "node", 10*time.Millisecond); err != nil {
+		t.Fatal("request not sent once the first one was answered")
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	limiter := newRateLimiter(fixedRateLimit(RateLimit{}))
+	for i := 0; i < 100; i++ {
+		if _, err := acquireWithin(limiter, "node", time.Millisecond); err != nil {
+			t.Fatal("unlimited request delayed")
+		}
+	}
+}
+
+func TestGatewayRateLimitReplacesDefault(t *testing.T) {
+	gatewayID := nodeid.NewRandomNodeID()
+	settings := newTestSettings(t, func(builder *SettingsBuilder) {
+		builder.SetRateLimit(RateLimit{RequestsPerSecond: 1})
+		builder.SetGatewayRateLimit(gatewayID, RateLimit{MaxInFlight: 4})
+	})
+	if limit := settings.RateLimit(strings.ToUpper(gatewayID.ToString())); limit.MaxInFlight != 4 || limit.RequestsPerSecond != 0 {
+		t.Fatalf("expected the limit of the gateway, got %+v", limit)
+	}
+	if limit := settings.RateLimit(nodeid.NewRandomNodeID().ToString()); limit.RequestsPerSecond != 1 {
+		t.Fatalf("expected the default limit, got %+v", limit)
+	}
+}
+
+func TestClientRequestsWaitForRateLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	api := mocks.NewMockClientApi(ctrl)
+	gateway := newTestNode(t)
+	gatewayEntry := gateway.gateway(t)
+	c := newTestClientWithRegister(t, newTestRegister([]register.GatewayRegistrar{gatewayEntry}, nil), func(builder *SettingsBuilder) {
+		builder.SetClientApi(api)
+		builder.SetRateLimit(RateLimit{RequestsPerSecond: 0.001})
+	})
+	api.EXPECT().RequestEstablishment(gomock.Any(), gatewayEntry, gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	if c.AddGatewaysToUse([]*nodeid.NodeID{gateway.id}) != 1 || c.AddActiveGateways([]*nodeid.NodeID{gateway.id}) != 1 {
+		t.Fatal("gateway not activated")
+	}
+
+	// The discovery waits for the rate limit of the gateway, and is never sent
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.FindOffersStandardDiscoveryContext(ctx, cid.NewRandomContentID(), gateway.id); err == nil {
+		t.Fatal("discovery sent beyond the rate limit")
+	}
+}
@@ -0,0 +1,298 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+)
+
+// RegionDistanceModel gives the distance between two region codes.
+type RegionDistanceModel interface {
+	// Distance returns the distance in kilometres between two regions, and false if it is unknown.
+	Distance(from string, to string) (float64, bool)
+}
+
+// GatewayDistance - a registered gateway and its distance to a location
+type GatewayDistance struct {
+	GatewayID  *nodeid.NodeID
+	RegionCode string
+	// Distance is in kilometres, zero for gateways in the location itself.
+	Distance float64
+}
+
+// FindNearestGateways finds at most maxNumToLocate registered gateways, nearest to the location first, using the
+// settings' region distance model. Gateways whose distance to the location is unknown are left out.
+func (c *FilecoinRetrievalClient) FindNearestGateways(location string, maxNumToLocate int) ([]GatewayDistance, error) {
+	// Determine gateways to use. For the moment, this is just "use all of them"
+	// TODO: This will have to become, use gateways that this client has FIL registered with.
+	gateways := c.registerMgr.GetAllGateways()
+	if gateways == nil {
+		return nil, errors.New("error in getting all registered gateways")
+	}
+
+	model := c.Settings.RegionDistanceModel()
+	res := make([]GatewayDistance, 0)
+	for _, info := range gateways {
+		distance, known := model.Distance(location, info.GetRegionCode())
+		if !known {
+			continue
+		}
+		nodeID, err := nodeid.NewNodeIDFromHexString(info.GetNodeID())
+		if err != nil {
+			c.logger.Error("Error in generating node id, skipping", "gateway_id", info.GetNodeID())
+			continue
+		}
+		res = append(res, GatewayDistance{GatewayID: nodeID, RegionCode: info.GetRegionCode(), Distance: distance})
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Distance != res[j].Distance {
+			return res[i].Distance < res[j].Distance
+		}
+		return res[i].GatewayID.ToString() < res[j].GatewayID.ToString()
+	})
+	if maxNumToLocate < 0 {
+		maxNumToLocate = 0
+	}
+	if len(res) > maxNumToLocate {
+		res = res[:maxNumToLocate]
+	}
+	return res, nil
+}
+
+// RegionLocation - where a region is. A location without coordinates, both zero, is the location of its
+// Continent in a TableRegionDistanceModel, so that a region only known by its continent can be added to it.
+type RegionLocation struct {
+	Continent string
+	Latitude  float64
+	Longitude float64
+}
+
+// hasCoordinates returns whether the location has coordinates.
+func (l RegionLocation) hasCoordinates() bool {
+	return l.Latitude != 0 || l.Longitude != 0
+}
+
+// TableRegionDistanceModel computes great-circle distances between regions located by a table.
+// Region codes are looked up case-insensitively; a code with a subdivision, such as "US-CA", falls back
+// to its country when the subdivision is not in the table, and a location without coordinates falls back to
+// its continent in Continents.
+type TableRegionDistanceModel struct {
+	Locations  map[string]RegionLocation
+	Continents map[string]RegionLocation
+}
+
+// NewDefaultRegionDistanceModel creates a distance model locating ISO 3166-1 alpha-2 country codes, and the
+// continent codes AF, AN, AS, EU and OC, by their approximate centre. NA and SA are the country codes
+// of Namibia and Saudi Arabia.
+func NewDefaultRegionDistanceModel() *TableRegionDistanceModel {
+	locations := make(map[string]RegionLocation, len(countryLocations)+len(continentLocations))
+	for code, location := range continentLocations {
+		locations[code] = location
+	}
+	for code, location := range countryLocations {
+		locations[code] = location
+	}
+	continents := make(map[string]RegionLocation, len(continentLocations))
+	for code, location := range continentLocations {
+		continents[code] = location
+	}
+	return &TableRegionDistanceModel{Locations: locations, Continents: continents}
+}
+
+// Distance implements RegionDistanceModel
+func (m *TableRegionDistanceModel) Distance(from string, to string) (float64, bool) {
+	if strings.EqualFold(from, to) {
+		return 0, true
+	}
+	fromLocation, fromKnown := m.locate(from)
+	toLocation, toKnown := m.locate(to)
+	if !fromKnown || !toKnown {
+		return 0, false
+	}
+	return greatCircleDistance(fromLocation, toLocation), true
+}
+
+// locate returns the location of a region code.
+func (m *TableRegionDistanceModel) locate(region string) (RegionLocation, bool) {
+	region = strings.ToUpper(strings.TrimSpace(region))
+	location, exists := m.Locations[region]
+	if !exists {
+		i := strings.IndexAny(region, "-_")
+		if i <= 0 {
+			return RegionLocation{}, false
+		}
+		if location, exists = m.Locations[region[:i]]; !exists {
+			return RegionLocation{}, false
+		}
+	}
+	if location.hasCoordinates() {
+		return location, true
+	}
+	continent, exists := m.Continents[strings.ToUpper(location.Continent)]
+	if !exists || !continent.hasCoordinates() {
+		return RegionLocation{}, false
+	}
+	return continent, true
+}
+
+// greatCircleDistance returns the distance in kilometres between two locations, using the haversine formula.
+func greatCircleDistance(a RegionLocation, b RegionLocation) float64 {
+	lat1 := a.Latitude * math.Pi / 180
+	lat2 := b.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// earthRadius is the mean radius of the Earth, in kilometres.
+const earthRadius = 6371.0
+
+// maxRegionDistance is the longest distance between two regions, half the circumference of the Earth.
+const maxRegionDistance = math.Pi * earthRadius
+
+// continentLocations - approximate centres of the continents
+var continentLocations = map[string]RegionLocation{
+	"AF": {"AF", 2.0, 21.0},
+	"AN": {"AN", -80.0, 0.0},
+	"AS": {"AS", 34.0, 100.0},
+	"EU": {"EU", 50.0, 15.0},
+	"NA": {"NA", 45.0, -100.0},
+	"OC": {"OC", -25.0, 140.0},
+	"SA": {"SA", -15.0, -60.0},
+}
+
+// countryLocations - approximate centres of countries, by ISO 3166-1 alpha-2 code
+var countryLocations = map[string]RegionLocation{
+	// Africa
+	"DZ": {"AF", 28.0, 3.0},
+	"AO": {"AF", -12.5, 18.5},
+	"BW": {"AF", -22.0, 24.0},
+	"CM": {"AF", 6.0, 12.0},
+	"CD": {"AF", -2.5, 23.5},
+	"CI": {"AF", 8.0, -5.5},
+	"EG": {"AF", 27.0, 30.0},
+	"ET": {"AF", 8.0, 38.0},
+	"GH": {"AF", 8.0, -1.0},
+	"KE": {"AF", 0.5, 38.0},
+	"MA": {"AF", 32.0, -5.0},
+	"MZ": {"AF", -18.0, 35.0},
+	"NA": {"AF", -22.0, 17.0},
+	"NG": {"AF", 10.0, 8.0},
+	"RW": {"AF", -2.0, 30.0},
+	"SN": {"AF", 14.0, -14.0},
+	"TN": {"AF", 34.0, 9.0},
+	"TZ": {"AF", -6.0, 35.0},
+	"UG": {"AF", 1.0, 32.0},
+	"ZA": {"AF", -29.0, 24.0},
+	"ZM": {"AF", -14.0, 28.0},
+	"ZW": {"AF", -19.0, 30.0},
+	// Asia
+	"AE": {"AS", 24.0, 54.0},
+	"BD": {"AS", 24.0, 90.0},
+	"CN": {"AS", 35.0, 105.0},
+	"HK": {"AS", 22.3, 114.2},
+	"ID": {"AS", -2.5, 118.0},
+	"IL": {"AS", 31.5, 35.0},
+	"IN": {"AS", 21.0, 78.0},
+	"IQ": {"AS", 33.0, 44.0},
+	"IR": {"AS", 32.0, 53.0},
+	"JP": {"AS", 36.0, 138.0},
+	"JO": {"AS", 31.0, 36.0},
+	"KH": {"AS", 13.0, 105.0},
+	"KR": {"AS", 36.5, 128.0},
+	"KZ": {"AS", 48.0, 68.0},
+	"LK": {"AS", 7.5, 80.7},
+	"MN": {"AS", 46.0, 105.0},
+	"MY": {"AS", 4.0, 102.0},
+	"NP": {"AS", 28.0, 84.0},
+	"PH": {"AS", 13.0, 122.0},
+	"PK": {"AS", 30.0, 70.0},
+	"QA": {"AS", 25.3, 51.2},
+	"SA": {"AS", 24.0, 45.0},
+	"SG": {"AS", 1.35, 103.8},
+	"TH": {"AS", 15.0, 101.0},
+	"TR": {"AS", 39.0, 35.0},
+	"TW": {"AS", 23.7, 121.0},
+	"UZ": {"AS", 41.0, 64.0},
+	"VN": {"AS", 16.0, 106.0},
+	// Europe
+	"AT": {"EU", 47.5, 14.5},
+	"BE": {"EU", 50.8, 4.5},
+	"BG": {"EU", 42.7, 25.5},
+	"BY": {"EU", 53.5, 28.0},
+	"CH": {"EU", 46.8, 8.2},
+	"CZ": {"EU", 49.8, 15.5},
+	"DE": {"EU", 51.0, 10.0},
+	"DK": {"EU", 56.0, 10.0},
+	"EE": {"EU", 58.6, 25.0},
+	"ES": {"EU", 40.0, -4.0},
+	"FI": {"EU", 64.0, 26.0},
+	"FR": {"EU", 46.0, 2.0},
+	"GB": {"EU", 54.0, -2.0},
+	"GR": {"EU", 39.0, 22.0},
+	"HR": {"EU", 45.2, 15.5},
+	"HU": {"EU", 47.0, 19.5},
+	"IE": {"EU", 53.0, -8.0},
+	"IS": {"EU", 65.0, -18.0},
+	"IT": {"EU", 42.8, 12.8},
+	"LT": {"EU", 55.0, 24.0},
+	"LU": {"EU", 49.8, 6.1},
+	"LV": {"EU", 57.0, 25.0},
+	"NL": {"EU", 52.5, 5.75},
+	"NO": {"EU", 62.0, 10.0},
+	"PL": {"EU", 52.0, 20.0},
+	"PT": {"EU", 39.5, -8.0},
+	"RO": {"EU", 46.0, 25.0},
+	"RS": {"EU", 44.0, 21.0},
+	"RU": {"EU", 60.0, 100.0},
+	"SE": {"EU", 62.0, 15.0},
+	"SI": {"EU", 46.1, 14.8},
+	"SK": {"EU", 48.7, 19.5},
+	"UA": {"EU", 49.0, 32.0},
+	// North America
+	"CA": {"NA", 60.0, -95.0},
+	"CR": {"NA", 10.0, -84.0},
+	"CU": {"NA", 21.5, -80.0},
+	"DO": {"NA", 19.0, -70.7},
+	"GT": {"NA", 15.5, -90.25},
+	"JM": {"NA", 18.25, -77.5},
+	"MX": {"NA", 23.0, -102.0},
+	"PA": {"NA", 9.0, -80.0},
+	"PR": {"NA", 18.25, -66.5},
+	"US": {"NA", 38.0, -97.0},
+	// Oceania
+	"AU": {"OC", -27.0, 133.0},
+	"FJ": {"OC", -18.0, 175.0},
+	"NZ": {"OC", -41.0, 174.0},
+	"PG": {"OC", -6.0, 147.0},
+	// South America
+	"AR": {"SA", -34.0, -64.0},
+	"BO": {"SA", -17.0, -65.0},
+	"BR": {"SA", -10.0, -55.0},
+	"CL": {"SA", -30.0, -71.0},
+	"CO": {"SA", 4.0, -72.0},
+	"EC": {"SA", -2.0, -77.5},
+	"PE": {"SA", -10.0, -76.0},
+	"PY": {"SA", -23.0, -58.0},
+	"UY": {"SA", -33.0, -56.0},
+	"VE": {"SA", 8.0, -66.0},
+}
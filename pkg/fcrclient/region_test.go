@@ -0,0 +1,78 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"testing"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+)
+
+// newRegionalGateway returns the register entry of a new gateway in region.
+func newRegionalGateway(t *testing.T, region string) (*testNode, register.GatewayRegistrar) {
+	t.Helper()
+	gateway := newTestNode(t)
+	entry := gateway.gateway(t).Serialize()
+	entry.RegionCode = region
+	return gateway, &entry
+}
+
+func TestRegionLocationFallsBackToContinent(t *testing.T) {
+	model := NewDefaultRegionDistanceModel()
+	model.Locations["XK"] = RegionLocation{Continent: "EU"}
+	model.Locations["XN"] = RegionLocation{Continent: "NA"}
+	model.Locations["XX"] = RegionLocation{Continent: "ZZ"}
+
+	if distance, known := model.Distance("XK-01", "EU"); !known || distance != 0 {
+		t.Fatalf("expected a region without coordinates located at its continent, got %v, %v", distance, known)
+	}
+	fromNorthAmerica, known := model.Distance("XN", "US")
+	if !known {
+		t.Fatal("region located in North America unknown")
+	}
+	if fromNamibia, _ := model.Distance("NA", "US"); fromNorthAmerica >= fromNamibia {
+		t.Fatal("region located in North America located in Namibia")
+	}
+	if _, known := model.Distance("XX", "EU"); known {
+		t.Fatal("region of an unknown continent located")
+	}
+}
+
+func TestFindNearestGatewaysByContinent(t *testing.T) {
+	german, germanEntry := newRegionalGateway(t, "DE")
+	european, europeanEntry := newRegionalGateway(t, "XK")
+	_, unknownEntry := newRegionalGateway(t, "XX")
+	american, americanEntry := newRegionalGateway(t, "US")
+	model := NewDefaultRegionDistanceModel()
+	model.Locations["XK"] = RegionLocation{Continent: "EU"}
+	registerMgr := newTestRegister([]register.GatewayRegistrar{americanEntry, unknownEntry, europeanEntry, germanEntry}, nil)
+	c := newTestClientWithRegister(t, registerMgr, func(builder *SettingsBuilder) {
+		builder.SetRegionDistanceModel(model)
+	})
+
+	gateways, err := c.FindNearestGateways("FR", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gateways) != 3 {
+		t.Fatalf("expected the gateways of known regions, got %d gateways", len(gateways))
+	}
+	for i, expected := range []*testNode{german, european, american} {
+		if gateways[i].GatewayID.ToString() != expected.id.ToString() {
+			t.Fatalf("gateway %d is in %s, not in the expected region", i, gateways[i].RegionCode)
+		}
+	}
+}
@@ -16,50 +16,51 @@ package fcrclient
  */
 
 import (
-	"github.com/ConsenSys/fc-retrieval-common/pkg/logging"
 	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/fcrlogger"
 )
 
 // Validate the information coming from the Register.
 // Return true if the information is valid.
-func validateGatewayInfo(gateway register.GatewayRegistrar) bool {
+func validateGatewayInfo(logger fcrlogger.Logger, gateway register.GatewayRegistrar) bool {
 	// All of the fields must have a value in them.
 	if gateway.GetNodeID() == "" {
-		logging.Warn("Gateway registration issue: NodeID not set")
+		logger.Warn("Gateway registration issue: NodeID not set")
 		return false
 	}
 	if gateway.GetAddress() == "" {
-		logging.Warn("Gateway registration issue: Gateway IP address or domain name not set")
+		logger.Warn("Gateway registration issue: Gateway IP address or domain name not set", "node_id", gateway.GetNodeID())
 		return false
 	}
 	if gateway.GetNetworkInfoGateway() == "" {
-		logging.Warn("Gateway registration issue: Port for Gateway to Gateway communications not set")
+		logger.Warn("Gateway registration issue: Port for Gateway to Gateway communications not set", "node_id", gateway.GetNodeID())
 		return false
 	}
 	if gateway.GetNetworkInfoProvider() == "" {
-		logging.Warn("Gateway registration issue: Port for Provider to Gateway communications not set")
+		logger.Warn("Gateway registration issue: Port for Provider to Gateway communications not set", "node_id", gateway.GetNodeID())
 		return false
 	}
 	if gateway.GetNetworkInfoClient() == "" {
-		logging.Warn("Gateway registration issue: Port for Client to Gateway communications not set")
+		logger.Warn("Gateway registration issue: Port for Client to Gateway communications not set", "node_id", gateway.GetNodeID())
 		return false
 	}
 	if gateway.GetNetworkInfoAdmin() == "" {
-		logging.Warn("Gateway registration issue: Port for Admin to Gateway communications not set")
+		logger.Warn("Gateway registration issue: Port for Admin to Gateway communications not set", "node_id", gateway.GetNodeID())
 		return false
 	}
 	if gateway.GetRegionCode() == "" {
-		logging.Warn("Gateway registration issue: Region Code not set")
+		logger.Warn("Gateway registration issue: Region Code not set", "node_id", gateway.GetNodeID())
 		return false
 	}
 	_, err := gateway.GetRootSigningKey()
 	if err != nil {
-		logging.Warn("Gateway registration issue: Root Signing Public Key error: %+v", err)
+		logger.Warn("Gateway registration issue: Root Signing Public Key error", "node_id", gateway.GetNodeID(), "error", err)
 		return false
 	}
 	_, err = gateway.GetSigningKey()
 	if err != nil {
-		logging.Warn("Gateway registration issue: Retrieval Signing Key error: %+v", err)
+		logger.Warn("Gateway registration issue: Retrieval Signing Key error", "node_id", gateway.GetNodeID(), "error", err)
 		return false
 	}
 	return true
@@ -67,40 +68,40 @@ func validateGatewayInfo(gateway register.GatewayRegistrar) bool {
 
 // Validate the information coming from the Register.
 // Return true if the information is valid.
-func validateProviderInfo(provider register.ProviderRegistrar) bool {
+func validateProviderInfo(logger fcrlogger.Logger, provider register.ProviderRegistrar) bool {
 	// All of the fields must have a value in them.
 	if provider.GetNodeID() == "" {
-		logging.Warn("Provider registration issue: NodeID not set")
+		logger.Warn("Provider registration issue: NodeID not set")
 		return false
 	}
 	if provider.GetAddress() == "" {
-		logging.Warn("Provider registration issue: Provider IP address or domain name not set")
+		logger.Warn("Provider registration issue: Provider IP address or domain name not set", "node_id", provider.GetNodeID())
 		return false
 	}
 	if provider.GetNetworkInfoGateway() == "" {
-		logging.Warn("Provider registration issue: Port for Gateway to Provider communications not set")
+		logger.Warn("Provider registration issue: Port for Gateway to Provider communications not set", "node_id", provider.GetNodeID())
 		return false
 	}
 	if provider.GetNetworkInfoClient() == "" {
-		logging.Warn("Provider registration issue: Port for Client to Provider communications not set")
+		logger.Warn("Provider registration issue: Port for Client to Provider communications not set", "node_id", provider.GetNodeID())
 		return false
 	}
 	if provider.GetNetworkInfoAdmin() == "" {
-		logging.Warn("Provider registration issue: Port for Admin to Provider communications not set")
+		logger.Warn("Provider registration issue: Port for Admin to Provider communications not set", "node_id", provider.GetNodeID())
 		return false
 	}
 	if provider.GetRegionCode() == "" {
-		logging.Warn("Provider registration issue: Region Code not set")
+		logger.Warn("Provider registration issue: Region Code not set", "node_id", provider.GetNodeID())
 		return false
 	}
 	_, err := provider.GetRootSigningKey()
 	if err != nil {
-		logging.Warn("Provider registration issue: Root Signing Public Key error: %+v", err)
+		logger.Warn("Provider registration issue: Root Signing Public Key error", "node_id", provider.GetNodeID(), "error", err)
 		return false
 	}
 	_, err = provider.GetSigningKey()
 	if err != nil {
-		logging.Warn("Provider registration issue: Retrieval Signing Key error: %+v", err)
+		logger.Warn("Provider registration issue: Retrieval Signing Key error", "node_id", provider.GetNodeID(), "error", err)
 		return false
 	}
 	return true
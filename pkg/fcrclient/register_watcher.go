@@ -0,0 +1,302 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+)
+
+// Fields of a register entry reported by a RegisterEntryChangedEvent
+const (
+	RegisterFieldAddress        = "address"
+	RegisterFieldRegionCode     = "region_code"
+	RegisterFieldRootSigningKey = "root_signing_key"
+	RegisterFieldSigningKey     = "signing_key"
+	RegisterFieldNetworkInfo    = "network_info"
+)
+
+// registerWatcher - the state of the register watcher, and the register entries of the providers in use
+type registerWatcher struct {
+	providers map[string]watchedProvider
+	stop      chan bool
+	done      chan bool
+	lock      sync.Mutex
+}
+
+// watchedProvider - the register entry of a provider in use, and when it was last used
+type watchedProvider struct {
+	entry    register.ProviderRegister
+	lastUsed time.Time
+}
+
+// newRegisterWatcher creates a register watcher which is not running.
+func newRegisterWatcher() *registerWatcher {
+	return &registerWatcher{providers: make(map[string]watchedProvider)}
+}
+
+// watchProvider records the register entry of a provider whose offer or ack was just verified.
+func (w *registerWatcher) watchProvider(provider register.ProviderRegistrar) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.providers[strings.ToLower(provider.GetNodeID())] = watchedProvider{entry: provider.Serialize(), lastUsed: time.Now()}
+}
+
+// watchedProviders returns the register entries of the providers in use, forgetting the providers not used for
+// watchedProviderTTL.
+func (w *registerWatcher) watchedProviders() map[string]register.ProviderRegister {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	res := make(map[string]register.ProviderRegister, len(w.providers))
+	for id, provider := range w.providers {
+		if time.Since(provider.lastUsed) > watchedProviderTTL {
+			delete(w.providers, id)
+			continue
+		}
+		res[id] = provider.entry
+	}
+	return res
+}
+
+// updateProvider replaces the register entry of a watched provider, keeping when it was last used.
+func (w *registerWatcher) updateProvider(provider register.ProviderRegistrar) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	id := strings.ToLower(provider.GetNodeID())
+	if watched, exists := w.providers[id]; exists {
+		w.providers[id] = watchedProvider{entry: provider.Serialize(), lastUsed: watched.lastUsed}
+	}
+}
+
+// unwatchProvider forgets a provider.
+func (w *registerWatcher) unwatchProvider(providerID string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	delete(w.providers, providerID)
+}
+
+// StartRegisterWatcher checks the register entries of the gateways and providers in use every interval, see CheckRegister.
+func (c *FilecoinRetrievalClient) StartRegisterWatcher(interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("register watcher interval must be positive")
+	}
+	c.watcher.lock.Lock()
+	defer c.watcher.lock.Unlock()
+	if c.watcher.stop != nil {
+		return errors.New("register watcher already started")
+	}
+	stop := make(chan bool)
+	done := make(chan bool)
+	c.watcher.stop = stop
+	c.watcher.done = done
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.CheckRegister()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// StopRegisterWatcher stops the register watcher and waits for the check in progress, if any, to finish.
+func (c *FilecoinRetrievalClient) StopRegisterWatcher() {
+	c.watcher.lock.Lock()
+	stop, done := c.watcher.stop, c.watcher.done
+	c.watcher.stop, c.watcher.done = nil, nil
+	c.watcher.lock.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+// CheckRegister refreshes the register and compares the entries of the gateways and providers in use with the
+// ones the client has cached.
+// A gateway whose entry changed is validated again: it is removed if it is no longer valid, otherwise its cached
+// entry is replaced and, if it is active, the establishment is done again. A gateway is deactivated if the new
+// establishment fails, and removed if it is no longer in the register. Note the register manager of the common
+// library keeps every entry it has seen, so with it entries are reported changed but never removed.
+// A RegisterEntryChangedEvent or RegisterEntryRemovedEvent is published for each changed or removed entry.
+func (c *FilecoinRetrievalClient) CheckRegister() {
+	c.registerMgr.Refresh()
+	c.checkGateways()
+	c.checkProviders()
+}
+
+// checkGateways checks the register entries of the gateways to use.
+func (c *FilecoinRetrievalClient) checkGateways() {
+	registered := c.registerMgr.GetAllGateways()
+	if registered == nil {
+		c.logger.Error("Unable to check the gateways, the register manager is not started")
+		return
+	}
+	latestGateways := make(map[string]register.GatewayRegistrar, len(registered))
+	for _, gateway := range registered {
+		latestGateways[strings.ToLower(gateway.GetNodeID())] = gateway
+	}
+
+	c.GatewaysToUseLock.RLock()
+	cached := make(map[string]register.GatewayRegistrar, len(c.GatewaysToUse))
+	for id, gateway := range c.GatewaysToUse {
+		cached[id] = gateway
+	}
+	c.GatewaysToUseLock.RUnlock()
+
+	for id, gateway := range cached {
+		gatewayID, err := nodeid.NewNodeIDFromHexString(id)
+		if err != nil {
+			c.logger.Error("Error in generating node id", "node_id", id)
+			continue
+		}
+		latest, exist := latestGateways[id]
+		if !exist {
+			c.logger.Warn("Gateway no longer in the register, removing it", "gateway_id", id)
+			c.events.publish(RegisterEntryRemovedEvent{now(), id, registrationKindGateway})
+			c.removeGatewaysToUse([]*nodeid.NodeID{gatewayID}, false)
+			continue
+		}
+		old, cur := gateway.Serialize(), latest.Serialize()
+		changes := registerEntryChanges(old.Address, cur.Address, old.RegionCode, cur.RegionCode,
+			old.RootSigningKey, cur.RootSigningKey, old.SigningKey, cur.SigningKey,
+			[]string{old.NetworkInfoGateway, old.NetworkInfoProvider, old.NetworkInfoClient, old.NetworkInfoAdmin},
+			[]string{cur.NetworkInfoGateway, cur.NetworkInfoProvider, cur.NetworkInfoClient, cur.NetworkInfoAdmin})
+		if len(changes) == 0 {
+			continue
+		}
+		c.logger.Info("Gateway register entry changed", "gateway_id", id, "changes", changes)
+		c.events.publish(RegisterEntryChangedEvent{now(), id, registrationKindGateway, changes})
+		if !c.validGateway(latest) {
+			c.logger.Warn("Changed gateway register entry not valid, removing the gateway", "gateway_id", id)
+			c.removeGatewaysToUse([]*nodeid.NodeID{gatewayID}, false)
+			continue
+		}
+		c.updateGateway(gatewayID, latest)
+	}
+}
+
+// updateGateway replaces the cached register entry of a gateway, and establishes again with it if it is active.
+func (c *FilecoinRetrievalClient) updateGateway(gatewayID *nodeid.NodeID, gateway register.GatewayRegistrar) {
+	id := gatewayID.ToString()
+	c.GatewaysToUseLock.Lock()
+	if _, exist := c.GatewaysToUse[id]; !exist {
+		// Removed in the meantime
+		c.GatewaysToUseLock.Unlock()
+		return
+	}
+	c.GatewaysToUse[id] = gateway
+	c.GatewaysToUseLock.Unlock()
+
+	c.ActiveGatewaysLock.RLock()
+	_, active := c.ActiveGateways[id]
+	c.ActiveGatewaysLock.RUnlock()
+	if !active {
+		return
+	}
+	if err := c.establish(context.Background(), gatewayID, gateway); err != nil {
+		c.logger.Error("Error in establishment with changed gateway, deactivating it", "gateway_id", id, "error", err)
+		c.RemoveActiveGateways([]*nodeid.NodeID{gatewayID})
+		return
+	}
+	c.breakers.reset(id)
+	c.ActiveGatewaysLock.Lock()
+	c.ActiveGateways[id] = gateway
+	c.ActiveGatewaysLock.Unlock()
+	c.events.publish(GatewayActivatedEvent{now(), id})
+}
+
+// checkProviders checks the register entries of the providers whose offers or acks were verified in the last
+// watchedProviderTTL.
+func (c *FilecoinRetrievalClient) checkProviders() {
+	registered := c.registerMgr.GetAllProviders()
+	if registered == nil {
+		c.logger.Error("Unable to check the providers, the register manager is not started")
+		return
+	}
+	latestProviders := make(map[string]register.ProviderRegistrar, len(registered))
+	for _, provider := range registered {
+		latestProviders[strings.ToLower(provider.GetNodeID())] = provider
+	}
+
+	for id, old := range c.watcher.watchedProviders() {
+		providerID, err := nodeid.NewNodeIDFromHexString(id)
+		if err != nil {
+			c.watcher.unwatchProvider(id)
+			continue
+		}
+		latest, exist := latestProviders[providerID.ToString()]
+		if !exist {
+			c.logger.Warn("Provider no longer in the register", "provider_id", id)
+			c.watcher.unwatchProvider(id)
+			c.events.publish(RegisterEntryRemovedEvent{now(), id, registrationKindProvider})
+			continue
+		}
+		cur := latest.Serialize()
+		changes := registerEntryChanges(old.Address, cur.Address, old.RegionCode, cur.RegionCode,
+			old.RootSigningKey, cur.RootSigningKey, old.SigningKey, cur.SigningKey,
+			[]string{old.NetworkInfoGateway, old.NetworkInfoClient, old.NetworkInfoAdmin},
+			[]string{cur.NetworkInfoGateway, cur.NetworkInfoClient, cur.NetworkInfoAdmin})
+		if len(changes) == 0 {
+			continue
+		}
+		c.logger.Info("Provider register entry changed", "provider_id", id, "changes", changes)
+		c.events.publish(RegisterEntryChangedEvent{now(), id, registrationKindProvider, changes})
+		// A valid entry is watched with its new content
+		if !c.validProvider(latest) {
+			c.logger.Warn("Changed provider register entry not valid", "provider_id", id)
+			c.watcher.unwatchProvider(id)
+			continue
+		}
+		c.watcher.updateProvider(latest)
+	}
+}
+
+// registerEntryChanges returns the fields which differ between two versions of a register entry.
+func registerEntryChanges(oldAddress, address, oldRegion, region, oldRootKey, rootKey, oldKey, key string, oldNetworkInfo, networkInfo []string) []string {
+	changes := make([]string, 0)
+	if oldAddress != address {
+		changes = append(changes, RegisterFieldAddress)
+	}
+	if oldRegion != region {
+		changes = append(changes, RegisterFieldRegionCode)
+	}
+	if oldRootKey != rootKey {
+		changes = append(changes, RegisterFieldRootSigningKey)
+	}
+	if oldKey != key {
+		changes = append(changes, RegisterFieldSigningKey)
+	}
+	for i := range oldNetworkInfo {
+		if oldNetworkInfo[i] != networkInfo[i] {
+			changes = append(changes, RegisterFieldNetworkInfo)
+			break
+		}
+	}
+	return changes
+}
@@ -0,0 +1,115 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+)
+
+// newWatchingTestClient creates a client with provider in the register.
+func newWatchingTestClient(t *testing.T, provider *testNode) (*FilecoinRetrievalClient, *testRegister) {
+	t.Helper()
+	registerMgr := newTestRegister(nil, []register.ProviderRegistrar{provider.provider(t)})
+	return newTestClientWithRegister(t, registerMgr, nil), registerMgr
+}
+
+// watched returns whether the register watcher checks the entry of provider.
+func watched(c *FilecoinRetrievalClient, provider *testNode) bool {
+	_, exists := c.watcher.watchedProviders()[strings.ToLower(provider.id.ToString())]
+	return exists
+}
+
+func TestValidatingProviderDoesNotWatchIt(t *testing.T) {
+	provider := newTestNode(t)
+	c, _ := newWatchingTestClient(t, provider)
+
+	if !c.validProvider(provider.provider(t)) {
+		t.Fatal("valid provider rejected")
+	}
+	if watched(c, provider) {
+		t.Fatal("provider watched without any offer of it verified")
+	}
+}
+
+func TestVerifiedOffersWatchTheirProvider(t *testing.T) {
+	provider := newTestNode(t)
+	c, _ := newWatchingTestClient(t, provider)
+
+	forged := newTestOffer(t, provider.id, newTestKey(t), cid.NewRandomContentID(), 1)
+	if c.verifySubCIDOffer(&forged) == nil {
+		t.Fatal("forged offer verified")
+	}
+	if watched(c, provider) {
+		t.Fatal("provider watched after an offer of it was rejected")
+	}
+	offer := newTestOffer(t, provider.id, provider.signingKey, cid.NewRandomContentID(), 1)
+	if err := c.verifySubCIDOffer(&offer); err != nil {
+		t.Fatal(err)
+	}
+	if !watched(c, provider) {
+		t.Fatal("provider of a verified offer not watched")
+	}
+}
+
+func TestWatchedProvidersExpire(t *testing.T) {
+	provider := newTestNode(t)
+	c, _ := newWatchingTestClient(t, provider)
+	c.watcher.watchProvider(provider.provider(t))
+
+	id := strings.ToLower(provider.id.ToString())
+	c.watcher.lock.Lock()
+	entry := c.watcher.providers[id]
+	entry.lastUsed = time.Now().Add(-watchedProviderTTL - time.Minute)
+	c.watcher.providers[id] = entry
+	c.watcher.lock.Unlock()
+
+	if watched(c, provider) {
+		t.Fatal("provider not used for longer than the TTL still watched")
+	}
+	if len(c.watcher.providers) != 0 {
+		t.Fatal("expired provider not forgotten")
+	}
+}
+
+func TestCheckRegisterUpdatesChangedProviders(t *testing.T) {
+	provider := newTestNode(t)
+	c, registerMgr := newWatchingTestClient(t, provider)
+	c.watcher.watchProvider(provider.provider(t))
+	events, unsubscribe := c.events.SubscribeChannel(10, EventRegisterEntryChanged)
+
+	changed := provider.provider(t).Serialize()
+	changed.Address = "127.0.0.2"
+	registerMgr.providers[provider.id.ToString()] = &changed
+	c.CheckRegister()
+	c.CheckRegister()
+	unsubscribe()
+
+	changes := 0
+	for range events {
+		changes++
+	}
+	if changes != 1 {
+		t.Fatalf("expected the change reported once, got %d reports", changes)
+	}
+	if c.watcher.watchedProviders()[strings.ToLower(provider.id.ToString())].Address != "127.0.0.2" {
+		t.Fatal("changed provider not watched with its new entry")
+	}
+}
@@ -0,0 +1,166 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// TopupRequest - a payment about to be made to a gateway, for which a top up policy decides whether to top up
+// the payment channel first
+type TopupRequest struct {
+	GatewayID string
+	// Pending is the amount of the payment.
+	Pending *big.Int
+	// Expected is the amount the operation making the payment expects to spend with the gateway, the pending
+	// payment included. It is never less than Pending.
+	Expected *big.Int
+	// Balance is the amount topped up minus the amount paid since the client was created. It can be negative
+	// when the channel was created before.
+	Balance *big.Int
+	// TopUpAmount is the top up amount of the settings.
+	TopUpAmount *big.Int
+	// NeedTopup is true when the payment manager answered the balance of the channel is not enough for the payment,
+	// false when the policy is asked before the payment is attempted.
+	NeedTopup bool
+}
+
+// shortfall returns the amount missing from the balance to make the pending payment.
+func (r TopupRequest) shortfall() *big.Int {
+	res := new(big.Int).Sub(r.Pending, r.Balance)
+	if res.Sign() < 0 {
+		res.SetInt64(0)
+	}
+	return res
+}
+
+// TopupPolicy decides the amount the payment channel to a gateway is topped up with.
+type TopupPolicy interface {
+	// TopupAmount returns the amount to top up the payment channel with before the payment, zero for no top up.
+	// When request.NeedTopup is true, returning zero or an error fails the payment.
+	TopupAmount(request TopupRequest) (*big.Int, error)
+}
+
+// FixedTopupPolicy tops up channels with a fixed amount when their balance is not enough, which may not cover
+// a large payment. The top up amount of the settings is used if Amount is nil.
+type FixedTopupPolicy struct {
+	Amount *big.Int
+}
+
+// TopupAmount implements TopupPolicy
+func (p FixedTopupPolicy) TopupAmount(request TopupRequest) (*big.Int, error) {
+	if !request.NeedTopup {
+		return big.NewInt(0), nil
+	}
+	amount := p.Amount
+	if amount == nil {
+		amount = request.TopUpAmount
+	}
+	if amount == nil {
+		return big.NewInt(0), nil
+	}
+	return new(big.Int).Set(amount), nil
+}
+
+// CoverPendingTopupPolicy tops up channels, when their balance is not enough, with what the operation making the
+// payment expects to spend, and never less than Minimum. The top up amount of the settings is used if Minimum
+// is nil. This is the default policy.
+type CoverPendingTopupPolicy struct {
+	Minimum *big.Int
+}
+
+// TopupAmount implements TopupPolicy
+func (p CoverPendingTopupPolicy) TopupAmount(request TopupRequest) (*big.Int, error) {
+	if !request.NeedTopup {
+		return big.NewInt(0), nil
+	}
+	amount := new(big.Int).Sub(request.Expected, request.Balance)
+	if shortfall := request.shortfall(); amount.Cmp(shortfall) < 0 {
+		amount = shortfall
+	}
+	minimum := p.Minimum
+	if minimum == nil {
+		minimum = request.TopUpAmount
+	}
+	if minimum != nil && amount.Cmp(minimum) < 0 {
+		amount = new(big.Int).Set(minimum)
+	}
+	return amount, nil
+}
+
+// ProportionalTopupPolicy tops up channels, when their balance is not enough, with Multiplier times what the
+// operation making the payment expects to spend, so that the following operations need no top up.
+type ProportionalTopupPolicy struct {
+	Multiplier int64
+}
+
+// TopupAmount implements TopupPolicy
+func (p ProportionalTopupPolicy) TopupAmount(request TopupRequest) (*big.Int, error) {
+	if !request.NeedTopup {
+		return big.NewInt(0), nil
+	}
+	amount := new(big.Int).Mul(request.Expected, big.NewInt(p.Multiplier))
+	if shortfall := request.shortfall(); amount.Cmp(shortfall) < 0 {
+		amount = shortfall
+	}
+	return amount, nil
+}
+
+// LowWatermarkTopupPolicy refills channels before their balance runs out: when the balance left after the pending
+// payment would be less than Watermark, the channel is topped up with the amount of Refill before paying.
+type LowWatermarkTopupPolicy struct {
+	Watermark *big.Int
+	Refill    TopupPolicy
+}
+
+// TopupAmount implements TopupPolicy
+func (p LowWatermarkTopupPolicy) TopupAmount(request TopupRequest) (*big.Int, error) {
+	if !request.NeedTopup {
+		left := new(big.Int).Sub(request.Balance, request.Pending)
+		if left.Cmp(p.Watermark) >= 0 {
+			return big.NewInt(0), nil
+		}
+		request.NeedTopup = true
+	}
+	return p.Refill.TopupAmount(request)
+}
+
+// MaxBalanceTopupPolicy caps the top ups of Policy so that the balance of a channel never exceeds MaxBalance.
+// A payment needing a top up beyond the cap fails.
+type MaxBalanceTopupPolicy struct {
+	MaxBalance *big.Int
+	Policy     TopupPolicy
+}
+
+// TopupAmount implements TopupPolicy
+func (p MaxBalanceTopupPolicy) TopupAmount(request TopupRequest) (*big.Int, error) {
+	amount, err := p.Policy.TopupAmount(request)
+	if err != nil {
+		return nil, err
+	}
+	room := new(big.Int).Sub(p.MaxBalance, request.Balance)
+	if room.Sign() < 0 {
+		room.SetInt64(0)
+	}
+	if amount.Cmp(room) > 0 {
+		amount = room
+	}
+	if request.NeedTopup && amount.Cmp(request.shortfall()) < 0 {
+		return nil, fmt.Errorf("paying %s to gateway %s would exceed the maximum channel balance of %s", request.Pending.String(), request.GatewayID, p.MaxBalance.String())
+	}
+	return amount, nil
+}
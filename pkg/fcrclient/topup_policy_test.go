@@ -0,0 +1,121 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"math/big"
+	"testing"
+)
+
+// balancePayer - a payment manager asking for a top up when the balance of the channel is not enough
+type balancePayer struct {
+	balance *big.Int
+	topups  []*big.Int
+}
+
+func (p *balancePayer) Topup(recipient string, amount *big.Int) error {
+	p.topups = append(p.topups, amount)
+	p.balance.Add(p.balance, amount)
+	return nil
+}
+
+func (p *balancePayer) Pay(recipient string, lane uint64, amount *big.Int) (string, string, bool, error) {
+	if p.balance.Cmp(amount) < 0 {
+		return "", "", true, nil
+	}
+	p.balance.Sub(p.balance, amount)
+	return "f01234", "voucher", false, nil
+}
+
+// newTopupRequest returns a request for a pending payment, with the expected spending, the balance and whether
+// the payment manager needs a top up, with a settings' top up amount of 100.
+func newTopupRequest(pending int64, expected int64, balance int64, needTopup bool) TopupRequest {
+	return TopupRequest{
+		GatewayID:   "gateway",
+		Pending:     big.NewInt(pending),
+		Expected:    big.NewInt(expected),
+		Balance:     big.NewInt(balance),
+		TopUpAmount: big.NewInt(100),
+		NeedTopup:   needTopup,
+	}
+}
+
+func TestTopupPolicies(t *testing.T) {
+	for name, test := range map[string]struct {
+		policy   TopupPolicy
+		request  TopupRequest
+		expected int64
+	}{
+		"fixed without need":                {FixedTopupPolicy{}, newTopupRequest(10, 10, 0, false), 0},
+		"fixed settings amount":             {FixedTopupPolicy{}, newTopupRequest(500, 500, 0, true), 100},
+		"fixed own amount":                  {FixedTopupPolicy{Amount: big.NewInt(7)}, newTopupRequest(10, 10, 0, true), 7},
+		"cover expected":                    {CoverPendingTopupPolicy{}, newTopupRequest(50, 500, 20, true), 480},
+		"cover at least the minimum":        {CoverPendingTopupPolicy{}, newTopupRequest(10, 10, 0, true), 100},
+		"cover own minimum":                 {CoverPendingTopupPolicy{Minimum: big.NewInt(1)}, newTopupRequest(10, 10, 0, true), 10},
+		"cover negative balance":            {CoverPendingTopupPolicy{Minimum: big.NewInt(1)}, newTopupRequest(10, 10, -5, true), 15},
+		"proportional":                      {ProportionalTopupPolicy{Multiplier: 3}, newTopupRequest(10, 20, 0, true), 60},
+		"proportional covers the shortfall": {ProportionalTopupPolicy{Multiplier: 1}, newTopupRequest(10, 10, -90, true), 100},
+		"watermark not reached":             {LowWatermarkTopupPolicy{Watermark: big.NewInt(50), Refill: FixedTopupPolicy{}}, newTopupRequest(10, 10, 100, false), 0},
+		"watermark reached":                 {LowWatermarkTopupPolicy{Watermark: big.NewInt(50), Refill: FixedTopupPolicy{}}, newTopupRequest(10, 10, 55, false), 100},
+		"max balance without need":          {MaxBalanceTopupPolicy{MaxBalance: big.NewInt(150), Policy: FixedTopupPolicy{}}, newTopupRequest(10, 10, 80, false), 0},
+		"max balance within the cap":        {MaxBalanceTopupPolicy{MaxBalance: big.NewInt(150), Policy: FixedTopupPolicy{}}, newTopupRequest(60, 60, 50, true), 100},
+		"max balance leaves room":           {MaxBalanceTopupPolicy{MaxBalance: big.NewInt(120), Policy: FixedTopupPolicy{}}, newTopupRequest(60, 60, 50, true), 70},
+	} {
+		amount, err := test.policy.TopupAmount(test.request)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err.Error())
+		}
+		if amount.Int64() != test.expected {
+			t.Fatalf("%s: expected a top up of %d, got %s", name, test.expected, amount)
+		}
+	}
+}
+
+func TestMaxBalanceTopupPolicyFailsBeyondCap(t *testing.T) {
+	policy := MaxBalanceTopupPolicy{MaxBalance: big.NewInt(100), Policy: CoverPendingTopupPolicy{}}
+	if _, err := policy.TopupAmount(newTopupRequest(200, 200, 0, true)); err == nil {
+		t.Fatal("payment needing a balance beyond the cap accepted")
+	}
+}
+
+func TestPaymentsTopUpAsThePolicyDecides(t *testing.T) {
+	payer := &balancePayer{balance: big.NewInt(0)}
+	c := newTestClient(t, func(builder *SettingsBuilder) {
+		builder.SetTopUpAmount(big.NewInt(100))
+		builder.SetTopupPolicy(LowWatermarkTopupPolicy{Watermark: big.NewInt(30), Refill: CoverPendingTopupPolicy{}})
+	})
+	c.payer = payer
+	gw := newTestNode(t).gateway(t)
+
+	// The channel has no balance: the policy covers the expected spending, at least the top up amount
+	if _, _, err := c.payGatewayNoTrace(gw, 0, big.NewInt(10), big.NewInt(150)); err != nil {
+		t.Fatal(err)
+	}
+	// The balance left is above the watermark
+	if _, _, err := c.payGatewayNoTrace(gw, 0, big.NewInt(100), nil); err != nil {
+		t.Fatal(err)
+	}
+	// The balance left would be under the watermark, the channel is refilled before paying
+	if _, _, err := c.payGatewayNoTrace(gw, 0, big.NewInt(20), nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(payer.topups) != 2 || payer.topups[0].Int64() != 150 || payer.topups[1].Int64() != 100 {
+		t.Fatalf("expected top ups of 150 and 100, got %v", payer.topups)
+	}
+	if payer.balance.Int64() != 120 {
+		t.Fatalf("expected a balance of 120 left, got %s", payer.balance)
+	}
+}
@@ -0,0 +1,88 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+	"github.com/golang/mock/gomock"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/api/clientapi/mocks"
+)
+
+// callerContext returns a cancellable context carrying the span of a caller.
+func callerContext() (context.Context, context.CancelFunc, trace.TraceID) {
+	traceID := trace.TraceID{1, 2, 3, 4}
+	parent := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: trace.SpanID{5, 6, 7, 8}, TraceFlags: trace.FlagsSampled})
+	ctx, cancel := context.WithCancel(trace.ContextWithSpanContext(context.Background(), parent))
+	return ctx, cancel, traceID
+}
+
+func TestFindOffersContextUsesCallerContext(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	api := mocks.NewMockClientApi(ctrl)
+	gateway, provider := newTestNode(t), newTestNode(t)
+	c := newActiveGatewayClient(t, api, gateway, provider)
+
+	ctx, cancel, traceID := callerContext()
+	contentID := cid.NewRandomContentID()
+	api.EXPECT().RequestStandardDiscover(gomock.Any(), gomock.Any(), contentID, gomock.Any(), gomock.Any(), "", "").
+		DoAndReturn(func(requestCtx context.Context, gw register.GatewayRegistrar, contentID *cid.ContentID, nonce int64, ttl int64, paychAddr string, voucher string) ([]cidoffer.SubCIDOffer, error) {
+			if trace.SpanContextFromContext(requestCtx).TraceID() != traceID {
+				t.Error("request not traced within the trace of the caller")
+			}
+			cancel()
+			if requestCtx.Err() == nil {
+				t.Error("request not cancelled with the context of the caller")
+			}
+			return nil, nil
+		})
+	if _, err := c.FindOffersStandardDiscoveryContext(ctx, contentID, gateway.id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddActiveGatewaysContextUsesCallerContext(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	api := mocks.NewMockClientApi(ctrl)
+	gateway := newTestNode(t)
+	gatewayEntry := gateway.gateway(t)
+	c := newTestClientWithRegister(t, newTestRegister([]register.GatewayRegistrar{gatewayEntry}, nil), func(builder *SettingsBuilder) {
+		builder.SetClientApi(api)
+	})
+	if c.AddGatewaysToUse([]*nodeid.NodeID{gateway.id}) != 1 {
+		t.Fatal("gateway not added")
+	}
+
+	ctx, cancel, traceID := callerContext()
+	defer cancel()
+	api.EXPECT().RequestEstablishment(gomock.Any(), gatewayEntry, gomock.Any(), c.Settings.ClientID(), gomock.Any()).
+		DoAndReturn(func(requestCtx context.Context, gw register.GatewayRegistrar, challenge []byte, clientID *nodeid.NodeID, ttl int64) error {
+			if trace.SpanContextFromContext(requestCtx).TraceID() != traceID {
+				t.Error("establishment not traced within the trace of the caller")
+			}
+			return nil
+		})
+	if c.AddActiveGatewaysContext(ctx, []*nodeid.NodeID{gateway.id}) != 1 {
+		t.Fatal("gateway not activated")
+	}
+}
@@ -0,0 +1,301 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrcrypto"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+)
+
+// TrustMode - what the client does with register entries failing trust verification
+type TrustMode int
+
+// Trust modes
+const (
+	// TrustModeDisabled performs no trust verification. This is the default.
+	TrustModeDisabled TrustMode = iota
+	// TrustModeReject refuses to use the entries failing verification.
+	TrustModeReject
+	// TrustModeQuarantine refuses to use the entries failing verification and keeps them in quarantine,
+	// until they pass verification or are released with ReleaseQuarantined.
+	TrustModeQuarantine
+)
+
+// Kinds of register entries
+const (
+	registrationKindGateway  = "gateway"
+	registrationKindProvider = "provider"
+)
+
+// SigningKeyCertifier checks the signing key of a node is certified by the root signing key of the node.
+// The register entries do not carry a certificate of the signing key, so the certifier has to obtain it
+// out of band, for instance from the operator of the node.
+type SigningKeyCertifier interface {
+	CertifySigningKey(nodeID string, rootSigningKey *fcrcrypto.KeyPair, signingKey *fcrcrypto.KeyPair) error
+}
+
+// SigningKeyCertificates is a SigningKeyCertifier holding, for each node ID, the signature of the
+// hex encoded signing public key of the node by its root signing key.
+type SigningKeyCertificates map[string][]byte
+
+// CertifySigningKey implements SigningKeyCertifier
+func (s SigningKeyCertificates) CertifySigningKey(nodeID string, rootSigningKey *fcrcrypto.KeyPair, signingKey *fcrcrypto.KeyPair) error {
+	signature, exists := s[strings.ToLower(nodeID)]
+	if !exists {
+		return errors.New("no certificate for the signing key")
+	}
+	encoded, err := signingKey.EncodePublicKey()
+	if err != nil {
+		return fmt.Errorf("error encoding the signing key: %s", err.Error())
+	}
+	ok, err := rootSigningKey.Verify(signature, []byte(encoded))
+	if err != nil {
+		return fmt.Errorf("error verifying the signing key certificate: %s", err.Error())
+	}
+	if !ok {
+		return errors.New("signing key is not certified by the root signing key")
+	}
+	return nil
+}
+
+// TrustAnchor holds the root signing keys trusted by the client and the checks register entries must pass.
+// An entry is trusted when:
+//   - its root signing key is the key pinned for its node ID or, if no key is pinned for the node ID,
+//     one of the trusted root keys,
+//   - its signing key is certified by its root signing key with Certifier, pinned or not: the signing key is the
+//     key offers and acks are verified with, so an entry keeping the root key of a node with another signing
+//     key is never trusted,
+//   - if RequireNodeIDBinding is set, its node ID is derived from its root signing key.
+//
+// An anchor with neither trusted root keys nor pinned keys trusts no entry.
+type TrustAnchor struct {
+	// RequireNodeIDBinding requires the node ID of an entry to be the hash of its root signing key.
+	RequireNodeIDBinding bool
+	// Certifier checks the signing key of an entry chains to its root signing key. No entry is trusted without it.
+	Certifier SigningKeyCertifier
+
+	rootKeys   map[string]bool
+	pinnedKeys map[string]string
+	lock       sync.RWMutex
+}
+
+// NewTrustAnchor creates a trust anchor with no trusted root keys.
+func NewTrustAnchor() *TrustAnchor {
+	return &TrustAnchor{
+		rootKeys:   make(map[string]bool),
+		pinnedKeys: make(map[string]string),
+	}
+}
+
+// TrustRootKey adds a hex encoded root signing public key to the trusted root keys.
+func (a *TrustAnchor) TrustRootKey(encodedKey string) error {
+	key, err := normaliseEncodedKey(encodedKey)
+	if err != nil {
+		return err
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.rootKeys[key] = true
+	return nil
+}
+
+// PinRootKey pins the hex encoded root signing public key of a node: the entry of the node is only
+// trusted with this root key, whatever the trusted root keys are, and with a signing key it certifies.
+func (a *TrustAnchor) PinRootKey(nodeID *nodeid.NodeID, encodedKey string) error {
+	key, err := normaliseEncodedKey(encodedKey)
+	if err != nil {
+		return err
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.pinnedKeys[nodeID.ToString()] = key
+	return nil
+}
+
+// Verify checks the node ID, root signing key and signing key of a register entry.
+func (a *TrustAnchor) Verify(nodeID string, rootSigningKey *fcrcrypto.KeyPair, signingKey *fcrcrypto.KeyPair) error {
+	id, err := nodeid.NewNodeIDFromHexString(nodeID)
+	if err != nil {
+		return fmt.Errorf("invalid node id: %s", err.Error())
+	}
+	rootKey, err := rootSigningKey.EncodePublicKey()
+	if err != nil {
+		return fmt.Errorf("error encoding the root signing key: %s", err.Error())
+	}
+
+	a.lock.RLock()
+	pinned, isPinned := a.pinnedKeys[id.ToString()]
+	trusted := a.rootKeys[rootKey]
+	a.lock.RUnlock()
+	if isPinned && pinned != rootKey {
+		return errors.New("root signing key does not match the key pinned for the node")
+	}
+	if !isPinned && !trusted {
+		return errors.New("root signing key is not trusted")
+	}
+
+	if a.RequireNodeIDBinding {
+		derived, err := nodeid.NewNodeIDFromPublicKey(rootSigningKey)
+		if err != nil {
+			return fmt.Errorf("error deriving the node id from the root signing key: %s", err.Error())
+		}
+		if derived.ToString() != id.ToString() {
+			return errors.New("node id is not derived from the root signing key")
+		}
+	}
+	if a.Certifier == nil {
+		return errors.New("signing key can not be certified: no certifier")
+	}
+	return a.Certifier.CertifySigningKey(id.ToString(), rootSigningKey, signingKey)
+}
+
+// normaliseEncodedKey checks a hex encoded public key decodes and returns it in its canonical encoding.
+func normaliseEncodedKey(encodedKey string) (string, error) {
+	key, err := fcrcrypto.DecodePublicKey(strings.TrimPrefix(strings.ToLower(encodedKey), "0x"))
+	if err != nil {
+		return "", fmt.Errorf("invalid public key %s: %s", encodedKey, err.Error())
+	}
+	return key.EncodePublicKey()
+}
+
+// QuarantinedEntry - a register entry which failed trust verification
+type QuarantinedEntry struct {
+	NodeID string
+	Kind   string
+	Reason string
+	Since  time.Time
+
+	keys string
+}
+
+// trustState - the quarantined register entries, and the released ones with the keys they were released with
+type trustState struct {
+	quarantined map[string]QuarantinedEntry
+	released    map[string]string
+	lock        sync.RWMutex
+}
+
+// newTrustState creates an empty trust state.
+func newTrustState() *trustState {
+	return &trustState{
+		quarantined: make(map[string]QuarantinedEntry),
+		released:    make(map[string]string),
+	}
+}
+
+// Quarantined returns the register entries in quarantine, sorted by node ID.
+func (c *FilecoinRetrievalClient) Quarantined() []QuarantinedEntry {
+	c.trust.lock.RLock()
+	defer c.trust.lock.RUnlock()
+	res := make([]QuarantinedEntry, 0, len(c.trust.quarantined))
+	for _, entry := range c.trust.quarantined {
+		res = append(res, entry)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].NodeID < res[j].NodeID })
+	return res
+}
+
+// ReleaseQuarantined releases the entry of a node from quarantine: the entry is trusted for as long as
+// its keys stay the ones it had when quarantined. Returns false if the node is not in quarantine.
+func (c *FilecoinRetrievalClient) ReleaseQuarantined(nodeID *nodeid.NodeID) bool {
+	c.trust.lock.Lock()
+	defer c.trust.lock.Unlock()
+	entry, exists := c.trust.quarantined[nodeID.ToString()]
+	if !exists {
+		return false
+	}
+	delete(c.trust.quarantined, nodeID.ToString())
+	c.trust.released[nodeID.ToString()] = entry.keys
+	return true
+}
+
+// validGateway checks the register entry of a gateway is valid and, depending on the trust mode, trusted.
+func (c *FilecoinRetrievalClient) validGateway(gateway register.GatewayRegistrar) bool {
+	if !validateGatewayInfo(c.logger, gateway) {
+		return false
+	}
+	rootKey, _ := gateway.GetRootSigningKey()
+	signingKey, _ := gateway.GetSigningKey()
+	return c.trusted(registrationKindGateway, gateway.GetNodeID(), rootKey, signingKey)
+}
+
+// validProvider checks the register entry of a provider is valid and, depending on the trust mode, trusted.
+func (c *FilecoinRetrievalClient) validProvider(provider register.ProviderRegistrar) bool {
+	if !validateProviderInfo(c.logger, provider) {
+		return false
+	}
+	rootKey, _ := provider.GetRootSigningKey()
+	signingKey, _ := provider.GetSigningKey()
+	return c.trusted(registrationKindProvider, provider.GetNodeID(), rootKey, signingKey)
+}
+
+// trusted verifies a register entry against the trust anchor, quarantining it if it fails and the trust mode says so.
+func (c *FilecoinRetrievalClient) trusted(kind string, nodeID string, rootKey *fcrcrypto.KeyPair, signingKey *fcrcrypto.KeyPair) bool {
+	mode := c.Settings.TrustMode()
+	anchor := c.Settings.TrustAnchor()
+	if mode == TrustModeDisabled || anchor == nil {
+		return true
+	}
+	id, err := nodeid.NewNodeIDFromHexString(nodeID)
+	if err != nil {
+		c.logger.Warn("Register entry not trusted: invalid node id", "kind", kind, "node_id", nodeID, "error", err)
+		return false
+	}
+	keys := entryKeys(rootKey, signingKey)
+
+	c.trust.lock.RLock()
+	releasedKeys, released := c.trust.released[id.ToString()]
+	c.trust.lock.RUnlock()
+	if released && releasedKeys == keys {
+		return true
+	}
+
+	err = anchor.Verify(nodeID, rootKey, signingKey)
+	if err == nil {
+		c.trust.lock.Lock()
+		delete(c.trust.quarantined, id.ToString())
+		delete(c.trust.released, id.ToString())
+		c.trust.lock.Unlock()
+		return true
+	}
+
+	quarantine := mode == TrustModeQuarantine
+	c.logger.Warn("Register entry not trusted", "kind", kind, "node_id", id.ToString(), "quarantined", quarantine, "error", err)
+	if quarantine {
+		c.trust.lock.Lock()
+		if _, exists := c.trust.quarantined[id.ToString()]; !exists {
+			c.trust.quarantined[id.ToString()] = QuarantinedEntry{id.ToString(), kind, err.Error(), time.Now(), keys}
+		}
+		c.trust.lock.Unlock()
+	}
+	c.events.publish(RegistrationUntrustedEvent{now(), id.ToString(), kind, err.Error(), quarantine})
+	return false
+}
+
+// entryKeys returns the encoded keys of a register entry, identifying the keys a quarantined entry is released with.
+func entryKeys(rootKey *fcrcrypto.KeyPair, signingKey *fcrcrypto.KeyPair) string {
+	root, _ := rootKey.EncodePublicKey()
+	signing, _ := signingKey.EncodePublicKey()
+	return root + "/" + signing
+}
@@ -0,0 +1,166 @@
+package fcrclient
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"testing"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrcrypto"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+)
+
+// certify returns the certificate of signingKey by rootKey.
+func certify(t *testing.T, rootKey *fcrcrypto.KeyPair, signingKey *fcrcrypto.KeyPair) []byte {
+	t.Helper()
+	signature, err := rootKey.Sign([]byte(encodedPublicKey(t, signingKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signature
+}
+
+func TestEmptyTrustAnchorTrustsNothing(t *testing.T) {
+	rootKey, signingKey := newTestKey(t), newTestKey(t)
+	id := nodeid.NewRandomNodeID()
+	anchor := NewTrustAnchor()
+	if err := anchor.Verify(id.ToString(), rootKey, signingKey); err == nil {
+		t.Fatal("empty anchor trusted an entry")
+	}
+	anchor.Certifier = SigningKeyCertificates{id.ToString(): certify(t, rootKey, signingKey)}
+	if err := anchor.Verify(id.ToString(), rootKey, signingKey); err == nil {
+		t.Fatal("anchor with only a certifier trusted an entry")
+	}
+}
+
+func TestTrustedRootKeyRequiresCertifiedSigningKey(t *testing.T) {
+	rootKey, signingKey := newTestKey(t), newTestKey(t)
+	id := nodeid.NewRandomNodeID()
+	anchor := NewTrustAnchor()
+	if err := anchor.TrustRootKey(encodedPublicKey(t, rootKey)); err != nil {
+		t.Fatal(err)
+	}
+	if err := anchor.Verify(id.ToString(), rootKey, signingKey); err == nil {
+		t.Fatal("unpinned entry trusted without a certifier")
+	}
+
+	anchor.Certifier = SigningKeyCertificates{}
+	if err := anchor.Verify(id.ToString(), rootKey, signingKey); err == nil {
+		t.Fatal("entry trusted without a certificate")
+	}
+	anchor.Certifier = SigningKeyCertificates{id.ToString(): certify(t, rootKey, newTestKey(t))}
+	if err := anchor.Verify(id.ToString(), rootKey, signingKey); err == nil {
+		t.Fatal("entry trusted with the certificate of another signing key")
+	}
+	anchor.Certifier = SigningKeyCertificates{id.ToString(): certify(t, rootKey, signingKey)}
+	if err := anchor.Verify(id.ToString(), rootKey, signingKey); err != nil {
+		t.Fatalf("certified entry not trusted: %s", err.Error())
+	}
+
+	otherRoot := newTestKey(t)
+	anchor.Certifier = SigningKeyCertificates{id.ToString(): certify(t, otherRoot, signingKey)}
+	if err := anchor.Verify(id.ToString(), otherRoot, signingKey); err == nil {
+		t.Fatal("entry trusted with an untrusted root key")
+	}
+}
+
+func TestPinnedRootKey(t *testing.T) {
+	rootKey, signingKey := newTestKey(t), newTestKey(t)
+	id := nodeid.NewRandomNodeID()
+	anchor := NewTrustAnchor()
+	if err := anchor.PinRootKey(id, encodedPublicKey(t, rootKey)); err != nil {
+		t.Fatal(err)
+	}
+	if err := anchor.Verify(id.ToString(), rootKey, signingKey); err == nil {
+		t.Fatal("pinned entry trusted without a certifier")
+	}
+	anchor.Certifier = SigningKeyCertificates{id.ToString(): certify(t, rootKey, signingKey)}
+	if err := anchor.Verify(id.ToString(), rootKey, signingKey); err != nil {
+		t.Fatalf("pinned entry not trusted: %s", err.Error())
+	}
+	if err := anchor.Verify(id.ToString(), newTestKey(t), signingKey); err == nil {
+		t.Fatal("entry trusted with another root key than the pinned one")
+	}
+	if err := anchor.Verify(nodeid.NewRandomNodeID().ToString(), rootKey, signingKey); err == nil {
+		t.Fatal("pin of a node trusted another node")
+	}
+}
+
+func TestPinnedRootKeyRejectsSwappedSigningKey(t *testing.T) {
+	rootKey, signingKey := newTestKey(t), newTestKey(t)
+	id := nodeid.NewRandomNodeID()
+	anchor := NewTrustAnchor()
+	if err := anchor.PinRootKey(id, encodedPublicKey(t, rootKey)); err != nil {
+		t.Fatal(err)
+	}
+	anchor.Certifier = SigningKeyCertificates{id.ToString(): certify(t, rootKey, signingKey)}
+
+	// A forged entry keeps the pinned root key of the node with a signing key of the forger
+	forged := newTestKey(t)
+	if err := anchor.Verify(id.ToString(), rootKey, forged); err == nil {
+		t.Fatal("entry trusted with a signing key swapped under the pinned root key")
+	}
+	anchor.Certifier = SigningKeyCertificates{id.ToString(): certify(t, forged, forged)}
+	if err := anchor.Verify(id.ToString(), rootKey, forged); err == nil {
+		t.Fatal("entry trusted with a signing key certified by another key than the pinned root key")
+	}
+}
+
+func TestNodeIDBinding(t *testing.T) {
+	rootKey, signingKey := newTestKey(t), newTestKey(t)
+	derived, err := nodeid.NewNodeIDFromPublicKey(rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := nodeid.NewRandomNodeID()
+	anchor := NewTrustAnchor()
+	anchor.RequireNodeIDBinding = true
+	anchor.Certifier = SigningKeyCertificates{
+		derived.ToString(): certify(t, rootKey, signingKey),
+		other.ToString():   certify(t, rootKey, signingKey),
+	}
+	for _, id := range []*nodeid.NodeID{derived, other} {
+		if err := anchor.PinRootKey(id, encodedPublicKey(t, rootKey)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := anchor.Verify(derived.ToString(), rootKey, signingKey); err != nil {
+		t.Fatalf("bound entry not trusted: %s", err.Error())
+	}
+	if err := anchor.Verify(other.ToString(), rootKey, signingKey); err == nil {
+		t.Fatal("entry trusted with a node id not derived from its root key")
+	}
+}
+
+func TestClientQuarantinesUntrustedEntries(t *testing.T) {
+	rootKey, signingKey := newTestKey(t), newTestKey(t)
+	id := nodeid.NewRandomNodeID()
+	c := newTestClient(t, func(builder *SettingsBuilder) {
+		builder.SetTrustAnchor(NewTrustAnchor(), TrustModeQuarantine)
+	})
+	if c.trusted(registrationKindGateway, id.ToString(), rootKey, signingKey) {
+		t.Fatal("entry trusted by an empty anchor")
+	}
+	quarantined := c.Quarantined()
+	if len(quarantined) != 1 || quarantined[0].NodeID != id.ToString() {
+		t.Fatalf("unexpected quarantine: %+v", quarantined)
+	}
+	if !c.ReleaseQuarantined(id) || !c.trusted(registrationKindGateway, id.ToString(), rootKey, signingKey) {
+		t.Fatal("released entry not trusted")
+	}
+	if c.trusted(registrationKindGateway, id.ToString(), rootKey, newTestKey(t)) {
+		t.Fatal("released entry trusted with other keys")
+	}
+}
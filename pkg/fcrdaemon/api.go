@@ -0,0 +1,182 @@
+package fcrdaemon
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/fcrclient"
+)
+
+// API paths
+const (
+	pathFindGateways        = "/v1/gateways/find"
+	pathGatewaysToUse       = "/v1/gateways/use"
+	pathActiveGateways      = "/v1/gateways/active"
+	pathStandardDiscovery   = "/v1/discover/standard"
+	pathStandardDiscoveryV2 = "/v1/discover/standard-v2"
+	pathDHTDiscovery        = "/v1/discover/dht"
+	pathDHTDiscoveryV2      = "/v1/discover/dht-v2"
+	pathOfferAck            = "/v1/offer-ack"
+	pathRetrieve            = "/v1/retrieve"
+	pathSpending            = "/v1/spending"
+	pathChannels            = "/v1/channels"
+	pathSettleChannels      = "/v1/channels/settle"
+	pathCollectChannels     = "/v1/channels/collect"
+)
+
+// GatewaysRequest - the body of requests adding or removing gateways
+type GatewaysRequest struct {
+	GatewayIDs []string `json:"gateway_ids"`
+}
+
+// GatewaysResponse - a list of gateways, and the number of gateways changed by the request
+type GatewaysResponse struct {
+	GatewayIDs []string `json:"gateway_ids"`
+	Changed    int      `json:"changed"`
+}
+
+// DiscoveryRequest - the body of discovery requests
+type DiscoveryRequest struct {
+	ContentID string `json:"cid"`
+	GatewayID string `json:"gateway_id"`
+	// NumDHT is the number of gateways contacted by DHT discovery.
+	NumDHT int64 `json:"num_dht,omitempty"`
+	// MaxOffers is the maximum number of offers of V2 discovery.
+	MaxOffers int `json:"max_offers,omitempty"`
+}
+
+// DiscoveryResponse - the verified offers found, by gateway
+type DiscoveryResponse struct {
+	Offers map[string][]cidoffer.SubCIDOffer `json:"offers"`
+}
+
+// OfferAckRequest - the body of offer ack requests
+type OfferAckRequest struct {
+	ContentID  string `json:"cid"`
+	GatewayID  string `json:"gateway_id"`
+	ProviderID string `json:"provider_id"`
+}
+
+// OfferAckResponse - whether the gateway acknowledged the offer, with the nonce and hex encoded hash of the
+// acknowledged request when it did
+type OfferAckResponse struct {
+	Acknowledged bool   `json:"acknowledged"`
+	Nonce        int64  `json:"nonce,omitempty"`
+	RequestHash  string `json:"request_hash,omitempty"`
+}
+
+// SpendingResponse - the payments made by the daemon's client, by gateway and in total
+type SpendingResponse struct {
+	Gateways      []GatewaySpending `json:"gateways"`
+	TotalPaid     string            `json:"total_paid"`
+	TotalToppedUp string            `json:"total_topped_up"`
+}
+
+// GatewaySpending - the payments made to a gateway
+type GatewaySpending struct {
+	GatewayID      string `json:"gateway_id"`
+	PaymentChannel string `json:"payment_channel"`
+	Paid           string `json:"paid"`
+	ToppedUp       string `json:"topped_up"`
+	Payments       int    `json:"payments"`
+	TopUps         int    `json:"topups"`
+	// ToppedUpOnRequest is the part of ToppedUp topped up after the gateway answered payment required
+	ToppedUpOnRequest string        `json:"topped_up_on_request"`
+	Lanes             []PaymentLane `json:"lanes"`
+}
+
+// PaymentLane - the payments made on a lane of the payment channel to a gateway
+type PaymentLane struct {
+	Lane        uint64 `json:"lane"`
+	RequestType string `json:"request_type,omitempty"`
+	Paid        string `json:"paid"`
+	Payments    int    `json:"payments"`
+	Sessions    int    `json:"sessions"`
+	LastPayment string `json:"last_payment,omitempty"`
+}
+
+// ChannelsResponse - the payment channels of the daemon's client, with the errors of the gateways whose
+// channel could not be settled or collected by the request
+type ChannelsResponse struct {
+	Channels []PaymentChannel  `json:"channels"`
+	Errors   map[string]string `json:"errors,omitempty"`
+}
+
+// PaymentChannel - the payment channel to a gateway
+type PaymentChannel struct {
+	GatewayID      string `json:"gateway_id"`
+	PaymentChannel string `json:"payment_channel"`
+	Balance        string `json:"balance"`
+	Redeemed       string `json:"redeemed"`
+	State          string `json:"state"`
+	SettledAt      string `json:"settled_at,omitempty"`
+}
+
+// ErrorResponse - the body of failed requests
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// newGatewaySpending converts the payment status of a gateway.
+func newGatewaySpending(status fcrclient.GatewayPaymentStatus) GatewaySpending {
+	return GatewaySpending{
+		GatewayID:         status.GatewayID,
+		PaymentChannel:    status.PaymentChannel,
+		Paid:              status.Paid.String(),
+		ToppedUp:          status.ToppedUp.String(),
+		Payments:          status.Payments,
+		TopUps:            status.TopUps,
+		ToppedUpOnRequest: status.ToppedUpOnRequest.String(),
+		Lanes:             newPaymentLanes(status.Lanes),
+	}
+}
+
+// newPaymentLanes converts the lanes of the payment channel to a gateway.
+func newPaymentLanes(lanes []fcrclient.PaymentLaneStatus) []PaymentLane {
+	res := make([]PaymentLane, 0, len(lanes))
+	for _, lane := range lanes {
+		entry := PaymentLane{
+			Lane:        lane.Lane,
+			RequestType: string(lane.RequestType),
+			Paid:        lane.Paid.String(),
+			Payments:    lane.Payments,
+			Sessions:    lane.Sessions,
+		}
+		if !lane.LastPayment.IsZero() {
+			entry.LastPayment = lane.LastPayment.UTC().Format(time.RFC3339)
+		}
+		res = append(res, entry)
+	}
+	return res
+}
+
+// newPaymentChannel converts the info of a payment channel.
+func newPaymentChannel(info fcrclient.PaymentChannelInfo) PaymentChannel {
+	res := PaymentChannel{
+		GatewayID:      info.GatewayID,
+		PaymentChannel: info.PaymentChannel,
+		Balance:        info.Balance.String(),
+		Redeemed:       info.Redeemed.String(),
+		State:          string(info.State),
+	}
+	if !info.SettledAt.IsZero() {
+		res.SettledAt = info.SettledAt.UTC().Format(time.RFC3339)
+	}
+	return res
+}
@@ -0,0 +1,112 @@
+// Package fcrdaemon hosts a single FilecoinRetrievalClient and exposes its operations over a local,
+// bearer token authenticated HTTP/JSON API, so that several processes share gateways and payment channels.
+package fcrdaemon
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/fcrclient"
+)
+
+const (
+	readTimeout     = 30 * time.Second
+	shutdownTimeout = 10 * time.Second
+)
+
+// Daemon serves the API of a client.
+type Daemon struct {
+	client *fcrclient.FilecoinRetrievalClient
+	token  string
+	server *http.Server
+}
+
+// NewDaemon creates a daemon serving the API of the given client on the given address.
+// Every request must carry the token as a bearer token.
+func NewDaemon(client *fcrclient.FilecoinRetrievalClient, listenAddr string, token string) (*Daemon, error) {
+	if client == nil {
+		return nil, errors.New("client is required")
+	}
+	if token == "" {
+		return nil, errors.New("API token is required")
+	}
+	d := &Daemon{
+		client: client,
+		token:  token,
+	}
+	d.server = &http.Server{
+		Addr:        listenAddr,
+		Handler:     d.authenticate(d.routes()),
+		ReadTimeout: readTimeout,
+	}
+	return d, nil
+}
+
+// ListenAndServe serves the API until Shutdown is called.
+func (d *Daemon) ListenAndServe() error {
+	d.client.Logger().Info("Client daemon listening", "address", d.server.Addr)
+	err := d.server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops the daemon, waiting for the requests in progress.
+func (d *Daemon) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return d.server.Shutdown(ctx)
+}
+
+// routes returns the handler of the API.
+func (d *Daemon) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(pathFindGateways, d.handleFindGateways)
+	mux.HandleFunc(pathGatewaysToUse, d.handleGatewaysToUse)
+	mux.HandleFunc(pathActiveGateways, d.handleActiveGateways)
+	mux.HandleFunc(pathStandardDiscovery, d.handleStandardDiscovery)
+	mux.HandleFunc(pathStandardDiscoveryV2, d.handleStandardDiscoveryV2)
+	mux.HandleFunc(pathDHTDiscovery, d.handleDHTDiscovery)
+	mux.HandleFunc(pathDHTDiscoveryV2, d.handleDHTDiscoveryV2)
+	mux.HandleFunc(pathOfferAck, d.handleOfferAck)
+	mux.HandleFunc(pathRetrieve, d.handleRetrieve)
+	mux.HandleFunc(pathSpending, d.handleSpending)
+	mux.HandleFunc(pathChannels, d.handleChannels)
+	mux.HandleFunc(pathSettleChannels, d.handleSettleChannels)
+	mux.HandleFunc(pathCollectChannels, d.handleCollectChannels)
+	return mux
+}
+
+// authenticate rejects the requests which don't carry the API token.
+func (d *Daemon) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(header, "Bearer ")
+		if token == header || subtle.ConstantTimeCompare([]byte(token), []byte(d.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			d.writeError(w, http.StatusUnauthorized, errors.New("invalid or missing API token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,155 @@
+package fcrdaemon
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrcrypto"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/register"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/fcrclient"
+	"github.com/ConsenSys/fc-retrieval-client/pkg/fcrlogger"
+)
+
+const testToken = "secret"
+
+// emptyRegister - a register without any gateway or provider
+type emptyRegister struct{}
+
+func (emptyRegister) Refresh()                                                 {}
+func (emptyRegister) GetGateway(id *nodeid.NodeID) register.GatewayRegistrar   { return nil }
+func (emptyRegister) GetProvider(id *nodeid.NodeID) register.ProviderRegistrar { return nil }
+func (emptyRegister) GetAllGateways() []register.GatewayRegistrar              { return nil }
+func (emptyRegister) GetAllProviders() []register.ProviderRegistrar            { return nil }
+func (emptyRegister) GetGatewaysNearCID(cID *cid.ContentID, numDHT int, notAllowed *nodeid.NodeID) ([]register.GatewayRegistrar, error) {
+	return nil, nil
+}
+
+// newTestDaemon creates a daemon serving a client with an empty register.
+func newTestDaemon(t *testing.T) *Daemon {
+	t.Helper()
+	blockchainKey, err := fcrcrypto.GenerateBlockchainKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := fcrclient.CreateSettings()
+	builder.SetBlockchainPrivateKey(blockchainKey)
+	builder.SetLogger(fcrlogger.NewNopLogger())
+	client, err := fcrclient.NewFilecoinRetrievalClient(*builder.Build(), emptyRegister{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDaemon(client, "127.0.0.1:0", testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// serve sends a request with the given token to the daemon and returns the response.
+func serve(d *Daemon, method string, path string, body string, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	d.server.Handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestNewDaemonRequiresToken(t *testing.T) {
+	if _, err := NewDaemon(newTestDaemon(t).client, "127.0.0.1:0", ""); err == nil {
+		t.Fatal("daemon created without an API token")
+	}
+}
+
+func TestDaemonRequiresToken(t *testing.T) {
+	d := newTestDaemon(t)
+	for _, token := range []string{"", "wrong"} {
+		if w := serve(d, http.MethodGet, pathSpending, "", token); w.Code != http.StatusUnauthorized {
+			t.Fatalf("request with token %q answered %d", token, w.Code)
+		}
+	}
+	w := serve(d, http.MethodGet, pathSpending, "", testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("request with the API token answered %d", w.Code)
+	}
+	var res SpendingResponse
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Gateways) != 0 || res.TotalPaid != "0" {
+		t.Fatalf("expected no spending, got %+v", res)
+	}
+}
+
+func TestDaemonRejectsInvalidRequests(t *testing.T) {
+	d := newTestDaemon(t)
+	gatewayID := nodeid.NewRandomNodeID().ToString()
+	for _, test := range []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodGet, pathStandardDiscovery, "", http.StatusMethodNotAllowed},
+		{http.MethodPost, pathStandardDiscovery, `{"cid": "zz", "gateway_id": "` + gatewayID + `"}`, http.StatusBadRequest},
+		{http.MethodPost, pathStandardDiscovery, `{"cid": "` + cid.NewRandomContentID().ToString() + `", "gateway_id": "` + gatewayID + `", "extra": 1}`, http.StatusBadRequest},
+		{http.MethodPost, pathGatewaysToUse, `{"gateway_ids": ["zz"]}`, http.StatusBadRequest},
+		{http.MethodGet, pathFindGateways, "", http.StatusBadRequest},
+		{http.MethodPost, pathRetrieve, "", http.StatusNotImplemented},
+	} {
+		w := serve(d, test.method, test.path, test.body, testToken)
+		if w.Code != test.status {
+			t.Fatalf("%s %s answered %d, expected %d", test.method, test.path, w.Code, test.status)
+		}
+		var res ErrorResponse
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil || res.Error == "" {
+			t.Fatalf("%s %s answered without an error", test.method, test.path)
+		}
+	}
+}
+
+func TestDaemonDiscoveryThroughInactiveGateway(t *testing.T) {
+	d := newTestDaemon(t)
+	body := `{"cid": "` + cid.NewRandomContentID().ToString() + `", "gateway_id": "` + nodeid.NewRandomNodeID().ToString() + `"}`
+	if w := serve(d, http.MethodPost, pathStandardDiscovery, body, testToken); w.Code != http.StatusBadGateway {
+		t.Fatalf("discovery through a gateway not active answered %d", w.Code)
+	}
+}
+
+func TestDaemonGatewaysToUse(t *testing.T) {
+	d := newTestDaemon(t)
+	body := `{"gateway_ids": ["` + nodeid.NewRandomNodeID().ToString() + `"]}`
+	w := serve(d, http.MethodPost, pathGatewaysToUse, body, testToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("adding gateways answered %d", w.Code)
+	}
+	var res GatewaysResponse
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Changed != 0 || len(res.GatewayIDs) != 0 {
+		t.Fatalf("gateway missing from the register added: %+v", res)
+	}
+}
@@ -0,0 +1,349 @@
+package fcrdaemon
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cid"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/cidoffer"
+	"github.com/ConsenSys/fc-retrieval-common/pkg/nodeid"
+)
+
+const (
+	defaultMaxGateways = 16
+	maxRequestBodySize = 1 << 20
+)
+
+// handleFindGateways finds the gateways of a region: GET ?region=<code>&max=<n>
+func (d *Daemon) handleFindGateways(w http.ResponseWriter, r *http.Request) {
+	if !d.allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	region := r.URL.Query().Get("region")
+	if region == "" {
+		d.writeError(w, http.StatusBadRequest, errors.New("region is required"))
+		return
+	}
+	max := defaultMaxGateways
+	if value := r.URL.Query().Get("max"); value != "" {
+		var err error
+		if max, err = strconv.Atoi(value); err != nil || max <= 0 {
+			d.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid max: %s", value))
+			return
+		}
+	}
+	ids, err := d.client.FindGateways(region, max)
+	if err != nil {
+		d.writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	d.writeJSON(w, http.StatusOK, GatewaysResponse{GatewayIDs: nodeIDStrings(ids)})
+}
+
+// handleGatewaysToUse lists (GET), adds (POST) or removes (DELETE) gateways to use.
+func (d *Daemon) handleGatewaysToUse(w http.ResponseWriter, r *http.Request) {
+	if !d.allowMethods(w, r, http.MethodGet, http.MethodPost, http.MethodDelete) {
+		return
+	}
+	changed := 0
+	if r.Method != http.MethodGet {
+		ids, ok := d.readGatewayIDs(w, r)
+		if !ok {
+			return
+		}
+		if r.Method == http.MethodPost {
+			changed = d.client.AddGatewaysToUse(ids)
+		} else {
+			changed = d.client.RemoveGatewaysToUse(ids)
+		}
+	}
+	d.writeJSON(w, http.StatusOK, GatewaysResponse{GatewayIDs: nodeIDStrings(d.client.GetGatewaysToUse()), Changed: changed})
+}
+
+// handleActiveGateways lists (GET), establishes with (POST) or deactivates (DELETE) gateways.
+func (d *Daemon) handleActiveGateways(w http.ResponseWriter, r *http.Request) {
+	if !d.allowMethods(w, r, http.MethodGet, http.MethodPost, http.MethodDelete) {
+		return
+	}
+	changed := 0
+	if r.Method != http.MethodGet {
+		ids, ok := d.readGatewayIDs(w, r)
+		if !ok {
+			return
+		}
+		if r.Method == http.MethodPost {
+			changed = d.client.AddActiveGatewaysContext(r.Context(), ids)
+		} else {
+			changed = d.client.RemoveActiveGateways(ids)
+		}
+	}
+	d.writeJSON(w, http.StatusOK, GatewaysResponse{GatewayIDs: nodeIDStrings(d.client.GetActiveGateways()), Changed: changed})
+}
+
+// handleStandardDiscovery finds offers using standard discovery.
+func (d *Daemon) handleStandardDiscovery(w http.ResponseWriter, r *http.Request) {
+	req, contentID, gatewayID, ok := d.readDiscoveryRequest(w, r)
+	if !ok {
+		return
+	}
+	offers, err := d.client.FindOffersStandardDiscoveryContext(r.Context(), contentID, gatewayID)
+	d.writeDiscoveryResponse(w, map[string]*[]cidoffer.SubCIDOffer{req.GatewayID: &offers}, err)
+}
+
+// handleStandardDiscoveryV2 finds offers using standard discovery with a maximum number of offers.
+func (d *Daemon) handleStandardDiscoveryV2(w http.ResponseWriter, r *http.Request) {
+	req, contentID, gatewayID, ok := d.readDiscoveryRequest(w, r)
+	if !ok {
+		return
+	}
+	offers, err := d.client.FindOffersStandardDiscoveryV2Context(r.Context(), contentID, gatewayID, req.MaxOffers)
+	d.writeDiscoveryResponse(w, map[string]*[]cidoffer.SubCIDOffer{req.GatewayID: &offers}, err)
+}
+
+// handleDHTDiscovery finds offers using DHT discovery.
+func (d *Daemon) handleDHTDiscovery(w http.ResponseWriter, r *http.Request) {
+	req, contentID, gatewayID, ok := d.readDiscoveryRequest(w, r)
+	if !ok {
+		return
+	}
+	offers, err := d.client.FindOffersDHTDiscoveryContext(r.Context(), contentID, gatewayID, req.NumDHT)
+	d.writeDiscoveryResponse(w, offers, err)
+}
+
+// handleDHTDiscoveryV2 finds offers using DHT discovery with a maximum number of offers.
+func (d *Daemon) handleDHTDiscoveryV2(w http.ResponseWriter, r *http.Request) {
+	req, contentID, gatewayID, ok := d.readDiscoveryRequest(w, r)
+	if !ok {
+		return
+	}
+	offers, err := d.client.FindOffersDHTDiscoveryV2Context(r.Context(), contentID, gatewayID, req.NumDHT, req.MaxOffers)
+	d.writeDiscoveryResponse(w, offers, err)
+}
+
+// handleOfferAck checks a gateway acknowledged the DHT offer of a provider.
+func (d *Daemon) handleOfferAck(w http.ResponseWriter, r *http.Request) {
+	if !d.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+	var req OfferAckRequest
+	if !d.readJSON(w, r, &req) {
+		return
+	}
+	contentID, err := cid.NewContentIDFromHexString(req.ContentID)
+	if err != nil {
+		d.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid cid: %s", err.Error()))
+		return
+	}
+	gatewayID, err := nodeid.NewNodeIDFromHexString(req.GatewayID)
+	if err != nil {
+		d.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid gateway_id: %s", err.Error()))
+		return
+	}
+	providerID, err := nodeid.NewNodeIDFromHexString(req.ProviderID)
+	if err != nil {
+		d.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid provider_id: %s", err.Error()))
+		return
+	}
+	proof, err := d.client.FindDHTOfferAckProofContext(r.Context(), contentID, gatewayID, providerID)
+	if err != nil {
+		d.writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	res := OfferAckResponse{Acknowledged: proof != nil}
+	if proof != nil {
+		res.Nonce = proof.Nonce
+		res.RequestHash = hex.EncodeToString(proof.RequestHash)
+	}
+	d.writeJSON(w, http.StatusOK, res)
+}
+
+// handleRetrieve is reserved for retrieval, which the client library does not implement yet.
+func (d *Daemon) handleRetrieve(w http.ResponseWriter, r *http.Request) {
+	d.writeError(w, http.StatusNotImplemented, errors.New("retrieval is not supported by the client library yet"))
+}
+
+// handleSpending returns the payments made by the client.
+func (d *Daemon) handleSpending(w http.ResponseWriter, r *http.Request) {
+	if !d.allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	res := SpendingResponse{Gateways: make([]GatewaySpending, 0)}
+	totalPaid := big.NewInt(0)
+	totalToppedUp := big.NewInt(0)
+	for _, status := range d.client.PaymentStatus() {
+		res.Gateways = append(res.Gateways, newGatewaySpending(status))
+		totalPaid.Add(totalPaid, status.Paid)
+		totalToppedUp.Add(totalToppedUp, status.ToppedUp)
+	}
+	res.TotalPaid = totalPaid.String()
+	res.TotalToppedUp = totalToppedUp.String()
+	d.writeJSON(w, http.StatusOK, res)
+}
+
+// handleChannels lists the payment channels of the client.
+func (d *Daemon) handleChannels(w http.ResponseWriter, r *http.Request) {
+	if !d.allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	d.writeJSON(w, http.StatusOK, d.channelsResponse(nil))
+}
+
+// handleSettleChannels settles the payment channels of the given gateways.
+func (d *Daemon) handleSettleChannels(w http.ResponseWriter, r *http.Request) {
+	d.changeChannels(w, r, d.client.SettlePaymentChannel)
+}
+
+// handleCollectChannels collects the payment channels of the given gateways.
+func (d *Daemon) handleCollectChannels(w http.ResponseWriter, r *http.Request) {
+	d.changeChannels(w, r, d.client.CollectPaymentChannel)
+}
+
+// changeChannels applies an operation to the payment channels of the gateways of the request body.
+func (d *Daemon) changeChannels(w http.ResponseWriter, r *http.Request, operation func(*nodeid.NodeID) error) {
+	if !d.allowMethods(w, r, http.MethodPost) {
+		return
+	}
+	ids, ok := d.readGatewayIDs(w, r)
+	if !ok {
+		return
+	}
+	errs := make(map[string]string)
+	for _, id := range ids {
+		if err := operation(id); err != nil {
+			errs[id.ToString()] = err.Error()
+		}
+	}
+	d.writeJSON(w, http.StatusOK, d.channelsResponse(errs))
+}
+
+// channelsResponse returns the payment channels of the client, with the given errors.
+func (d *Daemon) channelsResponse(errs map[string]string) ChannelsResponse {
+	res := ChannelsResponse{Channels: make([]PaymentChannel, 0), Errors: errs}
+	for _, info := range d.client.PaymentChannels() {
+		res.Channels = append(res.Channels, newPaymentChannel(info))
+	}
+	return res
+}
+
+// readDiscoveryRequest reads and validates the body of a discovery request.
+func (d *Daemon) readDiscoveryRequest(w http.ResponseWriter, r *http.Request) (*DiscoveryRequest, *cid.ContentID, *nodeid.NodeID, bool) {
+	if !d.allowMethods(w, r, http.MethodPost) {
+		return nil, nil, nil, false
+	}
+	var req DiscoveryRequest
+	if !d.readJSON(w, r, &req) {
+		return nil, nil, nil, false
+	}
+	contentID, err := cid.NewContentIDFromHexString(req.ContentID)
+	if err != nil {
+		d.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid cid: %s", err.Error()))
+		return nil, nil, nil, false
+	}
+	gatewayID, err := nodeid.NewNodeIDFromHexString(req.GatewayID)
+	if err != nil {
+		d.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid gateway_id: %s", err.Error()))
+		return nil, nil, nil, false
+	}
+	req.GatewayID = gatewayID.ToString()
+	return &req, contentID, gatewayID, true
+}
+
+// writeDiscoveryResponse writes the offers found by a discovery, or its error.
+func (d *Daemon) writeDiscoveryResponse(w http.ResponseWriter, offers map[string]*[]cidoffer.SubCIDOffer, err error) {
+	if err != nil {
+		d.writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	res := DiscoveryResponse{Offers: make(map[string][]cidoffer.SubCIDOffer)}
+	for gatewayID, entry := range offers {
+		if entry != nil {
+			res.Offers[gatewayID] = *entry
+		}
+	}
+	d.writeJSON(w, http.StatusOK, res)
+}
+
+// readGatewayIDs reads the gateway IDs of a request body.
+func (d *Daemon) readGatewayIDs(w http.ResponseWriter, r *http.Request) ([]*nodeid.NodeID, bool) {
+	var req GatewaysRequest
+	if !d.readJSON(w, r, &req) {
+		return nil, false
+	}
+	ids := make([]*nodeid.NodeID, 0, len(req.GatewayIDs))
+	for _, entry := range req.GatewayIDs {
+		id, err := nodeid.NewNodeIDFromHexString(entry)
+		if err != nil {
+			d.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid gateway id %s: %s", entry, err.Error()))
+			return nil, false
+		}
+		ids = append(ids, id)
+	}
+	return ids, true
+}
+
+// allowMethods checks the request method, writing an error if it is not allowed.
+func (d *Daemon) allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	d.writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	return false
+}
+
+// readJSON decodes a request body, writing an error if it is not valid.
+func (d *Daemon) readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		d.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %s", err.Error()))
+		return false
+	}
+	return true
+}
+
+// writeJSON writes a response body.
+func (d *Daemon) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		d.client.Logger().Error("Error writing daemon response", "error", err)
+	}
+}
+
+// writeError writes an error response body.
+func (d *Daemon) writeError(w http.ResponseWriter, status int, err error) {
+	d.writeJSON(w, status, ErrorResponse{Error: err.Error()})
+}
+
+// nodeIDStrings returns the string representations of node IDs, sorted.
+func nodeIDStrings(ids []*nodeid.NodeID) []string {
+	res := make([]string, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, id.ToString())
+	}
+	sort.Strings(res)
+	return res
+}
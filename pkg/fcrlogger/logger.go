@@ -0,0 +1,89 @@
+// Package fcrlogger defines the structured logger used by the client library.
+package fcrlogger
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/logging"
+)
+
+// Logger is a structured logger. The message is a constant and the variable parts are given as alternating
+// keys and values, the way *slog.Logger logs; zap's SugaredLogger only needs its Debugw, Infow, Warnw and Errorw
+// methods mapped.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// globalLogger - a Logger writing to the global fc-retrieval-common logging package
+type globalLogger struct{}
+
+// NewGlobalLogger returns a logger writing to the global fc-retrieval-common logging package.
+// It is the default logger of the client library.
+func NewGlobalLogger() Logger {
+	return globalLogger{}
+}
+
+func (globalLogger) Debug(msg string, keysAndValues ...interface{}) {
+	logging.Debug("%s", Format(msg, keysAndValues...))
+}
+
+func (globalLogger) Info(msg string, keysAndValues ...interface{}) {
+	logging.Info("%s", Format(msg, keysAndValues...))
+}
+
+func (globalLogger) Warn(msg string, keysAndValues ...interface{}) {
+	logging.Warn("%s", Format(msg, keysAndValues...))
+}
+
+func (globalLogger) Error(msg string, keysAndValues ...interface{}) {
+	logging.Error("%s", Format(msg, keysAndValues...))
+}
+
+// nopLogger - a Logger discarding everything
+type nopLogger struct{}
+
+// NewNopLogger returns a logger discarding everything.
+func NewNopLogger() Logger {
+	return nopLogger{}
+}
+
+func (nopLogger) Debug(msg string, keysAndValues ...interface{}) {}
+
+func (nopLogger) Info(msg string, keysAndValues ...interface{}) {}
+
+func (nopLogger) Warn(msg string, keysAndValues ...interface{}) {}
+
+func (nopLogger) Error(msg string, keysAndValues ...interface{}) {}
+
+// Format formats a message and its keys and values as "msg key1=value1 key2=value2".
+func Format(msg string, keysAndValues ...interface{}) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if i+1 < len(keysAndValues) {
+			fmt.Fprintf(&b, " %v=%v", keysAndValues[i], keysAndValues[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v=<missing>", keysAndValues[i])
+		}
+	}
+	return b.String()
+}
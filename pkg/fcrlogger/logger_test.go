@@ -0,0 +1,42 @@
+package fcrlogger
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	for _, test := range []struct {
+		keysAndValues []interface{}
+		expected      string
+	}{
+		{nil, "message"},
+		{[]interface{}{"gateway_id", "0a", "attempt", 2}, "message gateway_id=0a attempt=2"},
+		{[]interface{}{"gateway_id"}, "message gateway_id=<missing>"},
+	} {
+		if formatted := Format("message", test.keysAndValues...); formatted != test.expected {
+			t.Fatalf("expected %q, got %q", test.expected, formatted)
+		}
+	}
+}
+
+func TestLoggersImplementLogger(t *testing.T) {
+	for _, logger := range []Logger{NewNopLogger(), NewGlobalLogger()} {
+		logger.Debug("message", "key", "value")
+		logger.Error("message", "key")
+	}
+}
@@ -0,0 +1,14 @@
+module github.com/ConsenSys/fc-retrieval-client/pkg/lotussettler
+
+go 1.16
+
+require (
+	github.com/ConsenSys/fc-retrieval-client v0.0.0
+	github.com/ConsenSys/fc-retrieval-common v0.0.0-20210629151030-12ab560d14bb
+	github.com/filecoin-project/go-address v0.0.5
+	github.com/filecoin-project/go-jsonrpc v0.1.4-0.20210217175800-45ea43ac2bec
+	github.com/filecoin-project/go-state-types v0.1.0
+	github.com/filecoin-project/lotus v1.8.0
+)
+
+replace github.com/ConsenSys/fc-retrieval-client => ../..
@@ -0,0 +1,125 @@
+// Package lotussettler settles and collects the payment channels of the client through a lotus node. It is a
+// module of its own, so that the client library does not depend on lotus.
+package lotussettler
+
+/*
+ * Copyright 2020 ConsenSys Software Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file except in compliance with
+ * the License. You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software distributed under the License is distributed on
+ * an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the License for the
+ * specific language governing permissions and limitations under the License.
+ *
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/ConsenSys/fc-retrieval-common/pkg/fcrpaymentmgr"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-jsonrpc"
+	crypto2 "github.com/filecoin-project/go-state-types/crypto"
+	"github.com/filecoin-project/lotus/api/apistruct"
+	"github.com/filecoin-project/lotus/chain/actors"
+	"github.com/filecoin-project/lotus/chain/actors/builtin/paych"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/filecoin-project/lotus/lib/sigs"
+
+	"github.com/ConsenSys/fc-retrieval-client/pkg/fcrclient"
+)
+
+// messageConfidence is the number of epochs waited for after a payment channel message is executed.
+const messageConfidence = uint64(5)
+
+// settler - a fcrclient.ChannelSettler sending messages through a lotus node, signed with the wallet key
+type settler struct {
+	privKey        []byte
+	address        address.Address
+	lotusAP        string
+	lotusAuthToken string
+}
+
+// NewChannelSettler creates a ChannelSettler signing messages with the hex encoded wallet private key
+// and sending them to the lotus node at lotusAP.
+func NewChannelSettler(walletPrivateKey string, lotusAP string, lotusAuthToken string) (fcrclient.ChannelSettler, error) {
+	sigs.RegisterSignature(crypto2.SigTypeSecp256k1, fcrpaymentmgr.SecpSigner{})
+	privKey, err := hex.DecodeString(walletPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wallet private key: %s", err.Error())
+	}
+	pubKey, err := sigs.ToPublic(crypto2.SigTypeSecp256k1, privKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wallet private key: %s", err.Error())
+	}
+	addr, err := address.NewSecp256k1Address(pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving the wallet address: %s", err.Error())
+	}
+	return &settler{privKey: privKey, address: addr, lotusAP: lotusAP, lotusAuthToken: lotusAuthToken}, nil
+}
+
+// Settle implements fcrclient.ChannelSettler
+func (s *settler) Settle(ctx context.Context, paymentChannel string) error {
+	return s.send(ctx, paymentChannel, func(builder paych.MessageBuilder, channel address.Address) (*types.Message, error) {
+		return builder.Settle(channel)
+	})
+}
+
+// Collect implements fcrclient.ChannelSettler
+func (s *settler) Collect(ctx context.Context, paymentChannel string) error {
+	return s.send(ctx, paymentChannel, func(builder paych.MessageBuilder, channel address.Address) (*types.Message, error) {
+		return builder.Collect(channel)
+	})
+}
+
+// send builds a payment channel message, signs it, pushes it and waits for its execution.
+func (s *settler) send(ctx context.Context, paymentChannel string, build func(paych.MessageBuilder, address.Address) (*types.Message, error)) error {
+	channel, err := address.NewFromString(paymentChannel)
+	if err != nil {
+		return fmt.Errorf("invalid payment channel address %s: %s", paymentChannel, err.Error())
+	}
+	var api apistruct.FullNodeStruct
+	headers := http.Header{"Authorization": []string{"Bearer " + s.lotusAuthToken}}
+	closer, err := jsonrpc.NewMergeClient(ctx, s.lotusAP, "Filecoin", []interface{}{&api.Internal, &api.CommonStruct.Internal}, headers)
+	if err != nil {
+		return fmt.Errorf("error connecting to lotus: %s", err.Error())
+	}
+	defer closer()
+
+	msg, err := build(paych.Message(actors.Version4, s.address), channel)
+	if err != nil {
+		return err
+	}
+	msg.Nonce, err = api.MpoolGetNonce(ctx, s.address)
+	if err != nil {
+		return fmt.Errorf("error getting the wallet nonce: %s", err.Error())
+	}
+	msg, err = api.GasEstimateMessageGas(ctx, msg, nil, types.EmptyTSK)
+	if err != nil {
+		return fmt.Errorf("error estimating gas: %s", err.Error())
+	}
+	sig, err := sigs.Sign(crypto2.SigTypeSecp256k1, s.privKey, msg.Cid().Bytes())
+	if err != nil {
+		return fmt.Errorf("error signing message: %s", err.Error())
+	}
+	msgCid, err := api.MpoolPush(ctx, &types.SignedMessage{Message: *msg, Signature: *sig})
+	if err != nil {
+		return fmt.Errorf("error pushing message: %s", err.Error())
+	}
+	lookup, err := api.StateWaitMsg(ctx, msgCid, messageConfidence)
+	if err != nil {
+		return fmt.Errorf("error waiting for message %s: %s", msgCid.String(), err.Error())
+	}
+	if lookup.Receipt.ExitCode != 0 {
+		return fmt.Errorf("message %s failed with exit code %d", msgCid.String(), lookup.Receipt.ExitCode)
+	}
+	return nil
+}